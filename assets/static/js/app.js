@@ -153,6 +153,98 @@ function closeModal(modalId) {
     window.dispatchEvent(event);
 }
 
+// Header Search
+let headerSearchTimer = null;
+let headerSearchRequest = 0;
+
+function headerSearch(query) {
+    clearTimeout(headerSearchTimer);
+    if (query.trim() === '') {
+        closeHeaderSearch();
+        return;
+    }
+    headerSearchTimer = setTimeout(() => runHeaderSearch(query.trim()), 250);
+}
+
+async function runHeaderSearch(query) {
+    const request = ++headerSearchRequest;
+    try {
+        const response = await fetch(`/api/search?q=${encodeURIComponent(query)}&limit=20`);
+        if (!response.ok) {
+            throw new Error('Search failed');
+        }
+        const data = await response.json();
+        // Ignore responses to queries that have since been replaced
+        if (request === headerSearchRequest) {
+            renderHeaderSearch(data);
+        }
+    } catch (err) {
+        console.error('Search failed:', err);
+    }
+}
+
+function renderHeaderSearch(data) {
+    const container = document.getElementById('header-search-results');
+    const input = document.getElementById('header-search-input');
+    if (!container) return;
+
+    if (data.results.length === 0) {
+        container.innerHTML = '<p class="px-4 py-3 text-sm text-gray-500">No matches</p>';
+    } else {
+        container.innerHTML = data.results.map(result => {
+            const item = result.directory || result.file;
+            const location = result.breadcrumbs.length
+                ? result.breadcrumbs.map(crumb => escapeHtml(crumb.name)).join(' / ')
+                : 'My Files';
+            const detail = result.file ? ` &middot; ${formatFileSize(result.file.size)}` : '';
+            // The snippet is escaped by the server, with matches in <mark>
+            const snippet = result.snippet
+                ? `<span class="block text-xs text-gray-600 line-clamp-2">${result.snippet}</span>`
+                : '';
+            const href = result.directory
+                ? `/files/${encodeURIComponent(item.id)}`
+                : `/api/files/${encodeURIComponent(item.id)}/download`;
+            return `<a href="${href}" role="option" class="block px-4 py-2 hover:bg-gray-100"
+                       onclick="return openSearchResult(event, '${result.type}', '${escapeHtml(item.id)}')">
+                        <span class="block text-sm font-medium text-gray-900 truncate">${result.directory ? '&#128193; ' : ''}${escapeHtml(item.name)}</span>
+                        <span class="block text-xs text-gray-500 truncate">${location}${detail}</span>
+                        ${snippet}
+                    </a>`;
+        }).join('');
+        if (data.total > data.results.length) {
+            container.innerHTML += `<p class="px-4 py-2 text-xs text-gray-500">Showing ${data.results.length} of ${data.total} matches</p>`;
+        }
+    }
+
+    container.classList.remove('hidden');
+    if (input) input.setAttribute('aria-expanded', 'true');
+}
+
+function openSearchResult(event, type, id) {
+    closeHeaderSearch();
+    // Open folders in place when the file browser is on the page
+    if (type === 'directory' && document.getElementById('file-list-container') && typeof navigateToDirectory === 'function') {
+        event.preventDefault();
+        navigateToDirectory(id);
+        return false;
+    }
+    return true;
+}
+
+function closeHeaderSearch() {
+    const container = document.getElementById('header-search-results');
+    const input = document.getElementById('header-search-input');
+    if (container) container.classList.add('hidden');
+    if (input) input.setAttribute('aria-expanded', 'false');
+}
+
+document.addEventListener('click', function(event) {
+    const search = document.getElementById('header-search');
+    if (search && !search.contains(event.target)) {
+        closeHeaderSearch();
+    }
+});
+
 // Note: openUploadModal is implemented in upload.js
 // Note: createFolder functionality is in file-browser.js (openNewFolderModal)
 
@@ -192,5 +284,8 @@ window.copyToClipboard = copyToClipboard;
 window.escapeHtml = escapeHtml;
 window.openModal = openModal;
 window.closeModal = closeModal;
+window.headerSearch = headerSearch;
+window.openSearchResult = openSearchResult;
+window.closeHeaderSearch = closeHeaderSearch;
 // Note: openUploadModal is exported in upload.js
 // Note: createFolder functionality is in file-browser.js (openNewFolderModal)
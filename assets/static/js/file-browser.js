@@ -10,7 +10,8 @@ const fileBrowserState = {
     viewMode: 'list',
     contextMenuTarget: null,
     pendingUploadFiles: [],
-    isUploading: false
+    isUploading: false,
+    directUploadsAvailable: true
 };
 
 // ============================================
@@ -43,6 +44,11 @@ function handleAfterSwap(event) {
     // Re-initialize components after HTMX swaps content
     updateSelectionUI();
     closeContextMenu();
+
+    // A new listing comes in the list view, so show the one in use
+    if (event.detail.target && event.detail.target.id === 'file-list-container') {
+        setViewMode(fileBrowserState.viewMode);
+    }
 }
 
 function handleHtmxError(event) {
@@ -148,6 +154,10 @@ function showContextMenu(event, id, type) {
     if (fileOptions) fileOptions.classList.toggle('hidden', type !== 'file');
     if (dirOptions) dirOptions.classList.toggle('hidden', type !== 'directory');
 
+    // Offer to star or unstar
+    const starLabel = document.getElementById('context-menu-star-label');
+    if (starLabel) starLabel.textContent = isStarred(id) ? 'Unstar' : 'Star';
+
     // Position menu
     const x = event.clientX;
     const y = event.clientY;
@@ -208,9 +218,15 @@ function contextMenuAction(action) {
         case 'open':
             navigateToDirectory(target.id);
             break;
+        case 'download-zip':
+            downloadDirectory(target.id);
+            break;
         case 'share':
             openShareModal(target.id, target.type);
             break;
+        case 'star':
+            toggleStar(target.id, target.type);
+            break;
         case 'rename':
             openRenameModal(target.id, target.type);
             break;
@@ -237,8 +253,42 @@ function downloadFile(id) {
     window.location.href = `/api/files/${id}/download`;
 }
 
+function downloadDirectory(id) {
+    window.location.href = `/api/directories/${id}/archive`;
+}
+
+function isStarred(id) {
+    const item = document.querySelector(`.file-item[data-id="${id}"]`);
+    return item?.dataset.starred === 'true';
+}
+
+async function toggleStar(id, type) {
+    const starred = isStarred(id);
+    const endpoint = type === 'directory'
+        ? `/api/directories/${id}/star`
+        : `/api/files/${id}/star`;
+
+    try {
+        const response = await fetch(endpoint, { method: starred ? 'DELETE' : 'PUT' });
+        if (!response.ok) {
+            throw new Error('Failed to update starred');
+        }
+
+        const item = document.querySelector(`.file-item[data-id="${id}"]`);
+        if (item) {
+            item.dataset.starred = String(!starred);
+            item.querySelector('.file-star')?.classList.toggle('hidden', starred);
+        }
+
+        showToast('success', starred ? 'Removed from starred' : 'Added to starred');
+    } catch (error) {
+        console.error('Star error:', error);
+        showToast('error', 'Failed to update starred', error.message);
+    }
+}
+
 function navigateToDirectory(id) {
-    htmx.ajax('GET', `/api/directories/${id}`, {
+    htmx.ajax('GET', `/api/directories?directory_id=${encodeURIComponent(id)}`, {
         target: '#file-list-container',
         swap: 'innerHTML'
     });
@@ -253,14 +303,30 @@ function downloadSelected() {
         return;
     }
 
-    if (selected.length === 1) {
+    const firstItem = document.querySelector(`.file-item[data-id="${selected[0]}"]`);
+    if (selected.length === 1 && firstItem?.dataset.type !== 'directory') {
         downloadFile(selected[0]);
-    } else {
-        // For multiple files, trigger batch download
-        showToast('info', 'Preparing download...');
-        // TODO: Implement batch download endpoint
-        window.location.href = `/api/files/download-batch?ids=${selected.join(',')}`;
+        return;
     }
+
+    // Anything else downloads as one ZIP, posted as a form so the browser
+    // handles the streamed response as a download
+    showToast('info', 'Preparing download...');
+    const form = document.createElement('form');
+    form.method = 'POST';
+    form.action = '/api/archive';
+    form.style.display = 'none';
+    selected.forEach(id => {
+        const item = document.querySelector(`.file-item[data-id="${id}"]`);
+        const input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = item?.dataset.type === 'directory' ? 'directory_ids' : 'file_ids';
+        input.value = id;
+        form.appendChild(input);
+    });
+    document.body.appendChild(form);
+    form.submit();
+    form.remove();
 }
 
 function deleteSelected() {
@@ -385,6 +451,23 @@ function closeRenameModal() {
     if (modal) modal.classList.add('hidden');
 }
 
+function renameRequestDone(event) {
+    if (event.detail.successful) {
+        closeRenameModal();
+        refreshFileList();
+        showToast('success', 'Renamed successfully');
+        return;
+    }
+
+    let message = '';
+    try {
+        message = JSON.parse(event.detail.xhr.responseText).error || '';
+    } catch (e) {
+        // Not a JSON error response
+    }
+    showToast('error', 'Failed to rename', message);
+}
+
 // Delete Modal
 let deleteItems = [];
 
@@ -456,7 +539,7 @@ async function confirmDelete() {
                 setTimeout(() => item.remove(), 300);
             }
 
-            showToast('success', 'Deleted successfully');
+            showToast('success', 'Moved to trash');
         } else {
             // Batch delete
             for (const itemId of deleteItems) {
@@ -474,7 +557,7 @@ async function confirmDelete() {
                 }
             }
 
-            showToast('success', `${deleteItems.length} items deleted`);
+            showToast('success', `${deleteItems.length} items moved to trash`);
         }
 
         clearSelection();
@@ -501,12 +584,50 @@ function openMoveModal(id, type) {
     if (itemTypeField) itemTypeField.value = type;
     if (itemNameSpan) itemNameSpan.textContent = name;
 
-    // Refresh directory tree
-    htmx.trigger('#move-directory-tree', 'revealed');
+    // Nothing is selected until the tree is loaded
+    document.getElementById('move-target-id').value = '';
+    document.getElementById('move-confirm-btn').disabled = true;
+    loadMoveDirectoryTree(type === 'directory' ? id : null);
 
     modal.classList.remove('hidden');
 }
 
+async function loadMoveDirectoryTree(excludeId) {
+    const tree = document.getElementById('move-directory-tree');
+    if (!tree) return;
+
+    try {
+        const response = await fetch('/api/directories/tree');
+        if (!response.ok) {
+            throw new Error('Failed to load folders');
+        }
+        const data = await response.json();
+
+        // A folder can't be moved into itself or anything below it
+        const excludedDir = data.directories.find(dir => dir.id === excludeId);
+        const fullPath = dir => (dir.path && dir.path !== '/') ? `${dir.path}/${dir.name}` : dir.name;
+        const excluded = dir => excludedDir && (dir.id === excludeId ||
+            fullPath(dir).startsWith(fullPath(excludedDir) + '/'));
+
+        const option = (id, label, depth) => `
+            <button type="button"
+                    class="move-directory-option w-full text-left px-3 py-2 text-sm text-gray-700 rounded-md border border-transparent hover:bg-gray-50"
+                    style="padding-left: ${0.75 + depth}rem"
+                    data-id="${escapeHtml(id)}"
+                    onclick="selectMoveTarget(this.dataset.id)">
+                ${escapeHtml(label)}
+            </button>`;
+
+        tree.innerHTML = option('', 'Home', 0) + data.directories
+            .filter(dir => !excluded(dir))
+            .map(dir => option(dir.id, dir.name, fullPath(dir).split('/').length))
+            .join('');
+    } catch (error) {
+        console.error('Folder tree error:', error);
+        tree.innerHTML = `<p class="text-sm text-red-600 p-2">${escapeHtml(error.message)}</p>`;
+    }
+}
+
 function closeMoveModal() {
     const modal = document.getElementById('move-modal');
     if (modal) modal.classList.add('hidden');
@@ -531,23 +652,25 @@ async function confirmMove() {
     const itemType = document.getElementById('move-item-type').value;
     const targetId = document.getElementById('move-target-id').value;
 
-    if (!itemId || !targetId) return;
+    // An empty target is the root folder, so check that one was picked
+    if (!itemId || document.getElementById('move-confirm-btn').disabled) return;
 
     closeMoveModal();
 
     try {
         const endpoint = itemType === 'directory'
-            ? `/api/directories/${itemId}/move`
-            : `/api/files/${itemId}/move`;
+            ? `/api/directories/${itemId}`
+            : `/api/files/${itemId}`;
 
         const response = await fetch(endpoint, {
             method: 'PATCH',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ target_directory_id: targetId })
+            body: JSON.stringify({ parent_directory: targetId })
         });
 
         if (!response.ok) {
-            throw new Error('Failed to move');
+            const data = await response.json().catch(() => ({}));
+            throw new Error(data.error || 'Failed to move');
         }
 
         // Remove item from current view
@@ -638,6 +761,7 @@ async function openFileDetailsModal(id, type) {
     }
 
     modal.classList.remove('hidden');
+    loadDetailsTags();
 
     // Fetch detailed info from API
     try {
@@ -694,6 +818,118 @@ function updateFileDetails(data, type) {
     }
 }
 
+// Tags on the item in the details modal
+function detailsTagsUrl() {
+    const id = document.getElementById('details-item-id').value;
+    const type = document.getElementById('details-item-type').value;
+    return type === 'directory'
+        ? `/api/directories/${encodeURIComponent(id)}/tags`
+        : `/api/files/${encodeURIComponent(id)}/tags`;
+}
+
+async function loadDetailsTags() {
+    const input = document.getElementById('details-tag-input');
+    if (input) input.value = '';
+    renderDetailsTags([]);
+
+    try {
+        const response = await fetch(detailsTagsUrl());
+        if (response.ok) {
+            const data = await response.json();
+            renderDetailsTags(data.tags);
+        }
+    } catch (error) {
+        console.error('Failed to fetch tags:', error);
+    }
+}
+
+function renderDetailsTags(tags) {
+    const container = document.getElementById('details-tags');
+    if (!container) return;
+
+    if (tags.length === 0) {
+        container.innerHTML = '<span class="text-sm text-gray-400">No tags</span>';
+        return;
+    }
+    container.innerHTML = tags.map(tag => `
+        <span class="inline-flex items-center px-2 py-0.5 rounded-full text-xs font-medium ${tag.color ? 'text-white' : 'bg-gray-100 text-gray-700'}"
+              ${tag.color ? `style="background-color: ${escapeHtml(tag.color)}"` : ''}>
+            ${escapeHtml(tag.name)}
+            <button type="button"
+                    class="ml-1 hover:opacity-75"
+                    aria-label="Remove tag ${escapeHtml(tag.name)}"
+                    onclick="removeTagFromDetails('${escapeHtml(tag.id)}')">&times;</button>
+        </span>`).join('');
+}
+
+async function addTagFromDetails() {
+    const input = document.getElementById('details-tag-input');
+    const name = input?.value.trim();
+    if (!name) return;
+
+    try {
+        const response = await fetch(detailsTagsUrl(), {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name })
+        });
+        if (!response.ok) {
+            const data = await response.json().catch(() => ({}));
+            throw new Error(data.error || 'Request failed');
+        }
+        input.value = '';
+        await loadDetailsTags();
+        refreshFileList();
+    } catch (error) {
+        showToast('error', 'Failed to add tag', error.message);
+    }
+}
+
+async function removeTagFromDetails(tagId) {
+    try {
+        const response = await fetch(`${detailsTagsUrl()}/${encodeURIComponent(tagId)}`, { method: 'DELETE' });
+        if (!response.ok) {
+            throw new Error('Request failed');
+        }
+        await loadDetailsTags();
+        refreshFileList();
+    } catch (error) {
+        showToast('error', 'Failed to remove tag', error.message);
+    }
+}
+
+// Tag autocomplete: offers the user's tags starting with what was typed
+let tagSuggestTimer = null;
+
+function suggestTags(prefix) {
+    clearTimeout(tagSuggestTimer);
+    tagSuggestTimer = setTimeout(async () => {
+        const list = document.getElementById('details-tag-suggestions');
+        if (!list) return;
+        try {
+            const response = await fetch(`/api/tags?limit=10&q=${encodeURIComponent(prefix.trim())}`);
+            if (!response.ok) return;
+            const data = await response.json();
+            list.innerHTML = data.tags.map(tag => `<option value="${escapeHtml(tag.name)}"></option>`).join('');
+        } catch (error) {
+            console.error('Failed to fetch tag suggestions:', error);
+        }
+    }, 200);
+}
+
+// Lists the items in the current directory with a tag. The listing comes
+// back as HTML, and its sort headers and next pages keep the tag
+function filterByTag(tag) {
+    const params = new URLSearchParams({ tag });
+    if (fileBrowserState.currentDirectory) {
+        params.set('directory_id', fileBrowserState.currentDirectory);
+    }
+    htmx.ajax('GET', `/api/directories?${params}`, {
+        target: '#file-list-container',
+        swap: 'innerHTML'
+    });
+}
+
 function closeFileDetailsModal() {
     const modal = document.getElementById('file-details-modal');
     if (modal) modal.classList.add('hidden');
@@ -836,21 +1072,61 @@ function initDragAndDrop() {
         event.preventDefault();
     });
 
-    fileBrowser.addEventListener('drop', function(event) {
+    fileBrowser.addEventListener('drop', async function(event) {
         event.preventDefault();
         dragCounter = 0;
         if (overlay) overlay.classList.add('hidden');
 
-        const files = event.dataTransfer.files;
+        const files = await filesFromDataTransfer(event.dataTransfer);
         if (files.length > 0) {
             handleDroppedFiles(files);
         }
     });
 }
 
+// Collect the files in a drop, walking into dropped folders. Files found
+// in folders remember their path as uploadPath, so the folders are
+// recreated on upload.
+async function filesFromDataTransfer(dataTransfer) {
+    const entries = Array.from(dataTransfer.items || [])
+        .map(item => item.webkitGetAsEntry && item.webkitGetAsEntry())
+        .filter(Boolean);
+    if (entries.length === 0) {
+        return Array.from(dataTransfer.files);
+    }
+
+    const files = [];
+    async function walk(entry, path) {
+        if (entry.isFile) {
+            const file = await new Promise((resolve, reject) => entry.file(resolve, reject));
+            if (path) file.uploadPath = path + file.name;
+            files.push(file);
+        } else if (entry.isDirectory) {
+            const reader = entry.createReader();
+            // readEntries returns a batch at a time until it returns none
+            let batch;
+            do {
+                batch = await new Promise((resolve, reject) => reader.readEntries(resolve, reject));
+                for (const child of batch) {
+                    await walk(child, path + entry.name + '/');
+                }
+            } while (batch.length > 0);
+        }
+    }
+    for (const entry of entries) {
+        await walk(entry, '');
+    }
+    return files;
+}
+
+// The path of a file inside an uploaded folder, empty for single files
+function relativePathOf(file) {
+    return file.uploadPath || file.webkitRelativePath || '';
+}
+
 function handleDroppedFiles(files) {
     openUploadModal();
-    addFilesToUpload(Array.from(files));
+    addFilesToUpload(files);
 }
 
 function handleDragOver(event) {
@@ -863,13 +1139,13 @@ function handleDragLeave(event) {
     event.currentTarget.classList.remove('border-primary', 'bg-blue-50');
 }
 
-function handleDrop(event) {
+async function handleDrop(event) {
     event.preventDefault();
     event.currentTarget.classList.remove('border-primary', 'bg-blue-50');
 
-    const files = event.dataTransfer.files;
+    const files = await filesFromDataTransfer(event.dataTransfer);
     if (files.length > 0) {
-        addFilesToUpload(Array.from(files));
+        addFilesToUpload(files);
     }
 }
 
@@ -901,7 +1177,7 @@ function updateUploadFileList() {
                 <svg class="h-5 w-5 text-gray-400 mr-2 flex-shrink-0" fill="none" stroke="currentColor" viewBox="0 0 24 24">
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M9 12h6m-6 4h6m2 5H7a2 2 0 01-2-2V5a2 2 0 012-2h5.586a1 1 0 01.707.293l5.414 5.414a1 1 0 01.293.707V19a2 2 0 01-2 2z"></path>
                 </svg>
-                <span class="text-sm text-gray-700 truncate">${escapeHtml(file.name)}</span>
+                <span class="text-sm text-gray-700 truncate">${escapeHtml(relativePathOf(file) || file.name)}</span>
                 <span class="text-xs text-gray-500 ml-2">(${formatFileSize(file.size)})</span>
             </div>
             <button type="button" onclick="removeUploadFile(${index})" class="text-gray-400 hover:text-red-500 ml-2">
@@ -945,19 +1221,35 @@ async function startUpload() {
 
     for (const file of fileBrowserState.pendingUploadFiles) {
         try {
-            const formData = new FormData();
-            formData.append('file', file);
-            if (fileBrowserState.currentDirectory) {
-                formData.append('parent_directory', fileBrowserState.currentDirectory);
-            }
+            const extract = document.getElementById('upload-extract')?.checked && isArchive(file.name);
+            const relativePath = relativePathOf(file);
+
+            // Prefer sending the file straight to storage, falling back to
+            // posting it through the server when that isn't available or
+            // the file's folders need creating
+            const uploadedDirect = !extract && !relativePath && fileBrowserState.directUploadsAvailable && await uploadFileDirect(file);
+
+            if (extract) {
+                await uploadArchive(file);
+            } else if (!uploadedDirect) {
+                const formData = new FormData();
+                formData.append('file', file);
+                if (fileBrowserState.currentDirectory) {
+                    formData.append('directory_id', fileBrowserState.currentDirectory);
+                }
+                if (relativePath) {
+                    formData.append('relative_path', relativePath);
+                }
 
-            const response = await fetch('/api/files/upload', {
-                method: 'POST',
-                body: formData
-            });
+                const response = await fetch('/api/files/upload', {
+                    method: 'POST',
+                    body: formData
+                });
 
-            if (!response.ok) {
-                throw new Error(`Failed to upload ${file.name}`);
+                if (!response.ok) {
+                    const data = await response.json().catch(() => ({}));
+                    throw new Error(data.error || `Failed to upload ${file.name}`);
+                }
             }
 
             uploadedCount++;
@@ -983,6 +1275,106 @@ async function startUpload() {
     closeUploadModal();
 }
 
+function isArchive(name) {
+    return /\.(zip|tar|tar\.gz|tgz)$/i.test(name);
+}
+
+// Upload an archive to be extracted into the current directory, reporting
+// any entries the server skipped
+async function uploadArchive(file) {
+    const formData = new FormData();
+    formData.append('file', file);
+    formData.append('directory_id', fileBrowserState.currentDirectory || '');
+
+    const response = await fetch('/api/files/extract', {
+        method: 'POST',
+        body: formData
+    });
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        throw new Error(data.error || `Failed to extract ${file.name}`);
+    }
+
+    const skipped = data.result?.skipped || [];
+    if (skipped.length > 0) {
+        const details = skipped.slice(0, 5).map(entry => `${entry.name}: ${entry.reason}`).join('\n');
+        showToast('warning', `${skipped.length} item(s) in ${file.name} were skipped`, details);
+    }
+}
+
+// Upload a file straight to storage as a presigned multipart upload.
+// Returns false if direct uploads aren't available on this server.
+async function uploadFileDirect(file) {
+    const createResponse = await fetch('/api/uploads', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            file_name: file.name,
+            size: file.size,
+            mime_type: file.type,
+            directory_id: fileBrowserState.currentDirectory || ''
+        })
+    });
+
+    if (createResponse.status === 501 || createResponse.status === 404) {
+        fileBrowserState.directUploadsAvailable = false;
+        return false;
+    }
+    if (!createResponse.ok) {
+        const data = await createResponse.json().catch(() => ({}));
+        throw new Error(data.error || `Failed to upload ${file.name}`);
+    }
+
+    const { session, parts } = await createResponse.json();
+
+    try {
+        const completed = [];
+        for (const part of parts) {
+            const start = (part.part_number - 1) * session.part_size;
+            const blob = file.slice(start, Math.min(start + session.part_size, file.size));
+
+            let partResponse = await fetch(part.url, { method: 'PUT', body: blob });
+            if (partResponse.status === 403) {
+                // The presigned URL may have expired on a long upload
+                const refreshResponse = await fetch(`/api/uploads/${session.id}/parts`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ part_numbers: [part.part_number] })
+                });
+                if (refreshResponse.ok) {
+                    const refreshed = await refreshResponse.json();
+                    partResponse = await fetch(refreshed.parts[0].url, { method: 'PUT', body: blob });
+                }
+            }
+            if (!partResponse.ok) {
+                throw new Error(`Failed to upload part ${part.part_number} of ${file.name}`);
+            }
+
+            const etag = partResponse.headers.get('ETag');
+            if (!etag) {
+                throw new Error('Storage did not return an ETag; check the bucket CORS configuration');
+            }
+            completed.push({ part_number: part.part_number, etag: etag });
+        }
+
+        const completeResponse = await fetch(`/api/uploads/${session.id}/complete`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ parts: completed })
+        });
+        if (!completeResponse.ok) {
+            const data = await completeResponse.json().catch(() => ({}));
+            throw new Error(data.error || `Failed to upload ${file.name}`);
+        }
+    } catch (error) {
+        // Don't leave the parts behind in storage
+        fetch(`/api/uploads/${session.id}`, { method: 'DELETE' }).catch(() => {});
+        throw error;
+    }
+
+    return true;
+}
+
 // ============================================
 // Keyboard Shortcuts
 // ============================================
@@ -1078,6 +1470,7 @@ window.closeContextMenu = closeContextMenu;
 window.contextMenuAction = contextMenuAction;
 window.downloadFile = downloadFile;
 window.navigateToDirectory = navigateToDirectory;
+window.toggleStar = toggleStar;
 window.downloadSelected = downloadSelected;
 window.deleteSelected = deleteSelected;
 window.openNewFolderModal = openNewFolderModal;
@@ -1100,6 +1493,10 @@ window.openFileDetailsModal = openFileDetailsModal;
 window.closeFileDetailsModal = closeFileDetailsModal;
 window.openDirectoryFromDetails = openDirectoryFromDetails;
 window.shareFromDetails = shareFromDetails;
+window.addTagFromDetails = addTagFromDetails;
+window.removeTagFromDetails = removeTagFromDetails;
+window.suggestTags = suggestTags;
+window.filterByTag = filterByTag;
 window.setViewMode = setViewMode;
 window.clearSearch = clearSearch;
 window.handleDragOver = handleDragOver;
@@ -165,6 +165,22 @@ func (m *SessionManager) RequireAuth() gin.HandlerFunc {
 	}
 }
 
+// OptionalAuth is middleware that loads the session when there is one but
+// lets anonymous requests through, for routes that also accept share tokens
+func (m *SessionManager) OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims, err := m.GetClaims(c); err == nil {
+			c.Set("user_id", claims.UserID)
+			c.Set("email", claims.Email)
+			c.Set("username", claims.Username)
+			c.Set("is_admin", claims.IsAdmin)
+			c.Set("claims", claims)
+		}
+
+		c.Next()
+	}
+}
+
 // RequireAdmin is middleware that requires admin privileges
 func (m *SessionManager) RequireAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -10,10 +10,21 @@ import (
 	"github.com/spf13/viper"
 )
 
+// Supported storage backends
+const (
+	StorageBackendS3    = "s3"
+	StorageBackendLocal = "local"
+)
+
 // Config holds all application configuration.
 // Values can be set via YAML file or environment variables.
 // Environment variables take precedence over YAML values.
 type Config struct {
+	// Storage Backend Configuration
+	StorageBackend     string `mapstructure:"storage_backend"`      // "s3" or "local"
+	LocalStoragePath   string `mapstructure:"local_storage_path"`   // Base directory for the local backend
+	LocalStorageSecret string `mapstructure:"local_storage_secret"` // Signing key for local presigned URLs
+
 	// S3 Configuration
 	S3Endpoint  string `mapstructure:"s3_endpoint"`
 	S3Region    string `mapstructure:"s3_region"`
@@ -22,6 +33,11 @@ type Config struct {
 	S3SecretKey string `mapstructure:"s3_secret_key"`
 	S3UseSSL    bool   `mapstructure:"s3_use_ssl"`
 
+	S3UploadConcurrency int   `mapstructure:"s3_upload_concurrency"` // Parts uploaded in parallel per multipart upload
+	S3UploadPartSize    int64 `mapstructure:"s3_upload_part_size"`   // Multipart upload part size in bytes
+	S3MaxRetries        int   `mapstructure:"s3_max_retries"`        // Retries for transient S3 errors, 0 disables them
+	S3RetryBaseDelay    int   `mapstructure:"s3_retry_base_delay"`   // Initial retry backoff in milliseconds, doubled per attempt
+
 	// Application Configuration
 	AppPort        string `mapstructure:"app_port"`
 	AppEnvironment string `mapstructure:"app_environment"`
@@ -31,7 +47,29 @@ type Config struct {
 	DBPath string `mapstructure:"db_path"`
 
 	// Upload Configuration
-	MaxUploadSize int64 `mapstructure:"max_upload_size"` // in bytes
+	MaxUploadSize       int64  `mapstructure:"max_upload_size"`       // in bytes
+	DirectUploadEnabled bool   `mapstructure:"direct_upload_enabled"` // Let browsers upload parts straight to storage
+	UploadSessionTTL    int    `mapstructure:"upload_session_ttl"`    // Hours before an unfinished direct upload is aborted
+	TusUploadTTL        int    `mapstructure:"tus_upload_ttl"`        // Hours before an unfinished resumable upload is discarded
+	TusStagingPath      string `mapstructure:"tus_staging_path"`      // Directory holding resumable upload bytes that don't fill a part yet
+
+	// Archive Extraction Configuration
+	ExtractMaxEntries int   `mapstructure:"extract_max_entries"` // Most entries an uploaded archive may hold
+	ExtractMaxSize    int64 `mapstructure:"extract_max_size"`    // Most bytes an uploaded archive may expand to
+
+	// Download Configuration
+	DownloadRedirect  bool `mapstructure:"download_redirect"`   // Redirect downloads to presigned storage URLs
+	DownloadURLExpiry int  `mapstructure:"download_url_expiry"` // Presigned download URL lifetime in minutes
+
+	// Integrity Configuration
+	VerifyChecksumOnDownload bool `mapstructure:"verify_checksum_on_download"` // Re-hash downloads and report corruption
+	DedupEnabled             bool `mapstructure:"dedup_enabled"`               // Store identical content once, keyed by SHA-256
+
+	// Encryption Configuration
+	EncryptionEnabled      bool   `mapstructure:"encryption_enabled"`       // Encrypt stored files with per-file data keys
+	EncryptionKey          string `mapstructure:"encryption_key"`           // Base64 256-bit master key
+	EncryptionKeyFile      string `mapstructure:"encryption_key_file"`      // File with base64 master keys, one per line, current first
+	EncryptionPreviousKeys string `mapstructure:"encryption_previous_keys"` // Comma-separated retired master keys, kept for unwrapping
 
 	// Security Configuration
 	JWTSecret string `mapstructure:"jwt_secret"`
@@ -44,6 +82,20 @@ type Config struct {
 	// User Quota Configuration
 	DefaultUserQuota int64 `mapstructure:"default_user_quota"` // in bytes, 0 means unlimited
 
+	// Trash Configuration
+	TrashRetentionDays     int  `mapstructure:"trash_retention_days"`      // Days trashed items are kept before being purged, 0 keeps them until emptied
+	TrashCountsTowardQuota bool `mapstructure:"trash_counts_toward_quota"` // Whether trashed files still count toward their owner's quota
+
+	// Versioning Configuration
+	VersioningEnabled     bool `mapstructure:"versioning_enabled"`      // Keep re-uploads of a file as new versions instead of separate files
+	VersionRetentionCount int  `mapstructure:"version_retention_count"` // Older versions kept per file, 0 keeps all
+	VersionRetentionDays  int  `mapstructure:"version_retention_days"`  // Days an older version is kept after being replaced, 0 keeps it indefinitely
+
+	// Content Indexing Configuration
+	ContentIndexEnabled bool  `mapstructure:"content_index_enabled"`  // Index the text inside text-like files so search can match it
+	ContentIndexMaxSize int64 `mapstructure:"content_index_max_size"` // Largest file whose text is indexed, in bytes
+	ContentIndexPDF     bool  `mapstructure:"content_index_pdf"`      // Also index the text of PDFs
+
 	// TLS Configuration
 	TLSEnabled  bool   `mapstructure:"tls_enabled"`   // Enable HTTPS
 	TLSPort     string `mapstructure:"tls_port"`      // HTTPS port (default: 443)
@@ -114,6 +166,11 @@ func LoadWithPath(configPath string) (*Config, error) {
 
 // bindEnvVars explicitly binds environment variables to config keys
 func bindEnvVars(v *viper.Viper) {
+	// Storage Backend Configuration
+	v.BindEnv("storage_backend", "STORAGE_BACKEND")
+	v.BindEnv("local_storage_path", "LOCAL_STORAGE_PATH")
+	v.BindEnv("local_storage_secret", "LOCAL_STORAGE_SECRET")
+
 	// S3 Configuration
 	v.BindEnv("s3_endpoint", "S3_ENDPOINT")
 	v.BindEnv("s3_region", "S3_REGION")
@@ -121,6 +178,10 @@ func bindEnvVars(v *viper.Viper) {
 	v.BindEnv("s3_access_key", "S3_ACCESS_KEY")
 	v.BindEnv("s3_secret_key", "S3_SECRET_KEY")
 	v.BindEnv("s3_use_ssl", "S3_USE_SSL")
+	v.BindEnv("s3_upload_concurrency", "S3_UPLOAD_CONCURRENCY")
+	v.BindEnv("s3_upload_part_size", "S3_UPLOAD_PART_SIZE")
+	v.BindEnv("s3_max_retries", "S3_MAX_RETRIES")
+	v.BindEnv("s3_retry_base_delay", "S3_RETRY_BASE_DELAY")
 
 	// Application Configuration
 	v.BindEnv("app_port", "APP_PORT")
@@ -132,6 +193,28 @@ func bindEnvVars(v *viper.Viper) {
 
 	// Upload Configuration
 	v.BindEnv("max_upload_size", "MAX_UPLOAD_SIZE")
+	v.BindEnv("direct_upload_enabled", "DIRECT_UPLOAD_ENABLED")
+	v.BindEnv("upload_session_ttl", "UPLOAD_SESSION_TTL")
+	v.BindEnv("tus_upload_ttl", "TUS_UPLOAD_TTL")
+	v.BindEnv("tus_staging_path", "TUS_STAGING_PATH")
+
+	// Archive Extraction Configuration
+	v.BindEnv("extract_max_entries", "EXTRACT_MAX_ENTRIES")
+	v.BindEnv("extract_max_size", "EXTRACT_MAX_SIZE")
+
+	// Download Configuration
+	v.BindEnv("download_redirect", "DOWNLOAD_REDIRECT")
+	v.BindEnv("download_url_expiry", "DOWNLOAD_URL_EXPIRY")
+
+	// Integrity Configuration
+	v.BindEnv("verify_checksum_on_download", "VERIFY_CHECKSUM_ON_DOWNLOAD")
+	v.BindEnv("dedup_enabled", "DEDUP_ENABLED")
+
+	// Encryption Configuration
+	v.BindEnv("encryption_enabled", "ENCRYPTION_ENABLED")
+	v.BindEnv("encryption_key", "ENCRYPTION_KEY")
+	v.BindEnv("encryption_key_file", "ENCRYPTION_KEY_FILE")
+	v.BindEnv("encryption_previous_keys", "ENCRYPTION_PREVIOUS_KEYS")
 
 	// Security Configuration
 	v.BindEnv("jwt_secret", "JWT_SECRET")
@@ -144,6 +227,20 @@ func bindEnvVars(v *viper.Viper) {
 	// User Quota Configuration
 	v.BindEnv("default_user_quota", "DEFAULT_USER_QUOTA")
 
+	// Trash Configuration
+	v.BindEnv("trash_retention_days", "TRASH_RETENTION_DAYS")
+	v.BindEnv("trash_counts_toward_quota", "TRASH_COUNTS_TOWARD_QUOTA")
+
+	// Versioning Configuration
+	v.BindEnv("versioning_enabled", "VERSIONING_ENABLED")
+	v.BindEnv("version_retention_count", "VERSION_RETENTION_COUNT")
+	v.BindEnv("version_retention_days", "VERSION_RETENTION_DAYS")
+
+	// Content Indexing Configuration
+	v.BindEnv("content_index_enabled", "CONTENT_INDEX_ENABLED")
+	v.BindEnv("content_index_max_size", "CONTENT_INDEX_MAX_SIZE")
+	v.BindEnv("content_index_pdf", "CONTENT_INDEX_PDF")
+
 	// TLS Configuration
 	v.BindEnv("tls_enabled", "TLS_ENABLED")
 	v.BindEnv("tls_port", "TLS_PORT")
@@ -160,9 +257,17 @@ func bindEnvVars(v *viper.Viper) {
 
 // setDefaults sets default values for all configuration options
 func setDefaults(v *viper.Viper) {
+	// Storage Backend Configuration
+	v.SetDefault("storage_backend", StorageBackendS3)
+	v.SetDefault("local_storage_path", "./storage")
+
 	// S3 Configuration
 	v.SetDefault("s3_region", "us-east-1")
 	v.SetDefault("s3_use_ssl", true)
+	v.SetDefault("s3_upload_concurrency", 4)
+	v.SetDefault("s3_upload_part_size", 10*1024*1024) // 10MB
+	v.SetDefault("s3_max_retries", 3)
+	v.SetDefault("s3_retry_base_delay", 100)
 
 	// Application Configuration
 	v.SetDefault("app_port", "8090")
@@ -174,6 +279,25 @@ func setDefaults(v *viper.Viper) {
 
 	// Upload Configuration
 	v.SetDefault("max_upload_size", 100*1024*1024) // 100MB
+	v.SetDefault("direct_upload_enabled", false)
+	v.SetDefault("upload_session_ttl", 24)
+	v.SetDefault("tus_upload_ttl", 24)
+	v.SetDefault("tus_staging_path", "./tus-uploads")
+
+	// Archive Extraction Configuration
+	v.SetDefault("extract_max_entries", 10000)
+	v.SetDefault("extract_max_size", 10*1024*1024*1024) // 10GB
+
+	// Download Configuration
+	v.SetDefault("download_redirect", false)
+	v.SetDefault("download_url_expiry", 5)
+
+	// Integrity Configuration
+	v.SetDefault("verify_checksum_on_download", false)
+	v.SetDefault("dedup_enabled", false)
+
+	// Encryption Configuration
+	v.SetDefault("encryption_enabled", false)
 
 	// Feature Flags
 	v.SetDefault("public_registration", true)
@@ -183,6 +307,20 @@ func setDefaults(v *viper.Viper) {
 	// User Quota Configuration
 	v.SetDefault("default_user_quota", 10*1024*1024*1024) // 10GB
 
+	// Trash Configuration
+	v.SetDefault("trash_retention_days", 30)
+	v.SetDefault("trash_counts_toward_quota", true)
+
+	// Versioning Configuration
+	v.SetDefault("versioning_enabled", false)
+	v.SetDefault("version_retention_count", 10)
+	v.SetDefault("version_retention_days", 0)
+
+	// Content Indexing Configuration
+	v.SetDefault("content_index_enabled", false)
+	v.SetDefault("content_index_max_size", 10485760) // 10MB
+	v.SetDefault("content_index_pdf", false)
+
 	// TLS Configuration
 	v.SetDefault("tls_enabled", false)
 	v.SetDefault("tls_port", "443")
@@ -197,18 +335,40 @@ func setDefaults(v *viper.Viper) {
 func (c *Config) Validate() error {
 	var errs []error
 
-	// Required S3 Configuration
-	if c.S3Endpoint == "" {
-		errs = append(errs, errors.New("S3_ENDPOINT is required"))
-	}
-	if c.S3Bucket == "" {
-		errs = append(errs, errors.New("S3_BUCKET is required"))
-	}
-	if c.S3AccessKey == "" {
-		errs = append(errs, errors.New("S3_ACCESS_KEY is required"))
-	}
-	if c.S3SecretKey == "" {
-		errs = append(errs, errors.New("S3_SECRET_KEY is required"))
+	// Validate storage backend
+	switch c.StorageBackend {
+	case StorageBackendS3, "":
+		// Required S3 Configuration
+		if c.S3Endpoint == "" {
+			errs = append(errs, errors.New("S3_ENDPOINT is required"))
+		}
+		if c.S3Bucket == "" {
+			errs = append(errs, errors.New("S3_BUCKET is required"))
+		}
+		if c.S3AccessKey == "" {
+			errs = append(errs, errors.New("S3_ACCESS_KEY is required"))
+		}
+		if c.S3SecretKey == "" {
+			errs = append(errs, errors.New("S3_SECRET_KEY is required"))
+		}
+		if c.S3UploadConcurrency < 1 {
+			errs = append(errs, errors.New("S3_UPLOAD_CONCURRENCY must be at least 1"))
+		}
+		if c.S3UploadPartSize < 5*1024*1024 || c.S3UploadPartSize > 5*1024*1024*1024 {
+			errs = append(errs, errors.New("S3_UPLOAD_PART_SIZE must be between 5MB and 5GB"))
+		}
+		if c.S3MaxRetries < 0 {
+			errs = append(errs, errors.New("S3_MAX_RETRIES cannot be negative"))
+		}
+		if c.S3MaxRetries > 0 && c.S3RetryBaseDelay < 1 {
+			errs = append(errs, errors.New("S3_RETRY_BASE_DELAY must be at least 1 millisecond"))
+		}
+	case StorageBackendLocal:
+		if c.LocalStoragePath == "" {
+			errs = append(errs, errors.New("LOCAL_STORAGE_PATH is required when STORAGE_BACKEND is local"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_BACKEND must be %q or %q", StorageBackendS3, StorageBackendLocal))
 	}
 
 	// Validate JWT Secret in production
@@ -226,6 +386,70 @@ func (c *Config) Validate() error {
 		errs = append(errs, errors.New("MAX_UPLOAD_SIZE must be greater than 0"))
 	}
 
+	// Validate direct uploads, which need presigned part URLs from S3
+	if c.DirectUploadEnabled && c.StorageBackend == StorageBackendLocal {
+		errs = append(errs, errors.New("DIRECT_UPLOAD_ENABLED is not supported when STORAGE_BACKEND is local"))
+	}
+	if c.DirectUploadEnabled && c.UploadSessionTTL <= 0 {
+		errs = append(errs, errors.New("UPLOAD_SESSION_TTL must be greater than 0"))
+	}
+
+	// Validate resumable upload lifetime
+	if c.TusUploadTTL <= 0 {
+		errs = append(errs, errors.New("TUS_UPLOAD_TTL must be greater than 0"))
+	}
+	if c.TusStagingPath == "" {
+		errs = append(errs, errors.New("TUS_STAGING_PATH cannot be empty"))
+	}
+
+	// Validate archive extraction limits
+	if c.ExtractMaxEntries <= 0 {
+		errs = append(errs, errors.New("EXTRACT_MAX_ENTRIES must be greater than 0"))
+	}
+	if c.ExtractMaxSize <= 0 {
+		errs = append(errs, errors.New("EXTRACT_MAX_SIZE must be greater than 0"))
+	}
+
+	// Validate trash retention
+	if c.TrashRetentionDays < 0 {
+		errs = append(errs, errors.New("TRASH_RETENTION_DAYS cannot be negative"))
+	}
+
+	// Validate version retention
+	if c.VersionRetentionCount < 0 {
+		errs = append(errs, errors.New("VERSION_RETENTION_COUNT cannot be negative"))
+	}
+	if c.VersionRetentionDays < 0 {
+		errs = append(errs, errors.New("VERSION_RETENTION_DAYS cannot be negative"))
+	}
+
+	// Validate content indexing
+	if c.ContentIndexEnabled && c.ContentIndexMaxSize <= 0 {
+		errs = append(errs, errors.New("CONTENT_INDEX_MAX_SIZE must be greater than 0"))
+	}
+
+	// Validate presigned download lifetime
+	if c.DownloadRedirect && (c.DownloadURLExpiry < 1 || c.DownloadURLExpiry > 60) {
+		errs = append(errs, errors.New("DOWNLOAD_URL_EXPIRY must be between 1 and 60 minutes"))
+	}
+
+	// Validate encryption. Storage only ever sees ciphertext, so features
+	// that hand storage URLs to clients can't be combined with it.
+	if c.EncryptionEnabled {
+		if c.EncryptionKey == "" && c.EncryptionKeyFile == "" {
+			errs = append(errs, errors.New("ENCRYPTION_KEY or ENCRYPTION_KEY_FILE is required when encryption is enabled"))
+		}
+		if c.EncryptionKey != "" && c.EncryptionKeyFile != "" {
+			errs = append(errs, errors.New("only one of ENCRYPTION_KEY and ENCRYPTION_KEY_FILE can be set"))
+		}
+		if c.DownloadRedirect {
+			errs = append(errs, errors.New("DOWNLOAD_REDIRECT cannot be used with encryption"))
+		}
+		if c.DirectUploadEnabled {
+			errs = append(errs, errors.New("DIRECT_UPLOAD_ENABLED cannot be used with encryption"))
+		}
+	}
+
 	// Validate app URL
 	if c.AppURL == "" {
 		errs = append(errs, errors.New("APP_URL is required"))
@@ -272,6 +496,11 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// UsesLocalStorage returns true if files are stored on the local filesystem instead of S3
+func (c *Config) UsesLocalStorage() bool {
+	return c.StorageBackend == StorageBackendLocal
+}
+
 // IsDevelopment returns true if the app is running in development mode
 func (c *Config) IsDevelopment() bool {
 	return c.AppEnvironment == "development"
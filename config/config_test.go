@@ -351,6 +351,229 @@ func TestLoad_WorksWithoutConfigFile(t *testing.T) {
 	assert.Equal(t, "http://minio:9000", cfg.S3Endpoint)
 }
 
+func TestLoad_LocalStorageDoesNotRequireS3(t *testing.T) {
+	// Arrange
+	cleanTestEnv(t)
+	defer cleanTestEnv(t)
+
+	os.Setenv("STORAGE_BACKEND", "local")
+	os.Setenv("LOCAL_STORAGE_PATH", "/var/lib/filesonthego")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.NoError(t, err)
+	require.NotNil(t, cfg)
+	assert.True(t, cfg.UsesLocalStorage())
+	assert.Equal(t, "/var/lib/filesonthego", cfg.LocalStoragePath)
+}
+
+func TestLoad_DefaultStorageBackendIsS3(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.NoError(t, err)
+	require.NotNil(t, cfg)
+	assert.Equal(t, StorageBackendS3, cfg.StorageBackend)
+	assert.False(t, cfg.UsesLocalStorage())
+}
+
+func TestValidate_InvalidStorageBackend(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("STORAGE_BACKEND", "ftp")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "STORAGE_BACKEND")
+}
+
+func TestValidate_DirectUploadNeedsS3(t *testing.T) {
+	// Arrange
+	cleanTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("STORAGE_BACKEND", "local")
+	os.Setenv("LOCAL_STORAGE_PATH", "/var/lib/filesonthego")
+	os.Setenv("DIRECT_UPLOAD_ENABLED", "true")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "DIRECT_UPLOAD_ENABLED")
+
+	// S3 supports them
+	setTestEnv(t)
+	os.Setenv("DIRECT_UPLOAD_ENABLED", "true")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.DirectUploadEnabled)
+}
+
+func TestValidate_DownloadURLExpiry(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("DOWNLOAD_REDIRECT", "true")
+	os.Setenv("DOWNLOAD_URL_EXPIRY", "120")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "DOWNLOAD_URL_EXPIRY")
+
+	// The default expiry is valid
+	os.Unsetenv("DOWNLOAD_URL_EXPIRY")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.DownloadRedirect)
+	assert.Equal(t, 5, cfg.DownloadURLExpiry)
+}
+
+func TestValidate_S3Retries(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("S3_MAX_RETRIES", "-1")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "S3_MAX_RETRIES")
+
+	// Retries are on by default
+	os.Unsetenv("S3_MAX_RETRIES")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, 3, cfg.S3MaxRetries)
+	assert.Equal(t, 100, cfg.S3RetryBaseDelay)
+}
+
+func TestValidate_TrashRetention(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("TRASH_RETENTION_DAYS", "-1")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "TRASH_RETENTION_DAYS")
+
+	// Trash is kept for 30 days and counts toward quota by default
+	os.Unsetenv("TRASH_RETENTION_DAYS")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, 30, cfg.TrashRetentionDays)
+	assert.True(t, cfg.TrashCountsTowardQuota)
+
+	// Zero keeps trashed items until the trash is emptied
+	os.Setenv("TRASH_RETENTION_DAYS", "0")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Zero(t, cfg.TrashRetentionDays)
+}
+
+func TestValidate_VersionRetention(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("VERSION_RETENTION_COUNT", "-1")
+	os.Setenv("VERSION_RETENTION_DAYS", "-1")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "VERSION_RETENTION_COUNT")
+	assert.Contains(t, err.Error(), "VERSION_RETENTION_DAYS")
+
+	// Versioning is off, keeping 10 versions once enabled
+	os.Unsetenv("VERSION_RETENTION_COUNT")
+	os.Unsetenv("VERSION_RETENTION_DAYS")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.False(t, cfg.VersioningEnabled)
+	assert.Equal(t, 10, cfg.VersionRetentionCount)
+	assert.Zero(t, cfg.VersionRetentionDays)
+}
+
+func TestValidate_ExtractLimits(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("EXTRACT_MAX_ENTRIES", "0")
+	os.Setenv("EXTRACT_MAX_SIZE", "-1")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "EXTRACT_MAX_ENTRIES")
+	assert.Contains(t, err.Error(), "EXTRACT_MAX_SIZE")
+
+	os.Unsetenv("EXTRACT_MAX_ENTRIES")
+	os.Unsetenv("EXTRACT_MAX_SIZE")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, 10000, cfg.ExtractMaxEntries)
+	assert.Equal(t, int64(10*1024*1024*1024), cfg.ExtractMaxSize)
+}
+
+func TestValidate_Encryption(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("ENCRYPTION_ENABLED", "true")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "ENCRYPTION_KEY")
+
+	// Download redirects would hand out ciphertext
+	os.Setenv("ENCRYPTION_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	os.Setenv("DOWNLOAD_REDIRECT", "true")
+	cfg, err = Load()
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "DOWNLOAD_REDIRECT")
+
+	os.Unsetenv("DOWNLOAD_REDIRECT")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.EncryptionEnabled)
+}
+
 // Helper function to set up test environment variables
 func setTestEnv(t *testing.T) {
 	t.Helper()
@@ -373,6 +596,31 @@ func setTestEnv(t *testing.T) {
 	os.Setenv("DEFAULT_USER_QUOTA", "10737418240") // 10GB
 }
 
+func TestValidate_ContentIndex(t *testing.T) {
+	// Arrange
+	setTestEnv(t)
+	defer cleanTestEnv(t)
+	os.Setenv("CONTENT_INDEX_ENABLED", "true")
+	os.Setenv("CONTENT_INDEX_MAX_SIZE", "0")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "CONTENT_INDEX_MAX_SIZE")
+
+	// Indexing is off by default, with a 10MB limit and no PDFs
+	os.Unsetenv("CONTENT_INDEX_ENABLED")
+	os.Unsetenv("CONTENT_INDEX_MAX_SIZE")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.False(t, cfg.ContentIndexEnabled)
+	assert.Equal(t, int64(10485760), cfg.ContentIndexMaxSize)
+	assert.False(t, cfg.ContentIndexPDF)
+}
+
 // Helper function to clean up test environment variables
 func cleanTestEnv(t *testing.T) {
 	t.Helper()
@@ -380,6 +628,15 @@ func cleanTestEnv(t *testing.T) {
 		"S3_ENDPOINT", "S3_REGION", "S3_BUCKET", "S3_ACCESS_KEY", "S3_SECRET_KEY", "S3_USE_SSL",
 		"APP_PORT", "APP_ENVIRONMENT", "APP_URL", "DB_PATH", "MAX_UPLOAD_SIZE", "JWT_SECRET",
 		"PUBLIC_REGISTRATION", "EMAIL_VERIFICATION", "DEFAULT_USER_QUOTA",
+		"STORAGE_BACKEND", "LOCAL_STORAGE_PATH", "LOCAL_STORAGE_SECRET",
+		"DOWNLOAD_REDIRECT", "DOWNLOAD_URL_EXPIRY", "TUS_UPLOAD_TTL", "TUS_STAGING_PATH",
+		"DIRECT_UPLOAD_ENABLED", "UPLOAD_SESSION_TTL",
+		"TRASH_RETENTION_DAYS", "TRASH_COUNTS_TOWARD_QUOTA",
+		"VERSIONING_ENABLED", "VERSION_RETENTION_COUNT", "VERSION_RETENTION_DAYS",
+		"EXTRACT_MAX_ENTRIES", "EXTRACT_MAX_SIZE",
+		"CONTENT_INDEX_ENABLED", "CONTENT_INDEX_MAX_SIZE", "CONTENT_INDEX_PDF",
+		"S3_MAX_RETRIES", "S3_RETRY_BASE_DELAY",
+		"ENCRYPTION_ENABLED", "ENCRYPTION_KEY", "ENCRYPTION_KEY_FILE", "ENCRYPTION_PREVIOUS_KEYS",
 	}
 	for _, v := range envVars {
 		os.Unsetenv(v)
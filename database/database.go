@@ -74,15 +74,31 @@ func AutoMigrate() error {
 	err := DB.AutoMigrate(
 		&models.User{},
 		&models.File{},
+		&models.FileVersion{},
 		&models.Directory{},
 		&models.Share{},
 		&models.ShareAccessLog{},
+		&models.Blob{},
+		&models.UploadSession{},
+		&models.TusUpload{},
+		&models.Tag{},
+		&models.TagLink{},
+		&models.Metadata{},
+		&models.Activity{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to run auto-migration: %w", err)
 	}
 
+	if err := models.MigrateNameIndexes(DB); err != nil {
+		return err
+	}
+
+	if err := models.MigrateSearchIndex(DB); err != nil {
+		return err
+	}
+
 	log.Info().Msg("Database migrations completed successfully")
 
 	return nil
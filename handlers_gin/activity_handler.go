@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ActivityHandler handles a user's recent activity and their starred files
+// and directories
+type ActivityHandler struct {
+	activityService *services.ActivityService
+	fileService     *services.FileService
+	logger          zerolog.Logger
+}
+
+// NewActivityHandler creates a new activity handler
+func NewActivityHandler(
+	activityService *services.ActivityService,
+	fileService *services.FileService,
+	logger zerolog.Logger,
+) *ActivityHandler {
+	return &ActivityHandler{
+		activityService: activityService,
+		fileService:     fileService,
+		logger:          logger,
+	}
+}
+
+// ListActivity lists what the user recently did, newest first
+func (h *ActivityHandler) ListActivity(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	limit, ok := activityLimit(c)
+	if !ok {
+		return
+	}
+
+	activities, err := h.activityService.Recent(userID, limit)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list activity")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list activity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"activity": activities})
+}
+
+// RecentFiles lists the files the user most recently worked with
+func (h *ActivityHandler) RecentFiles(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	limit, ok := activityLimit(c)
+	if !ok {
+		return
+	}
+
+	files, err := h.activityService.RecentFiles(userID, limit)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list recent files")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list recent files"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// ListStarred lists the user's starred directories and files
+func (h *ActivityHandler) ListStarred(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	items, err := h.fileService.ListStarred(userID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list starred items")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list starred items"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// SetStarred returns a handler starring or unstarring a file or directory
+func (h *ActivityHandler) SetStarred(resourceType models.ResourceType, starred bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		itemID := c.Param("id")
+		userID, _ := auth.GetUserID(c)
+
+		if err := h.fileService.SetStarred(userID, resourceType, itemID, starred); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+				return
+			}
+			h.logger.Error().Err(err).Str("item_id", itemID).Msg("Failed to update starred")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update starred"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"id": itemID, "starred": starred})
+	}
+}
+
+// activityLimit parses the ?limit of an activity listing, responding with
+// an error if it isn't valid
+func activityLimit(c *gin.Context) (int, bool) {
+	value := c.Query("limit")
+	if value == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return 0, false
+	}
+	return limit, true
+}
@@ -251,7 +251,8 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := h.userService.DeleteUser(userID); err != nil {
+	failed, err := h.userService.DeleteUser(userID)
+	if err != nil {
 		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to delete user")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -260,8 +261,18 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 	h.logger.Warn().
 		Str("admin_id", adminID).
 		Str("user_id", userID).
+		Int("failed_objects", len(failed)).
 		Msg("User deleted by admin")
 
+	// Objects that couldn't be removed from storage are reported with a 207
+	if len(failed) > 0 {
+		c.JSON(http.StatusMultiStatus, gin.H{
+			"message":        "User deleted, but some stored files could not be removed",
+			"failed_objects": failed,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ArchiveHandler handles downloading directories and selections as ZIP
+// archives
+type ArchiveHandler struct {
+	fileService       *services.FileService
+	permissionService *services.PermissionService
+	shareService      *services.ShareService
+	metricsService    *services.MetricsService
+	logger            zerolog.Logger
+}
+
+// NewArchiveHandler creates a new archive handler
+func NewArchiveHandler(
+	fileService *services.FileService,
+	permissionService *services.PermissionService,
+	shareService *services.ShareService,
+	metricsService *services.MetricsService,
+	logger zerolog.Logger,
+) *ArchiveHandler {
+	return &ArchiveHandler{
+		fileService:       fileService,
+		permissionService: permissionService,
+		shareService:      shareService,
+		metricsService:    metricsService,
+		logger:            logger,
+	}
+}
+
+// ArchiveRequest selects the files and directories to download
+type ArchiveRequest struct {
+	FileIDs      []string `json:"file_ids" form:"file_ids"`
+	DirectoryIDs []string `json:"directory_ids" form:"directory_ids"`
+}
+
+// DownloadDirectory downloads a directory and everything in it as a ZIP
+func (h *ArchiveHandler) DownloadDirectory(c *gin.Context) {
+	directoryID := c.Param("id")
+	h.streamArchive(c, &ArchiveRequest{DirectoryIDs: []string{directoryID}})
+}
+
+// DownloadSelection downloads a selection of files and directories as a ZIP
+func (h *ArchiveHandler) DownloadSelection(c *gin.Context) {
+	var req ArchiveRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if len(req.FileIDs) == 0 && len(req.DirectoryIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing selected"})
+		return
+	}
+
+	h.streamArchive(c, &req)
+}
+
+// streamArchive checks that every selected item can be read, then streams
+// the archive. Once the first byte is sent errors can only be logged, and
+// the client sees a truncated archive.
+func (h *ArchiveHandler) streamArchive(c *gin.Context, req *ArchiveRequest) {
+	userID, _ := auth.GetUserID(c)
+	shareToken := c.Query("share_token")
+
+	for _, dirID := range req.DirectoryIDs {
+		canRead, err := h.permissionService.CanReadDirectory(userID, dirID, shareToken)
+		if err != nil || !canRead {
+			h.denied(c, userID, dirID)
+			return
+		}
+	}
+	for _, fileID := range req.FileIDs {
+		canRead, err := h.permissionService.CanReadFile(userID, fileID, shareToken)
+		if err != nil || !canRead {
+			h.denied(c, userID, fileID)
+			return
+		}
+	}
+
+	entries, err := h.fileService.ArchiveEntries(req.DirectoryIDs, req.FileIDs)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list archive contents")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create archive"})
+		return
+	}
+
+	// A single directory is named after itself
+	name := "download.zip"
+	if len(req.DirectoryIDs) == 1 && len(req.FileIDs) == 0 {
+		name = entries[0].Name[:len(entries[0].Name)-1] + ".zip"
+	}
+
+	if shareToken != "" {
+		h.logShareAccess(c, shareToken, name)
+	}
+
+	c.Header("Content-Disposition", attachmentDisposition(name))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	written, err := h.fileService.WriteArchive(c.Request.Context(), c.Writer, entries)
+	h.metricsService.RecordFileDownload(written)
+	if err != nil {
+		h.logger.Error().
+			Err(err).
+			Str("user_id", userID).
+			Int64("bytes", written).
+			Msg("Archive download failed part way")
+		return
+	}
+
+	h.logger.Info().
+		Str("user_id", userID).
+		Str("filename", name).
+		Int("entries", len(entries)).
+		Int64("bytes", written).
+		Msg("Archive downloaded successfully")
+}
+
+// denied responds that an item in the selection can't be read
+func (h *ArchiveHandler) denied(c *gin.Context, userID, itemID string) {
+	h.logger.Warn().
+		Str("user_id", userID).
+		Str("item_id", itemID).
+		Msg("Archive permission denied")
+	c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+}
+
+// logShareAccess records an archive download through a share
+func (h *ArchiveHandler) logShareAccess(c *gin.Context, shareToken, name string) {
+	sharePerms, err := h.permissionService.ValidateShareToken(shareToken, "")
+	if err != nil {
+		return
+	}
+	if err := h.shareService.LogShareAccess(sharePerms.ShareID, c.ClientIP(), c.Request.UserAgent(), "download", name); err != nil {
+		h.logger.Error().Err(err).Str("share_id", sharePerms.ShareID).Msg("Failed to log share access")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jd-boyd/filesonthego/auth"
@@ -291,6 +292,10 @@ func (h *AuthHandler) ShowDashboard(c *gin.Context) {
 		h.db.Model(&models.File{}).Where("user = ?", userID).Count(&fileCount)
 		data.HasFiles = fileCount > 0
 
+		// Get recent activity and starred items
+		data.RecentActivity = h.recentActivity(userID)
+		data.Starred = h.starredItems(userID)
+
 		// Get admin status
 		data.Settings = map[string]interface{}{
 			"IsAdmin": user.IsAdmin,
@@ -304,6 +309,71 @@ func (h *AuthHandler) ShowDashboard(c *gin.Context) {
 	}
 }
 
+// dashboardActivityLimit is how many entries the dashboard's recent
+// activity shows
+const dashboardActivityLimit = 10
+
+// activityLabels are how activity actions read on the dashboard
+var activityLabels = map[string]string{
+	models.ActivityUpload:   "Uploaded",
+	models.ActivityDownload: "Downloaded",
+	models.ActivityRename:   "Renamed",
+	models.ActivityMove:     "Moved",
+	models.ActivityDelete:   "Deleted",
+	models.ActivityShare:    "Shared",
+}
+
+// recentActivity returns the user's latest activity for the dashboard
+func (h *AuthHandler) recentActivity(userID string) []ActivityItem {
+	var activities []*models.Activity
+	err := h.db.Where("user = ?", userID).
+		Order("created_at DESC").
+		Limit(dashboardActivityLimit).
+		Find(&activities).Error
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to load recent activity")
+		return nil
+	}
+
+	items := make([]ActivityItem, len(activities))
+	for i, activity := range activities {
+		action, ok := activityLabels[activity.Action]
+		if !ok {
+			action = activity.Action
+		}
+		items[i] = ActivityItem{
+			FileName: activity.Name,
+			Action:   action,
+			Time:     formatTimeAgo(activity.CreatedAt),
+		}
+	}
+	return items
+}
+
+// starredItems returns the user's starred directories and files for the
+// dashboard
+func (h *AuthHandler) starredItems(userID string) []StarredItem {
+	var dirs []*models.Directory
+	if err := h.db.Where("user = ? AND starred = ?", userID, true).Order("name ASC").Find(&dirs).Error; err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to load starred directories")
+		return nil
+	}
+	var files []*models.File
+	if err := h.db.Where("user = ? AND starred = ?", userID, true).Order("name ASC").Find(&files).Error; err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to load starred files")
+		return nil
+	}
+
+	items := make([]StarredItem, 0, len(dirs)+len(files))
+	for _, dir := range dirs {
+		items = append(items, StarredItem{ID: dir.ID, Name: dir.Name, Type: "directory", URL: "/files/" + dir.ID})
+	}
+	for _, file := range files {
+		items = append(items, StarredItem{ID: file.ID, Name: file.Name, Type: "file", URL: "/api/files/" + file.ID + "/download"})
+	}
+	return items
+}
+
 // Helper methods for error handling
 
 func (h *AuthHandler) handleLoginError(c *gin.Context, isHTMX bool, message string) {
@@ -356,3 +426,27 @@ func formatBytes(bytes int64) string {
 
 	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
 }
+
+// formatTimeAgo formats a time relative to now, like "5 minutes ago"
+func formatTimeAgo(t time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	elapsed := time.Since(t)
+	switch {
+	case elapsed < time.Minute:
+		return "Just now"
+	case elapsed < time.Hour:
+		return plural(int(elapsed/time.Minute), "minute")
+	case elapsed < 24*time.Hour:
+		return plural(int(elapsed/time.Hour), "hour")
+	case elapsed < 7*24*time.Hour:
+		return plural(int(elapsed/(24*time.Hour)), "day")
+	default:
+		return t.Format("Jan 2, 2006")
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+// ContentIndexHandler exposes content reindexing to admins
+type ContentIndexHandler struct {
+	contentIndexService *services.ContentIndexService
+	logger              zerolog.Logger
+}
+
+// NewContentIndexHandler creates a new content index handler
+func NewContentIndexHandler(
+	contentIndexService *services.ContentIndexService,
+	logger zerolog.Logger,
+) *ContentIndexHandler {
+	return &ContentIndexHandler{
+		contentIndexService: contentIndexService,
+		logger:              logger,
+	}
+}
+
+// Reindex indexes the text of files changed since they were last indexed
+// and returns the report. With ?force=true every file is indexed again.
+func (h *ContentIndexHandler) Reindex(c *gin.Context) {
+	force, _ := strconv.ParseBool(c.Query("force"))
+
+	report, err := h.contentIndexService.Reindex(c.Request.Context(), force)
+	if err != nil {
+		if errors.Is(err, services.ErrContentIndexDisabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Bool("force", force).Msg("Content reindex failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Content reindex failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jd-boyd/filesonthego/auth"
@@ -15,6 +22,7 @@ import (
 type DirectoryHandler struct {
 	db                *gorm.DB
 	permissionService *services.PermissionService
+	fileService       *services.FileService
 	logger            zerolog.Logger
 	renderer          *TemplateRenderer
 }
@@ -23,18 +31,24 @@ type DirectoryHandler struct {
 func NewDirectoryHandler(
 	db *gorm.DB,
 	permissionService *services.PermissionService,
+	fileService *services.FileService,
 	logger zerolog.Logger,
 	renderer *TemplateRenderer,
 ) *DirectoryHandler {
 	return &DirectoryHandler{
 		db:                db,
 		permissionService: permissionService,
+		fileService:       fileService,
 		logger:            logger,
 		renderer:          renderer,
 	}
 }
 
-// ListDirectory lists files and directories in a directory
+// ListDirectory lists a page of the files and directories in a directory.
+// The sort, order, mime and ext query parameters pick the order and filter
+// the files, each tag parameter limits the items to those with that tag,
+// and cursor is the next_cursor of the previous page. HTMX requests get
+// the page as HTML for the file browser, in the list or grid view.
 func (h *DirectoryHandler) ListDirectory(c *gin.Context) {
 	directoryID := c.Query("directory_id")
 	userID, _ := auth.GetUserID(c)
@@ -47,30 +61,155 @@ func (h *DirectoryHandler) ListDirectory(c *gin.Context) {
 		return
 	}
 
-	// Get subdirectories
+	opts := services.ListOptions{
+		Sort:       c.Query("sort"),
+		Cursor:     c.Query("cursor"),
+		MimePrefix: c.Query("mime"),
+		Extension:  c.Query("ext"),
+		Tags:       c.QueryArray("tag"),
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		opts.Limit = n
+	}
+
+	listing, err := h.fileService.ListDirectory(userID, directoryID, opts)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidListOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Str("directory_id", directoryID).Msg("Failed to list directory")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list directory"})
+		return
+	}
+
+	if IsHTMXRequest(c) {
+		h.renderListing(c, directoryID, opts, listing)
+		return
+	}
+
+	c.JSON(http.StatusOK, listing)
+}
+
+// renderListing renders a listing page for the file browser: the whole
+// list for the first page, or for a later page its items and the sentinel
+// that loads the next one
+func (h *DirectoryHandler) renderListing(c *gin.Context, directoryID string, opts services.ListOptions, listing *services.DirectoryListing) {
+	// Later pages are requested with the same query and a cursor, and
+	// sorting keeps the listing's filters
+	query := c.Request.URL.Query()
+	query.Del("cursor")
+	query.Del("view")
+	pageQuery := template.URL(query.Encode())
+	query.Del("sort")
+	query.Del("order")
+
+	data := &FileListData{
+		CurrentDirectoryID: directoryID,
+		ViewMode:           "list",
+		SortBy:             services.SortByName,
+		SortOrder:          "asc",
+		Query:              pageQuery,
+		FilterQuery:        template.URL(query.Encode()),
+		Items:              make([]FileListItem, 0, len(listing.Directories)+len(listing.Files)),
+		NextCursor:         listing.NextCursor,
+		TotalDirectories:   listing.TotalDirectories,
+		TotalFiles:         listing.TotalFiles,
+	}
+	if c.Query("view") == "grid" {
+		data.ViewMode = "grid"
+	}
+	if opts.Sort != "" {
+		data.SortBy = opts.Sort
+	}
+	if opts.Descending {
+		data.SortOrder = "desc"
+	}
+
+	for _, dir := range listing.Directories {
+		data.Items = append(data.Items, FileListItem{
+			ID:               dir.ID,
+			Name:             dir.Name,
+			Type:             "directory",
+			Created:          dir.CreatedAt.Format(time.RFC3339),
+			Updated:          dir.UpdatedAt.Format(time.RFC3339),
+			UpdatedFormatted: formatTimeAgo(dir.UpdatedAt),
+			Starred:          dir.Starred,
+			Tags:             listing.Tags[dir.ID],
+		})
+	}
+	for _, file := range listing.Files {
+		data.Items = append(data.Items, FileListItem{
+			ID:               file.ID,
+			Name:             file.Name,
+			Type:             "file",
+			Size:             file.Size,
+			SizeFormatted:    formatFileSize(file.Size),
+			MimeType:         file.MimeType,
+			Extension:        strings.ToLower(filepath.Ext(file.Name)),
+			Created:          file.CreatedAt.Format(time.RFC3339),
+			Updated:          file.UpdatedAt.Format(time.RFC3339),
+			UpdatedFormatted: formatTimeAgo(file.UpdatedAt),
+			Starred:          file.Starred,
+			Tags:             listing.Tags[file.ID],
+		})
+	}
+
+	partial := "file-list-content"
+	if opts.Cursor != "" {
+		partial = "file-list-page"
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := h.renderer.RenderPartial(c.Writer, "file-list", partial, data); err != nil {
+		h.logger.Error().Err(err).Str("directory_id", directoryID).Msg("Failed to render directory listing")
+		c.Status(http.StatusInternalServerError)
+	}
+}
+
+// formatFileSize formats a file size like "1.5 MB"
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	units := []string{"KB", "MB", "GB", "TB"}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < len(units)-1; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %s", float64(size)/float64(div), units[exp])
+}
+
+// DirectoryTree lists all of the user's directories, ordered by path, for
+// picking a destination
+func (h *DirectoryHandler) DirectoryTree(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
 	var directories []*models.Directory
-	query := h.db.Where("user = ?", userID)
-	if directoryID != "" {
-		query = query.Where("parent_directory = ?", directoryID)
-	} else {
-		query = query.Where("parent_directory IS NULL OR parent_directory = ''")
-	}
-	query.Order("name ASC").Find(&directories)
-
-	// Get files
-	var files []*models.File
-	fileQuery := h.db.Where("user = ?", userID)
-	if directoryID != "" {
-		fileQuery = fileQuery.Where("parent_directory = ?", directoryID)
-	} else {
-		fileQuery = fileQuery.Where("parent_directory IS NULL OR parent_directory = ''")
-	}
-	fileQuery.Order("name ASC").Find(&files)
-
-	c.JSON(http.StatusOK, gin.H{
-		"directories": directories,
-		"files":       files,
-	})
+	if err := h.db.Where("user = ?", userID).Order("path ASC, name ASC").Find(&directories).Error; err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list directories")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list directories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"directories": directories})
 }
 
 // CreateDirectory creates a new directory
@@ -80,6 +219,8 @@ func (h *DirectoryHandler) CreateDirectory(c *gin.Context) {
 	var req struct {
 		Name      string `json:"name" binding:"required"`
 		ParentID  string `json:"parent_id"`
+
+		OnConflict string `json:"on_conflict"` // fail (default) or rename
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -101,39 +242,33 @@ func (h *DirectoryHandler) CreateDirectory(c *gin.Context) {
 		return
 	}
 
-	// Get parent path
-	path := "/"
-	if req.ParentID != "" {
-		var parent models.Directory
-		if err := h.db.First(&parent, "id = ?", req.ParentID).Error; err == nil {
-			path = parent.GetFullPath()
-		}
+	policy, err := services.ParseConflictPolicy(req.OnConflict)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Create directory
-	dir := &models.Directory{
-		Name:            name,
-		Path:            path,
-		User:            userID,
-		ParentDirectory: req.ParentID,
-	}
-
-	if err := h.db.Create(dir).Error; err != nil {
-		h.logger.Error().Err(err).Msg("Failed to create directory")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory"})
+	dir, applied, err := h.fileService.CreateDirectory(userID, req.ParentID, name, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNameConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent directory not found"})
+		default:
+			h.logger.Error().Err(err).Msg("Failed to create directory")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory"})
+		}
 		return
 	}
 
-	h.logger.Info().
-		Str("user_id", userID).
-		Str("directory_id", dir.ID).
-		Str("name", name).
-		Msg("Directory created successfully")
-
-	c.JSON(http.StatusCreated, gin.H{"directory": dir})
+	c.JSON(http.StatusCreated, gin.H{"directory": dir, "conflict_policy": applied})
 }
 
-// DeleteDirectory deletes a directory
+// DeleteDirectory moves a directory to its owner's trash. Only empty
+// directories are accepted unless the request has ?recursive=true, in which
+// case everything below it goes to the trash with it.
 func (h *DirectoryHandler) DeleteDirectory(c *gin.Context) {
 	directoryID := c.Param("id")
 	userID, _ := auth.GetUserID(c)
@@ -145,27 +280,24 @@ func (h *DirectoryHandler) DeleteDirectory(c *gin.Context) {
 		return
 	}
 
-	// Check if directory is empty
-	var fileCount, dirCount int64
-	h.db.Model(&models.File{}).Where("parent_directory = ?", directoryID).Count(&fileCount)
-	h.db.Model(&models.Directory{}).Where("parent_directory = ?", directoryID).Count(&dirCount)
+	if recursive, _ := strconv.ParseBool(c.Query("recursive")); !recursive {
+		// Check if directory is empty; trashed items don't count
+		var fileCount, dirCount int64
+		h.db.Model(&models.File{}).Where("parent_directory = ?", directoryID).Count(&fileCount)
+		h.db.Model(&models.Directory{}).Where("parent_directory = ?", directoryID).Count(&dirCount)
 
-	if fileCount > 0 || dirCount > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Directory is not empty"})
-		return
+		if fileCount > 0 || dirCount > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Directory is not empty"})
+			return
+		}
 	}
 
-	// Delete directory
-	if err := h.db.Delete(&models.Directory{}, "id = ?", directoryID).Error; err != nil {
-		h.logger.Error().Err(err).Msg("Failed to delete directory")
+	item, err := h.fileService.TrashDirectory(userID, directoryID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("directory_id", directoryID).Msg("Failed to move directory to trash")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete directory"})
 		return
 	}
 
-	h.logger.Info().
-		Str("user_id", userID).
-		Str("directory_id", directoryID).
-		Msg("Directory deleted successfully")
-
-	c.JSON(http.StatusOK, gin.H{"message": "Directory deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Directory moved to trash", "item": item})
 }
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"io"
+	"mime"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/config"
 	"github.com/jd-boyd/filesonthego/models"
 	"github.com/jd-boyd/filesonthego/services"
 	"github.com/rs/zerolog"
@@ -17,7 +20,10 @@ type FileDownloadHandler struct {
 	db                *gorm.DB
 	s3Service         services.S3Service
 	permissionService *services.PermissionService
+	metricsService    *services.MetricsService
+	fileService       *services.FileService
 	logger            zerolog.Logger
+	config            *config.Config
 }
 
 // NewFileDownloadHandler creates a new file download handler
@@ -25,13 +31,19 @@ func NewFileDownloadHandler(
 	db *gorm.DB,
 	s3Service services.S3Service,
 	permissionService *services.PermissionService,
+	metricsService *services.MetricsService,
+	fileService *services.FileService,
 	logger zerolog.Logger,
+	cfg *config.Config,
 ) *FileDownloadHandler {
 	return &FileDownloadHandler{
 		db:                db,
 		s3Service:         s3Service,
 		permissionService: permissionService,
+		metricsService:    metricsService,
+		fileService:       fileService,
 		logger:            logger,
+		config:            cfg,
 	}
 }
 
@@ -60,34 +72,151 @@ func (h *FileDownloadHandler) HandleDownload(c *gin.Context) {
 		return
 	}
 
-	// Download from S3
-	reader, err := h.s3Service.DownloadFile(file.S3Key)
+	h.fileService.RecordDownload(userID, &file)
+	h.serveFile(c, &file, userID)
+}
+
+// DownloadVersion downloads an older version of a file
+func (h *FileDownloadHandler) DownloadVersion(c *gin.Context) {
+	fileID := c.Param("id")
+	versionID := c.Param("versionId")
+	userID, _ := auth.GetUserID(c)
+
+	canRead, err := h.permissionService.CanReadFile(userID, fileID, "")
+	if err != nil || !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := h.fileService.VersionContent(fileID, versionID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("s3_key", file.S3Key).Msg("Failed to download file from S3")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("file_id", fileID).Str("version_id", versionID).Msg("Failed to load file version")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
+		return
+	}
+
+	h.serveFile(c, file, userID)
+}
+
+// serveFile sends a file's content to the client
+func (h *FileDownloadHandler) serveFile(c *gin.Context, file *models.File, userID string) {
+	// Hand the transfer off to storage if configured
+	if h.config.DownloadRedirect {
+		h.redirectToStorage(c, file, userID)
+		return
+	}
+
+	// Get object metadata for the ETag and Last-Modified validators
+	metadata, err := h.s3Service.GetFileMetadata(c.Request.Context(), file.S3Key)
+	if err != nil {
+		if errors.Is(err, services.ErrFileNotFound) {
+			h.logger.Error().Err(err).Str("s3_key", file.S3Key).Msg("File missing from S3")
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("s3_key", file.S3Key).Msg("Failed to get file metadata from S3")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
 		return
 	}
-	defer reader.Close()
 
 	// Set headers
-	c.Header("Content-Disposition", "attachment; filename=\""+file.Name+"\"")
+	c.Header("Content-Disposition", attachmentDisposition(file.Name))
 	c.Header("Content-Type", file.MimeType)
-	c.Header("Content-Length", string(rune(file.Size)))
+	if metadata.ETag != "" {
+		c.Header("ETag", `"`+metadata.ETag+`"`)
+	}
 
-	// Stream file to client
-	if _, err := io.Copy(c.Writer, reader); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to stream file to client")
-		return
+	if file.Checksum != "" {
+		c.Header("X-Checksum-SHA256", file.Checksum)
 	}
 
+	// Ranges are fetched from S3 lazily, so 304s and partial responses
+	// only download what is actually sent
+	objectReader := services.NewObjectReadSeeker(c.Request.Context(), h.s3Service, file.S3Key, metadata.Size)
+	defer objectReader.Close()
+
+	// Optionally re-hash the content as it streams to detect storage corruption
+	var content io.ReadSeeker = objectReader
+	var checksumReader *services.ChecksumReader
+	if h.config.VerifyChecksumOnDownload && file.Checksum != "" {
+		checksumReader = services.NewChecksumReader(objectReader)
+		content = struct {
+			io.Reader
+			io.Seeker
+		}{checksumReader, objectReader}
+	}
+
+	// Serve the content, handling Range, If-Range and conditional requests
+	http.ServeContent(c.Writer, c.Request, "", metadata.LastModified, content)
+
+	// Only a complete, unranged read can be checked against the stored checksum
+	if checksumReader != nil && c.Writer.Status() == http.StatusOK &&
+		checksumReader.BytesRead() == metadata.Size && checksumReader.Checksum() != file.Checksum {
+		// Headers are already sent, so all we can do is report it
+		h.logger.Error().
+			Str("file_id", file.ID).
+			Str("s3_key", file.S3Key).
+			Str("expected_checksum", file.Checksum).
+			Str("actual_checksum", checksumReader.Checksum()).
+			Int64("bytes", checksumReader.BytesRead()).
+			Msg("Checksum mismatch on download, stored object may be corrupted")
+		h.metricsService.RecordChecksumMismatch()
+	}
+
+	h.metricsService.RecordFileDownload(objectReader.BytesRead())
+
 	h.logger.Info().
 		Str("user_id", userID).
-		Str("file_id", fileID).
+		Str("file_id", file.ID).
 		Str("filename", file.Name).
+		Int("version", file.Version).
+		Int("status", c.Writer.Status()).
 		Msg("File downloaded successfully")
 }
 
-// HandleDelete handles file deletion
+// redirectToStorage sends the client to a short-lived presigned URL for the
+// file, so the transfer doesn't go through the application
+func (h *FileDownloadHandler) redirectToStorage(c *gin.Context, file *models.File, userID string) {
+	opts := services.PresignOptions{
+		ContentDisposition: attachmentDisposition(file.Name),
+		ContentType:        file.MimeType,
+	}
+
+	signedURL, err := h.s3Service.GetPresignedDownloadURL(c.Request.Context(), file.S3Key, h.config.DownloadURLExpiry, opts)
+	if err != nil {
+		h.logger.Error().Err(err).Str("s3_key", file.S3Key).Msg("Failed to generate presigned download URL")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download file"})
+		return
+	}
+
+	h.metricsService.RecordFileDownload(file.Size)
+
+	h.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("filename", file.Name).
+		Msg("Redirected download to storage")
+
+	// The URL expires, so it must not be cached
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, signedURL)
+}
+
+// attachmentDisposition builds a Content-Disposition header for downloading
+// a file under its original name. Non-ASCII names are RFC 2231 encoded.
+func attachmentDisposition(filename string) string {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		return "attachment"
+	}
+	return disposition
+}
+
+// HandleDelete moves a file to its owner's trash
 func (h *FileDownloadHandler) HandleDelete(c *gin.Context) {
 	fileID := c.Param("id")
 	userID, _ := auth.GetUserID(c)
@@ -103,31 +232,17 @@ func (h *FileDownloadHandler) HandleDelete(c *gin.Context) {
 		return
 	}
 
-	// Get file record
-	var file models.File
-	if err := h.db.First(&file, "id = ?", fileID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		return
-	}
-
-	// Delete from S3
-	if err := h.s3Service.DeleteFile(file.S3Key); err != nil {
-		h.logger.Error().Err(err).Str("s3_key", file.S3Key).Msg("Failed to delete file from S3")
-		// Continue with database deletion even if S3 delete fails
-	}
-
-	// Delete from database
-	if err := h.db.Delete(&file).Error; err != nil {
-		h.logger.Error().Err(err).Msg("Failed to delete file record")
+	// Move to trash; the object is kept until the trash is purged
+	item, err := h.fileService.TrashFile(userID, fileID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("file_id", fileID).Msg("Failed to move file to trash")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
 		return
 	}
 
-	h.logger.Info().
-		Str("user_id", userID).
-		Str("file_id", fileID).
-		Str("filename", file.Name).
-		Msg("File deleted successfully")
-
-	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "File moved to trash", "item": item})
 }
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// FileOperationsHandler handles operations that rearrange the file tree
+type FileOperationsHandler struct {
+	fileService       *services.FileService
+	permissionService *services.PermissionService
+	logger            zerolog.Logger
+}
+
+// NewFileOperationsHandler creates a new file operations handler
+func NewFileOperationsHandler(
+	fileService *services.FileService,
+	permissionService *services.PermissionService,
+	logger zerolog.Logger,
+) *FileOperationsHandler {
+	return &FileOperationsHandler{
+		fileService:       fileService,
+		permissionService: permissionService,
+		logger:            logger,
+	}
+}
+
+// copyRequest is the body of a copy request. An empty destination is the
+// root directory.
+type copyRequest struct {
+	DestinationID string `json:"destination_id"`
+	OnConflict    string `json:"on_conflict"`
+}
+
+// CopyFile copies a file into a destination directory
+func (h *FileOperationsHandler) CopyFile(c *gin.Context) {
+	fileID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	req, policy, ok := h.bindCopyRequest(c)
+	if !ok {
+		return
+	}
+
+	canRead, err := h.permissionService.CanReadFile(userID, fileID, "")
+	if err != nil || !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	canUpload, err := h.permissionService.CanUploadFile(userID, req.DestinationID, "")
+	if err != nil || !canUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := h.fileService.CopyFile(c.Request.Context(), userID, fileID, req.DestinationID, policy)
+	if err != nil {
+		h.respondError(c, err, "Failed to copy file")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"file": file})
+}
+
+// CopyDirectory copies a directory and its contents into a destination
+// directory
+func (h *FileOperationsHandler) CopyDirectory(c *gin.Context) {
+	directoryID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	req, policy, ok := h.bindCopyRequest(c)
+	if !ok {
+		return
+	}
+
+	canRead, err := h.permissionService.CanReadDirectory(userID, directoryID, "")
+	if err != nil || !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	canCreate, err := h.permissionService.CanCreateDirectory(userID, req.DestinationID)
+	if err != nil || !canCreate {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	dir, err := h.fileService.CopyDirectory(c.Request.Context(), userID, directoryID, req.DestinationID, policy)
+	if err != nil {
+		h.respondError(c, err, "Failed to copy directory")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"directory": dir})
+}
+
+// moveRequest is the body of a rename or move request. Omitted fields are
+// left unchanged; an empty parent_directory moves to the root directory.
+type moveRequest struct {
+	Name            *string `json:"name" form:"name"`
+	ParentDirectory *string `json:"parent_directory" form:"parent_directory"`
+}
+
+// UpdateFile renames a file and/or moves it to another directory
+func (h *FileOperationsHandler) UpdateFile(c *gin.Context) {
+	fileID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	var req moveRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	canModify, err := h.permissionService.CanDeleteFile(userID, fileID)
+	if err != nil || !canModify {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if req.ParentDirectory != nil {
+		canUpload, err := h.permissionService.CanUploadFile(userID, *req.ParentDirectory, "")
+		if err != nil || !canUpload {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+	}
+
+	file, err := h.fileService.MoveFile(userID, fileID, services.MoveOptions{
+		Name:            req.Name,
+		ParentDirectory: req.ParentDirectory,
+	})
+	if err != nil {
+		h.respondError(c, err, "Failed to update file")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
+// UpdateDirectory renames a directory and/or moves it to another directory
+func (h *FileOperationsHandler) UpdateDirectory(c *gin.Context) {
+	directoryID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	var req moveRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	canModify, err := h.permissionService.CanDeleteDirectory(userID, directoryID)
+	if err != nil || !canModify {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if req.ParentDirectory != nil {
+		canCreate, err := h.permissionService.CanCreateDirectory(userID, *req.ParentDirectory)
+		if err != nil || !canCreate {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+	}
+
+	dir, err := h.fileService.MoveDirectory(userID, directoryID, services.MoveOptions{
+		Name:            req.Name,
+		ParentDirectory: req.ParentDirectory,
+	})
+	if err != nil {
+		h.respondError(c, err, "Failed to update directory")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"directory": dir})
+}
+
+// ListVersions lists a file's older versions
+func (h *FileOperationsHandler) ListVersions(c *gin.Context) {
+	fileID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	canRead, err := h.permissionService.CanReadFile(userID, fileID, "")
+	if err != nil || !canRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, versions, err := h.fileService.ListVersions(fileID)
+	if err != nil {
+		h.respondError(c, err, "Failed to list versions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file, "versions": versions})
+}
+
+// RestoreVersion makes an older version of a file its current version
+func (h *FileOperationsHandler) RestoreVersion(c *gin.Context) {
+	fileID := c.Param("id")
+	versionID := c.Param("versionId")
+	userID, _ := auth.GetUserID(c)
+
+	canModify, err := h.permissionService.CanDeleteFile(userID, fileID)
+	if err != nil || !canModify {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	file, err := h.fileService.RestoreVersion(userID, fileID, versionID)
+	if err != nil {
+		h.respondError(c, err, "Failed to restore version")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file": file})
+}
+
+// bindCopyRequest parses a copy request, responding to the client if it is
+// invalid
+func (h *FileOperationsHandler) bindCopyRequest(c *gin.Context) (*copyRequest, services.ConflictPolicy, bool) {
+	var req copyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+
+	policy, err := services.ParseConflictPolicy(req.OnConflict)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+
+	return &req, policy, true
+}
+
+// respondError maps file operation errors to responses
+func (h *FileOperationsHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errors.Is(err, services.ErrNameConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+	case errors.Is(err, services.ErrInvalidDestination), errors.Is(err, services.ErrInvalidName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error().Err(err).Str("path", c.Request.URL.Path).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
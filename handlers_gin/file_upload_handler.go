@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -21,6 +24,8 @@ type FileUploadHandler struct {
 	s3Service         services.S3Service
 	permissionService *services.PermissionService
 	userService       *services.UserService
+	blobService       *services.BlobService
+	fileService       *services.FileService
 	logger            zerolog.Logger
 	config            *config.Config
 }
@@ -31,6 +36,8 @@ func NewFileUploadHandler(
 	s3Service services.S3Service,
 	permissionService *services.PermissionService,
 	userService       *services.UserService,
+	blobService *services.BlobService,
+	fileService *services.FileService,
 	logger zerolog.Logger,
 	cfg *config.Config,
 ) *FileUploadHandler {
@@ -39,6 +46,8 @@ func NewFileUploadHandler(
 		s3Service:         s3Service,
 		permissionService: permissionService,
 		userService:       userService,
+		blobService:       blobService,
+		fileService:       fileService,
 		logger:            logger,
 		config:            cfg,
 	}
@@ -76,19 +85,68 @@ func (h *FileUploadHandler) HandleUpload(c *gin.Context) {
 		return
 	}
 
+	// Share uploads are charged to the directory's owner
+	owner, err := h.fileService.UploadOwner(userID, directoryID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Directory not found"})
+		return
+	}
+
 	// Check user quota
-	if userID != "" {
-		canUpload, err := h.permissionService.CanUploadSize(userID, fileHeader.Size)
-		if err != nil || !canUpload {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+	canUpload, err = h.permissionService.CanUploadSize(owner, fileHeader.Size)
+	if err != nil || !canUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+		return
+	}
+
+	// Sanitize filename. A folder upload gives the file's path relative to
+	// the target directory, ending in its name.
+	var filename string
+	var folders []string
+	if relativePath := c.PostForm("relative_path"); relativePath != "" {
+		parts, err := services.SplitRelativePath(relativePath)
+		if err != nil || len(parts) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid relative path"})
+			return
+		}
+		filename, folders = parts[len(parts)-1], parts[:len(parts)-1]
+	} else {
+		filename, err = models.SanitizeFilename(fileHeader.Filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
 			return
 		}
 	}
 
-	// Sanitize filename
-	filename, err := models.SanitizeFilename(fileHeader.Filename)
+	policy, err := h.fileService.UploadConflictPolicy(c.PostForm("on_conflict"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create the file's folders, reusing any that already exist
+	var createdDirs []*models.Directory
+	if len(folders) > 0 {
+		directoryID, createdDirs, err = h.fileService.EnsureDirectories(owner, directoryID, folders)
+		if err != nil {
+			if errors.Is(err, services.ErrNameConflict) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			h.logger.Error().Err(err).Msg("Failed to create upload folders")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+			return
+		}
+	}
+
+	// Check the name before storing anything
+	if err := h.fileService.CheckUploadName(owner, directoryID, filename, policy); err != nil {
+		if errors.Is(err, services.ErrNameConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Msg("Failed to check upload name")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 		return
 	}
 
@@ -101,15 +159,46 @@ func (h *FileUploadHandler) HandleUpload(c *gin.Context) {
 	}
 	defer file.Close()
 
-	// Generate S3 key
-	s3Key := h.generateS3Key(userID, filename)
+	// Storage calls are cancelled if the client goes away
+	ctx := c.Request.Context()
 
-	// Upload to S3
-	err = h.s3Service.UploadFile(s3Key, file, fileHeader.Size, fileHeader.Header.Get("Content-Type"))
-	if err != nil {
-		h.logger.Error().Err(err).Str("s3_key", s3Key).Msg("Failed to upload file to S3")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
-		return
+	var s3Key, checksum, wrappedKey string
+	if h.config.DedupEnabled {
+		// Store content-addressed, sharing the object with identical uploads
+		blob, err := h.blobService.Store(ctx, file, fileHeader.Size, fileHeader.Header.Get("Content-Type"))
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to store deduplicated blob")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+			return
+		}
+		s3Key = blob.S3Key
+		checksum = blob.Checksum
+		wrappedKey = blob.WrappedKey
+	} else {
+		// Generate S3 key
+		s3Key = h.generateS3Key(owner, filename)
+
+		// Hash the content, then upload it from the start. Uploading the
+		// seekable file itself lets a retried upload resend it.
+		checksumReader := services.NewChecksumReader(file)
+		if _, err := io.Copy(io.Discard, checksumReader); err != nil {
+			h.logger.Error().Err(err).Msg("Failed to hash uploaded file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			h.logger.Error().Err(err).Msg("Failed to rewind uploaded file")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+			return
+		}
+		checksum = checksumReader.Checksum()
+
+		wrappedKey, err = services.UploadObject(ctx, h.s3Service, s3Key, file, fileHeader.Size, fileHeader.Header.Get("Content-Type"))
+		if err != nil {
+			h.logger.Error().Err(err).Str("s3_key", s3Key).Msg("Failed to upload file to S3")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+			return
+		}
 	}
 
 	// Get directory path
@@ -125,37 +214,121 @@ func (h *FileUploadHandler) HandleUpload(c *gin.Context) {
 	fileRecord := &models.File{
 		Name:            filename,
 		Path:            directoryPath,
-		User:            userID,
+		User:            owner,
 		ParentDirectory: directoryID,
 		Size:            fileHeader.Size,
 		MimeType:        fileHeader.Header.Get("Content-Type"),
 		S3Key:           s3Key,
 		S3Bucket:        h.config.S3Bucket,
+		Checksum:        checksum,
+		WrappedKey:      wrappedKey,
 	}
 
-	if err := h.db.Create(fileRecord).Error; err != nil {
-		// Rollback S3 upload
-		h.s3Service.DeleteFile(s3Key)
+	// Overwriting or versioning replaces an existing file's content
+	var result *services.UploadResult
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result, err = h.fileService.SaveUpload(tx, fileRecord, policy)
+		return err
+	})
+	if err != nil {
+		// Rollback S3 upload (or our reference to a shared blob)
+		h.blobService.ReleaseObject(context.WithoutCancel(ctx), s3Key)
+		if errors.Is(err, services.ErrNameConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		h.logger.Error().Err(err).Msg("Failed to create file record")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file metadata"})
 		return
 	}
 
 	// Update user storage
-	if userID != "" {
-		h.userService.UpdateStorageUsed(userID, fileHeader.Size)
-	}
+	h.userService.UpdateStorageUsed(owner, fileHeader.Size)
+
+	h.fileService.FinishUpload(ctx, fileRecord, result)
 
 	h.logger.Info().
 		Str("user_id", userID).
 		Str("file_id", fileRecord.ID).
 		Str("filename", filename).
 		Int64("size", fileHeader.Size).
+		Str("checksum", fileRecord.Checksum).
+		Str("conflict_policy", string(result.Policy)).
 		Msg("File uploaded successfully")
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "File uploaded successfully",
-		"file":    fileRecord,
+		"message":             "File uploaded successfully",
+		"file":                fileRecord,
+		"conflict_policy":     result.Policy,
+		"created_directories": createdDirs,
+	})
+}
+
+// HandleExtract uploads a zip, tar or tar.gz archive and extracts it into
+// a directory, reporting the entries that were skipped
+func (h *FileUploadHandler) HandleExtract(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+	directoryID := c.PostForm("directory_id")
+
+	canUpload, err := h.permissionService.CanUploadFile(userID, directoryID, "")
+	if err != nil || !canUpload {
+		h.logger.Warn().
+			Str("user_id", userID).
+			Str("directory_id", directoryID).
+			Msg("Extract permission denied")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		return
+	}
+
+	// Entries are checked against MAX_UPLOAD_SIZE as they are extracted;
+	// the archive itself can't be bigger than everything in it
+	if fileHeader.Size > h.config.ExtractMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Archive size exceeds maximum allowed size of %d bytes", h.config.ExtractMaxSize),
+		})
+		return
+	}
+
+	policy, err := h.fileService.UploadConflictPolicy(c.PostForm("on_conflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	archive, err := fileHeader.Open()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to open uploaded archive")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process file"})
+		return
+	}
+	defer archive.Close()
+
+	result, err := h.fileService.ExtractArchive(c.Request.Context(), userID, directoryID, archive, fileHeader.Size, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrArchiveTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrUnsupportedArchive), errors.Is(err, services.ErrInvalidDestination):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidArchive):
+			// A damaged tar can fail part way, after some entries were extracted
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "result": result})
+		default:
+			h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to extract archive")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract archive", "result": result})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Extracted %d files", len(result.Files)),
+		"result":  result,
 	})
 }
 
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+// LocalStorageHandler serves presigned URLs issued by the local storage backend
+type LocalStorageHandler struct {
+	storage *services.LocalStorageService
+	logger  zerolog.Logger
+}
+
+// NewLocalStorageHandler creates a new local storage handler
+func NewLocalStorageHandler(
+	storage *services.LocalStorageService,
+	logger zerolog.Logger,
+) *LocalStorageHandler {
+	return &LocalStorageHandler{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// ServeObject streams an object for a valid presigned URL
+func (h *LocalStorageHandler) ServeObject(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	overrides, err := h.storage.VerifyPresignedURL(http.MethodGet, key, c.Request.URL.Query())
+	if err != nil {
+		h.logger.Warn().
+			Err(err).
+			Str("key", key).
+			Msg("Rejected presigned storage request")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	file, metadata, err := h.storage.OpenObject(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, services.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("key", key).Msg("Failed to open stored object")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", metadata.ContentType)
+	if overrides.ContentType != "" {
+		c.Header("Content-Type", overrides.ContentType)
+	}
+	if overrides.ContentDisposition != "" {
+		c.Header("Content-Disposition", overrides.ContentDisposition)
+	}
+	if metadata.ETag != "" {
+		c.Header("ETag", `"`+metadata.ETag+`"`)
+	}
+
+	// ServeContent handles Range, If-Modified-Since and friends for us
+	http.ServeContent(c.Writer, c.Request, path.Base(key), metadata.LastModified, file)
+}
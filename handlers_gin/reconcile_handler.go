@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+// ReconcileHandler exposes storage reconciliation to admins
+type ReconcileHandler struct {
+	reconcileService *services.ReconcileService
+	logger           zerolog.Logger
+}
+
+// NewReconcileHandler creates a new reconcile handler
+func NewReconcileHandler(
+	reconcileService *services.ReconcileService,
+	logger zerolog.Logger,
+) *ReconcileHandler {
+	return &ReconcileHandler{
+		reconcileService: reconcileService,
+		logger:           logger,
+	}
+}
+
+// Reconcile compares storage with the database and returns the report.
+// It is a dry run unless the request has ?apply=true.
+func (h *ReconcileHandler) Reconcile(c *gin.Context) {
+	apply, _ := strconv.ParseBool(c.Query("apply"))
+
+	report, err := h.reconcileService.Reconcile(c.Request.Context(), apply)
+	if err != nil {
+		if errors.Is(err, services.ErrListingUnsupported) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error().Err(err).Bool("apply", apply).Msg("Storage reconciliation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storage reconciliation failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+// SearchHandler handles searching a user's files and directories
+type SearchHandler struct {
+	fileService *services.FileService
+	logger      zerolog.Logger
+}
+
+// NewSearchHandler creates a new search handler
+func NewSearchHandler(
+	fileService *services.FileService,
+	logger zerolog.Logger,
+) *SearchHandler {
+	return &SearchHandler{
+		fileService: fileService,
+		logger:      logger,
+	}
+}
+
+// Search finds the user's files and directories. The query parameters are
+// q (a name substring), type (file or directory), ext, mime (a prefix),
+// min_size and max_size in bytes, modified_after and modified_before (a
+// date or RFC 3339 time), tag (repeated for items with every tag), and
+// limit.
+func (h *SearchHandler) Search(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	opts := services.SearchOptions{
+		Query:      c.Query("q"),
+		Type:       c.Query("type"),
+		Extension:  c.Query("ext"),
+		MimePrefix: c.Query("mime"),
+		Tags:       c.QueryArray("tag"),
+	}
+	if opts.Type != "" && opts.Type != services.SearchTypeFile && opts.Type != services.SearchTypeDirectory {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be file or directory"})
+		return
+	}
+
+	var err error
+	if opts.MinSize, err = sizeParam(c, "min_size"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.MaxSize, err = sizeParam(c, "max_size"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.ModifiedAfter, err = timeParam(c, "modified_after"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.ModifiedBefore, err = timeParam(c, "modified_before"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		opts.Limit = n
+	}
+
+	results, err := h.fileService.Search(userID, opts)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to search")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// sizeParam parses an optional size in bytes from a query parameter
+func sizeParam(c *gin.Context, name string) (*int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%s must be a size in bytes", name)
+	}
+	return &size, nil
+}
+
+// timeParam parses an optional date (2006-01-02, midnight UTC) or RFC 3339
+// time from a query parameter
+func timeParam(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 time", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// TagHandler handles a user's tags, and the tags and metadata on their
+// files and directories
+type TagHandler struct {
+	fileService *services.FileService
+	logger      zerolog.Logger
+}
+
+// NewTagHandler creates a new tag handler
+func NewTagHandler(
+	fileService *services.FileService,
+	logger zerolog.Logger,
+) *TagHandler {
+	return &TagHandler{
+		fileService: fileService,
+		logger:      logger,
+	}
+}
+
+// tagRequest is the body of a tag create or update request. Omitted fields
+// are left unchanged on update.
+type tagRequest struct {
+	Name  *string `json:"name" form:"name"`
+	Color *string `json:"color" form:"color"`
+}
+
+// ListTags lists the user's tags with how many items each is on. With ?q,
+// only the tags starting with it, for autocomplete.
+func (h *TagHandler) ListTags(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+
+	tags, err := h.fileService.ListTags(userID, c.Query("q"), limit)
+	if err != nil {
+		h.respondError(c, err, "Failed to list tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// CreateTag creates a tag
+func (h *TagHandler) CreateTag(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	var req tagRequest
+	if err := c.ShouldBind(&req); err != nil || req.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	color := ""
+	if req.Color != nil {
+		color = *req.Color
+	}
+
+	tag, err := h.fileService.CreateTag(userID, *req.Name, color)
+	if err != nil {
+		h.respondError(c, err, "Failed to create tag")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"tag": tag})
+}
+
+// UpdateTag renames and/or recolors a tag
+func (h *TagHandler) UpdateTag(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	var req tagRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.fileService.UpdateTag(userID, c.Param("id"), req.Name, req.Color)
+	if err != nil {
+		h.respondError(c, err, "Failed to update tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tag": tag})
+}
+
+// DeleteTag deletes a tag and takes it off everything it is on
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	if err := h.fileService.DeleteTag(userID, c.Param("id")); err != nil {
+		h.respondError(c, err, "Failed to delete tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted"})
+}
+
+// ItemTags returns a handler listing the tags on a file or directory
+func (h *TagHandler) ItemTags(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		tags, err := h.fileService.ItemTags(userID, resourceType, c.Param("id"))
+		if err != nil {
+			h.respondError(c, err, "Failed to list tags")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+// AddItemTag returns a handler putting a tag on a file or directory by
+// name, creating the tag if needed
+func (h *TagHandler) AddItemTag(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		var req tagRequest
+		if err := c.ShouldBind(&req); err != nil || req.Name == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		tag, err := h.fileService.TagItem(userID, resourceType, c.Param("id"), *req.Name)
+		if err != nil {
+			h.respondError(c, err, "Failed to add tag")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tag": tag})
+	}
+}
+
+// RemoveItemTag returns a handler taking a tag off a file or directory
+func (h *TagHandler) RemoveItemTag(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		if err := h.fileService.UntagItem(userID, resourceType, c.Param("id"), c.Param("tagId")); err != nil {
+			h.respondError(c, err, "Failed to remove tag")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Tag removed"})
+	}
+}
+
+// ItemMetadata returns a handler listing the metadata of a file or
+// directory
+func (h *TagHandler) ItemMetadata(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		metadata, err := h.fileService.ItemMetadata(userID, resourceType, c.Param("id"))
+		if err != nil {
+			h.respondError(c, err, "Failed to get metadata")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"metadata": metadata})
+	}
+}
+
+// metadataRequest is the body of a metadata update
+type metadataRequest struct {
+	Value *string `json:"value" form:"value"`
+}
+
+// SetItemMetadata returns a handler setting a metadata key on a file or
+// directory
+func (h *TagHandler) SetItemMetadata(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		var req metadataRequest
+		if err := c.ShouldBind(&req); err != nil || req.Value == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "value is required"})
+			return
+		}
+
+		key := c.Param("key")
+		if err := h.fileService.SetItemMetadata(userID, resourceType, c.Param("id"), key, *req.Value); err != nil {
+			h.respondError(c, err, "Failed to set metadata")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"key": key, "value": *req.Value})
+	}
+}
+
+// DeleteItemMetadata returns a handler removing a metadata key from a file
+// or directory
+func (h *TagHandler) DeleteItemMetadata(resourceType models.ResourceType) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := auth.GetUserID(c)
+
+		if err := h.fileService.DeleteItemMetadata(userID, resourceType, c.Param("id"), c.Param("key")); err != nil {
+			h.respondError(c, err, "Failed to delete metadata")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Metadata deleted"})
+	}
+}
+
+// respondError maps service errors to responses
+func (h *TagHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errors.Is(err, services.ErrNameConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidTag), errors.Is(err, services.ErrInvalidMetadata):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error().Err(err).Str("path", c.Request.URL.Path).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/models"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -29,6 +31,7 @@ type TemplateData struct {
 	StoragePercent     int
 	HasFiles           bool
 	RecentActivity     []ActivityItem
+	Starred            []StarredItem
 	PublicRegistration bool
 	Settings           map[string]interface{}
 }
@@ -46,6 +49,46 @@ type ActivityItem struct {
 	Time     string
 }
 
+// StarredItem represents a starred file or directory
+type StarredItem struct {
+	ID   string
+	Name string
+	Type string
+	URL  string
+}
+
+// FileListData is a page of a directory listing for the file list
+// templates
+type FileListData struct {
+	CurrentDirectoryID string
+	ViewMode           string // list or grid
+	SortBy             string
+	SortOrder          string       // asc or desc
+	Query              template.URL // The listing's query, without the cursor
+	FilterQuery        template.URL // The listing's query, without the cursor or sort
+	IsLoading          bool
+	Items              []FileListItem
+	NextCursor         string
+	TotalDirectories   int64
+	TotalFiles         int64
+}
+
+// FileListItem is a directory or file in the file list
+type FileListItem struct {
+	ID               string
+	Name             string
+	Type             string // directory or file
+	Size             int64
+	SizeFormatted    string
+	MimeType         string
+	Extension        string
+	Created          string
+	Updated          string
+	UpdatedFormatted string
+	Starred          bool
+	Tags             []*models.Tag
+}
+
 // TemplateRenderer handles template rendering with caching
 type TemplateRenderer struct {
 	templates map[string]*template.Template
@@ -77,6 +120,8 @@ func getTemplateFuncs() template.FuncMap {
 		"upper": strings.ToUpper,
 		"lower": strings.ToLower,
 		"title": caser.String,
+
+		"hasPrefix": strings.HasPrefix,
 	}
 }
 
@@ -135,6 +180,11 @@ func (r *TemplateRenderer) LoadTemplates() error {
 			"pages/shares.html",
 			"components/header.html",
 		},
+		// Partials swapped into the file browser, see RenderPartial
+		"file-list": {
+			"components/file-list.html",
+			"components/file-item.html",
+		},
 	}
 
 	// Load each template set
@@ -180,10 +230,18 @@ func (r *TemplateRenderer) loadTemplateFromFS(files []string) (*template.Templat
 			return nil, err
 		}
 
-		_, err = tmpl.New(file).Parse(string(content))
+		parsed, err := tmpl.New(file).Parse(string(content))
 		if err != nil {
 			return nil, err
 		}
+
+		// Templates include each other by file name, as ParseFiles names
+		// them, unless the file defines that name itself
+		if base := path.Base(file); parsed.Tree != nil && tmpl.Lookup(base) == nil {
+			if _, err := tmpl.AddParseTree(base, parsed.Tree.Copy()); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return tmpl, nil
@@ -215,6 +273,20 @@ func (r *TemplateRenderer) Render(w io.Writer, name string, data interface{}) er
 	return tmpl.ExecuteTemplate(w, "layouts/base.html", data)
 }
 
+// RenderPartial renders the template called partial from the set name on
+// its own, without the base layout, for HTMX to swap into a page
+func (r *TemplateRenderer) RenderPartial(w io.Writer, name, partial string, data interface{}) error {
+	r.mu.RLock()
+	tmpl, exists := r.templates[name]
+	r.mu.RUnlock()
+
+	if !exists {
+		return errors.New("template not found: " + name)
+	}
+
+	return tmpl.ExecuteTemplate(w, partial, data)
+}
+
 // Helper functions for handlers
 
 // IsHTMXRequest checks if the request is an HTMX request
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// TrashHandler handles a user's trash
+type TrashHandler struct {
+	fileService *services.FileService
+	logger      zerolog.Logger
+}
+
+// NewTrashHandler creates a new trash handler
+func NewTrashHandler(
+	fileService *services.FileService,
+	logger zerolog.Logger,
+) *TrashHandler {
+	return &TrashHandler{
+		fileService: fileService,
+		logger:      logger,
+	}
+}
+
+// ListTrash lists the items in the user's trash
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	items, err := h.fileService.ListTrash(userID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list trash")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// RestoreItem puts an item in the trash back where it was
+func (h *TrashHandler) RestoreItem(c *gin.Context) {
+	itemID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	item, err := h.fileService.Restore(userID, itemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		case errors.Is(err, services.ErrQuotaExceeded):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+		default:
+			h.logger.Error().Err(err).Str("item_id", itemID).Msg("Failed to restore trash item")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item restored", "item": item})
+}
+
+// PurgeItem permanently deletes an item in the trash
+func (h *TrashHandler) PurgeItem(c *gin.Context) {
+	itemID := c.Param("id")
+	userID, _ := auth.GetUserID(c)
+
+	report, err := h.fileService.Purge(c.Request.Context(), userID, itemID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		h.logger.Error().Err(err).Str("item_id", itemID).Msg("Failed to purge trash item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete item"})
+		return
+	}
+
+	h.respondDeleted(c, report, "Item deleted permanently")
+}
+
+// EmptyTrash permanently deletes everything in the trash
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	report, err := h.fileService.EmptyTrash(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to empty trash")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+		return
+	}
+
+	h.respondDeleted(c, report, "Trash emptied")
+}
+
+// respondDeleted reports a permanent delete. Objects that couldn't be
+// removed from storage are reported with a 207.
+func (h *TrashHandler) respondDeleted(c *gin.Context, report *services.DeleteReport, message string) {
+	if len(report.FailedObjects) > 0 {
+		c.JSON(http.StatusMultiStatus, gin.H{
+			"message": message + ", but some stored files could not be removed",
+			"report":  report,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"report":  report,
+	})
+}
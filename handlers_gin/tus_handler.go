@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// tusVersion is the only tus protocol version supported
+	tusVersion = "1.0.0"
+	// tusExtensions lists the supported tus protocol extensions
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+	// tusContentType is the required content type of PATCH requests
+	tusContentType = "application/offset+octet-stream"
+	// tusBasePath is where uploads are created; each upload lives below it
+	tusBasePath = "/api/tus/files"
+)
+
+// TusHandler implements the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload) on top of TusService
+type TusHandler struct {
+	tusService        *services.TusService
+	permissionService *services.PermissionService
+	logger            zerolog.Logger
+	config            *config.Config
+}
+
+// NewTusHandler creates a new tus handler
+func NewTusHandler(
+	tusService *services.TusService,
+	permissionService *services.PermissionService,
+	logger zerolog.Logger,
+	cfg *config.Config,
+) *TusHandler {
+	return &TusHandler{
+		tusService:        tusService,
+		permissionService: permissionService,
+		logger:            logger,
+		config:            cfg,
+	}
+}
+
+// Protocol is middleware that adds the Tus-Resumable header to every
+// response and rejects requests for other protocol versions
+func (h *TusHandler) Protocol() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Options describes the server's tus capabilities
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.config.MaxUploadSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a new upload. The file name, type and target directory
+// come from the Upload-Metadata header; data sent with the request is
+// stored right away (creation-with-upload).
+func (h *TusHandler) CreateUpload(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+	shareToken := c.Query("share_token")
+
+	if !h.tusService.Enabled() {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Resumable uploads are not available"})
+		return
+	}
+
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length"})
+		return
+	}
+	if size > h.config.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("File size exceeds maximum allowed size of %d bytes", h.config.MaxUploadSize),
+		})
+		return
+	}
+
+	rawMetadata := c.GetHeader("Upload-Metadata")
+	metadata, err := parseTusMetadata(rawMetadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+		return
+	}
+	directoryID := metadata["directory_id"]
+
+	// Check upload permission
+	canUpload, err := h.permissionService.CanUploadFile(userID, directoryID, shareToken)
+	if err != nil || !canUpload {
+		h.logger.Warn().
+			Str("user_id", userID).
+			Str("directory_id", directoryID).
+			Msg("Resumable upload permission denied")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Check quota of whoever the file will belong to
+	owner, err := h.tusService.UploadOwner(userID, directoryID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	canUpload, err = h.permissionService.CanUploadSize(owner, size)
+	if err != nil || !canUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+		return
+	}
+
+	// Sanitize filename
+	filename, err := models.SanitizeFilename(metadata["filename"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+
+	mimeType := metadata["filetype"]
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	upload, err := h.tusService.CreateUpload(c.Request.Context(), userID, directoryID, shareToken, filename, mimeType, rawMetadata, size, metadata["on_conflict"])
+	if err != nil {
+		h.respondTusError(c, err, "Failed to create upload")
+		return
+	}
+
+	location := tusBasePath + "/" + upload.ID
+	if shareToken != "" {
+		location += "?share_token=" + url.QueryEscape(shareToken)
+	}
+	c.Header("Location", location)
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if c.ContentType() == tusContentType && c.Request.ContentLength != 0 {
+		upload, err = h.tusService.WriteChunk(c.Request.Context(), upload.ID, 0, c.Request.Body)
+		if err != nil {
+			h.respondTusError(c, err, "Failed to store upload data")
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+// UploadStatus reports how many bytes of an upload the server has stored
+func (h *TusHandler) UploadStatus(c *gin.Context) {
+	upload, ok := h.accessibleUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+// WriteChunk appends the request body to an upload
+func (h *TusHandler) WriteChunk(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset"})
+		return
+	}
+
+	upload, ok := h.accessibleUpload(c)
+	if !ok {
+		return
+	}
+	if c.Request.ContentLength > upload.Size-offset {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Data exceeds Upload-Length"})
+		return
+	}
+
+	upload, err = h.tusService.WriteChunk(c.Request.Context(), upload.ID, offset, c.Request.Body)
+	if err != nil {
+		h.respondTusError(c, err, "Failed to store upload data")
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate cancels an upload and discards its data
+func (h *TusHandler) Terminate(c *gin.Context) {
+	upload, ok := h.accessibleUpload(c)
+	if !ok {
+		return
+	}
+
+	if err := h.tusService.Terminate(c.Request.Context(), upload.ID); err != nil {
+		h.respondTusError(c, err, "Failed to terminate upload")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// accessibleUpload loads the upload named in the URL and checks that the
+// request may use it. Uploads the request can't access are reported as not
+// found.
+func (h *TusHandler) accessibleUpload(c *gin.Context) (*models.TusUpload, bool) {
+	userID, _ := auth.GetUserID(c)
+
+	upload, err := h.tusService.GetUpload(c.Param("id"))
+	if err != nil {
+		h.respondTusError(c, err, "Failed to load upload")
+		return nil, false
+	}
+
+	if !h.tusService.CanAccess(upload, userID, c.Query("share_token")) {
+		h.respondTusError(c, services.ErrTusUploadNotFound, "")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// respondTusError maps resumable upload errors to HTTP responses
+func (h *TusHandler) respondTusError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrTusUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Resumable uploads are not available"})
+	case errors.Is(err, services.ErrTusUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+	case errors.Is(err, services.ErrTusUploadExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+	case errors.Is(err, services.ErrTusOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the stored offset"})
+	case errors.Is(err, services.ErrTusQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+	case errors.Is(err, services.ErrTusDirectoryNotFound):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+	case errors.Is(err, services.ErrNameConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTusUploadDiscarded):
+		c.JSON(http.StatusGone, gin.H{"error": "Upload could not be saved, start a new upload"})
+	case errors.Is(err, services.ErrInvalidConflictPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error().Err(err).Str("upload_id", c.Param("id")).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated
+// "key base64value" pairs where the value may be omitted
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %q: %w", fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jd-boyd/filesonthego/auth"
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/jd-boyd/filesonthego/services"
+	"github.com/rs/zerolog"
+)
+
+// UploadSessionHandler handles browser-driven direct uploads to storage
+type UploadSessionHandler struct {
+	uploadSessionService *services.UploadSessionService
+	permissionService    *services.PermissionService
+	logger               zerolog.Logger
+	config               *config.Config
+}
+
+// NewUploadSessionHandler creates a new upload session handler
+func NewUploadSessionHandler(
+	uploadSessionService *services.UploadSessionService,
+	permissionService *services.PermissionService,
+	logger zerolog.Logger,
+	cfg *config.Config,
+) *UploadSessionHandler {
+	return &UploadSessionHandler{
+		uploadSessionService: uploadSessionService,
+		permissionService:    permissionService,
+		logger:               logger,
+		config:               cfg,
+	}
+}
+
+// CreateSessionRequest represents the request to start a direct upload
+type CreateSessionRequest struct {
+	FileName    string `json:"file_name" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+	MimeType    string `json:"mime_type"`
+	DirectoryID string `json:"directory_id"`
+	OnConflict  string `json:"on_conflict"`
+}
+
+// RefreshPartsRequest represents the request for fresh part URLs
+type RefreshPartsRequest struct {
+	PartNumbers []int `json:"part_numbers" binding:"required"`
+}
+
+// CompleteSessionRequest represents the request to finish a direct upload
+type CompleteSessionRequest struct {
+	Parts []services.CompletedPart `json:"parts" binding:"required"`
+}
+
+// CreateSession checks permissions and quota, then starts a direct upload
+func (h *UploadSessionHandler) CreateSession(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+
+	if !h.uploadSessionService.Enabled() {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Direct uploads are not available"})
+		return
+	}
+
+	var req CreateSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Check upload permission
+	canUpload, err := h.permissionService.CanUploadFile(userID, req.DirectoryID, "")
+	if err != nil || !canUpload {
+		h.logger.Warn().
+			Str("user_id", userID).
+			Str("directory_id", req.DirectoryID).
+			Msg("Direct upload permission denied")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Validate file size
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file size"})
+		return
+	}
+	if req.Size > h.config.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("File size exceeds maximum allowed size of %d bytes", h.config.MaxUploadSize),
+		})
+		return
+	}
+
+	// Check user quota
+	canUpload, err = h.permissionService.CanUploadSize(userID, req.Size)
+	if err != nil || !canUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+		return
+	}
+
+	// Sanitize filename
+	filename, err := models.SanitizeFilename(req.FileName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filename"})
+		return
+	}
+
+	mimeType := req.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	session, parts, err := h.uploadSessionService.CreateSession(c.Request.Context(), userID, req.DirectoryID, filename, mimeType, req.Size, req.OnConflict)
+	if err != nil {
+		h.respondSessionError(c, err, "Failed to start upload")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"session": session,
+		"parts":   parts,
+	})
+}
+
+// RefreshParts issues fresh presigned URLs for parts whose URLs expired
+func (h *UploadSessionHandler) RefreshParts(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+	sessionID := c.Param("id")
+
+	var req RefreshPartsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	parts, err := h.uploadSessionService.PartURLs(c.Request.Context(), sessionID, userID, req.PartNumbers)
+	if err != nil {
+		h.respondSessionError(c, err, "Failed to refresh part URLs")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"parts": parts})
+}
+
+// CompleteSession finalizes a direct upload and creates the file record
+func (h *UploadSessionHandler) CompleteSession(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+	sessionID := c.Param("id")
+
+	var req CompleteSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Quota may have been used up while the parts were uploading
+	session, err := h.uploadSessionService.GetSession(sessionID, userID)
+	if err != nil {
+		h.respondSessionError(c, err, "Failed to complete upload")
+		return
+	}
+	canUpload, err := h.permissionService.CanUploadSize(userID, session.Size)
+	if err != nil || !canUpload {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient storage quota"})
+		return
+	}
+
+	file, applied, err := h.uploadSessionService.CompleteSession(c.Request.Context(), sessionID, userID, req.Parts)
+	if err != nil {
+		h.respondSessionError(c, err, "Failed to complete upload")
+		return
+	}
+
+	h.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("filename", file.Name).
+		Int64("size", file.Size).
+		Msg("File uploaded successfully")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "File uploaded successfully",
+		"file":            file,
+		"conflict_policy": applied,
+	})
+}
+
+// AbortSession cancels a direct upload
+func (h *UploadSessionHandler) AbortSession(c *gin.Context) {
+	userID, _ := auth.GetUserID(c)
+	sessionID := c.Param("id")
+
+	if err := h.uploadSessionService.AbortSession(c.Request.Context(), sessionID, userID); err != nil {
+		h.respondSessionError(c, err, "Failed to abort upload")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+// respondSessionError maps upload session errors to HTTP responses
+func (h *UploadSessionHandler) respondSessionError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrDirectUploadUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Direct uploads are not available"})
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload session not found"})
+	case errors.Is(err, services.ErrUploadSessionExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Upload session expired"})
+	case errors.Is(err, services.ErrInvalidParts), errors.Is(err, services.ErrUploadSizeMismatch),
+		errors.Is(err, services.ErrInvalidConflictPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNameConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error().Err(err).Str("session_id", c.Param("id")).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
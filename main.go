@@ -4,7 +4,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -18,6 +21,7 @@ import (
 	"github.com/jd-boyd/filesonthego/config"
 	"github.com/jd-boyd/filesonthego/database"
 	handlers "github.com/jd-boyd/filesonthego/handlers_gin"
+	"github.com/jd-boyd/filesonthego/models"
 	"github.com/jd-boyd/filesonthego/services"
 	"github.com/rs/zerolog"
 	gormlogger "gorm.io/gorm/logger"
@@ -27,6 +31,11 @@ func main() {
 	// Parse CLI flags
 	useExternalAssets := flag.Bool("external-assets", false, "Use external filesystem for templates and static files instead of embedded assets")
 	assetsDir := flag.String("assets-dir", ".", "Base directory for external assets (only used with -external-assets)")
+	reconcile := flag.Bool("reconcile", false, "Compare storage with the database, print a report and exit")
+	reconcileApply := flag.Bool("reconcile-apply", false, "With -reconcile, delete orphaned objects and remove file records without objects")
+	reindexContent := flag.Bool("reindex-content", false, "Index the text of files changed since they were last indexed, print a report and exit")
+	reindexContentForce := flag.Bool("reindex-content-force", false, "With -reindex-content, index every file again")
+	rotateEncryptionKey := flag.Bool("rotate-encryption-key", false, "Re-wrap stored data keys with the current encryption master key and exit")
 	flag.Parse()
 
 	// Configure assets based on CLI flags
@@ -56,6 +65,7 @@ func main() {
 	logger.Info().
 		Str("environment", cfg.AppEnvironment).
 		Str("port", cfg.AppPort).
+		Str("storage_backend", cfg.StorageBackend).
 		Str("s3_bucket", cfg.S3Bucket).
 		Str("s3_region", cfg.S3Region).
 		Int64("max_upload_size", cfg.MaxUploadSize).
@@ -132,17 +142,78 @@ func main() {
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to initialize S3 service")
 	}
+	if cfg.EncryptionEnabled {
+		keyRing, err := services.NewKeyRing(cfg)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load encryption keys")
+		}
+		s3Service = services.NewEncryptedStorage(s3Service, db, keyRing, logger)
+		logger.Info().
+			Str("key_id", keyRing.CurrentKeyID()).
+			Msg("Stored files are encrypted, resumable uploads are unavailable")
+	}
+	blobService := services.NewBlobService(db, s3Service, logger)
+	userService.SetBlobService(blobService)
 	permissionService := services.NewPermissionService(db, logger)
 	shareService := services.NewShareService(db, logger)
+	fileService := services.NewFileService(db, s3Service, blobService, userService, logger, cfg)
+	uploadSessionService := services.NewUploadSessionService(db, s3Service, userService, fileService, logger, cfg)
+	tusService := services.NewTusService(db, s3Service, userService, fileService, logger, cfg)
+	reconcileService := services.NewReconcileService(db, s3Service, userService, fileService, logger)
+	contentIndexService := services.NewContentIndexService(db, s3Service, logger, cfg)
+	fileService.SetContentIndexService(contentIndexService)
+	activityService := services.NewActivityService(db, logger)
+	fileService.SetActivityService(activityService)
+	shareService.SetActivityService(activityService)
+
+	// Re-wrap data keys instead of running the server when asked to
+	if *rotateEncryptionKey {
+		if err := runKeyRotation(s3Service); err != nil {
+			logger.Error().Err(err).Msg("Encryption key rotation failed")
+			database.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Run storage reconciliation instead of the server when asked to
+	if *reconcile {
+		if err := runReconcile(reconcileService, *reconcileApply); err != nil {
+			logger.Error().Err(err).Msg("Storage reconciliation failed")
+			database.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Run content reindexing instead of the server when asked to
+	if *reindexContent {
+		if err := runReindexContent(contentIndexService, *reindexContentForce); err != nil {
+			logger.Error().Err(err).Msg("Content reindex failed")
+			database.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, templateRenderer, logger, cfg, jwtManager, sessionManager)
 	settingsHandler := handlers.NewSettingsHandler(userService, templateRenderer, logger)
 	adminHandler := handlers.NewAdminHandler(userService, templateRenderer, logger)
-	fileUploadHandler := handlers.NewFileUploadHandler(db, s3Service, permissionService, userService, logger, cfg)
-	fileDownloadHandler := handlers.NewFileDownloadHandler(db, s3Service, permissionService, logger)
-	directoryHandler := handlers.NewDirectoryHandler(db, permissionService, logger, templateRenderer)
+	fileUploadHandler := handlers.NewFileUploadHandler(db, s3Service, permissionService, userService, blobService, fileService, logger, cfg)
+	fileDownloadHandler := handlers.NewFileDownloadHandler(db, s3Service, permissionService, metricsService, fileService, logger, cfg)
+	uploadSessionHandler := handlers.NewUploadSessionHandler(uploadSessionService, permissionService, logger, cfg)
+	tusHandler := handlers.NewTusHandler(tusService, permissionService, logger, cfg)
+	directoryHandler := handlers.NewDirectoryHandler(db, permissionService, fileService, logger, templateRenderer)
+	fileOperationsHandler := handlers.NewFileOperationsHandler(fileService, permissionService, logger)
+	archiveHandler := handlers.NewArchiveHandler(fileService, permissionService, shareService, metricsService, logger)
+	trashHandler := handlers.NewTrashHandler(fileService, logger)
+	searchHandler := handlers.NewSearchHandler(fileService, logger)
+	tagHandler := handlers.NewTagHandler(fileService, logger)
+	activityHandler := handlers.NewActivityHandler(activityService, fileService, logger)
 	shareHandler := handlers.NewShareHandler(db, shareService, permissionService, logger, templateRenderer)
+	reconcileHandler := handlers.NewReconcileHandler(reconcileService, logger)
+	contentIndexHandler := handlers.NewContentIndexHandler(contentIndexService, logger)
 
 	// Ensure admin user exists with proper permissions
 	ensureAdminUser(userService, logger)
@@ -206,13 +277,70 @@ func main() {
 
 		// File routes
 		protected.POST("/api/files/upload", fileUploadHandler.HandleUpload)
+		protected.POST("/api/files/extract", fileUploadHandler.HandleExtract)
 		protected.GET("/api/files/:id/download", fileDownloadHandler.HandleDownload)
 		protected.DELETE("/api/files/:id", fileDownloadHandler.HandleDelete)
+		protected.POST("/api/files/:id/copy", fileOperationsHandler.CopyFile)
+		protected.PATCH("/api/files/:id", fileOperationsHandler.UpdateFile)
+		protected.GET("/api/files/:id/versions", fileOperationsHandler.ListVersions)
+		protected.GET("/api/files/:id/versions/:versionId/download", fileDownloadHandler.DownloadVersion)
+		protected.POST("/api/files/:id/versions/:versionId/restore", fileOperationsHandler.RestoreVersion)
+
+		// Direct upload routes (browser uploads parts straight to storage)
+		protected.POST("/api/uploads", uploadSessionHandler.CreateSession)
+		protected.POST("/api/uploads/:id/parts", uploadSessionHandler.RefreshParts)
+		protected.POST("/api/uploads/:id/complete", uploadSessionHandler.CompleteSession)
+		protected.DELETE("/api/uploads/:id", uploadSessionHandler.AbortSession)
 
 		// Directory routes
 		protected.GET("/api/directories", directoryHandler.ListDirectory)
+		protected.GET("/api/directories/tree", directoryHandler.DirectoryTree)
 		protected.POST("/api/directories", directoryHandler.CreateDirectory)
 		protected.DELETE("/api/directories/:id", directoryHandler.DeleteDirectory)
+		protected.POST("/api/directories/:id/copy", fileOperationsHandler.CopyDirectory)
+		protected.PATCH("/api/directories/:id", fileOperationsHandler.UpdateDirectory)
+
+		// Archive routes (ZIP downloads of directories and selections)
+		protected.GET("/api/directories/:id/archive", archiveHandler.DownloadDirectory)
+		protected.POST("/api/archive", archiveHandler.DownloadSelection)
+
+		// Trash routes
+		protected.GET("/api/trash", trashHandler.ListTrash)
+		protected.POST("/api/trash/:id/restore", trashHandler.RestoreItem)
+		protected.DELETE("/api/trash/:id", trashHandler.PurgeItem)
+		protected.DELETE("/api/trash", trashHandler.EmptyTrash)
+
+		// Tag routes
+		protected.GET("/api/tags", tagHandler.ListTags)
+		protected.POST("/api/tags", tagHandler.CreateTag)
+		protected.PATCH("/api/tags/:id", tagHandler.UpdateTag)
+		protected.DELETE("/api/tags/:id", tagHandler.DeleteTag)
+		protected.GET("/api/files/:id/tags", tagHandler.ItemTags(models.ResourceTypeFile))
+		protected.POST("/api/files/:id/tags", tagHandler.AddItemTag(models.ResourceTypeFile))
+		protected.DELETE("/api/files/:id/tags/:tagId", tagHandler.RemoveItemTag(models.ResourceTypeFile))
+		protected.GET("/api/directories/:id/tags", tagHandler.ItemTags(models.ResourceTypeDirectory))
+		protected.POST("/api/directories/:id/tags", tagHandler.AddItemTag(models.ResourceTypeDirectory))
+		protected.DELETE("/api/directories/:id/tags/:tagId", tagHandler.RemoveItemTag(models.ResourceTypeDirectory))
+
+		// Metadata routes
+		protected.GET("/api/files/:id/metadata", tagHandler.ItemMetadata(models.ResourceTypeFile))
+		protected.PUT("/api/files/:id/metadata/:key", tagHandler.SetItemMetadata(models.ResourceTypeFile))
+		protected.DELETE("/api/files/:id/metadata/:key", tagHandler.DeleteItemMetadata(models.ResourceTypeFile))
+		protected.GET("/api/directories/:id/metadata", tagHandler.ItemMetadata(models.ResourceTypeDirectory))
+		protected.PUT("/api/directories/:id/metadata/:key", tagHandler.SetItemMetadata(models.ResourceTypeDirectory))
+		protected.DELETE("/api/directories/:id/metadata/:key", tagHandler.DeleteItemMetadata(models.ResourceTypeDirectory))
+
+		// Activity and starred routes
+		protected.GET("/api/activity", activityHandler.ListActivity)
+		protected.GET("/api/recent", activityHandler.RecentFiles)
+		protected.GET("/api/starred", activityHandler.ListStarred)
+		protected.PUT("/api/files/:id/star", activityHandler.SetStarred(models.ResourceTypeFile, true))
+		protected.DELETE("/api/files/:id/star", activityHandler.SetStarred(models.ResourceTypeFile, false))
+		protected.PUT("/api/directories/:id/star", activityHandler.SetStarred(models.ResourceTypeDirectory, true))
+		protected.DELETE("/api/directories/:id/star", activityHandler.SetStarred(models.ResourceTypeDirectory, false))
+
+		// Search routes
+		protected.GET("/api/search", searchHandler.Search)
 
 		// Share routes
 		protected.POST("/api/shares", shareHandler.CreateShare)
@@ -224,6 +352,29 @@ func main() {
 	// Public share access (no auth required)
 	router.GET("/share", shareHandler.AccessShare)
 	router.POST("/share", shareHandler.AccessShare)
+	router.GET("/share/files/:id/download", fileDownloadHandler.HandleDownload)
+	router.POST("/share/files/upload", sessionManager.OptionalAuth(), fileUploadHandler.HandleUpload)
+	router.GET("/share/directories/:id/archive", archiveHandler.DownloadDirectory)
+	router.POST("/share/archive", archiveHandler.DownloadSelection)
+
+	// Resumable uploads (tus protocol); share uploads work without a session
+	tus := router.Group("/api/tus/files")
+	tus.Use(sessionManager.OptionalAuth(), tusHandler.Protocol())
+	{
+		tus.OPTIONS("", tusHandler.Options)
+		tus.POST("", tusHandler.CreateUpload)
+		tus.OPTIONS("/:id", tusHandler.Options)
+		tus.HEAD("/:id", tusHandler.UploadStatus)
+		tus.PATCH("/:id", tusHandler.WriteChunk)
+		tus.DELETE("/:id", tusHandler.Terminate)
+	}
+
+	// Presigned URLs for the local storage backend (authorized by signature)
+	if localStorage, ok := s3Service.(*services.LocalStorageService); ok {
+		localStorageHandler := handlers.NewLocalStorageHandler(localStorage, logger)
+		router.GET(services.LocalStoragePathPrefix+"*key", localStorageHandler.ServeObject)
+		router.HEAD(services.LocalStoragePathPrefix+"*key", localStorageHandler.ServeObject)
+	}
 
 	// Admin routes (require admin privileges)
 	admin := router.Group("/admin")
@@ -242,6 +393,10 @@ func main() {
 		admin.DELETE("/api/users/:id", adminHandler.DeleteUser)
 		admin.GET("/api/users/search", adminHandler.SearchUsers)
 		admin.POST("/api/settings/update", adminHandler.UpdateSystemSettings)
+
+		// Storage maintenance
+		admin.POST("/api/storage/reconcile", reconcileHandler.Reconcile)
+		admin.POST("/api/search/reindex", contentIndexHandler.Reindex)
 	}
 
 	// Root redirect to dashboard or login
@@ -402,6 +557,46 @@ func metricsMiddleware(metricsService *services.MetricsService) gin.HandlerFunc
 	}
 }
 
+// runReconcile runs storage reconciliation and prints the report as JSON
+func runReconcile(reconcileService *services.ReconcileService, apply bool) error {
+	report, err := reconcileService.Reconcile(context.Background(), apply)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// runReindexContent indexes the text of files and prints the report as JSON
+func runReindexContent(contentIndexService *services.ContentIndexService, force bool) error {
+	report, err := contentIndexService.Reindex(context.Background(), force)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// runKeyRotation re-wraps every data key with the current master key
+func runKeyRotation(s3Service services.S3Service) error {
+	encrypted, ok := s3Service.(*services.EncryptedStorage)
+	if !ok {
+		return errors.New("encryption is not enabled")
+	}
+
+	rotated, err := encrypted.RotateKeys(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-wrapped %d data keys\n", rotated)
+	return nil
+}
+
 // ensureAdminUser ensures the admin user exists with proper is_admin flag
 func ensureAdminUser(userService *services.UserService, logger zerolog.Logger) {
 	adminEmail := os.Getenv("ADMIN_EMAIL")
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in a user's activity
+const (
+	ActivityUpload   = "upload"
+	ActivityDownload = "download"
+	ActivityRename   = "rename"
+	ActivityMove     = "move"
+	ActivityDelete   = "delete"
+	ActivityShare    = "share"
+)
+
+// Activity records something a user did to one of their files or
+// directories, for their recent activity. The name is kept as it was at
+// the time, so entries still read well after the item is renamed or gone.
+type Activity struct {
+	ID        string    `gorm:"primaryKey;size:15" json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_activities_user_created,priority:2" json:"created"`
+
+	User         string       `gorm:"size:15;not null;index:idx_activities_user_created,priority:1" json:"user"` // Foreign key to users, who did it
+	Action       string       `gorm:"size:20;not null" json:"action"`                                            // One of the Activity* actions
+	ResourceType ResourceType `gorm:"size:20;not null" json:"resource_type"`                                     // ResourceTypeFile or ResourceTypeDirectory
+	Resource     string       `gorm:"size:15;not null;index" json:"resource"`                                    // Foreign key to files or directories
+	Name         string       `gorm:"size:255;not null" json:"name"`                                             // Name of the item at the time
+	Detail       string       `gorm:"size:255" json:"detail,omitempty"`                                          // Like the old name of a renamed item
+}
+
+// TableName returns the table name for the Activity model
+func (a *Activity) TableName() string {
+	return "activities"
+}
+
+// BeforeCreate hook to generate ID if not set
+func (a *Activity) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		a.ID = GenerateID()
+	}
+	return nil
+}
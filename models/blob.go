@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Blob represents a content-addressed stored object that can be shared by
+// several file records. Blobs are keyed by the SHA-256 of their content and
+// reference counted, so the object is only removed with its last reference.
+type Blob struct {
+	Checksum  string    `gorm:"primaryKey;size:64" json:"checksum"` // SHA256 checksum
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated"`
+
+	S3Key    string `gorm:"size:512;not null;uniqueIndex" json:"s3_key"`
+	Size     int64  `gorm:"not null;default:0" json:"size"`
+	RefCount int64  `gorm:"not null;default:0" json:"ref_count"`
+
+	WrappedKey string `gorm:"size:255" json:"-"` // Data key wrapped with the master key, empty if stored unencrypted
+}
+
+// TableName returns the table name for the Blob model
+func (b *Blob) TableName() string {
+	return "blobs"
+}
+
+// BlobKey returns the S3 key for content with the given SHA-256 checksum.
+// Keys are fanned out by the first two bytes to keep listings manageable:
+// blobs/sha256/{ab}/{cd}/{checksum}
+func BlobKey(checksum string) string {
+	if len(checksum) < 4 {
+		return "blobs/sha256/" + checksum
+	}
+	return "blobs/sha256/" + checksum[0:2] + "/" + checksum[2:4] + "/" + checksum
+}
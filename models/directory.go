@@ -21,6 +21,13 @@ type Directory struct {
 	Path            string `gorm:"size:1024;not null;index" json:"path"`
 	User            string `gorm:"size:15;not null;index" json:"user"` // Foreign key to users
 	ParentDirectory string `gorm:"size:15;index" json:"parent_directory"` // Foreign key to directories (optional)
+
+	Starred bool `gorm:"not null;default:false;index" json:"starred"` // Marked as a favorite by the owner
+
+	// Trash. Trashed items keep their parent and path, so they can be
+	// restored where they were.
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`         // When the directory was moved to the trash
+	TrashedWith string         `gorm:"size:15;index" json:"-"` // Trashed directory the directory went with, empty if trashed directly
 }
 
 // TableName returns the table name for the Directory model
@@ -21,9 +21,19 @@ type File struct {
 	ParentDirectory string `gorm:"size:15;index" json:"parent_directory"` // Foreign key to directories (optional)
 	Size            int64  `gorm:"not null;default:0" json:"size"`
 	MimeType        string `gorm:"size:255" json:"mime_type"`
-	S3Key           string `gorm:"size:512;not null" json:"s3_key"`
+	S3Key           string `gorm:"size:512;not null;index" json:"s3_key"`
 	S3Bucket        string `gorm:"size:255;not null" json:"s3_bucket"`
 	Checksum        string `gorm:"size:64" json:"checksum"` // SHA256 checksum
+	WrappedKey      string `gorm:"size:255" json:"-"`       // Data key wrapped with the master key, empty if stored unencrypted
+
+	Version int `gorm:"not null;default:1" json:"version"` // Current version number; replaced versions are kept as FileVersions
+
+	Starred bool `gorm:"not null;default:false;index" json:"starred"` // Marked as a favorite by the owner
+
+	// Trash. Trashed items keep their parent and path, so they can be
+	// restored where they were.
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`         // When the file was moved to the trash
+	TrashedWith string         `gorm:"size:15;index" json:"-"` // Trashed directory the file went with, empty if trashed directly
 }
 
 // TableName returns the table name for the File model
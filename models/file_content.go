@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// Content index statuses
+const (
+	ContentIndexed = "indexed" // The file's text is in the content search table
+	ContentSkipped = "skipped" // The file isn't text-like, or is too large
+	ContentFailed  = "failed"  // Reading or extracting the file failed
+)
+
+// FileContent records the indexing of a file's text for search. The text
+// itself is in the ContentSearchTable FTS5 table, under DocID.
+type FileContent struct {
+	FileID    string    `gorm:"primaryKey;size:15" json:"file_id"` // Foreign key to files
+	IndexedAt time.Time `gorm:"autoUpdateTime" json:"indexed_at"`
+
+	DocID    int64  `gorm:"not null;default:0;index" json:"-"`    // Rowid of the text in the content search table, 0 if none
+	Checksum string `gorm:"size:64" json:"checksum"`              // Checksum of the content that was indexed
+	Status   string `gorm:"size:16;not null;index" json:"status"` // ContentIndexed, ContentSkipped or ContentFailed
+	Reason   string `gorm:"size:255" json:"reason,omitempty"`     // Why the file was skipped or failed
+}
+
+// TableName returns the table name for the FileContent model
+func (c *FileContent) TableName() string {
+	return "file_contents"
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FileVersion is an earlier content of a file, kept when an upload or a
+// restore replaced it. The file record always holds the current version.
+type FileVersion struct {
+	ID         string    `gorm:"primaryKey;size:15" json:"id"`
+	ReplacedAt time.Time `gorm:"autoCreateTime;index" json:"replaced_at"` // When a newer version took its place
+
+	File    string `gorm:"size:15;not null;index" json:"file"` // Foreign key to files
+	User    string `gorm:"size:15;not null;index" json:"user"` // Foreign key to users, charged for the version
+	Version int    `gorm:"not null" json:"version"`
+
+	Size       int64  `gorm:"not null;default:0" json:"size"`
+	MimeType   string `gorm:"size:255" json:"mime_type"`
+	S3Key      string `gorm:"size:512;not null;index" json:"-"`
+	S3Bucket   string `gorm:"size:255;not null" json:"-"`
+	Checksum   string `gorm:"size:64" json:"checksum"` // SHA256 checksum
+	WrappedKey string `gorm:"size:255" json:"-"`       // Data key wrapped with the master key, empty if stored unencrypted
+}
+
+// TableName returns the table name for the FileVersion model
+func (v *FileVersion) TableName() string {
+	return "file_versions"
+}
+
+// BeforeCreate hook to generate ID if not set
+func (v *FileVersion) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == "" {
+		v.ID = GenerateID()
+	}
+	return nil
+}
+
+// NewFileVersion captures the current content of file as a version
+func NewFileVersion(file *File) *FileVersion {
+	return &FileVersion{
+		File:       file.ID,
+		User:       file.User,
+		Version:    file.Version,
+		Size:       file.Size,
+		MimeType:   file.MimeType,
+		S3Key:      file.S3Key,
+		S3Bucket:   file.S3Bucket,
+		Checksum:   file.Checksum,
+		WrappedKey: file.WrappedKey,
+	}
+}
+
+// ContentOf returns file with its content replaced by this version's
+func (v *FileVersion) ContentOf(file *File) *File {
+	versioned := *file
+	versioned.Version = v.Version
+	versioned.Size = v.Size
+	versioned.MimeType = v.MimeType
+	versioned.S3Key = v.S3Key
+	versioned.S3Bucket = v.S3Bucket
+	versioned.Checksum = v.Checksum
+	versioned.WrappedKey = v.WrappedKey
+	return &versioned
+}
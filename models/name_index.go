@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Name indexes. Each is a unique index on the owner, parent directory and
+// name of the live (not trashed) rows of a table, so two files, or two
+// directories, can't share a name in one directory however they are
+// written. Root items may have an empty or a NULL parent, which the index
+// treats as the same.
+const (
+	FileNameIndex      = "idx_files_live_name"
+	DirectoryNameIndex = "idx_directories_live_name"
+)
+
+// MigrateNameIndexes creates the name indexes. Duplicates written before an
+// index existed are renamed first, all but the oldest getting a free
+// "name (n)" like a rename on conflict would give them.
+func MigrateNameIndexes(db *gorm.DB) error {
+	for _, index := range []struct {
+		name, table string
+		isDir       bool
+	}{
+		{FileNameIndex, "files", false},
+		{DirectoryNameIndex, "directories", true},
+	} {
+		var exists int64
+		if err := db.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?`, index.name).Scan(&exists).Error; err != nil {
+			return fmt.Errorf("failed to check name index %s: %w", index.name, err)
+		}
+		if exists > 0 {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := renameDuplicates(tx, index.table, index.isDir); err != nil {
+				return err
+			}
+			return tx.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX %s ON %s(user, COALESCE(parent_directory, ''), name) WHERE deleted_at IS NULL`, index.name, index.table)).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create name index %s: %w", index.name, err)
+		}
+	}
+	return nil
+}
+
+// renameDuplicates renames the live rows of table whose name is already
+// held by an older live row in the same directory
+func renameDuplicates(tx *gorm.DB, table string, isDir bool) error {
+	var duplicates []struct {
+		ID     string
+		User   string
+		Parent string
+		Name   string
+		Path   string
+	}
+	err := tx.Raw(fmt.Sprintf(`SELECT t.id, t.user, COALESCE(t.parent_directory, '') AS parent, t.name, t.path FROM %[1]s t
+		WHERE t.deleted_at IS NULL AND EXISTS (
+			SELECT 1 FROM %[1]s o WHERE o.deleted_at IS NULL AND o.user = t.user AND o.name = t.name
+				AND COALESCE(o.parent_directory, '') = COALESCE(t.parent_directory, '')
+				AND (o.created_at < t.created_at OR (o.created_at = t.created_at AND o.id < t.id))
+		) ORDER BY t.created_at, t.id`, table)).Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+
+	for _, dup := range duplicates {
+		name, err := freeName(tx, dup.User, dup.Parent, dup.Name, isDir)
+		if err != nil {
+			return err
+		}
+		if err := tx.Exec(fmt.Sprintf(`UPDATE %s SET name = ? WHERE id = ?`, table), name, dup.ID).Error; err != nil {
+			return err
+		}
+		if isDir {
+			dir := Directory{Name: dup.Name, Path: dup.Path}
+			oldPath := dir.GetFullPath()
+			dir.Name = name
+			if err := rewriteSubtreePaths(tx, dup.ID, oldPath, dir.GetFullPath()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// freeName returns the first "name (n)" not held by a live file or
+// directory of userID in directory parentID
+func freeName(tx *gorm.DB, userID, parentID, name string, isDir bool) (string, error) {
+	base, ext := name, ""
+	if !isDir {
+		ext = filepath.Ext(name)
+		base = strings.TrimSuffix(name, ext)
+	}
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		var taken int64
+		err := tx.Raw(`SELECT (SELECT COUNT(*) FROM files WHERE deleted_at IS NULL AND user = ? AND COALESCE(parent_directory, '') = ? AND name = ?)
+			+ (SELECT COUNT(*) FROM directories WHERE deleted_at IS NULL AND user = ? AND COALESCE(parent_directory, '') = ? AND name = ?)`,
+			userID, parentID, candidate, userID, parentID, candidate).Scan(&taken).Error
+		if err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// rewriteSubtreePaths replaces the oldPath prefix of the stored paths of
+// everything below directory dirID, trashed or not, with newPath
+func rewriteSubtreePaths(tx *gorm.DB, dirID, oldPath, newPath string) error {
+	subtree := `WITH RECURSIVE subtree(id) AS (
+		SELECT ? UNION SELECT d.id FROM directories d JOIN subtree ON d.parent_directory = subtree.id
+	) SELECT id FROM subtree`
+	// substr counts characters, not bytes
+	rest := utf8.RuneCountInString(oldPath) + 1
+	if err := tx.Exec(`UPDATE directories SET path = ? || substr(path, ?) WHERE id <> ? AND id IN (`+subtree+`)`,
+		newPath, rest, dirID, dirID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`UPDATE files SET path = ? || substr(path, ?) WHERE parent_directory IN (`+subtree+`)`,
+		newPath, rest, dirID).Error
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Search index tables. Each is an SQLite FTS5 table of the names and paths
+// of the live (not trashed) rows of a table, keyed by that table's rowid.
+// The trigram tokenizer lets any substring of three or more characters be
+// matched through the index.
+const (
+	FileSearchTable      = "file_search"
+	DirectorySearchTable = "directory_search"
+)
+
+// ContentSearchTable is the FTS5 table of the text inside files, written by
+// the content indexer and tracked by FileContent records. It is tokenized
+// into words, for matching and snippets of document text.
+const ContentSearchTable = "file_content_search"
+
+// MigrateSearchIndex creates the search index tables and the triggers that
+// keep them in sync with the files and directories tables on insert,
+// update (rename, move, trash and restore) and delete, then rebuilds them
+// from the tables. Rebuilding on every start picks up rows written before
+// the index existed, and rowids changed by a VACUUM. It also creates the
+// content index.
+func MigrateSearchIndex(db *gorm.DB) error {
+	for _, index := range []struct{ table, search string }{
+		{"files", FileSearchTable},
+		{"directories", DirectorySearchTable},
+	} {
+		statements := []string{
+			fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(name, path, tokenize = 'trigram')`, index.search),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_insert AFTER INSERT ON %[2]s WHEN NEW.deleted_at IS NULL BEGIN
+				INSERT INTO %[1]s(rowid, name, path) VALUES (NEW.rowid, NEW.name, NEW.path);
+			END`, index.search, index.table),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_update AFTER UPDATE OF name, path, deleted_at ON %[2]s BEGIN
+				DELETE FROM %[1]s WHERE rowid = OLD.rowid;
+				INSERT INTO %[1]s(rowid, name, path) SELECT NEW.rowid, NEW.name, NEW.path WHERE NEW.deleted_at IS NULL;
+			END`, index.search, index.table),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_delete AFTER DELETE ON %[2]s BEGIN
+				DELETE FROM %[1]s WHERE rowid = OLD.rowid;
+			END`, index.search, index.table),
+		}
+		for _, statement := range statements {
+			if err := db.Exec(statement).Error; err != nil {
+				return fmt.Errorf("failed to create search index %s: %w", index.search, err)
+			}
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(fmt.Sprintf(`DELETE FROM %s`, index.search)).Error; err != nil {
+				return err
+			}
+			return tx.Exec(fmt.Sprintf(`INSERT INTO %s(rowid, name, path) SELECT rowid, name, path FROM %s WHERE deleted_at IS NULL`, index.search, index.table)).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to rebuild search index %s: %w", index.search, err)
+		}
+	}
+
+	return migrateContentIndex(db)
+}
+
+// migrateContentIndex creates the content search table, its FileContent
+// records, and the trigger that drops a file's text when the file is
+// deleted. Unlike names, the text can't be rebuilt from the database, so
+// it is kept across starts.
+func migrateContentIndex(db *gorm.DB) error {
+	if err := db.AutoMigrate(&FileContent{}); err != nil {
+		return fmt.Errorf("failed to create content index: %w", err)
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(content, tokenize = 'unicode61 remove_diacritics 2')`, ContentSearchTable),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_delete AFTER DELETE ON files BEGIN
+			DELETE FROM %[1]s WHERE rowid = (SELECT doc_id FROM file_contents WHERE file_id = OLD.id);
+			DELETE FROM file_contents WHERE file_id = OLD.id;
+		END`, ContentSearchTable),
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create content index: %w", err)
+		}
+	}
+	return nil
+}
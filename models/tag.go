@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Tag is a label a user puts on their files and directories. Names are
+// unique per user, without regard to case.
+type Tag struct {
+	ID        string    `gorm:"primaryKey;size:15" json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated"`
+
+	User  string `gorm:"size:15;not null;uniqueIndex:idx_tags_user_name" json:"user"` // Foreign key to users
+	Name  string `gorm:"size:64;not null;uniqueIndex:idx_tags_user_name" json:"name"`
+	Color string `gorm:"size:7" json:"color,omitempty"` // Like #1e90ff, empty for the default
+}
+
+// TableName returns the table name for the Tag model
+func (t *Tag) TableName() string {
+	return "tags"
+}
+
+// BeforeCreate hook to generate ID if not set
+func (t *Tag) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = GenerateID()
+	}
+	return nil
+}
+
+// TagLink puts a tag on a file or directory
+type TagLink struct {
+	Tag          string       `gorm:"primaryKey;size:15" json:"tag"`            // Foreign key to tags
+	ResourceType ResourceType `gorm:"primaryKey;size:20" json:"resource_type"`  // ResourceTypeFile or ResourceTypeDirectory
+	Resource     string       `gorm:"primaryKey;size:15;index" json:"resource"` // Foreign key to files or directories
+	CreatedAt    time.Time    `gorm:"autoCreateTime" json:"created"`
+}
+
+// TableName returns the table name for the TagLink model
+func (l *TagLink) TableName() string {
+	return "tag_links"
+}
+
+// Metadata is a user-defined key and value on a file or directory
+type Metadata struct {
+	ResourceType ResourceType `gorm:"primaryKey;size:20" json:"-"` // ResourceTypeFile or ResourceTypeDirectory
+	Resource     string       `gorm:"primaryKey;size:15" json:"-"` // Foreign key to files or directories
+	Key          string       `gorm:"primaryKey;size:64" json:"key"`
+	Value        string       `gorm:"size:1024;not null" json:"value"`
+	User         string       `gorm:"size:15;not null;index" json:"-"` // Foreign key to users, the owner of the resource
+	UpdatedAt    time.Time    `gorm:"autoUpdateTime" json:"updated"`
+}
+
+// TableName returns the table name for the Metadata model
+func (m *Metadata) TableName() string {
+	return "metadata"
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TusPart records an uploaded part of a tus upload's storage multipart upload
+type TusPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// TusUpload tracks a resumable upload made with the tus protocol. Received
+// bytes are written to a storage multipart upload; bytes that don't fill a
+// whole part yet are staged in a file on disk until more data arrives.
+// Completed uploads are kept until they expire so clients can still query
+// the offset.
+type TusUpload struct {
+	ID        string    `gorm:"primaryKey;size:15" json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated"`
+
+	User            string    `gorm:"size:15;not null;index" json:"user"`    // Owner of the resulting file
+	Share           string    `gorm:"size:15;index" json:"share,omitempty"`  // Share the upload was made through (optional)
+	ParentDirectory string    `gorm:"size:15;index" json:"parent_directory"` // Foreign key to directories (optional)
+	FileName        string    `gorm:"size:255;not null" json:"file_name"`
+	MimeType        string    `gorm:"size:255" json:"mime_type"`
+	Metadata        string    `gorm:"size:4096" json:"-"` // Raw Upload-Metadata header, echoed back on HEAD
+	Size            int64     `gorm:"not null" json:"size"`
+	Offset          int64     `gorm:"not null;default:0" json:"offset"`
+	ConflictPolicy  string    `gorm:"size:16" json:"on_conflict"` // Applied if the name is taken when the upload completes
+	S3Key           string    `gorm:"size:512;not null" json:"-"`
+	UploadID        string    `gorm:"size:1024;not null" json:"-"` // Storage multipart upload ID
+	Parts           []TusPart `gorm:"serializer:json" json:"-"`
+	Staged          string    `gorm:"size:1024" json:"-"`            // File holding received bytes not yet uploaded as a part, empty if none
+	StagedSize      int64     `gorm:"not null;default:0" json:"-"`   // Bytes in the staged file
+	HashState       []byte    `json:"-"`                             // Marshaled SHA-256 state of the bytes received so far
+	File            string    `gorm:"size:15" json:"file,omitempty"` // Resulting file, set once the upload is complete
+	ExpiresAt       time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// TableName returns the table name for the TusUpload model
+func (t *TusUpload) TableName() string {
+	return "tus_uploads"
+}
+
+// BeforeCreate hook to generate ID if not set
+func (t *TusUpload) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = GenerateID()
+	}
+	return nil
+}
+
+// IsExpired checks if the upload has expired
+func (t *TusUpload) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+// IsComplete checks if all bytes of the upload have been received
+func (t *TusUpload) IsComplete() bool {
+	return t.Offset >= t.Size
+}
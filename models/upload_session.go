@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UploadSession tracks a browser-driven multipart upload straight to
+// storage. The file record is only created once the session is completed.
+type UploadSession struct {
+	ID        string    `gorm:"primaryKey;size:15" json:"id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated"`
+
+	User            string    `gorm:"size:15;not null;index" json:"user"`    // Foreign key to users
+	ParentDirectory string    `gorm:"size:15;index" json:"parent_directory"` // Foreign key to directories (optional)
+	FileName        string    `gorm:"size:255;not null" json:"file_name"`
+	Size            int64     `gorm:"not null" json:"size"`
+	MimeType        string    `gorm:"size:255" json:"mime_type"`
+	ConflictPolicy  string    `gorm:"size:16" json:"on_conflict"` // Applied if the name is taken when the upload completes
+	S3Key           string    `gorm:"size:512;not null" json:"-"`
+	UploadID        string    `gorm:"size:1024;not null" json:"-"` // Storage multipart upload ID
+	PartSize        int64     `gorm:"not null" json:"part_size"`
+	PartCount       int       `gorm:"not null" json:"part_count"`
+	ExpiresAt       time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// TableName returns the table name for the UploadSession model
+func (u *UploadSession) TableName() string {
+	return "upload_sessions"
+}
+
+// BeforeCreate hook to generate ID if not set
+func (u *UploadSession) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == "" {
+		u.ID = GenerateID()
+	}
+	return nil
+}
+
+// IsExpired checks if the upload session has expired
+func (u *UploadSession) IsExpired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
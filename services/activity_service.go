@@ -0,0 +1,144 @@
+package services
+
+import (
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxActivitiesPerUser bounds the activity kept for each user; the
+	// oldest entries are dropped as new ones are recorded
+	maxActivitiesPerUser = 1000
+	// DefaultActivityLimit is how many entries are listed when no limit is given
+	DefaultActivityLimit = 50
+	// MaxActivityLimit bounds how many entries one request can list
+	MaxActivityLimit = 200
+)
+
+// ActivityService records what users do to their files and directories
+// (uploads, downloads, renames, moves, deletes and shares) and lists it
+// back as their recent activity
+type ActivityService struct {
+	db     *gorm.DB
+	logger zerolog.Logger
+}
+
+// NewActivityService creates a new activity service
+func NewActivityService(db *gorm.DB, logger zerolog.Logger) *ActivityService {
+	return &ActivityService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// RecentFile is a file with the last thing its owner did to it
+type RecentFile struct {
+	File   *models.File `json:"file"`
+	Action string       `json:"action"`
+	At     time.Time    `json:"at"`
+}
+
+// Record records that userID did action to a file or directory. Failures
+// are logged rather than returned, so recording never fails the action
+// itself.
+func (s *ActivityService) Record(userID, action string, resourceType models.ResourceType, resourceID, name, detail string) {
+	activity := &models.Activity{
+		User:         userID,
+		Action:       action,
+		ResourceType: resourceType,
+		Resource:     resourceID,
+		Name:         name,
+		Detail:       detail,
+	}
+	if err := s.db.Create(activity).Error; err != nil {
+		s.logger.Error().Err(err).
+			Str("user_id", userID).
+			Str("action", action).
+			Str("resource_id", resourceID).
+			Msg("Failed to record activity")
+		return
+	}
+
+	// Drop what falls past the limit
+	kept := s.db.Model(&models.Activity{}).
+		Select("id").
+		Where("user = ?", userID).
+		Order("created_at DESC").
+		Limit(maxActivitiesPerUser)
+	if err := s.db.Where("user = ? AND id NOT IN (?)", userID, kept).Delete(&models.Activity{}).Error; err != nil {
+		s.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to prune activity")
+	}
+}
+
+// Recent returns userID's most recent activity, newest first. A limit of 0
+// is DefaultActivityLimit.
+func (s *ActivityService) Recent(userID string, limit int) ([]*models.Activity, error) {
+	var activities []*models.Activity
+	err := s.db.Where("user = ?", userID).
+		Order("created_at DESC").
+		Limit(activityLimit(limit)).
+		Find(&activities).Error
+	if err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// RecentFiles returns userID's live files they most recently uploaded,
+// downloaded, renamed, moved or shared, each once, most recent first. A
+// limit of 0 is DefaultActivityLimit.
+func (s *ActivityService) RecentFiles(userID string, limit int) ([]*RecentFile, error) {
+	limit = activityLimit(limit)
+
+	var activities []*models.Activity
+	err := s.db.Table("activities AS a").
+		Select("a.*").
+		Joins("JOIN files ON files.id = a.resource AND files.user = a.user AND files.deleted_at IS NULL").
+		Where("a.user = ? AND a.resource_type = ?", userID, models.ResourceTypeFile).
+		Where("a.created_at = (SELECT MAX(b.created_at) FROM activities b WHERE b.user = a.user AND b.resource = a.resource)").
+		Order("a.created_at DESC").
+		Limit(limit).
+		Find(&activities).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(activities) == 0 {
+		return []*RecentFile{}, nil
+	}
+
+	ids := make([]string, len(activities))
+	for i, activity := range activities {
+		ids[i] = activity.Resource
+	}
+	var files []*models.File
+	if err := s.db.Where("id IN ? AND user = ?", ids, userID).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.File, len(files))
+	for _, file := range files {
+		byID[file.ID] = file
+	}
+
+	recent := make([]*RecentFile, 0, len(activities))
+	for _, activity := range activities {
+		file := byID[activity.Resource]
+		if file == nil {
+			continue
+		}
+		// Entries recorded at the same instant list a file once
+		delete(byID, activity.Resource)
+		recent = append(recent, &RecentFile{File: file, Action: activity.Action, At: activity.CreatedAt})
+	}
+	return recent, nil
+}
+
+// activityLimit applies the default and maximum to a requested limit
+func activityLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultActivityLimit
+	}
+	return min(limit, MaxActivityLimit)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestActivityService(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	activity := NewActivityService(db, zerolog.Nop())
+	service.SetActivityService(activity)
+	shares := NewShareService(db, zerolog.Nop())
+	shares.SetActivityService(activity)
+
+	user, err := service.userService.CreateUser("activity@example.com", "activityuser", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("activity2@example.com", "activityuser2", "Password123!", false)
+	require.NoError(t, err)
+
+	docs := createTestDirectory(t, db, user.ID, nil, "Docs")
+	report := createTestTreeFile(t, storage, db, user.ID, nil, "report.txt", "report")
+	notes := createTestTreeFile(t, storage, db, user.ID, nil, "notes.txt", "notes")
+	theirs := createTestTreeFile(t, storage, db, other.ID, nil, "theirs.txt", "theirs")
+
+	actions := func(activities []*models.Activity) []string {
+		var result []string
+		for _, a := range activities {
+			result = append(result, a.Action+" "+a.Name)
+		}
+		return result
+	}
+
+	t.Run("Records uploads, downloads, renames, moves, shares and deletes", func(t *testing.T) {
+		service.FinishUpload(context.Background(), report, &UploadResult{})
+		service.RecordDownload(user.ID, notes)
+		service.RecordDownload(user.ID, theirs)
+		service.RecordDownload("", report)
+
+		name := "summary.txt"
+		_, err := service.MoveFile(user.ID, report.ID, MoveOptions{Name: &name})
+		require.NoError(t, err)
+		_, err = service.MoveFile(user.ID, notes.ID, MoveOptions{ParentDirectory: &docs.ID})
+		require.NoError(t, err)
+		same := "notes.txt"
+		_, err = service.MoveFile(user.ID, notes.ID, MoveOptions{Name: &same})
+		require.NoError(t, err)
+
+		_, err = shares.CreateShare(user.ID, docs.ID, models.ResourceTypeDirectory, models.PermissionRead, "", nil)
+		require.NoError(t, err)
+		_, err = service.TrashDirectory(user.ID, docs.ID)
+		require.NoError(t, err)
+
+		recent, err := activity.Recent(user.ID, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"delete Docs",
+			"share Docs",
+			"move notes.txt",
+			"rename summary.txt",
+			"download notes.txt",
+			"upload report.txt",
+		}, actions(recent), "Unchanged moves and others' downloads aren't recorded")
+		assert.Equal(t, "report.txt", recent[3].Detail, "Renames keep the old name")
+		assert.Equal(t, "/", recent[2].Detail, "Moves keep the old path")
+		assert.Equal(t, string(models.PermissionRead), recent[1].Detail)
+
+		recent, err = activity.Recent(user.ID, 2)
+		require.NoError(t, err)
+		assert.Len(t, recent, 2)
+
+		recent, err = activity.Recent(other.ID, 0)
+		require.NoError(t, err)
+		assert.Empty(t, recent)
+	})
+
+	t.Run("Recent files are listed once and only while live", func(t *testing.T) {
+		_, err := service.Restore(user.ID, docs.ID)
+		require.NoError(t, err)
+		service.RecordDownload(user.ID, report)
+
+		files, err := activity.RecentFiles(user.ID, 0)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		assert.Equal(t, report.ID, files[0].File.ID)
+		assert.Equal(t, models.ActivityDownload, files[0].Action)
+		assert.Equal(t, notes.ID, files[1].File.ID)
+		assert.Equal(t, models.ActivityMove, files[1].Action)
+
+		_, err = service.TrashFile(user.ID, report.ID)
+		require.NoError(t, err)
+		files, err = activity.RecentFiles(user.ID, 0)
+		require.NoError(t, err)
+		require.Len(t, files, 1, "Trashed files are left out")
+		assert.Equal(t, notes.ID, files[0].File.ID)
+	})
+
+	t.Run("Old activity is dropped past the limit", func(t *testing.T) {
+		old := make([]*models.Activity, maxActivitiesPerUser)
+		for i := range old {
+			old[i] = &models.Activity{
+				User:         other.ID,
+				Action:       models.ActivityDownload,
+				ResourceType: models.ResourceTypeFile,
+				Resource:     theirs.ID,
+				Name:         theirs.Name,
+				CreatedAt:    time.Now().Add(-time.Duration(i+1) * time.Minute),
+			}
+		}
+		require.NoError(t, db.CreateInBatches(old, fileBatchSize).Error)
+
+		activity.Record(other.ID, models.ActivityUpload, models.ResourceTypeFile, theirs.ID, theirs.Name, "")
+
+		var count int64
+		require.NoError(t, db.Model(&models.Activity{}).Where("user = ?", other.ID).Count(&count).Error)
+		assert.Equal(t, int64(maxActivitiesPerUser), count)
+		var oldest int64
+		require.NoError(t, db.Model(&models.Activity{}).Where("id = ?", old[len(old)-1].ID).Count(&oldest).Error)
+		assert.Zero(t, oldest, "The oldest entry is dropped")
+	})
+}
+
+func TestFileService_Starred(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("starred@example.com", "starreduser", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("starred2@example.com", "starreduser2", "Password123!", false)
+	require.NoError(t, err)
+
+	projects := createTestDirectory(t, db, user.ID, nil, "Projects")
+	plan := createTestTreeFile(t, storage, db, user.ID, projects, "plan.txt", "plan")
+	budget := createTestTreeFile(t, storage, db, user.ID, nil, "budget.txt", "budget")
+	createTestTreeFile(t, storage, db, user.ID, nil, "other.txt", "other")
+
+	require.NoError(t, service.SetStarred(user.ID, models.ResourceTypeDirectory, projects.ID, true))
+	require.NoError(t, service.SetStarred(user.ID, models.ResourceTypeFile, plan.ID, true))
+	require.NoError(t, service.SetStarred(user.ID, models.ResourceTypeFile, budget.ID, true))
+	require.NoError(t, service.SetStarred(user.ID, models.ResourceTypeFile, budget.ID, true), "Starring twice is a no-op")
+
+	assert.ErrorIs(t, service.SetStarred(other.ID, models.ResourceTypeFile, plan.ID, true), gorm.ErrRecordNotFound, "Only the owner can star an item")
+	assert.ErrorIs(t, service.SetStarred(user.ID, models.ResourceTypeDirectory, plan.ID, true), gorm.ErrRecordNotFound, "Types must match")
+
+	var stored models.File
+	require.NoError(t, db.First(&stored, "id = ?", plan.ID).Error)
+	assert.True(t, stored.Starred)
+	assert.True(t, stored.UpdatedAt.Equal(plan.UpdatedAt), "Starring doesn't change the updated time")
+
+	starred, err := service.ListStarred(user.ID)
+	require.NoError(t, err)
+	require.Len(t, starred.Directories, 1)
+	assert.Equal(t, projects.ID, starred.Directories[0].ID)
+	require.Len(t, starred.Files, 2)
+	assert.Equal(t, budget.ID, starred.Files[0].ID, "Files are listed by name")
+	assert.Equal(t, plan.ID, starred.Files[1].ID)
+
+	require.NoError(t, service.SetStarred(user.ID, models.ResourceTypeFile, budget.ID, false))
+	_, err = service.TrashDirectory(user.ID, projects.ID)
+	require.NoError(t, err)
+	starred, err = service.ListStarred(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, starred.Directories, "Trashed items are left out")
+	assert.Empty(t, starred.Files)
+
+	_, err = service.Restore(user.ID, projects.ID)
+	require.NoError(t, err)
+	starred, err = service.ListStarred(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, starred.Directories, 1, "Restored items are still starred")
+	assert.Len(t, starred.Files, 1)
+
+	otherStarred, err := service.ListStarred(other.ID)
+	require.NoError(t, err)
+	assert.Empty(t, otherStarred.Directories)
+	assert.Empty(t, otherStarred.Files)
+}
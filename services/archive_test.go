@@ -0,0 +1,61 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService_WriteArchive(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("archive@example.com", "archiveuser", "Password123!", false)
+	require.NoError(t, err)
+
+	projects := createTestDirectory(t, db, user.ID, nil, "projects")
+	site := createTestDirectory(t, db, user.ID, projects, "site")
+	createTestDirectory(t, db, user.ID, site, "empty")
+	createTestTreeFile(t, storage, db, user.ID, projects, "plan.md", "the plan")
+	createTestTreeFile(t, storage, db, user.ID, site, "index.html", "<html></html>")
+	loose := createTestTreeFile(t, storage, db, user.ID, nil, "projects", "same name as the directory")
+
+	entries, err := service.ArchiveEntries([]string{projects.ID}, []string{loose.ID})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	written, err := service.WriteArchive(context.Background(), &buf, entries)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("the plan")+len("<html></html>")+len("same name as the directory")), written)
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	contents := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+
+	assert.Equal(t, map[string]string{
+		"projects/":                "",
+		"projects/site/":           "",
+		"projects/site/empty/":     "",
+		"projects/plan.md":         "the plan",
+		"projects/site/index.html": "<html></html>",
+		"projects (1)":             "same name as the directory",
+	}, contents)
+
+	t.Run("Missing items", func(t *testing.T) {
+		_, err := service.ArchiveEntries(nil, []string{"missing"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlobService manages content-addressed, reference-counted stored objects.
+// It is also the single place that releases file objects from storage, so
+// shared blobs are only removed when their last file reference goes away.
+type BlobService struct {
+	db        *gorm.DB
+	s3Service S3Service
+	logger    zerolog.Logger
+	locks     stripedMutex
+}
+
+// NewBlobService creates a new blob service
+func NewBlobService(db *gorm.DB, s3Service S3Service, logger zerolog.Logger) *BlobService {
+	return &BlobService{
+		db:        db,
+		s3Service: s3Service,
+		logger:    logger,
+	}
+}
+
+// Store hashes the content and takes a reference on the matching blob,
+// uploading it first if no blob with that checksum exists yet. The reader
+// is read twice (once to hash, once to upload), so it must be seekable.
+func (s *BlobService) Store(ctx context.Context, reader io.ReadSeeker, size int64, contentType string) (*models.Blob, error) {
+	checksumReader := NewChecksumReader(reader)
+	if _, err := io.Copy(io.Discard, checksumReader); err != nil {
+		return nil, fmt.Errorf("failed to hash content: %w", err)
+	}
+	if checksumReader.BytesRead() != size {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, checksumReader.BytesRead())
+	}
+	checksum := checksumReader.Checksum()
+
+	lock := s.locks.lockFor(checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Reuse an existing blob if there is one
+	result := s.db.Model(&models.Blob{}).
+		Where("checksum = ?", checksum).
+		Update("ref_count", gorm.Expr("ref_count + ?", 1))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to reference blob: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		var blob models.Blob
+		if err := s.db.First(&blob, "checksum = ?", checksum).Error; err != nil {
+			return nil, fmt.Errorf("failed to load blob: %w", err)
+		}
+
+		s.logger.Debug().
+			Str("checksum", checksum).
+			Int64("ref_count", blob.RefCount).
+			Msg("Deduplicated upload against existing blob")
+
+		return &blob, nil
+	}
+
+	// New content: upload it, then record the blob
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind content: %w", err)
+	}
+
+	blob := &models.Blob{
+		Checksum: checksum,
+		S3Key:    models.BlobKey(checksum),
+		Size:     size,
+		RefCount: 1,
+	}
+
+	wrappedKey, err := UploadObject(ctx, s.s3Service, blob.S3Key, reader, size, contentType)
+	if err != nil {
+		return nil, err
+	}
+	blob.WrappedKey = wrappedKey
+
+	err = s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "checksum"}},
+		// The object was just overwritten, so its data key is ours now
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":   gorm.Expr("ref_count + ?", 1),
+			"wrapped_key": blob.WrappedKey,
+		}),
+	}).Create(blob).Error
+	if err != nil {
+		// Nothing references the object yet, so it's safe to remove
+		s.s3Service.DeleteFile(context.WithoutCancel(ctx), blob.S3Key)
+		return nil, fmt.Errorf("failed to record blob: %w", err)
+	}
+
+	s.logger.Info().
+		Str("checksum", checksum).
+		Str("s3_key", blob.S3Key).
+		Int64("size", size).
+		Msg("Stored new blob")
+
+	return blob, nil
+}
+
+// AddReference takes an extra reference on the blob stored at key, if any.
+// It returns false when the key is not a blob, i.e. the object is owned
+// outright by a single file.
+func (s *BlobService) AddReference(key string) (bool, error) {
+	var blob models.Blob
+	if err := s.db.First(&blob, "s3_key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	lock := s.locks.lockFor(blob.Checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	result := s.db.Model(&models.Blob{}).
+		Where("checksum = ?", blob.Checksum).
+		Update("ref_count", gorm.Expr("ref_count + ?", 1))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ReleaseObject drops one file reference to the object stored at key. Blob
+// objects are deleted once their last reference is released; objects that
+// aren't blobs are deleted immediately.
+func (s *BlobService) ReleaseObject(ctx context.Context, key string) error {
+	deletable, err := s.release(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !deletable {
+		return nil
+	}
+	return s.s3Service.DeleteFile(ctx, key)
+}
+
+// ReleaseObjects releases several object references at once, batching the
+// storage deletes of objects that aren't blobs. If some objects couldn't be
+// released or deleted, it returns a *DeleteFilesError listing their keys.
+func (s *BlobService) ReleaseObjects(ctx context.Context, keys []string) error {
+	var toDelete, failed []string
+	var errs []error
+
+	for _, key := range keys {
+		deletable, err := s.release(ctx, key)
+		if err != nil {
+			failed = append(failed, key)
+			errs = append(errs, err)
+			continue
+		}
+		if deletable {
+			toDelete = append(toDelete, key)
+		}
+	}
+
+	if err := s.s3Service.DeleteFiles(ctx, toDelete); err != nil {
+		failed = append(failed, FailedKeys(err, toDelete)...)
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return &DeleteFilesError{Keys: failed, Err: errors.Join(errs...)}
+	}
+	return nil
+}
+
+// release drops the blob reference for key, if it is a blob, and reports
+// whether the caller should delete the object: objects that aren't blobs
+// belong to one file and are the caller's to delete. A blob's object is
+// deleted here once its last reference goes, while the checksum is still
+// locked, so a concurrent Store of the same content can't upload it again
+// in between and lose it to the delete.
+func (s *BlobService) release(ctx context.Context, key string) (bool, error) {
+	var blob models.Blob
+	if err := s.db.First(&blob, "s3_key = ?", key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Not a shared blob, the object belongs to this file alone
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to look up blob: %w", err)
+	}
+
+	lock := s.locks.lockFor(blob.Checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	released := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Blob{}).
+			Where("checksum = ?", blob.Checksum).
+			Update("ref_count", gorm.Expr("ref_count - ?", 1)).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("checksum = ? AND ref_count <= 0", blob.Checksum).Delete(&models.Blob{})
+		if result.Error != nil {
+			return result.Error
+		}
+		released = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to release blob: %w", err)
+	}
+	if !released {
+		return false, nil
+	}
+
+	if err := s.s3Service.DeleteFile(ctx, key); err != nil {
+		return false, fmt.Errorf("failed to delete blob %s: %w", blob.Checksum, err)
+	}
+
+	s.logger.Info().
+		Str("checksum", blob.Checksum).
+		Str("s3_key", key).
+		Msg("Released last reference to blob")
+
+	return false, nil
+}
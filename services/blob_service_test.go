@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+func newTestBlobService(t *testing.T) (*BlobService, *gorm.DB, *LocalStorageService) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Blob{}))
+
+	storage := newTestLocalStorage(t)
+	return NewBlobService(db, storage, zerolog.Nop()), db, storage
+}
+
+func TestBlobService_Store(t *testing.T) {
+	service, db, storage := newTestBlobService(t)
+
+	first, err := service.Store(context.Background(), strings.NewReader("same content"), 12, "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, models.BlobKey(first.Checksum), first.S3Key)
+	assert.Equal(t, int64(1), first.RefCount)
+
+	second, err := service.Store(context.Background(), strings.NewReader("same content"), 12, "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, first.S3Key, second.S3Key)
+	assert.Equal(t, int64(2), second.RefCount)
+
+	other, err := service.Store(context.Background(), strings.NewReader("other content"), 13, "text/plain")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.S3Key, other.S3Key)
+
+	var count int64
+	require.NoError(t, db.Model(&models.Blob{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+
+	exists, err := storage.FileExists(context.Background(), first.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestBlobService_Store_SizeMismatch(t *testing.T) {
+	service, _, _ := newTestBlobService(t)
+
+	_, err := service.Store(context.Background(), strings.NewReader("abc"), 10, "text/plain")
+	assert.Error(t, err)
+}
+
+func TestBlobService_ReleaseObject(t *testing.T) {
+	service, db, storage := newTestBlobService(t)
+
+	blob, err := service.Store(context.Background(), strings.NewReader("shared"), 6, "text/plain")
+	require.NoError(t, err)
+	_, err = service.Store(context.Background(), strings.NewReader("shared"), 6, "text/plain")
+	require.NoError(t, err)
+
+	// First release keeps the object for the remaining reference
+	require.NoError(t, service.ReleaseObject(context.Background(), blob.S3Key))
+	exists, err := storage.FileExists(context.Background(), blob.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Last release removes both the row and the object
+	require.NoError(t, service.ReleaseObject(context.Background(), blob.S3Key))
+	exists, err = storage.FileExists(context.Background(), blob.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	var count int64
+	require.NoError(t, db.Model(&models.Blob{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestBlobService_ReleaseObject_NonBlob(t *testing.T) {
+	service, _, storage := newTestBlobService(t)
+	require.NoError(t, storage.UploadFile(context.Background(), "users/u1/file.txt", strings.NewReader("x"), 1, "text/plain"))
+
+	require.NoError(t, service.ReleaseObject(context.Background(), "users/u1/file.txt"))
+
+	exists, err := storage.FileExists(context.Background(), "users/u1/file.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestBlobService_ReleaseObjects(t *testing.T) {
+	service, _, storage := newTestBlobService(t)
+
+	shared, err := service.Store(context.Background(), strings.NewReader("shared"), 6, "text/plain")
+	require.NoError(t, err)
+	_, err = service.Store(context.Background(), strings.NewReader("shared"), 6, "text/plain")
+	require.NoError(t, err)
+	require.NoError(t, storage.UploadFile(context.Background(), "users/u1/own.txt", strings.NewReader("own"), 3, "text/plain"))
+
+	require.NoError(t, service.ReleaseObjects(context.Background(), []string{shared.S3Key, "users/u1/own.txt"}))
+
+	exists, err := storage.FileExists(context.Background(), shared.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists, "Blob with a remaining reference must be kept")
+
+	exists, err = storage.FileExists(context.Background(), "users/u1/own.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestBlobService_AddReference(t *testing.T) {
+	service, _, _ := newTestBlobService(t)
+
+	blob, err := service.Store(context.Background(), strings.NewReader("content"), 7, "text/plain")
+	require.NoError(t, err)
+
+	ok, err := service.AddReference(blob.S3Key)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = service.AddReference("users/u1/not-a-blob.txt")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Two references now, so one release keeps it
+	deletable, err := service.release(context.Background(), blob.S3Key)
+	require.NoError(t, err)
+	assert.False(t, deletable)
+}
+
+func TestBlobService_ReleaseDeletesLastBlobUnderLock(t *testing.T) {
+	service, db, storage := newTestBlobService(t)
+
+	blob, err := service.Store(context.Background(), strings.NewReader("content"), 7, "text/plain")
+	require.NoError(t, err)
+
+	// The blob's object is deleted by release itself, not left to the caller
+	deletable, err := service.release(context.Background(), blob.S3Key)
+	require.NoError(t, err)
+	assert.False(t, deletable)
+
+	exists, err := storage.FileExists(context.Background(), blob.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	var count int64
+	require.NoError(t, db.Model(&models.Blob{}).Where("checksum = ?", blob.Checksum).Count(&count).Error)
+	assert.Zero(t, count)
+
+	// Storing the same content again brings the object back
+	again, err := service.Store(context.Background(), strings.NewReader("content"), 7, "text/plain")
+	require.NoError(t, err)
+	exists, err = storage.FileExists(context.Background(), again.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// ChecksumReader wraps a reader and computes the SHA-256 checksum of
+// everything read through it, so content can be hashed while it streams
+type ChecksumReader struct {
+	reader    io.Reader
+	hash      hash.Hash
+	bytesRead int64
+}
+
+// NewChecksumReader creates a new checksum reader
+func NewChecksumReader(reader io.Reader) *ChecksumReader {
+	return &ChecksumReader{
+		reader: reader,
+		hash:   sha256.New(),
+	}
+}
+
+// Read implements io.Reader
+func (r *ChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.hash.Write(p[:n])
+		r.bytesRead += int64(n)
+	}
+	return n, err
+}
+
+// Checksum returns the hex-encoded SHA-256 of the data read so far
+func (r *ChecksumReader) Checksum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// BytesRead returns the number of bytes read so far
+func (r *ChecksumReader) BytesRead() int64 {
+	return r.bytesRead
+}
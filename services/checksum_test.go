@@ -0,0 +1,44 @@
+package services
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumReader(t *testing.T) {
+	t.Run("Hashes streamed content", func(t *testing.T) {
+		reader := NewChecksumReader(strings.NewReader("hello"))
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		assert.Equal(t, "hello", string(data))
+		assert.Equal(t, int64(5), reader.BytesRead())
+		assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", reader.Checksum())
+	})
+
+	t.Run("Empty input", func(t *testing.T) {
+		reader := NewChecksumReader(strings.NewReader(""))
+
+		_, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(0), reader.BytesRead())
+		assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", reader.Checksum())
+	})
+
+	t.Run("Partial read", func(t *testing.T) {
+		reader := NewChecksumReader(strings.NewReader("hello world"))
+
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(reader, buf)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(5), reader.BytesRead())
+		assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", reader.Checksum())
+	})
+}
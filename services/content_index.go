@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// contentQueueSize bounds the files waiting to be indexed. When it is
+	// full, files are left for the next reindex.
+	contentQueueSize = 1000
+	// contentIndexTimeout bounds reading and indexing one file
+	contentIndexTimeout = 5 * time.Minute
+)
+
+// ErrContentIndexDisabled is returned when reindexing with content indexing
+// turned off
+var ErrContentIndexDisabled = errors.New("content indexing is disabled")
+
+// textMimeTypes are MIME types outside text/ whose content is text
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/toml":       true,
+	"application/sql":        true,
+	"application/csv":        true,
+}
+
+// textExtensions are file extensions of text formats, for files stored
+// with a generic MIME type
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".tsv": true, ".json": true, ".log": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".conf": true, ".sql": true,
+	".html": true, ".htm": true, ".css": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".go": true, ".py": true, ".rb": true, ".rs": true, ".java": true, ".kt": true, ".swift": true,
+	".c": true, ".h": true, ".cpp": true, ".hpp": true, ".cs": true, ".php": true, ".sh": true,
+	".lua": true, ".pl": true, ".r": true, ".scala": true, ".vue": true, ".tex": true, ".rst": true,
+}
+
+// ReindexReport summarises a content reindex run
+type ReindexReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Scanned    int       `json:"scanned"`
+	Indexed    int       `json:"indexed"`
+	Unchanged  int       `json:"unchanged"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+}
+
+// ContentIndexService extracts the text of text-like files (and optionally
+// PDFs) and stores it in the content search table, so search can match
+// what's inside files. New uploads are queued and indexed in the
+// background; Reindex catches up on everything else.
+type ContentIndexService struct {
+	db        *gorm.DB
+	s3Service S3Service
+	logger    zerolog.Logger
+	config    *config.Config
+	queue     chan string
+}
+
+// NewContentIndexService creates a new content index service, starting the
+// background indexer when content indexing is enabled
+func NewContentIndexService(db *gorm.DB, s3Service S3Service, logger zerolog.Logger, cfg *config.Config) *ContentIndexService {
+	service := &ContentIndexService{
+		db:        db,
+		s3Service: s3Service,
+		logger:    logger,
+		config:    cfg,
+		queue:     make(chan string, contentQueueSize),
+	}
+
+	if cfg.ContentIndexEnabled {
+		// Start background goroutine to index queued files
+		go service.indexQueued()
+	}
+
+	return service
+}
+
+// Enqueue queues a file to be indexed in the background. It never blocks,
+// and does nothing when content indexing is disabled.
+func (s *ContentIndexService) Enqueue(fileID string) {
+	if !s.config.ContentIndexEnabled {
+		return
+	}
+	select {
+	case s.queue <- fileID:
+	default:
+		s.logger.Warn().Str("file_id", fileID).Msg("Content index queue is full, file left for the next reindex")
+	}
+}
+
+// indexQueued indexes queued files one at a time
+func (s *ContentIndexService) indexQueued() {
+	for fileID := range s.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), contentIndexTimeout)
+		if _, err := s.IndexFile(ctx, fileID, false); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Error().Err(err).Str("file_id", fileID).Msg("Failed to index file content")
+		}
+		cancel()
+	}
+}
+
+// IndexFile indexes the text of a file and returns the resulting status.
+// Unless force is set, a file whose content was already indexed at the
+// same checksum is left alone and reported as unchanged (an empty status).
+// Files that can't be read or parsed are recorded as failed rather than
+// returned as errors.
+func (s *ContentIndexService) IndexFile(ctx context.Context, fileID string, force bool) (string, error) {
+	var file models.File
+	if err := s.db.First(&file, "id = ?", fileID).Error; err != nil {
+		return "", err
+	}
+
+	var existing models.FileContent
+	err := s.db.First(&existing, "file_id = ?", file.ID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	if !force && err == nil && existing.Status != models.ContentFailed && file.Checksum != "" && existing.Checksum == file.Checksum {
+		return "", nil
+	}
+
+	text, status, reason := s.extractText(ctx, &file)
+	if status == models.ContentFailed {
+		s.logger.Warn().Str("file_id", file.ID).Str("reason", reason).Msg("Failed to extract file content")
+	}
+	if err := s.store(&file, text, status, reason); err != nil {
+		return "", err
+	}
+
+	s.logger.Debug().
+		Str("file_id", file.ID).
+		Str("status", status).
+		Int("bytes", len(text)).
+		Msg("File content indexed")
+
+	return status, nil
+}
+
+// extractText reads a file's text, or says why it can't
+func (s *ContentIndexService) extractText(ctx context.Context, file *models.File) (string, string, string) {
+	pdf := s.config.ContentIndexPDF && isPDF(file)
+	if !pdf && !isTextLike(file) {
+		return "", models.ContentSkipped, "not a text document"
+	}
+	if file.Size > s.config.ContentIndexMaxSize {
+		return "", models.ContentSkipped, fmt.Sprintf("larger than %d bytes", s.config.ContentIndexMaxSize)
+	}
+
+	reader, err := s.s3Service.DownloadFile(ctx, file.S3Key)
+	if err != nil {
+		return "", models.ContentFailed, "failed to read the file"
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, s.config.ContentIndexMaxSize))
+	if err != nil {
+		return "", models.ContentFailed, "failed to read the file"
+	}
+
+	if pdf {
+		return extractPDFText(data), models.ContentIndexed, ""
+	}
+	if bytes.IndexByte(data[:min(len(data), 8192)], 0) >= 0 {
+		return "", models.ContentSkipped, "binary content"
+	}
+	if !utf8.Valid(data) {
+		return strings.ToValidUTF8(string(data), " "), models.ContentIndexed, ""
+	}
+	return string(data), models.ContentIndexed, ""
+}
+
+// store replaces a file's indexed text and records its status
+func (s *ContentIndexService) store(file *models.File, text, status, reason string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// A file deleted while it was read has had its record removed
+		var live int64
+		if err := tx.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).Count(&live).Error; err != nil || live == 0 {
+			return err
+		}
+
+		var existing models.FileContent
+		err := tx.First(&existing, "file_id = ?", file.ID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if existing.DocID != 0 {
+			if err := tx.Exec("DELETE FROM "+models.ContentSearchTable+" WHERE rowid = ?", existing.DocID).Error; err != nil {
+				return err
+			}
+		}
+
+		record := models.FileContent{
+			FileID:   file.ID,
+			Checksum: file.Checksum,
+			Status:   status,
+			Reason:   reason,
+		}
+		if status == models.ContentIndexed && strings.TrimSpace(text) != "" {
+			if err := tx.Exec("INSERT INTO "+models.ContentSearchTable+"(content) VALUES (?)", text).Error; err != nil {
+				return err
+			}
+			if err := tx.Raw("SELECT last_insert_rowid()").Scan(&record.DocID).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&record).Error
+	})
+}
+
+// Reindex indexes every live file whose content changed since it was last
+// indexed, that was never indexed, or whose indexing failed. With force,
+// every file is indexed again.
+func (s *ContentIndexService) Reindex(ctx context.Context, force bool) (*ReindexReport, error) {
+	if !s.config.ContentIndexEnabled {
+		return nil, ErrContentIndexDisabled
+	}
+
+	report := &ReindexReport{StartedAt: time.Now()}
+
+	lastID := ""
+	for {
+		var ids []string
+		err := s.db.Model(&models.File{}).
+			Where("id > ?", lastID).
+			Order("id ASC").
+			Limit(fileBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		lastID = ids[len(ids)-1]
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			report.Scanned++
+
+			status, err := s.IndexFile(ctx, id, force)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Deleted since the batch was listed
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch status {
+			case models.ContentIndexed:
+				report.Indexed++
+			case models.ContentSkipped:
+				report.Skipped++
+			case models.ContentFailed:
+				report.Failed++
+			default:
+				report.Unchanged++
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	s.logger.Info().
+		Int("scanned", report.Scanned).
+		Int("indexed", report.Indexed).
+		Int("unchanged", report.Unchanged).
+		Int("skipped", report.Skipped).
+		Int("failed", report.Failed).
+		Msg("Content reindex finished")
+
+	return report, nil
+}
+
+// isTextLike reports whether a file's content is text, by its MIME type
+// or extension
+func isTextLike(file *models.File) bool {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.SplitN(file.MimeType, ";", 2)[0]))
+	if strings.HasPrefix(mimeType, "text/") || textMimeTypes[mimeType] || strings.HasSuffix(mimeType, "+json") || strings.HasSuffix(mimeType, "+xml") {
+		return true
+	}
+	return textExtensions[strings.ToLower(filepath.Ext(file.Name))]
+}
+
+// isPDF reports whether a file is a PDF, by its MIME type or extension
+func isPDF(file *models.File) bool {
+	return strings.EqualFold(file.MimeType, "application/pdf") || strings.EqualFold(filepath.Ext(file.Name), ".pdf")
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPDF returns a one-page PDF showing lines of text in a compressed
+// content stream
+func testPDF(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	var content bytes.Buffer
+	content.WriteString("BT /F1 12 Tf 72 720 Td\n")
+	for i, line := range lines {
+		if i > 0 {
+			content.WriteString("0 -14 Td\n")
+		}
+		fmt.Fprintf(&content, "(%s) Tj\n", strings.NewReplacer(`(`, `\(`, `)`, `\)`).Replace(line))
+	}
+	content.WriteString("ET\n")
+
+	var stream bytes.Buffer
+	w := zlib.NewWriter(&stream)
+	_, err := w.Write(content.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return "%PDF-1.4\n" +
+		"1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R /Contents 4 0 R >> endobj\n" +
+		fmt.Sprintf("4 0 obj << /Length %d /Filter /FlateDecode >>\nstream\n", stream.Len()) +
+		stream.String() + "\nendstream\nendobj\n" +
+		"5 0 obj << /Type /XObject /Subtype /Image /Length 4 >>\nstream\n(no)\nendstream\nendobj\n" +
+		"trailer << /Root 1 0 R >>\n%%EOF\n"
+}
+
+func TestExtractPDFText(t *testing.T) {
+	text := extractPDFText([]byte(testPDF(t, "Annual budget (draft)", "Second line")))
+	assert.Equal(t, "Annual budget (draft)\nSecond line\n", text)
+
+	assert.Empty(t, extractPDFText([]byte("not a pdf")))
+}
+
+func TestContentIndexService(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, models.MigrateSearchIndex(db))
+	service.config.ContentIndexEnabled = true
+	service.config.ContentIndexMaxSize = 512
+	service.config.ContentIndexPDF = true
+	contentIndex := NewContentIndexService(db, storage, zerolog.Nop(), service.config)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("content@example.com", "contentuser", "Password123!", false)
+	require.NoError(t, err)
+
+	notes := createTestTreeFile(t, storage, db, user.ID, nil, "notes.md", "Remember the quarterly budget <review> meeting")
+	code := createTestTreeFile(t, storage, db, user.ID, nil, "main.go", "package main\n\nfunc budgetTotal() {}\n")
+	pdf := createTestTreeFile(t, storage, db, user.ID, nil, "plan.pdf", testPDF(t, "Budget plan"))
+	large := createTestTreeFile(t, storage, db, user.ID, nil, "large.txt", strings.Repeat("budget ", 100))
+	binary := createTestTreeFile(t, storage, db, user.ID, nil, "data.txt", "budget\x00\x01\x02")
+	photo := createTestTreeFile(t, storage, db, user.ID, nil, "photo.png", "budget")
+	require.NoError(t, db.Model(&models.File{}).Where("id = ?", photo.ID).Update("mime_type", "image/png").Error)
+	require.NoError(t, db.Model(&models.File{}).Where("id = ?", notes.ID).Update("checksum", "abc").Error)
+
+	status := func(file *models.File) string {
+		t.Helper()
+		var content models.FileContent
+		require.NoError(t, db.First(&content, "file_id = ?", file.ID).Error)
+		return content.Status
+	}
+
+	t.Run("Reindex indexes text documents", func(t *testing.T) {
+		report, err := contentIndex.Reindex(ctx, false)
+		require.NoError(t, err)
+		assert.Equal(t, 6, report.Scanned)
+		assert.Equal(t, 3, report.Indexed)
+		assert.Equal(t, 3, report.Skipped)
+		assert.Zero(t, report.Failed)
+
+		assert.Equal(t, models.ContentIndexed, status(notes))
+		assert.Equal(t, models.ContentIndexed, status(code))
+		assert.Equal(t, models.ContentIndexed, status(pdf))
+		assert.Equal(t, models.ContentSkipped, status(large), "Files over the size limit are skipped")
+		assert.Equal(t, models.ContentSkipped, status(binary))
+		assert.Equal(t, models.ContentSkipped, status(photo))
+	})
+
+	t.Run("Unchanged files are left alone unless forced", func(t *testing.T) {
+		result, err := contentIndex.IndexFile(ctx, notes.ID, false)
+		require.NoError(t, err)
+		assert.Empty(t, result)
+
+		result, err = contentIndex.IndexFile(ctx, notes.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, models.ContentIndexed, result)
+
+		var docs int64
+		require.NoError(t, db.Raw("SELECT COUNT(*) FROM "+models.ContentSearchTable).Scan(&docs).Error)
+		assert.Equal(t, int64(3), docs, "Reindexing replaces a file's text")
+	})
+
+	t.Run("Search matches content with snippets", func(t *testing.T) {
+		results, err := service.Search(user.ID, SearchOptions{Query: "quarterly budg"})
+		require.NoError(t, err)
+		require.Len(t, results.Results, 1)
+		assert.Equal(t, notes.ID, results.Results[0].File.ID)
+		assert.Contains(t, results.Results[0].Snippet, "the <mark>quarterly budget</mark> &lt;review&gt; meeting")
+
+		results, err = service.Search(user.ID, SearchOptions{Query: "budget"})
+		require.NoError(t, err)
+		var names []string
+		for _, result := range results.Results {
+			names = append(names, result.File.Name)
+			if result.File.ID == photo.ID {
+				assert.Empty(t, result.Snippet, "Name matches have no snippet")
+			}
+		}
+		assert.Equal(t, []string{"main.go", "notes.md", "plan.pdf"}, names)
+
+		service.config.ContentIndexEnabled = false
+		defer func() { service.config.ContentIndexEnabled = true }()
+		results, err = service.Search(user.ID, SearchOptions{Query: "budget"})
+		require.NoError(t, err)
+		assert.Empty(t, results.Results, "Content isn't searched when indexing is disabled")
+	})
+
+	t.Run("Deleting a file drops its text", func(t *testing.T) {
+		require.NoError(t, db.Unscoped().Delete(&models.File{}, "id = ?", code.ID).Error)
+
+		var records, docs int64
+		require.NoError(t, db.Model(&models.FileContent{}).Where("file_id = ?", code.ID).Count(&records).Error)
+		assert.Zero(t, records)
+		require.NoError(t, db.Raw("SELECT COUNT(*) FROM "+models.ContentSearchTable).Scan(&docs).Error)
+		assert.Equal(t, int64(2), docs)
+	})
+
+	t.Run("Reindex needs indexing enabled", func(t *testing.T) {
+		disabled := NewContentIndexService(db, storage, zerolog.Nop(), &config.Config{})
+		_, err := disabled.Reindex(ctx, false)
+		assert.ErrorIs(t, err, ErrContentIndexDisabled)
+	})
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Encrypted storage errors
+var (
+	ErrWrappedKeyRequired = errors.New("encrypted storage needs an upload that records the wrapped data key")
+	ErrPresignUnsupported = errors.New("presigned URLs are not available for encrypted storage")
+)
+
+// EncryptingUploader is implemented by storage that encrypts every object
+// with its own data key. The returned wrapped key must be stored with the
+// object's records, or the object can't be decrypted again.
+type EncryptingUploader interface {
+	UploadEncrypted(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (string, error)
+}
+
+// UploadObject uploads content and returns the wrapped data key to store
+// with its records. The key is empty when storage doesn't encrypt.
+func UploadObject(ctx context.Context, s3Service S3Service, key string, reader io.Reader, size int64, contentType string) (string, error) {
+	if encrypter, ok := s3Service.(EncryptingUploader); ok {
+		return encrypter.UploadEncrypted(ctx, key, reader, size, contentType)
+	}
+	return "", s3Service.UploadFile(ctx, key, reader, size, contentType)
+}
+
+// EncryptedStorage wraps another storage backend with envelope encryption.
+// Each object is encrypted with a random data key; the data key is wrapped
+// with a master key and stored on the file (and blob) records, where reads
+// look it up. Objects without a wrapped key are passed through as
+// plaintext, so existing files stay readable after encryption is enabled.
+//
+// Parts can't be encrypted independently of the whole object, so the
+// multipart and presigning capabilities of the wrapped backend are not
+// exposed: resumable and direct uploads are unavailable.
+type EncryptedStorage struct {
+	inner  S3Service
+	db     *gorm.DB
+	keys   *KeyRing
+	logger zerolog.Logger
+}
+
+// NewEncryptedStorage wraps a storage backend with envelope encryption
+func NewEncryptedStorage(inner S3Service, db *gorm.DB, keys *KeyRing, logger zerolog.Logger) *EncryptedStorage {
+	return &EncryptedStorage{
+		inner:  inner,
+		db:     db,
+		keys:   keys,
+		logger: logger,
+	}
+}
+
+// UploadEncrypted encrypts content with a new data key and uploads it,
+// returning the wrapped data key
+func (s *EncryptedStorage) UploadEncrypted(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (string, error) {
+	dataKey, wrapped, err := s.keys.NewDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := newEncryptReader(dataKey, reader)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.inner.UploadFile(ctx, key, encrypted, EncryptedSize(size), contentType); err != nil {
+		return "", err
+	}
+	return wrapped, nil
+}
+
+// UploadFile fails, since the wrapped data key would be lost; callers use
+// UploadObject instead
+func (s *EncryptedStorage) UploadFile(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	return ErrWrappedKeyRequired
+}
+
+// UploadStream fails for the same reason as UploadFile
+func (s *EncryptedStorage) UploadStream(ctx context.Context, key string, reader io.Reader) error {
+	return ErrWrappedKeyRequired
+}
+
+// DownloadFile downloads and decrypts an object
+func (s *EncryptedStorage) DownloadFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.DownloadRange(ctx, key, 0, -1)
+}
+
+// DownloadRange downloads just the segments covering the range and
+// decrypts them
+func (s *EncryptedStorage) DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	wrapped, size, err := s.lookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if wrapped == "" {
+		return s.inner.DownloadRange(ctx, key, offset, length)
+	}
+
+	dataKey, err := s.keys.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("range offset %d outside object of %d bytes", offset, size)
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	const stored = encSegmentSize + encTagSize
+	first := offset / encSegmentSize
+	last := (offset + length - 1) / encSegmentSize
+	start := first * stored
+	end := min((last+1)*stored, EncryptedSize(size))
+
+	body, err := s.inner.DownloadRange(ctx, key, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		aead:      aead,
+		src:       body,
+		nonce:     make([]byte, aead.NonceSize()),
+		buf:       make([]byte, stored),
+		index:     first,
+		final:     encSegmentCount(size) - 1,
+		skip:      offset - first*encSegmentSize,
+		remaining: length,
+	}, nil
+}
+
+// DeleteFile deletes an object
+func (s *EncryptedStorage) DeleteFile(ctx context.Context, key string) error {
+	return s.inner.DeleteFile(ctx, key)
+}
+
+// DeleteFiles deletes several objects
+func (s *EncryptedStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	return s.inner.DeleteFiles(ctx, keys)
+}
+
+// CopyObject copies an object as stored. The copy is encrypted with the
+// same data key, so its records must carry the source's wrapped key.
+func (s *EncryptedStorage) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	return s.inner.CopyObject(ctx, srcKey, dstKey)
+}
+
+// GetPresignedURL fails, storage would hand out ciphertext
+func (s *EncryptedStorage) GetPresignedURL(ctx context.Context, key string, expirationMinutes int) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// GetPresignedDownloadURL fails, storage would hand out ciphertext
+func (s *EncryptedStorage) GetPresignedDownloadURL(ctx context.Context, key string, expirationMinutes int, opts PresignOptions) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// FileExists checks if an object exists
+func (s *EncryptedStorage) FileExists(ctx context.Context, key string) (bool, error) {
+	return s.inner.FileExists(ctx, key)
+}
+
+// GetFileMetadata returns the object's metadata, with the plaintext size
+// for encrypted objects
+func (s *EncryptedStorage) GetFileMetadata(ctx context.Context, key string) (*FileMetadata, error) {
+	metadata, err := s.inner.GetFileMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, _, err := s.lookupKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if wrapped != "" {
+		metadata.Size = PlaintextSize(metadata.Size)
+	}
+	return metadata, nil
+}
+
+// ListObjects lists the wrapped backend's objects. Sizes are stored sizes.
+func (s *EncryptedStorage) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	lister, ok := s.inner.(ObjectLister)
+	if !ok {
+		return ErrListingUnsupported
+	}
+	return lister.ListObjects(ctx, prefix, fn)
+}
+
+// RotateKeys re-wraps every data key still wrapped with a previous master
+// key. Objects aren't touched, only the wrapped keys on their records;
+// trashed files are included so they can still be restored.
+func (s *EncryptedStorage) RotateKeys(ctx context.Context) (int, error) {
+	rotated := 0
+
+	for _, model := range []interface{}{&models.Blob{}, &models.File{}, &models.FileVersion{}} {
+		var rows []struct {
+			S3Key      string
+			WrappedKey string
+		}
+		err := s.db.Unscoped().Model(model).
+			Distinct("s3_key", "wrapped_key").
+			Where("wrapped_key <> ''").
+			Find(&rows).Error
+		if err != nil {
+			return rotated, fmt.Errorf("failed to load wrapped keys: %w", err)
+		}
+
+		for _, row := range rows {
+			if err := ctx.Err(); err != nil {
+				return rotated, err
+			}
+			if !s.keys.NeedsRewrap(row.WrappedKey) {
+				continue
+			}
+
+			dataKey, err := s.keys.Unwrap(row.WrappedKey)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to unwrap key for %s: %w", row.S3Key, err)
+			}
+			rewrapped, err := s.keys.Wrap(dataKey)
+			if err != nil {
+				return rotated, err
+			}
+
+			// Files sharing a blob hold the same wrapped key, so they are
+			// updated together
+			result := s.db.Unscoped().Model(model).
+				Where("s3_key = ? AND wrapped_key = ?", row.S3Key, row.WrappedKey).
+				Update("wrapped_key", rewrapped)
+			if result.Error != nil {
+				return rotated, fmt.Errorf("failed to store rewrapped key for %s: %w", row.S3Key, result.Error)
+			}
+			rotated += int(result.RowsAffected)
+		}
+	}
+
+	s.logger.Info().
+		Int("rotated", rotated).
+		Str("key_id", s.keys.CurrentKeyID()).
+		Msg("Re-wrapped data keys with the current master key")
+
+	return rotated, nil
+}
+
+// lookupKey finds the wrapped data key and plaintext size recorded for an
+// object. Blobs are checked first since their keys are unique. Trashed
+// records count too, as their objects are still encrypted.
+func (s *EncryptedStorage) lookupKey(key string) (string, int64, error) {
+	for _, model := range []interface{}{&models.Blob{}, &models.File{}, &models.FileVersion{}} {
+		var row struct {
+			WrappedKey string
+			Size       int64
+		}
+		result := s.db.Unscoped().Model(model).
+			Select("wrapped_key", "size").
+			Where("s3_key = ?", key).
+			Limit(1).
+			Find(&row)
+		if result.Error != nil {
+			return "", 0, fmt.Errorf("failed to look up data key: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			return row.WrappedKey, row.Size, nil
+		}
+	}
+	return "", 0, nil
+}
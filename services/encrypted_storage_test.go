@@ -0,0 +1,240 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestEncryptedStorage(t *testing.T) (*EncryptedStorage, *LocalStorageService, *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.File{}, &models.FileVersion{}, &models.Blob{}))
+
+	storage := newTestLocalStorage(t)
+	return NewEncryptedStorage(storage, db, newTestKeyRing(t), zerolog.Nop()), storage, db
+}
+
+// storeEncryptedFile uploads content through encrypted storage and records
+// it as a file
+func storeEncryptedFile(t *testing.T, storage *EncryptedStorage, db *gorm.DB, key string, content []byte) *models.File {
+	t.Helper()
+	wrapped, err := UploadObject(context.Background(), storage, key, bytes.NewReader(content), int64(len(content)), "application/octet-stream")
+	require.NoError(t, err)
+	require.NotEmpty(t, wrapped)
+
+	file := &models.File{Name: "f", Path: "/", User: "u1", Size: int64(len(content)), S3Key: key, S3Bucket: "b", WrappedKey: wrapped}
+	require.NoError(t, db.Create(file).Error)
+	return file
+}
+
+func TestEncryptedStorage_UploadAndDownload(t *testing.T) {
+	storage, inner, db := newTestEncryptedStorage(t)
+	ctx := context.Background()
+
+	content := make([]byte, 2*encSegmentSize+123)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	storeEncryptedFile(t, storage, db, "users/u1/f1/data.bin", content)
+
+	// Storage only holds ciphertext
+	raw, err := inner.DownloadFile(ctx, "users/u1/f1/data.bin")
+	require.NoError(t, err)
+	stored, err := io.ReadAll(raw)
+	raw.Close()
+	require.NoError(t, err)
+	assert.Equal(t, EncryptedSize(int64(len(content))), int64(len(stored)))
+	assert.False(t, bytes.Contains(stored, content[:64]))
+
+	reader, err := storage.DownloadFile(ctx, "users/u1/f1/data.bin")
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, decrypted)
+
+	metadata, err := storage.GetFileMetadata(ctx, "users/u1/f1/data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), metadata.Size)
+
+	t.Run("Ranges", func(t *testing.T) {
+		for _, r := range [][2]int64{{0, 1}, {encSegmentSize - 3, 10}, {2 * encSegmentSize, 123}, {100, -1}} {
+			reader, err := storage.DownloadRange(ctx, "users/u1/f1/data.bin", r[0], r[1])
+			require.NoError(t, err)
+			got, err := io.ReadAll(reader)
+			reader.Close()
+			require.NoError(t, err)
+
+			end := int64(len(content))
+			if r[1] >= 0 {
+				end = r[0] + r[1]
+			}
+			assert.Equal(t, content[r[0]:end], got, "range %v", r)
+		}
+	})
+
+	t.Run("Seekable reader", func(t *testing.T) {
+		objectReader := NewObjectReadSeeker(ctx, storage, "users/u1/f1/data.bin", metadata.Size)
+		defer objectReader.Close()
+
+		_, err := objectReader.Seek(encSegmentSize+7, io.SeekStart)
+		require.NoError(t, err)
+		buf := make([]byte, 32)
+		_, err = io.ReadFull(objectReader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, content[encSegmentSize+7:encSegmentSize+39], buf)
+	})
+}
+
+func TestEncryptedStorage_EmptyFile(t *testing.T) {
+	storage, _, db := newTestEncryptedStorage(t)
+	storeEncryptedFile(t, storage, db, "users/u1/f1/empty", nil)
+
+	reader, err := storage.DownloadFile(context.Background(), "users/u1/f1/empty")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestEncryptedStorage_TrashedFile(t *testing.T) {
+	storage, _, db := newTestEncryptedStorage(t)
+	content := []byte("still encrypted in the trash")
+	file := storeEncryptedFile(t, storage, db, "users/u1/f1/trashed.txt", content)
+	require.NoError(t, db.Delete(file).Error)
+
+	reader, err := storage.DownloadFile(context.Background(), "users/u1/f1/trashed.txt")
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestEncryptedStorage_PlaintextPassthrough(t *testing.T) {
+	storage, inner, db := newTestEncryptedStorage(t)
+	ctx := context.Background()
+
+	// Files stored before encryption was enabled have no wrapped key
+	require.NoError(t, inner.UploadFile(ctx, "users/u1/old.txt", strings.NewReader("plain old file"), 14, "text/plain"))
+	require.NoError(t, db.Create(&models.File{Name: "old.txt", Path: "/", User: "u1", Size: 14, S3Key: "users/u1/old.txt", S3Bucket: "b"}).Error)
+
+	reader, err := storage.DownloadRange(ctx, "users/u1/old.txt", 6, 3)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	metadata, err := storage.GetFileMetadata(ctx, "users/u1/old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(14), metadata.Size)
+}
+
+func TestEncryptedStorage_Unsupported(t *testing.T) {
+	storage, _, _ := newTestEncryptedStorage(t)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, storage.UploadFile(ctx, "k", strings.NewReader("x"), 1, ""), ErrWrappedKeyRequired)
+	assert.ErrorIs(t, storage.UploadStream(ctx, "k", strings.NewReader("x")), ErrWrappedKeyRequired)
+
+	_, err := storage.GetPresignedDownloadURL(ctx, "k", 5, PresignOptions{})
+	assert.ErrorIs(t, err, ErrPresignUnsupported)
+
+	// Parts can't be encrypted on their own, so resumable uploads are off
+	var s3Service S3Service = storage
+	_, ok := s3Service.(MultipartUploader)
+	assert.False(t, ok)
+	_, ok = s3Service.(ObjectLister)
+	assert.True(t, ok)
+}
+
+func TestEncryptedStorage_RotateKeys(t *testing.T) {
+	storage, inner, db := newTestEncryptedStorage(t)
+	ctx := context.Background()
+	content := []byte("rotate me")
+
+	oldKey := newTestMasterKey(t)
+	oldRing, err := NewKeyRing(&config.Config{EncryptionKey: oldKey})
+	require.NoError(t, err)
+	storage.keys = oldRing
+	file := storeEncryptedFile(t, storage, db, "users/u1/f1/r.txt", content)
+
+	// Make a new key current and keep the old one as a previous key
+	newRing, err := NewKeyRing(&config.Config{EncryptionKey: newTestMasterKey(t), EncryptionPreviousKeys: oldKey})
+	require.NoError(t, err)
+	storage = NewEncryptedStorage(inner, db, newRing, zerolog.Nop())
+
+	rotated, err := storage.RotateKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotated)
+
+	var updated models.File
+	require.NoError(t, db.First(&updated, "id = ?", file.ID).Error)
+	assert.NotEqual(t, file.WrappedKey, updated.WrappedKey)
+	assert.False(t, newRing.NeedsRewrap(updated.WrappedKey))
+
+	// The object itself is unchanged and still decrypts
+	reader, err := storage.DownloadFile(ctx, "users/u1/f1/r.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// Nothing is left to rotate
+	rotated, err = storage.RotateKeys(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, rotated)
+
+	// Keys wrapped with a master key that isn't configured are reported
+	storage.keys = newTestKeyRing(t)
+	storeEncryptedFile(t, storage, db, "users/u1/f2/r.txt", content)
+	storage.keys = newRing
+	_, err = storage.RotateKeys(ctx)
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+}
+
+func TestEncryptedStorage_WithBlobs(t *testing.T) {
+	storage, _, db := newTestEncryptedStorage(t)
+	blobService := NewBlobService(db, storage, zerolog.Nop())
+	ctx := context.Background()
+
+	first, err := blobService.Store(ctx, strings.NewReader("shared content"), 14, "text/plain")
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.WrappedKey)
+
+	second, err := blobService.Store(ctx, strings.NewReader("shared content"), 14, "text/plain")
+	require.NoError(t, err)
+	assert.Equal(t, first.WrappedKey, second.WrappedKey)
+
+	reader, err := storage.DownloadFile(ctx, first.S3Key)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "shared content", string(data))
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jd-boyd/filesonthego/config"
+)
+
+// Encryption errors
+var (
+	ErrInvalidEncryptionKey = errors.New("invalid encryption key")
+	ErrUnknownMasterKey     = errors.New("data key was wrapped with an unknown master key")
+	ErrDecryptionFailed     = errors.New("failed to decrypt stored object")
+)
+
+const (
+	// encSegmentSize is the plaintext size of each encrypted segment. Every
+	// segment is sealed separately so ranges can be decrypted on their own.
+	encSegmentSize = 64 * 1024
+	// encTagSize is the GCM authentication tag added to each segment
+	encTagSize = 16
+	// dataKeySize is the size of per-object data keys and master keys (AES-256)
+	dataKeySize = 32
+)
+
+// masterKey is a key-encryption key, identified by a fingerprint so
+// wrapped data keys record which master key they need
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// KeyRing holds the master keys used to wrap per-object data keys. New
+// data keys are wrapped with the current key; previous keys are kept so
+// data keys wrapped before a rotation can still be unwrapped.
+type KeyRing struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyRing loads the master keys from the configuration. The key file
+// holds one base64 key per line, the first being the current key.
+func NewKeyRing(cfg *config.Config) (*KeyRing, error) {
+	var encoded []string
+	if cfg.EncryptionKeyFile != "" {
+		file, err := os.Open(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				encoded = append(encoded, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+	} else if cfg.EncryptionKey != "" {
+		encoded = append(encoded, cfg.EncryptionKey)
+	}
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("%w: no master key configured", ErrInvalidEncryptionKey)
+	}
+
+	for _, key := range strings.Split(cfg.EncryptionPreviousKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			encoded = append(encoded, key)
+		}
+	}
+
+	ring := &KeyRing{keys: make(map[string]*masterKey)}
+	for i, value := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(raw) != dataKeySize {
+			return nil, fmt.Errorf("%w: master keys must be %d bytes, base64 encoded", ErrInvalidEncryptionKey, dataKeySize)
+		}
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			ring.current = key
+		}
+		ring.keys[key.id] = key
+	}
+
+	return ring, nil
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// CurrentKeyID returns the fingerprint of the key new data keys are wrapped with
+func (r *KeyRing) CurrentKeyID() string {
+	return r.current.id
+}
+
+// NewDataKey generates a random data key and returns it with its wrapped form
+func (r *KeyRing) NewDataKey() ([]byte, string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := r.Wrap(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// Wrap encrypts a data key with the current master key. The result has the
+// form {keyID}:{base64 nonce and ciphertext}.
+func (r *KeyRing) Wrap(dataKey []byte) (string, error) {
+	nonce := make([]byte, r.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := r.current.aead.Seal(nonce, nonce, dataKey, []byte(r.current.id))
+	return r.current.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap decrypts a wrapped data key with whichever master key wrapped it
+func (r *KeyRing) Unwrap(wrapped string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrInvalidEncryptionKey)
+	}
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrInvalidEncryptionKey)
+	}
+	nonceSize := key.aead.NonceSize()
+	dataKey, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrapped key failed authentication", ErrInvalidEncryptionKey)
+	}
+	return dataKey, nil
+}
+
+// NeedsRewrap reports whether a wrapped key was made with an older master key
+func (r *KeyRing) NeedsRewrap(wrapped string) bool {
+	id, _, _ := strings.Cut(wrapped, ":")
+	return id != r.current.id
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncryptionKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypted objects are a sequence of segments, each holding up to
+// encSegmentSize bytes of plaintext sealed with AES-GCM. The nonce is the
+// segment index plus a flag marking the final segment, so segments can't
+// be reordered and a truncated object fails to decrypt.
+
+// encSegmentCount returns the number of segments for a plaintext size.
+// Empty content still gets one (empty) final segment.
+func encSegmentCount(plaintextSize int64) int64 {
+	if plaintextSize <= 0 {
+		return 1
+	}
+	return (plaintextSize + encSegmentSize - 1) / encSegmentSize
+}
+
+// EncryptedSize returns the stored size of an object with the given plaintext size
+func EncryptedSize(plaintextSize int64) int64 {
+	return plaintextSize + encSegmentCount(plaintextSize)*encTagSize
+}
+
+// PlaintextSize returns the plaintext size of a stored object of the given size
+func PlaintextSize(encryptedSize int64) int64 {
+	segments := (encryptedSize + encSegmentSize + encTagSize - 1) / (encSegmentSize + encTagSize)
+	return encryptedSize - max(segments, 1)*encTagSize
+}
+
+func segmentNonce(nonce []byte, index int64, final bool) []byte {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptReader encrypts its source as it is read
+type encryptReader struct {
+	aead   cipher.AEAD
+	src    io.Reader
+	start  int64 // source offset to rewind to, or -1 if it can't be rewound
+	nonce  []byte
+	buf    []byte // plaintext, read one byte past a segment to spot the last one
+	have   int
+	out    []byte // sealed bytes not yet returned
+	sealed []byte
+	index  int64
+	pos    int64
+	done   bool
+}
+
+func newEncryptReader(dataKey []byte, src io.Reader) (*encryptReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &encryptReader{
+		aead:   aead,
+		src:    src,
+		start:  -1,
+		nonce:  make([]byte, aead.NonceSize()),
+		buf:    make([]byte, encSegmentSize+1),
+		sealed: make([]byte, 0, encSegmentSize+encTagSize),
+	}
+	if seeker, ok := src.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			r.start = start
+		}
+	}
+	return r, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+// sealNext encrypts the next segment into out
+func (r *encryptReader) sealNext() error {
+	n, err := io.ReadFull(r.src, r.buf[r.have:])
+	r.have += n
+
+	final := false
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return err
+	}
+
+	segment := min(r.have, encSegmentSize)
+	r.out = r.aead.Seal(r.sealed[:0], segmentNonce(r.nonce, r.index, final), r.buf[:segment], nil)
+
+	// Keep the read-ahead byte for the next segment
+	r.have = copy(r.buf, r.buf[segment:r.have])
+	r.index++
+	r.done = final
+	return nil
+}
+
+// Seek only supports reporting the position and rewinding to the start,
+// which is enough for uploads to be retried
+func (r *encryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case offset == 0 && whence == io.SeekCurrent:
+		return r.pos, nil
+	case offset == 0 && whence == io.SeekStart:
+		seeker, ok := r.src.(io.Seeker)
+		if !ok || r.start < 0 {
+			return 0, errors.New("encrypted content can't be rewound")
+		}
+		if _, err := seeker.Seek(r.start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.have, r.out, r.index, r.pos, r.done = 0, nil, 0, 0, false
+		return 0, nil
+	default:
+		return 0, errors.New("encrypted content only supports rewinding")
+	}
+}
+
+// decryptReader decrypts a run of segments, returning only the requested
+// plaintext range
+type decryptReader struct {
+	aead      cipher.AEAD
+	src       io.ReadCloser
+	nonce     []byte
+	buf       []byte
+	out       []byte
+	index     int64 // index of the next segment to read
+	final     int64 // index of the object's last segment
+	skip      int64 // plaintext bytes to drop from the first segment
+	remaining int64 // plaintext bytes still to return
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if err := r.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// openNext reads and decrypts the next segment into out
+func (r *decryptReader) openNext() error {
+	if r.index > r.final {
+		return fmt.Errorf("%w: object is shorter than expected", ErrDecryptionFailed)
+	}
+
+	n, err := io.ReadFull(r.src, r.buf)
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && r.index == r.final) {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: object is shorter than expected", ErrDecryptionFailed)
+		}
+		return err
+	}
+
+	plain, err := r.aead.Open(r.buf[:0], segmentNonce(r.nonce, r.index, r.index == r.final), r.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d failed authentication", ErrDecryptionFailed, r.index)
+	}
+	r.index++
+
+	if r.skip > 0 {
+		drop := min(r.skip, int64(len(plain)))
+		plain = plain[drop:]
+		r.skip -= drop
+	}
+	r.out = plain[:min(int64(len(plain)), r.remaining)]
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMasterKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestKeyRing(t *testing.T) *KeyRing {
+	t.Helper()
+	ring, err := NewKeyRing(&config.Config{EncryptionKey: newTestMasterKey(t)})
+	require.NoError(t, err)
+	return ring
+}
+
+func TestNewKeyRing(t *testing.T) {
+	t.Run("Key file with retired keys", func(t *testing.T) {
+		current, retired := newTestMasterKey(t), newTestMasterKey(t)
+		path := filepath.Join(t.TempDir(), "master.key")
+		require.NoError(t, os.WriteFile(path, []byte("# master keys\n"+current+"\n\n"+retired+"\n"), 0o600))
+
+		oldRing, err := NewKeyRing(&config.Config{EncryptionKey: retired})
+		require.NoError(t, err)
+		wrapped, err := oldRing.Wrap(bytes.Repeat([]byte{7}, dataKeySize))
+		require.NoError(t, err)
+
+		ring, err := NewKeyRing(&config.Config{EncryptionKeyFile: path})
+		require.NoError(t, err)
+		assert.NotEqual(t, oldRing.CurrentKeyID(), ring.CurrentKeyID())
+
+		dataKey, err := ring.Unwrap(wrapped)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{7}, dataKeySize), dataKey)
+		assert.True(t, ring.NeedsRewrap(wrapped))
+	})
+
+	t.Run("Invalid keys", func(t *testing.T) {
+		_, err := NewKeyRing(&config.Config{})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+
+		_, err = NewKeyRing(&config.Config{EncryptionKey: base64.StdEncoding.EncodeToString([]byte("short"))})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+
+		_, err = NewKeyRing(&config.Config{EncryptionKey: newTestMasterKey(t), EncryptionPreviousKeys: "not base64!"})
+		assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+	})
+}
+
+func TestKeyRing_WrapUnwrap(t *testing.T) {
+	ring := newTestKeyRing(t)
+
+	dataKey, wrapped, err := ring.NewDataKey()
+	require.NoError(t, err)
+	assert.Len(t, dataKey, dataKeySize)
+	assert.False(t, ring.NeedsRewrap(wrapped))
+
+	unwrapped, err := ring.Unwrap(wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// Another master key can't unwrap it
+	_, err = newTestKeyRing(t).Unwrap(wrapped)
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+
+	// Tampering is detected
+	tampered := wrapped[:len(wrapped)-4] + "AAA="
+	_, err = ring.Unwrap(tampered)
+	assert.ErrorIs(t, err, ErrInvalidEncryptionKey)
+}
+
+func TestEncryptedSize(t *testing.T) {
+	for _, size := range []int64{0, 1, encSegmentSize - 1, encSegmentSize, encSegmentSize + 1, 3*encSegmentSize + 17} {
+		assert.Equal(t, size, PlaintextSize(EncryptedSize(size)), "size %d", size)
+	}
+	assert.Equal(t, int64(encTagSize), EncryptedSize(0))
+	assert.Equal(t, int64(2*encSegmentSize+2*encTagSize), EncryptedSize(2*encSegmentSize))
+}
+
+func encryptForTest(t *testing.T, dataKey, plaintext []byte) []byte {
+	t.Helper()
+	reader, err := newEncryptReader(dataKey, bytes.NewReader(plaintext))
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, EncryptedSize(int64(len(plaintext))), int64(len(ciphertext)))
+	return ciphertext
+}
+
+func decryptForTest(dataKey, ciphertext []byte, size, offset, length int64) ([]byte, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	first := offset / encSegmentSize
+	reader := &decryptReader{
+		aead:      aead,
+		src:       io.NopCloser(bytes.NewReader(ciphertext[first*(encSegmentSize+encTagSize):])),
+		nonce:     make([]byte, aead.NonceSize()),
+		buf:       make([]byte, encSegmentSize+encTagSize),
+		index:     first,
+		final:     encSegmentCount(size) - 1,
+		skip:      offset - first*encSegmentSize,
+		remaining: length,
+	}
+	return io.ReadAll(reader)
+}
+
+func TestEncryptDecrypt_RoundTrip(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{1}, dataKeySize)
+
+	for _, size := range []int{0, 1, 100, encSegmentSize, encSegmentSize + 1, 3*encSegmentSize + 500} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		ciphertext := encryptForTest(t, dataKey, plaintext)
+		if size > 16 {
+			assert.False(t, bytes.Contains(ciphertext, plaintext[:16]))
+		}
+
+		decrypted, err := decryptForTest(dataKey, ciphertext, int64(size), 0, int64(size))
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestEncryptDecrypt_Ranges(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{2}, dataKeySize)
+	plaintext := make([]byte, 3*encSegmentSize+500)
+	_, err := rand.Read(plaintext)
+	require.NoError(t, err)
+	ciphertext := encryptForTest(t, dataKey, plaintext)
+	size := int64(len(plaintext))
+
+	ranges := [][2]int64{
+		{0, 10},
+		{encSegmentSize - 5, 10},     // spans a segment boundary
+		{2*encSegmentSize + 3, 100},  // starts mid-object
+		{3 * encSegmentSize, 500},    // just the final segment
+		{10, 3*encSegmentSize + 490}, // to the end
+	}
+	for _, r := range ranges {
+		decrypted, err := decryptForTest(dataKey, ciphertext, size, r[0], r[1])
+		require.NoError(t, err)
+		assert.Equal(t, plaintext[r[0]:r[0]+r[1]], decrypted, "range %v", r)
+	}
+}
+
+func TestEncryptDecrypt_DetectsTampering(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{3}, dataKeySize)
+	plaintext := bytes.Repeat([]byte("secret"), encSegmentSize/3)
+	ciphertext := encryptForTest(t, dataKey, plaintext)
+	size := int64(len(plaintext))
+
+	t.Run("Flipped bit", func(t *testing.T) {
+		corrupted := bytes.Clone(ciphertext)
+		corrupted[10] ^= 1
+		_, err := decryptForTest(dataKey, corrupted, size, 0, size)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("Truncated at a segment boundary", func(t *testing.T) {
+		truncated := ciphertext[:encSegmentSize+encTagSize]
+		_, err := decryptForTest(dataKey, truncated, size, 0, size)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+		// Even when the recorded size agrees, the first segment isn't final
+		_, err = decryptForTest(dataKey, truncated, encSegmentSize, 0, encSegmentSize)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		_, err := decryptForTest(bytes.Repeat([]byte{4}, dataKeySize), ciphertext, size, 0, size)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+}
+
+func TestEncryptReader_Rewind(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{5}, dataKeySize)
+	plaintext := bytes.Repeat([]byte("x"), encSegmentSize+10)
+
+	reader, err := newEncryptReader(dataKey, bytes.NewReader(plaintext))
+	require.NoError(t, err)
+
+	first, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	pos, err := reader.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(first)), pos)
+
+	_, err = reader.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	second, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	_, err = reader.Seek(5, io.SeekStart)
+	assert.Error(t, err)
+}
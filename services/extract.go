@@ -0,0 +1,373 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// Archive extraction errors
+var (
+	ErrUnsupportedArchive = errors.New("unsupported archive format, expected zip, tar or tar.gz")
+	ErrInvalidArchive     = errors.New("invalid archive")
+	ErrArchiveTooLarge    = errors.New("archive exceeds extraction limits")
+)
+
+// ExtractResult reports what extracting an archive created
+type ExtractResult struct {
+	Directories []*models.Directory `json:"directories"`
+	Files       []*models.File      `json:"files"`
+	Skipped     []SkippedEntry      `json:"skipped"`
+	BytesStored int64               `json:"bytes_stored"`
+}
+
+// SkippedEntry is an archive entry that wasn't extracted, and why
+type SkippedEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// archiveItem is an entry read from a zip or tar archive
+type archiveItem struct {
+	name    string
+	isDir   bool
+	regular bool
+	size    int64
+	open    func() (io.ReadCloser, error)
+}
+
+// ExtractArchive extracts a zip, tar or tar.gz archive into directory
+// parentID (empty for the root directory), owned by userID. Directories in
+// the archive are merged with existing ones of the same name, and files
+// whose name is taken are handled by policy, as for uploads.
+//
+// The archive is read twice. The first pass only reads headers and rejects
+// the whole archive with ErrArchiveTooLarge if it has more entries or
+// expands to more bytes than the configured limits, so nothing is stored
+// for a zip bomb. Entries that can't be extracted on their own, such as
+// unsafe names, files over MaxUploadSize or past the quota, and name
+// conflicts, are skipped and reported in the result.
+func (s *FileService) ExtractArchive(ctx context.Context, userID, parentID string, archive io.ReaderAt, size int64, policy ConflictPolicy) (*ExtractResult, error) {
+	if _, err := s.destinationPath(userID, parentID); err != nil {
+		return nil, err
+	}
+
+	walk, err := archiveWalker(archive, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the limits before storing anything
+	entries := 0
+	var total int64
+	err = walk(func(item *archiveItem) error {
+		entries++
+		if entries > s.config.ExtractMaxEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, s.config.ExtractMaxEntries)
+		}
+		if item.regular {
+			total += item.size
+			if total > s.config.ExtractMaxSize {
+				return fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, s.config.ExtractMaxSize)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	x := &extraction{
+		service:  s,
+		ctx:      ctx,
+		userID:   userID,
+		parentID: parentID,
+		policy:   policy,
+		result:   &ExtractResult{Directories: []*models.Directory{}, Files: []*models.File{}, Skipped: []SkippedEntry{}},
+	}
+	if err := walk(x.extract); err != nil {
+		return x.result, err
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("directory_id", parentID).
+		Int("directories", len(x.result.Directories)).
+		Int("files", len(x.result.Files)).
+		Int("skipped", len(x.result.Skipped)).
+		Int64("bytes", x.result.BytesStored).
+		Msg("Archive extracted")
+
+	return x.result, nil
+}
+
+// extraction holds the state of one ExtractArchive call
+type extraction struct {
+	service  *FileService
+	ctx      context.Context
+	userID   string
+	parentID string
+	policy   ConflictPolicy
+	result   *ExtractResult
+}
+
+// extract extracts one archive entry, recording it as skipped if it can't
+// be. Only cancellation stops the extraction.
+func (x *extraction) extract(item *archiveItem) error {
+	if err := x.ctx.Err(); err != nil {
+		return err
+	}
+
+	parts, err := SplitRelativePath(item.name)
+	if err != nil {
+		x.skip(item.name, err.Error())
+		return nil
+	}
+	if len(parts) == 0 {
+		// The archive's own root, like "./"
+		return nil
+	}
+
+	if item.isDir {
+		if _, err := x.directory(parts); err != nil {
+			x.skip(item.name, err.Error())
+		}
+		return nil
+	}
+	if !item.regular {
+		x.skip(item.name, "not a regular file")
+		return nil
+	}
+
+	if err := x.file(item, parts); err != nil {
+		x.skip(item.name, err.Error())
+	}
+	return nil
+}
+
+// directory returns the ID of the directory at parts, creating it and its
+// parents as needed
+func (x *extraction) directory(parts []string) (string, error) {
+	dirID, created, err := x.service.EnsureDirectories(x.userID, x.parentID, parts)
+	x.result.Directories = append(x.result.Directories, created...)
+	return dirID, err
+}
+
+// file stores a file entry and saves its record
+func (x *extraction) file(item *archiveItem, parts []string) error {
+	s := x.service
+	name := parts[len(parts)-1]
+
+	if item.size > s.config.MaxUploadSize {
+		return fmt.Errorf("exceeds the maximum upload size of %d bytes", s.config.MaxUploadSize)
+	}
+	if err := s.checkQuota(x.userID, item.size); err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			return errors.New("insufficient storage quota")
+		}
+		return err
+	}
+
+	parentID, err := x.directory(parts[:len(parts)-1])
+	if err != nil {
+		return err
+	}
+	path, err := s.destinationPath(x.userID, parentID)
+	if err != nil {
+		return err
+	}
+	if err := s.CheckUploadName(x.userID, parentID, name, x.policy); err != nil {
+		return err
+	}
+
+	reader, err := item.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	key, checksum, wrappedKey, err := x.store(reader, item.size, name, mimeType)
+	if err != nil {
+		s.logger.Error().Err(err).Str("entry", item.name).Msg("Failed to store extracted file")
+		return errors.New("failed to read or store the file")
+	}
+
+	file := &models.File{
+		Name:            name,
+		Path:            path,
+		User:            x.userID,
+		ParentDirectory: parentID,
+		Size:            item.size,
+		MimeType:        mimeType,
+		S3Key:           key,
+		S3Bucket:        s.config.S3Bucket,
+		Checksum:        checksum,
+		WrappedKey:      wrappedKey,
+	}
+
+	var result *UploadResult
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result, err = s.SaveUpload(tx, file, x.policy)
+		return err
+	})
+	if err != nil {
+		s.blobService.ReleaseObject(context.WithoutCancel(x.ctx), key)
+		return err
+	}
+
+	if err := s.userService.UpdateStorageUsed(x.userID, file.Size); err != nil {
+		s.logger.Error().Err(err).Str("user_id", x.userID).Msg("Failed to update storage used after extraction")
+	}
+	s.FinishUpload(x.ctx, file, result)
+
+	x.result.Files = append(x.result.Files, file)
+	x.result.BytesStored += file.Size
+	return nil
+}
+
+// store stores an entry's content as an upload would, returning its key,
+// checksum and wrapped data key
+func (x *extraction) store(reader io.Reader, size int64, name, mimeType string) (string, string, string, error) {
+	s := x.service
+
+	if s.config.DedupEnabled {
+		// Blobs are hashed before they are stored, so the entry is read
+		// twice from a temporary copy
+		tmp, err := os.CreateTemp("", "extract-*")
+		if err != nil {
+			return "", "", "", err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, io.LimitReader(reader, size+1)); err != nil {
+			return "", "", "", err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return "", "", "", err
+		}
+
+		blob, err := s.blobService.Store(x.ctx, tmp, size, mimeType)
+		if err != nil {
+			return "", "", "", err
+		}
+		return blob.S3Key, blob.Checksum, blob.WrappedKey, nil
+	}
+
+	key := GenerateS3Key(x.userID, models.GenerateID(), name)
+	checksumReader := NewChecksumReader(reader)
+	wrappedKey, err := UploadObject(x.ctx, s.s3Service, key, checksumReader, size, mimeType)
+	if err != nil {
+		return "", "", "", err
+	}
+	return key, checksumReader.Checksum(), wrappedKey, nil
+}
+
+// skip records an entry that wasn't extracted
+func (x *extraction) skip(name, reason string) {
+	x.result.Skipped = append(x.result.Skipped, SkippedEntry{Name: name, Reason: reason})
+}
+
+// archiveWalker detects the format of an archive and returns a function
+// that calls fn for each of its entries in order. Each call reads the
+// archive from the start.
+func archiveWalker(archive io.ReaderAt, size int64) (func(fn func(*archiveItem) error) error, error) {
+	magic := make([]byte, 512)
+	n, err := archive.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		reader, err := zip.NewReader(archive, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		return func(fn func(*archiveItem) error) error {
+			return walkZip(reader, fn)
+		}, nil
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return func(fn func(*archiveItem) error) error {
+			gz, err := gzip.NewReader(io.NewSectionReader(archive, 0, size))
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+			}
+			defer gz.Close()
+			return walkTar(tar.NewReader(gz), fn)
+		}, nil
+
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		return func(fn func(*archiveItem) error) error {
+			return walkTar(tar.NewReader(io.NewSectionReader(archive, 0, size)), fn)
+		}, nil
+
+	default:
+		return nil, ErrUnsupportedArchive
+	}
+}
+
+// walkZip calls fn for each entry of a zip archive
+func walkZip(reader *zip.Reader, fn func(*archiveItem) error) error {
+	for _, f := range reader.File {
+		mode := f.Mode()
+		item := &archiveItem{
+			name:    f.Name,
+			isDir:   mode.IsDir() || strings.HasSuffix(f.Name, "/"),
+			regular: mode.IsRegular(),
+			size:    int64(f.UncompressedSize64),
+			open:    f.Open,
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar calls fn for each entry of a tar archive
+func walkTar(reader *tar.Reader, fn func(*archiveItem) error) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			// Metadata for the archive, not an entry
+			continue
+		}
+
+		item := &archiveItem{
+			name:    header.Name,
+			isDir:   header.Typeflag == tar.TypeDir,
+			regular: header.Typeflag == tar.TypeReg,
+			size:    header.Size,
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(reader), nil
+			},
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testArchiveEntry is an entry for building test archives; names ending
+// in "/" are directories
+type testArchiveEntry struct {
+	name    string
+	content string
+}
+
+func buildTestZip(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildTestTarGz(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if entry.name[len(entry.name)-1] == '/' {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestFileService_ExtractArchive(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	service.config.MaxUploadSize = 100
+	service.config.ExtractMaxEntries = 100
+	service.config.ExtractMaxSize = 1000
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("extract@example.com", "extractuser", "Password123!", false)
+	require.NoError(t, err)
+	docs := createTestDirectory(t, db, user.ID, nil, "docs")
+	createTestTreeFile(t, storage, db, user.ID, docs, "taken.txt", "old")
+
+	entries := []testArchiveEntry{
+		{"docs/", ""},
+		{"docs/readme.md", "# Readme"},
+		{"docs/taken.txt", "new"},
+		{"photos/2024/trip.jpg", "jpeg"},
+		{"empty/", ""},
+		{"../escape.txt", "nope"},
+		{"/etc/passwd", "nope"},
+		{"big.bin", string(make([]byte, 101))},
+	}
+
+	for format, archive := range map[string][]byte{
+		"zip":    buildTestZip(t, entries),
+		"tar.gz": buildTestTarGz(t, entries),
+	} {
+		t.Run(format, func(t *testing.T) {
+			parent := createTestDirectory(t, db, user.ID, nil, "import-"+format)
+			createTestDirectory(t, db, user.ID, parent, "docs")
+			createTestTreeFile(t, storage, db, user.ID, nil, "unrelated-"+format, "x")
+
+			result, err := service.ExtractArchive(ctx, user.ID, parent.ID, bytes.NewReader(archive), int64(len(archive)), ConflictFail)
+			require.NoError(t, err)
+
+			names := make(map[string]string)
+			for _, file := range result.Files {
+				names[file.Path+"/"+file.Name] = readObject(t, storage, file.S3Key)
+			}
+			assert.Equal(t, map[string]string{
+				"import-" + format + "/docs/readme.md":       "# Readme",
+				"import-" + format + "/docs/taken.txt":       "new",
+				"import-" + format + "/photos/2024/trip.jpg": "jpeg",
+			}, names)
+			assert.Equal(t, int64(len("# Readme")+len("new")+len("jpeg")), result.BytesStored)
+
+			// The existing docs directory is reused
+			var dirNames []string
+			for _, dir := range result.Directories {
+				dirNames = append(dirNames, dir.Name)
+			}
+			assert.ElementsMatch(t, []string{"photos", "2024", "empty"}, dirNames)
+
+			skipped := make(map[string]string)
+			for _, entry := range result.Skipped {
+				skipped[entry.Name] = entry.Reason
+			}
+			assert.Contains(t, skipped, "../escape.txt")
+			assert.Contains(t, skipped, "/etc/passwd")
+			assert.Contains(t, skipped["big.bin"], "maximum upload size")
+			if format == "tar.gz" {
+				assert.Equal(t, "not a regular file", skipped["link"])
+			}
+		})
+	}
+
+	t.Run("Name conflicts follow the policy", func(t *testing.T) {
+		archive := buildTestZip(t, []testArchiveEntry{{"taken.txt", "again"}})
+
+		result, err := service.ExtractArchive(ctx, user.ID, docs.ID, bytes.NewReader(archive), int64(len(archive)), ConflictFail)
+		require.NoError(t, err)
+		require.Len(t, result.Skipped, 1)
+		assert.Contains(t, result.Skipped[0].Reason, "already exists")
+
+		result, err = service.ExtractArchive(ctx, user.ID, docs.ID, bytes.NewReader(archive), int64(len(archive)), ConflictRename)
+		require.NoError(t, err)
+		require.Len(t, result.Files, 1)
+		assert.Equal(t, "taken (1).txt", result.Files[0].Name)
+	})
+
+	t.Run("Identical entries share a blob when deduplicating", func(t *testing.T) {
+		service.config.DedupEnabled = true
+		defer func() { service.config.DedupEnabled = false }()
+
+		var key string
+		for format, archive := range map[string][]byte{
+			"zip":    buildTestZip(t, []testArchiveEntry{{"a.txt", "same"}, {"b.txt", "same"}}),
+			"tar.gz": buildTestTarGz(t, []testArchiveEntry{{"a.txt", "same"}, {"b.txt", "same"}}),
+		} {
+			parent := createTestDirectory(t, db, user.ID, nil, "dedup-"+format)
+			result, err := service.ExtractArchive(ctx, user.ID, parent.ID, bytes.NewReader(archive), int64(len(archive)), ConflictFail)
+			require.NoError(t, err)
+			require.Len(t, result.Files, 2, format)
+			assert.Equal(t, models.BlobKey(result.Files[0].Checksum), result.Files[0].S3Key, format)
+			assert.Equal(t, result.Files[0].S3Key, result.Files[1].S3Key, format)
+			assert.Equal(t, "same", readObject(t, storage, result.Files[0].S3Key))
+			key = result.Files[0].S3Key
+		}
+
+		var blob models.Blob
+		require.NoError(t, db.First(&blob, "s3_key = ?", key).Error)
+		assert.Equal(t, int64(4), blob.RefCount)
+	})
+
+	t.Run("Quota is checked per entry", func(t *testing.T) {
+		current, err := service.userService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", current.StorageUsed+5).Error)
+		defer db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", current.StorageQuota)
+
+		archive := buildTestZip(t, []testArchiveEntry{{"a.txt", "1234"}, {"b.txt", "1234"}})
+		result, err := service.ExtractArchive(ctx, user.ID, "", bytes.NewReader(archive), int64(len(archive)), ConflictFail)
+		require.NoError(t, err)
+		require.Len(t, result.Files, 1)
+		assert.Equal(t, "a.txt", result.Files[0].Name)
+		require.Len(t, result.Skipped, 1)
+		assert.Equal(t, "insufficient storage quota", result.Skipped[0].Reason)
+	})
+
+	t.Run("Limits reject the whole archive", func(t *testing.T) {
+		var before int64
+		db.Model(&models.File{}).Count(&before)
+
+		service.config.ExtractMaxEntries = 2
+		archive := buildTestZip(t, []testArchiveEntry{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+		_, err := service.ExtractArchive(ctx, user.ID, "", bytes.NewReader(archive), int64(len(archive)), ConflictRename)
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+		service.config.ExtractMaxEntries = 100
+
+		service.config.ExtractMaxSize = 10
+		archive = buildTestTarGz(t, []testArchiveEntry{{"a", "123456"}, {"b", "123456"}})
+		_, err = service.ExtractArchive(ctx, user.ID, "", bytes.NewReader(archive), int64(len(archive)), ConflictRename)
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+
+		var after int64
+		db.Model(&models.File{}).Count(&after)
+		assert.Equal(t, before, after, "Nothing should be extracted")
+	})
+
+	t.Run("Other formats are rejected", func(t *testing.T) {
+		data := []byte("just some text")
+		_, err := service.ExtractArchive(ctx, user.ID, "", bytes.NewReader(data), int64(len(data)), ConflictFail)
+		assert.ErrorIs(t, err, ErrUnsupportedArchive)
+	})
+}
@@ -0,0 +1,996 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// File operation errors
+var (
+	ErrNameConflict          = errors.New("an item with that name already exists")
+	ErrQuotaExceeded         = errors.New("insufficient storage quota")
+	ErrInvalidDestination    = errors.New("invalid destination directory")
+	ErrInvalidConflictPolicy = errors.New("invalid conflict policy")
+	ErrInvalidName           = errors.New("invalid name")
+)
+
+const (
+	// maxRenameAttempts bounds the search for a free "name (n)" when
+	// renaming around a conflict
+	maxRenameAttempts = 1000
+	// maxNameRetries bounds how often a write that lost a race for its
+	// name is retried with a freshly resolved one
+	maxNameRetries = 5
+	// fileBatchSize bounds how many records are written per statement
+	fileBatchSize = 500
+	// deleteBatchSize is the most keys S3 accepts in one delete request
+	deleteBatchSize = 1000
+)
+
+// DeleteReport summarises a recursive delete. Records are removed before
+// objects, so objects that couldn't be deleted are listed for cleanup (the
+// reconciliation job will also find them).
+type DeleteReport struct {
+	DirectoriesDeleted int      `json:"directories_deleted"`
+	FilesDeleted       int      `json:"files_deleted"`
+	BytesFreed         int64    `json:"bytes_freed"`
+	FailedObjects      []string `json:"failed_objects,omitempty"`
+}
+
+// ConflictPolicy decides what happens when an operation would create an
+// item whose name is already taken in the destination directory
+type ConflictPolicy string
+
+const (
+	// ConflictFail rejects the operation with ErrNameConflict
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictRename picks a free name by appending " (n)"
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite replaces the existing file's content, keeping its
+	// ID and shares. Only uploads can overwrite.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictVersion makes the upload the existing file's next version,
+	// keeping the content it replaces. Only uploads can add versions, and
+	// only with versioning enabled.
+	ConflictVersion ConflictPolicy = "version"
+)
+
+// ParseConflictPolicy parses a conflict policy, defaulting to ConflictFail
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch ConflictPolicy(value) {
+	case "", ConflictFail:
+		return ConflictFail, nil
+	case ConflictRename:
+		return ConflictRename, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidConflictPolicy, value)
+	}
+}
+
+// SplitRelativePath splits a slash-separated path relative to a directory,
+// such as a folder upload's path or an archive entry's name, into the
+// names of its parts. Empty and "." parts are dropped. Absolute paths,
+// traversal and names that SanitizeFilename would change fail with
+// ErrInvalidName.
+func SplitRelativePath(path string) ([]string, error) {
+	path = strings.ReplaceAll(path, "\\", "/")
+	if err := models.ValidatePathTraversal(path); err != nil {
+		return nil, fmt.Errorf("%w: unsafe path", ErrInvalidName)
+	}
+	if strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("%w: absolute path", ErrInvalidName)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		sanitized, err := models.SanitizeFilename(part)
+		if err != nil || sanitized != part {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidName, part)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// UploadConflictPolicy parses the conflict policy for an upload. Uploads
+// default to ConflictVersion with versioning enabled, and ConflictFail
+// otherwise.
+func (s *FileService) UploadConflictPolicy(value string) (ConflictPolicy, error) {
+	switch ConflictPolicy(value) {
+	case "":
+		if s.config.VersioningEnabled {
+			return ConflictVersion, nil
+		}
+		return ConflictFail, nil
+	case ConflictFail, ConflictRename, ConflictOverwrite:
+		return ConflictPolicy(value), nil
+	case ConflictVersion:
+		if !s.config.VersioningEnabled {
+			return "", fmt.Errorf("%w: versioning is not enabled", ErrInvalidConflictPolicy)
+		}
+		return ConflictVersion, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidConflictPolicy, value)
+	}
+}
+
+// FileService implements operations on the file and directory tree
+type FileService struct {
+	db          *gorm.DB
+	s3Service   S3Service
+	blobService *BlobService
+	userService *UserService
+	logger      zerolog.Logger
+	config      *config.Config
+
+	// dirLocks serializes creating a directory by name, so concurrent
+	// uploads into a new folder don't each create it
+	dirLocks *stripedMutex
+
+	contentIndex *ContentIndexService // Indexes the text of new content, nil if not set
+	activity     *ActivityService     // Records what users do, nil if not set
+}
+
+// NewFileService creates a new file service
+func NewFileService(db *gorm.DB, s3Service S3Service, blobService *BlobService, userService *UserService, logger zerolog.Logger, cfg *config.Config) *FileService {
+	service := &FileService{
+		db:          db,
+		s3Service:   s3Service,
+		blobService: blobService,
+		userService: userService,
+		logger:      logger,
+		config:      cfg,
+		dirLocks:    &stripedMutex{},
+	}
+
+	if cfg.TrashRetentionDays > 0 {
+		// Start background goroutine to purge trash past its retention
+		go service.purgeExpiredTrash()
+	}
+	if cfg.VersioningEnabled && cfg.VersionRetentionDays > 0 {
+		// Start background goroutine to prune versions past their retention
+		go service.pruneExpiredVersions()
+	}
+
+	return service
+}
+
+// SetContentIndexService sets the service that indexes the text of new
+// uploads, copies and restored versions
+func (s *FileService) SetContentIndexService(contentIndex *ContentIndexService) {
+	s.contentIndex = contentIndex
+}
+
+// queueContentIndex queues files whose content is new for content indexing
+func (s *FileService) queueContentIndex(files ...*models.File) {
+	if s.contentIndex == nil {
+		return
+	}
+	for _, file := range files {
+		s.contentIndex.Enqueue(file.ID)
+	}
+}
+
+// SetActivityService sets the service that records uploads, renames,
+// moves, deletes and downloads
+func (s *FileService) SetActivityService(activity *ActivityService) {
+	s.activity = activity
+}
+
+// recordActivity records that userID did action to an item
+func (s *FileService) recordActivity(userID, action string, resourceType models.ResourceType, id, name, detail string) {
+	if s.activity == nil {
+		return
+	}
+	s.activity.Record(userID, action, resourceType, id, name, detail)
+}
+
+// RecordDownload records that userID downloaded file. Downloads by anyone
+// but the owner, like through a share link, aren't recorded.
+func (s *FileService) RecordDownload(userID string, file *models.File) {
+	if userID == "" || !file.IsOwnedBy(userID) {
+		return
+	}
+	s.recordActivity(userID, models.ActivityDownload, models.ResourceTypeFile, file.ID, file.Name, "")
+}
+
+// CreateDirectory creates a directory called name in parentID (empty for
+// the root directory), owned by userID. It returns the policy applied to a
+// name conflict, empty if the name was free.
+func (s *FileService) CreateDirectory(userID, parentID, name string, policy ConflictPolicy) (*models.Directory, ConflictPolicy, error) {
+	path := "/"
+	if parentID != "" {
+		var parent models.Directory
+		if err := s.db.First(&parent, "id = ?", parentID).Error; err != nil {
+			return nil, "", err
+		}
+		path = parent.GetFullPath()
+	}
+
+	dir := &models.Directory{
+		Path:            path,
+		User:            userID,
+		ParentDirectory: parentID,
+	}
+	var applied ConflictPolicy
+	err := retryName(policy, name, func() error {
+		resolved, err := s.resolveName(userID, parentID, name, true, policy)
+		if err != nil {
+			return err
+		}
+		applied = ""
+		if resolved != name {
+			applied = ConflictRename
+		}
+		dir.Name = resolved
+		return s.db.Create(dir).Error
+	})
+	if errors.Is(err, ErrNameConflict) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("directory_id", dir.ID).
+		Str("name", dir.Name).
+		Msg("Directory created successfully")
+
+	return dir, applied, nil
+}
+
+// EnsureDirectories returns the ID of the directory at the path parts
+// below parentID (empty for the root directory), creating any that are
+// missing, along with the directories it created. Existing directories are
+// reused, so calling it again for the same path changes nothing, and
+// concurrent calls create each directory once. A file holding one of the
+// names fails with ErrNameConflict.
+func (s *FileService) EnsureDirectories(userID, parentID string, parts []string) (string, []*models.Directory, error) {
+	var created []*models.Directory
+	for i, name := range parts {
+		dir, isNew, err := s.ensureDirectory(userID, parentID, name)
+		if err != nil {
+			if errors.Is(err, ErrNameConflict) {
+				err = fmt.Errorf("%w: %s is a file", ErrNameConflict, strings.Join(parts[:i+1], "/"))
+			}
+			return "", created, err
+		}
+		if isNew {
+			created = append(created, dir)
+		}
+		parentID = dir.ID
+	}
+	return parentID, created, nil
+}
+
+// ensureDirectory returns userID's directory called name in parentID,
+// creating it if there is none and reporting whether it did
+func (s *FileService) ensureDirectory(userID, parentID, name string) (*models.Directory, bool, error) {
+	lock := s.dirLocks.lockFor(userID + "/" + parentID + "/" + name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	existing, err := s.liveDirectory(userID, parentID, name)
+	if err != nil || existing != nil {
+		return existing, false, err
+	}
+
+	dir, _, err := s.CreateDirectory(userID, parentID, name, ConflictFail)
+	if errors.Is(err, ErrNameConflict) {
+		// Another process may have created it since the lookup
+		existing, lookupErr := s.liveDirectory(userID, parentID, name)
+		if lookupErr == nil && existing != nil {
+			return existing, false, nil
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return dir, true, nil
+}
+
+// CopyFile copies a file into destDirID (empty for the root directory),
+// owned by userID. The object is copied inside storage.
+func (s *FileService) CopyFile(ctx context.Context, userID, fileID, destDirID string, policy ConflictPolicy) (*models.File, error) {
+	var source models.File
+	if err := s.db.First(&source, "id = ?", fileID).Error; err != nil {
+		return nil, err
+	}
+
+	destPath, err := s.destinationPath(userID, destDirID)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := s.resolveName(userID, destDirID, source.Name, false, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkQuota(userID, source.Size); err != nil {
+		return nil, err
+	}
+
+	key, err := s.copyObject(ctx, userID, &source)
+	if err != nil {
+		return nil, err
+	}
+
+	file := copyFileRecord(&source, userID, name, destPath, destDirID, key)
+	err = retryName(policy, source.Name, func() error {
+		name, err := s.resolveName(userID, destDirID, source.Name, false, policy)
+		if err != nil {
+			return err
+		}
+		file.Name = name
+		return s.db.Create(file).Error
+	})
+	if err != nil {
+		s.blobService.ReleaseObject(context.WithoutCancel(ctx), key)
+		if errors.Is(err, ErrNameConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create file record: %w", err)
+	}
+
+	if err := s.userService.UpdateStorageUsed(userID, file.Size); err != nil {
+		s.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update storage used after copy")
+	}
+	s.queueContentIndex(file)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("source_id", source.ID).
+		Str("file_id", file.ID).
+		Str("directory_id", destDirID).
+		Msg("File copied")
+
+	return file, nil
+}
+
+// CopyDirectory copies a directory and everything below it into destDirID
+// (empty for the root directory), owned by userID. All records are created
+// in one transaction, after the objects have been copied.
+func (s *FileService) CopyDirectory(ctx context.Context, userID, dirID, destDirID string, policy ConflictPolicy) (*models.Directory, error) {
+	var source models.Directory
+	if err := s.db.First(&source, "id = ?", dirID).Error; err != nil {
+		return nil, err
+	}
+
+	if destDirID != "" {
+		inside, err := s.isWithin(destDirID, source.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inside {
+			return nil, fmt.Errorf("%w: can't copy a directory into itself", ErrInvalidDestination)
+		}
+	}
+
+	destPath, err := s.destinationPath(userID, destDirID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked again as the records are written, but a conflict should
+	// fail before any objects are copied
+	if _, err := s.resolveName(userID, destDirID, source.Name, true, policy); err != nil {
+		return nil, err
+	}
+
+	dirs, files, err := s.loadSubtree(&source)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	if err := s.checkQuota(userID, total); err != nil {
+		return nil, err
+	}
+
+	// Build the new tree, parents before children
+	copies := make(map[string]*models.Directory, len(dirs))
+	newDirs := make([]*models.Directory, 0, len(dirs))
+	for _, dir := range dirs {
+		dirCopy := &models.Directory{
+			ID:   models.GenerateID(),
+			Name: dir.Name,
+			User: userID,
+		}
+		if dir.ID == source.ID {
+			dirCopy.ParentDirectory = destDirID
+		} else {
+			dirCopy.ParentDirectory = copies[dir.ParentDirectory].ID
+		}
+		copies[dir.ID] = dirCopy
+		newDirs = append(newDirs, dirCopy)
+	}
+
+	newFiles := make([]*models.File, 0, len(files))
+	var keys []string
+	for _, file := range files {
+		key, err := s.copyObject(ctx, userID, file)
+		if err != nil {
+			s.releaseCopies(ctx, keys)
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		newFiles = append(newFiles, copyFileRecord(file, userID, file.Name, "", copies[file.ParentDirectory].ID, key))
+	}
+
+	// The paths follow from the copy's name, which is only settled once
+	// its record is written; parents come first, so every copy can take
+	// its path from its already placed parent
+	place := func(name string) {
+		newDirs[0].Name = name
+		newDirs[0].Path = destPath
+		for i, dir := range dirs[1:] {
+			newDirs[i+1].Path = copies[dir.ParentDirectory].GetFullPath()
+		}
+		for i, file := range files {
+			newFiles[i].Path = copies[file.ParentDirectory].GetFullPath()
+		}
+	}
+
+	err = retryName(policy, source.Name, func() error {
+		name, err := s.resolveName(userID, destDirID, source.Name, true, policy)
+		if err != nil {
+			return err
+		}
+		place(name)
+
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(newDirs).Error; err != nil {
+				return err
+			}
+			if len(newFiles) > 0 {
+				return tx.CreateInBatches(newFiles, fileBatchSize).Error
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		s.releaseCopies(ctx, keys)
+		if errors.Is(err, ErrNameConflict) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create copied records: %w", err)
+	}
+
+	if err := s.userService.UpdateStorageUsed(userID, total); err != nil {
+		s.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update storage used after copy")
+	}
+	s.queueContentIndex(newFiles...)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("source_id", source.ID).
+		Str("directory_id", newDirs[0].ID).
+		Int("directories", len(newDirs)).
+		Int("files", len(newFiles)).
+		Int64("size", total).
+		Msg("Directory copied")
+
+	return newDirs[0], nil
+}
+
+// MoveOptions describes a rename and/or a move. Nil fields are left
+// unchanged; an empty ParentDirectory is the root directory.
+type MoveOptions struct {
+	Name            *string
+	ParentDirectory *string
+}
+
+// MoveFile renames a file and/or moves it to another directory
+func (s *FileService) MoveFile(userID, fileID string, opts MoveOptions) (*models.File, error) {
+	var file models.File
+	if err := s.db.First(&file, "id = ? AND user = ?", fileID, userID).Error; err != nil {
+		return nil, err
+	}
+
+	name, parentID, path, err := s.resolveMove(userID, file.ID, file.Name, file.ParentDirectory, opts)
+	if err != nil {
+		return nil, err
+	}
+	oldName, oldParentID, oldPath := file.Name, file.ParentDirectory, file.Path
+
+	file.Name = name
+	file.Path = path
+	file.ParentDirectory = parentID
+
+	err = retryName(ConflictFail, name, func() error {
+		return s.db.Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+			"name":             file.Name,
+			"path":             file.Path,
+			"parent_directory": file.ParentDirectory,
+		}).Error
+	})
+	if errors.Is(err, ErrNameConflict) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to move file: %w", err)
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("name", name).
+		Str("directory_id", parentID).
+		Msg("File moved")
+
+	s.recordMove(userID, models.ResourceTypeFile, file.ID, name, oldName, parentID != oldParentID, oldPath)
+
+	return &file, nil
+}
+
+// MoveDirectory renames a directory and/or moves it to another directory.
+// The stored path of everything below it is rewritten in the same
+// transaction.
+func (s *FileService) MoveDirectory(userID, dirID string, opts MoveOptions) (*models.Directory, error) {
+	var dir models.Directory
+	if err := s.db.First(&dir, "id = ? AND user = ?", dirID, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if opts.ParentDirectory != nil && *opts.ParentDirectory != "" {
+		inside, err := s.isWithin(*opts.ParentDirectory, dir.ID)
+		if err != nil {
+			return nil, err
+		}
+		if inside {
+			return nil, fmt.Errorf("%w: can't move a directory into itself", ErrInvalidDestination)
+		}
+	}
+
+	name, parentID, path, err := s.resolveMove(userID, dir.ID, dir.Name, dir.ParentDirectory, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, files, err := s.loadSubtree(&dir)
+	if err != nil {
+		return nil, err
+	}
+	oldName, oldParentID, oldPath := dir.Name, dir.ParentDirectory, dir.Path
+
+	dir.Name = name
+	dir.Path = path
+	dir.ParentDirectory = parentID
+
+	err = retryName(ConflictFail, name, func() error {
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			return relocateDirectory(tx, dirs, files)
+		})
+	})
+	if errors.Is(err, ErrNameConflict) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to move directory: %w", err)
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("directory_id", dir.ID).
+		Str("name", name).
+		Str("parent_id", parentID).
+		Int("descendants", len(dirs)-1+len(files)).
+		Msg("Directory moved")
+
+	s.recordMove(userID, models.ResourceTypeDirectory, dir.ID, name, oldName, parentID != oldParentID, oldPath)
+
+	return &dir, nil
+}
+
+// recordMove records a rename or a move of an item. A move is recorded with
+// the path the item was in, and a rename with its old name; an item that
+// was renamed as it moved is recorded as moved.
+func (s *FileService) recordMove(userID string, resourceType models.ResourceType, id, name, oldName string, moved bool, oldPath string) {
+	switch {
+	case moved:
+		s.recordActivity(userID, models.ActivityMove, resourceType, id, name, oldPath)
+	case name != oldName:
+		s.recordActivity(userID, models.ActivityRename, resourceType, id, name, oldName)
+	}
+}
+
+// resolveMove works out the name, parent and path of an item after a move,
+// checking the new name and destination
+func (s *FileService) resolveMove(userID, itemID, name, parentID string, opts MoveOptions) (string, string, string, error) {
+	if opts.Name != nil {
+		sanitized, err := models.SanitizeFilename(*opts.Name)
+		if err != nil {
+			return "", "", "", fmt.Errorf("%w: %v", ErrInvalidName, err)
+		}
+		name = sanitized
+	}
+	if opts.ParentDirectory != nil {
+		parentID = *opts.ParentDirectory
+	}
+
+	path, err := s.destinationPath(userID, parentID)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	taken, err := s.nameTakenExcept(userID, parentID, name, itemID)
+	if err != nil {
+		return "", "", "", err
+	}
+	if taken {
+		return "", "", "", fmt.Errorf("%w: %s", ErrNameConflict, name)
+	}
+
+	return name, parentID, path, nil
+}
+
+// relocateDirectory stores the name, parent and path of dirs[0] and rewrites
+// the paths below it to match. dirs must be its subtree, parents first, as
+// returned by loadSubtree.
+func relocateDirectory(tx *gorm.DB, dirs []*models.Directory, files []*models.File) error {
+	dir := dirs[0]
+	if err := tx.Model(&models.Directory{}).Where("id = ?", dir.ID).Updates(map[string]interface{}{
+		"name":             dir.Name,
+		"path":             dir.Path,
+		"parent_directory": dir.ParentDirectory,
+	}).Error; err != nil {
+		return err
+	}
+
+	// Directories come parents first, so each parent's full path is
+	// already rewritten when its children are reached
+	fullPaths := map[string]string{dir.ID: dir.GetFullPath()}
+	for _, child := range dirs[1:] {
+		child.Path = fullPaths[child.ParentDirectory]
+		fullPaths[child.ID] = child.GetFullPath()
+		if err := tx.Model(&models.Directory{}).Where("id = ?", child.ID).Update("path", child.Path).Error; err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		file.Path = fullPaths[file.ParentDirectory]
+		if err := tx.Model(&models.File{}).Where("id = ?", file.ID).Update("path", file.Path).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRecords permanently deletes dirs and files, trashed or not, with
+// the files' older versions and the shares pointing at any of them. The records go in one transaction,
+// then the objects are released in batches; storage failures are returned
+// in the report rather than failing the delete. The freed bytes are taken
+// off owner's storage used when releaseQuota is set.
+func (s *FileService) deleteRecords(ctx context.Context, owner string, dirs []*models.Directory, files []*models.File, releaseQuota bool) (*DeleteReport, error) {
+	dirIDs := make([]string, len(dirs))
+	for i, d := range dirs {
+		dirIDs[i] = d.ID
+	}
+	fileIDs := make([]string, len(files))
+	keys := make([]string, len(files))
+	report := &DeleteReport{
+		DirectoriesDeleted: len(dirs),
+		FilesDeleted:       len(files),
+	}
+	for i, file := range files {
+		fileIDs[i] = file.ID
+		keys[i] = file.S3Key
+		report.BytesFreed += file.Size
+	}
+
+	versions, err := s.versionsOf(fileIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		keys = append(keys, version.S3Key)
+		report.BytesFreed += version.Size
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(fileIDs); start += fileBatchSize {
+			batch := fileIDs[start:min(start+fileBatchSize, len(fileIDs))]
+			if err := tx.Where("file IN ?", batch).Delete(&models.Share{}).Error; err != nil {
+				return fmt.Errorf("failed to delete file shares: %w", err)
+			}
+			if err := tx.Where("file IN ?", batch).Delete(&models.FileVersion{}).Error; err != nil {
+				return fmt.Errorf("failed to delete file versions: %w", err)
+			}
+			if err := s.in(tx).deleteLabels(models.ResourceTypeFile, batch); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("id IN ?", batch).Delete(&models.File{}).Error; err != nil {
+				return fmt.Errorf("failed to delete files: %w", err)
+			}
+		}
+		for start := 0; start < len(dirIDs); start += fileBatchSize {
+			batch := dirIDs[start:min(start+fileBatchSize, len(dirIDs))]
+			if err := tx.Where("directory IN ?", batch).Delete(&models.Share{}).Error; err != nil {
+				return fmt.Errorf("failed to delete directory shares: %w", err)
+			}
+			if err := s.in(tx).deleteLabels(models.ResourceTypeDirectory, batch); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("id IN ?", batch).Delete(&models.Directory{}).Error; err != nil {
+				return fmt.Errorf("failed to delete directories: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if releaseQuota {
+		if err := s.userService.UpdateStorageUsed(owner, -report.BytesFreed); err != nil {
+			s.logger.Error().Err(err).Str("user_id", owner).Msg("Failed to update storage used after delete")
+		}
+	}
+
+	// The records are already gone, so finish even if the client disconnects
+	releaseCtx := context.WithoutCancel(ctx)
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		batch := keys[start:min(start+deleteBatchSize, len(keys))]
+		if err := s.blobService.ReleaseObjects(releaseCtx, batch); err != nil {
+			s.logger.Error().
+				Err(err).
+				Str("user_id", owner).
+				Int("objects", len(batch)).
+				Msg("Failed to delete stored objects")
+			report.FailedObjects = append(report.FailedObjects, FailedKeys(err, batch)...)
+		}
+	}
+
+	return report, nil
+}
+
+// copyObject gives a copy its own reference to the source's object. Shared
+// blobs just gain a reference; other objects are copied to a new key.
+func (s *FileService) copyObject(ctx context.Context, userID string, source *models.File) (string, error) {
+	shared, err := s.blobService.AddReference(source.S3Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to reference blob: %w", err)
+	}
+	if shared {
+		return source.S3Key, nil
+	}
+
+	key := GenerateS3Key(userID, models.GenerateID(), source.Name)
+	if err := s.s3Service.CopyObject(ctx, source.S3Key, key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// releaseCopies drops the object references taken for a copy that failed
+func (s *FileService) releaseCopies(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := s.blobService.ReleaseObjects(context.WithoutCancel(ctx), keys); err != nil {
+		s.logger.Error().Err(err).Int("count", len(keys)).Msg("Failed to release copied objects")
+	}
+}
+
+// copyFileRecord builds the record for a copy of source. The copy shares
+// the source's wrapped key, since the object was copied as stored.
+func copyFileRecord(source *models.File, userID, name, path, parentID, key string) *models.File {
+	return &models.File{
+		Name:            name,
+		Path:            path,
+		User:            userID,
+		ParentDirectory: parentID,
+		Size:            source.Size,
+		MimeType:        source.MimeType,
+		S3Key:           key,
+		S3Bucket:        source.S3Bucket,
+		Checksum:        source.Checksum,
+		WrappedKey:      source.WrappedKey,
+	}
+}
+
+// in returns a copy of the service that runs its queries in tx
+func (s *FileService) in(tx *gorm.DB) *FileService {
+	scoped := *s
+	scoped.db = tx
+	return &scoped
+}
+
+// destinationPath returns the path of items placed in destDirID, which
+// must belong to userID
+func (s *FileService) destinationPath(userID, destDirID string) (string, error) {
+	if destDirID == "" {
+		return "/", nil
+	}
+
+	var dir models.Directory
+	if err := s.db.First(&dir, "id = ? AND user = ?", destDirID, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrInvalidDestination
+		}
+		return "", err
+	}
+	return dir.GetFullPath(), nil
+}
+
+// isWithin reports whether dirID is ancestorID or one of its descendants
+func (s *FileService) isWithin(dirID, ancestorID string) (bool, error) {
+	maxDepth := 100 // Prevent infinite loops
+	for i := 0; i < maxDepth && dirID != ""; i++ {
+		if dirID == ancestorID {
+			return true, nil
+		}
+
+		var dir models.Directory
+		if err := s.db.Select("parent_directory").First(&dir, "id = ?", dirID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		dirID = dir.ParentDirectory
+	}
+	return false, nil
+}
+
+// loadSubtree loads root and every directory and file below it, narrowed
+// by scopes. Directories are returned parents first.
+func (s *FileService) loadSubtree(root *models.Directory, scopes ...func(*gorm.DB) *gorm.DB) ([]*models.Directory, []*models.File, error) {
+	dirs := []*models.Directory{root}
+	var files []*models.File
+
+	level := []string{root.ID}
+	for len(level) > 0 {
+		var levelFiles []*models.File
+		if err := s.db.Scopes(scopes...).Where("parent_directory IN ?", level).Order("name ASC").Find(&levelFiles).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to load files: %w", err)
+		}
+		files = append(files, levelFiles...)
+
+		var children []*models.Directory
+		if err := s.db.Scopes(scopes...).Where("parent_directory IN ?", level).Order("name ASC").Find(&children).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to load directories: %w", err)
+		}
+		dirs = append(dirs, children...)
+
+		level = level[:0]
+		for _, child := range children {
+			level = append(level, child.ID)
+		}
+	}
+
+	return dirs, files, nil
+}
+
+// checkQuota fails with ErrQuotaExceeded if userID can't store size more bytes
+func (s *FileService) checkQuota(userID string, size int64) error {
+	user, err := s.userService.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.HasQuotaAvailable(size) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// resolveName applies the conflict policy to name in directory parentID
+func (s *FileService) resolveName(userID, parentID, name string, isDir bool, policy ConflictPolicy) (string, error) {
+	taken, err := s.nameTaken(userID, parentID, name)
+	if err != nil || !taken {
+		return name, err
+	}
+
+	if policy != ConflictRename {
+		return "", fmt.Errorf("%w: %s", ErrNameConflict, name)
+	}
+
+	base, ext := name, ""
+	if !isDir {
+		ext = filepath.Ext(name)
+		base = strings.TrimSuffix(name, ext)
+	}
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		taken, err := s.nameTaken(userID, parentID, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNameConflict, name)
+}
+
+// retryName runs save, which resolves a name under policy and writes the
+// item. The name checks only see committed rows, so a concurrent write can
+// take the name between the check and the write; the name indexes then
+// refuse the write (see models.MigrateNameIndexes). Unless policy is
+// ConflictFail, save is run again to resolve the name afresh; otherwise,
+// or once the retries run out, the write fails with ErrNameConflict.
+func retryName(policy ConflictPolicy, name string, save func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := save()
+		if !isNameConstraint(err) {
+			return err
+		}
+		if policy == ConflictFail || attempt == maxNameRetries {
+			return fmt.Errorf("%w: %s", ErrNameConflict, name)
+		}
+	}
+}
+
+// isNameConstraint reports whether err is a write refused by one of the
+// name indexes
+func isNameConstraint(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, models.FileNameIndex) || strings.Contains(msg, models.DirectoryNameIndex)
+}
+
+// liveDirectory returns userID's directory called name in directory
+// parentID, or nil if there is none
+func (s *FileService) liveDirectory(userID, parentID, name string) (*models.Directory, error) {
+	var dir models.Directory
+	query := s.db.Where("user = ? AND name = ?", userID, name)
+	if parentID != "" {
+		query = query.Where("parent_directory = ?", parentID)
+	} else {
+		query = query.Where("parent_directory IS NULL OR parent_directory = ''")
+	}
+	err := query.First(&dir).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dir, nil
+}
+
+// nameTaken reports whether a file or directory called name exists in
+// directory parentID
+func (s *FileService) nameTaken(userID, parentID, name string) (bool, error) {
+	return s.nameTakenExcept(userID, parentID, name, "")
+}
+
+// nameTakenExcept is nameTaken, ignoring the item with ID exceptID
+func (s *FileService) nameTakenExcept(userID, parentID, name, exceptID string) (bool, error) {
+	for _, model := range []interface{}{&models.File{}, &models.Directory{}} {
+		var count int64
+		query := s.db.Model(model).Where("user = ? AND name = ? AND id <> ?", userID, name, exceptID)
+		if parentID != "" {
+			query = query.Where("parent_directory = ?", parentID)
+		} else {
+			query = query.Where("parent_directory IS NULL OR parent_directory = ''")
+		}
+		if err := query.Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,500 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestFileService(t *testing.T) (*FileService, *LocalStorageService, *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Directory{}, &models.Blob{},
+		&models.Tag{}, &models.TagLink{}, &models.Metadata{}, &models.Activity{}))
+	require.NoError(t, models.MigrateNameIndexes(db))
+
+	storage := newTestLocalStorage(t)
+	userService := NewUserService(db, zerolog.Nop())
+	blobService := NewBlobService(db, storage, zerolog.Nop())
+	userService.SetBlobService(blobService)
+
+	cfg := &config.Config{TrashCountsTowardQuota: true}
+	return NewFileService(db, storage, blobService, userService, zerolog.Nop(), cfg), storage, db
+}
+
+// createTestTreeFile stores content and records it as a file in dir
+func createTestTreeFile(t *testing.T, storage *LocalStorageService, db *gorm.DB, userID string, dir *models.Directory, name, content string) *models.File {
+	t.Helper()
+
+	key := GenerateS3Key(userID, models.GenerateID(), name)
+	require.NoError(t, storage.UploadFile(context.Background(), key, strings.NewReader(content), int64(len(content)), "text/plain"))
+
+	file := &models.File{Name: name, Path: "/", User: userID, Size: int64(len(content)), S3Key: key, S3Bucket: "b"}
+	if dir != nil {
+		file.Path = dir.GetFullPath()
+		file.ParentDirectory = dir.ID
+	}
+	require.NoError(t, db.Create(file).Error)
+	return file
+}
+
+func createTestDirectory(t *testing.T, db *gorm.DB, userID string, parent *models.Directory, name string) *models.Directory {
+	t.Helper()
+
+	dir := &models.Directory{Name: name, Path: "/", User: userID}
+	if parent != nil {
+		dir.Path = parent.GetFullPath()
+		dir.ParentDirectory = parent.ID
+	}
+	require.NoError(t, db.Create(dir).Error)
+	return dir
+}
+
+func readObject(t *testing.T, storage S3Service, key string) string {
+	t.Helper()
+	reader, err := storage.DownloadFile(context.Background(), key)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictFail, policy)
+
+	policy, err = ParseConflictPolicy("rename")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictRename, policy)
+
+	_, err = ParseConflictPolicy("clobber")
+	assert.ErrorIs(t, err, ErrInvalidConflictPolicy)
+}
+
+func TestFileService_CopyFile(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("copy@example.com", "copyuser", "Password123!", false)
+	require.NoError(t, err)
+
+	docs := createTestDirectory(t, db, user.ID, nil, "docs")
+	source := createTestTreeFile(t, storage, db, user.ID, nil, "report.txt", "quarterly")
+
+	copied, err := service.CopyFile(ctx, user.ID, source.ID, docs.ID, ConflictFail)
+	require.NoError(t, err)
+	assert.Equal(t, "report.txt", copied.Name)
+	assert.Equal(t, "docs", copied.Path)
+	assert.Equal(t, docs.ID, copied.ParentDirectory)
+	assert.NotEqual(t, source.S3Key, copied.S3Key)
+	assert.Equal(t, "quarterly", readObject(t, storage, copied.S3Key))
+
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("quarterly")), refreshed.StorageUsed)
+
+	t.Run("Conflicts", func(t *testing.T) {
+		_, err := service.CopyFile(ctx, user.ID, source.ID, docs.ID, ConflictFail)
+		assert.ErrorIs(t, err, ErrNameConflict)
+
+		renamed, err := service.CopyFile(ctx, user.ID, source.ID, docs.ID, ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "report (1).txt", renamed.Name)
+
+		renamed, err = service.CopyFile(ctx, user.ID, source.ID, docs.ID, ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "report (2).txt", renamed.Name)
+
+		// Copying next to the source is a conflict too
+		renamed, err = service.CopyFile(ctx, user.ID, source.ID, "", ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "report (1).txt", renamed.Name)
+		assert.Equal(t, "/", renamed.Path)
+	})
+
+	t.Run("Another user's destination", func(t *testing.T) {
+		other, err := service.userService.CreateUser("other@example.com", "otheruser", "Password123!", false)
+		require.NoError(t, err)
+		otherDir := createTestDirectory(t, db, other.ID, nil, "theirs")
+
+		_, err = service.CopyFile(ctx, user.ID, source.ID, otherDir.ID, ConflictFail)
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", 40).Error)
+
+		_, err := service.CopyFile(ctx, user.ID, source.ID, docs.ID, ConflictRename)
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+	})
+}
+
+func TestFileService_CopyFile_SharedBlob(t *testing.T) {
+	service, _, db := newTestFileService(t)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("blob@example.com", "blobuser", "Password123!", false)
+	require.NoError(t, err)
+
+	blob, err := service.blobService.Store(ctx, strings.NewReader("deduplicated"), 12, "text/plain")
+	require.NoError(t, err)
+	source := &models.File{Name: "d.txt", Path: "/", User: user.ID, Size: 12, S3Key: blob.S3Key, S3Bucket: "b"}
+	require.NoError(t, db.Create(source).Error)
+
+	// Blob objects are shared, not copied
+	copied, err := service.CopyFile(ctx, user.ID, source.ID, "", ConflictRename)
+	require.NoError(t, err)
+	assert.Equal(t, blob.S3Key, copied.S3Key)
+
+	var updated models.Blob
+	require.NoError(t, db.First(&updated, "checksum = ?", blob.Checksum).Error)
+	assert.Equal(t, int64(2), updated.RefCount)
+}
+
+func TestFileService_CopyDirectory(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("tree@example.com", "treeuser", "Password123!", false)
+	require.NoError(t, err)
+
+	// projects/app/src/main.go, projects/readme.md, archive/
+	projects := createTestDirectory(t, db, user.ID, nil, "projects")
+	app := createTestDirectory(t, db, user.ID, projects, "app")
+	src := createTestDirectory(t, db, user.ID, app, "src")
+	archive := createTestDirectory(t, db, user.ID, nil, "archive")
+	createTestTreeFile(t, storage, db, user.ID, src, "main.go", "package main")
+	createTestTreeFile(t, storage, db, user.ID, projects, "readme.md", "# readme")
+
+	copied, err := service.CopyDirectory(ctx, user.ID, projects.ID, archive.ID, ConflictFail)
+	require.NoError(t, err)
+	assert.Equal(t, "projects", copied.Name)
+	assert.Equal(t, "archive", copied.Path)
+	assert.Equal(t, archive.ID, copied.ParentDirectory)
+
+	var appCopy, srcCopy models.Directory
+	require.NoError(t, db.First(&appCopy, "parent_directory = ?", copied.ID).Error)
+	assert.Equal(t, "app", appCopy.Name)
+	assert.Equal(t, "archive/projects", appCopy.Path)
+	require.NoError(t, db.First(&srcCopy, "parent_directory = ?", appCopy.ID).Error)
+	assert.Equal(t, "archive/projects/app", srcCopy.Path)
+
+	var mainCopy, readmeCopy models.File
+	require.NoError(t, db.First(&mainCopy, "parent_directory = ?", srcCopy.ID).Error)
+	assert.Equal(t, "archive/projects/app/src", mainCopy.Path)
+	assert.Equal(t, "package main", readObject(t, storage, mainCopy.S3Key))
+	require.NoError(t, db.First(&readmeCopy, "parent_directory = ?", copied.ID).Error)
+	assert.Equal(t, "# readme", readObject(t, storage, readmeCopy.S3Key))
+
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("package main")+len("# readme")), refreshed.StorageUsed)
+
+	t.Run("Into itself", func(t *testing.T) {
+		_, err := service.CopyDirectory(ctx, user.ID, projects.ID, projects.ID, ConflictRename)
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+
+		_, err = service.CopyDirectory(ctx, user.ID, projects.ID, src.ID, ConflictRename)
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+	})
+
+	t.Run("Conflicts", func(t *testing.T) {
+		_, err := service.CopyDirectory(ctx, user.ID, projects.ID, archive.ID, ConflictFail)
+		assert.ErrorIs(t, err, ErrNameConflict)
+
+		renamed, err := service.CopyDirectory(ctx, user.ID, app.ID, projects.ID, ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "app (1)", renamed.Name)
+		assert.Equal(t, "projects", renamed.Path)
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", 1).Error)
+
+		var before int64
+		db.Model(&models.Directory{}).Count(&before)
+
+		_, err := service.CopyDirectory(ctx, user.ID, projects.ID, "", ConflictRename)
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+		var after int64
+		db.Model(&models.Directory{}).Count(&after)
+		assert.Equal(t, before, after)
+	})
+}
+
+func TestFileService_MoveFile(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("move@example.com", "moveuser", "Password123!", false)
+	require.NoError(t, err)
+
+	docs := createTestDirectory(t, db, user.ID, nil, "docs")
+	file := createTestTreeFile(t, storage, db, user.ID, nil, "draft.txt", "text")
+	createTestTreeFile(t, storage, db, user.ID, docs, "taken.txt", "text")
+
+	name := "../final.txt"
+	moved, err := service.MoveFile(user.ID, file.ID, MoveOptions{Name: &name, ParentDirectory: &docs.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "final.txt", moved.Name)
+	assert.Equal(t, "docs", moved.Path)
+	assert.Equal(t, docs.ID, moved.ParentDirectory)
+	assert.Equal(t, file.S3Key, moved.S3Key)
+
+	var stored models.File
+	require.NoError(t, db.First(&stored, "id = ?", file.ID).Error)
+	assert.Equal(t, "final.txt", stored.Name)
+	assert.Equal(t, "docs", stored.Path)
+
+	// Renaming to its own name is not a conflict
+	_, err = service.MoveFile(user.ID, file.ID, MoveOptions{Name: &moved.Name})
+	assert.NoError(t, err)
+
+	taken := "taken.txt"
+	_, err = service.MoveFile(user.ID, file.ID, MoveOptions{Name: &taken})
+	assert.ErrorIs(t, err, ErrNameConflict)
+
+	empty := ""
+	_, err = service.MoveFile(user.ID, file.ID, MoveOptions{Name: &empty})
+	assert.ErrorIs(t, err, ErrInvalidName)
+
+	root := ""
+	moved, err = service.MoveFile(user.ID, file.ID, MoveOptions{ParentDirectory: &root})
+	require.NoError(t, err)
+	assert.Equal(t, "/", moved.Path)
+	assert.Empty(t, moved.ParentDirectory)
+}
+
+func TestFileService_MoveDirectory(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("mvdir@example.com", "mvdiruser", "Password123!", false)
+	require.NoError(t, err)
+
+	// projects/app/src/main.go, archive/
+	projects := createTestDirectory(t, db, user.ID, nil, "projects")
+	app := createTestDirectory(t, db, user.ID, projects, "app")
+	src := createTestDirectory(t, db, user.ID, app, "src")
+	archive := createTestDirectory(t, db, user.ID, nil, "archive")
+	mainFile := createTestTreeFile(t, storage, db, user.ID, src, "main.go", "package main")
+
+	t.Run("Cycles are blocked", func(t *testing.T) {
+		_, err := service.MoveDirectory(user.ID, projects.ID, MoveOptions{ParentDirectory: &projects.ID})
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+
+		_, err = service.MoveDirectory(user.ID, projects.ID, MoveOptions{ParentDirectory: &src.ID})
+		assert.ErrorIs(t, err, ErrInvalidDestination)
+	})
+
+	name := "old-projects"
+	moved, err := service.MoveDirectory(user.ID, projects.ID, MoveOptions{Name: &name, ParentDirectory: &archive.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "old-projects", moved.Name)
+	assert.Equal(t, "archive", moved.Path)
+
+	// Every descendant's stored path is rewritten
+	var storedApp, storedSrc models.Directory
+	require.NoError(t, db.First(&storedApp, "id = ?", app.ID).Error)
+	assert.Equal(t, "archive/old-projects", storedApp.Path)
+	require.NoError(t, db.First(&storedSrc, "id = ?", src.ID).Error)
+	assert.Equal(t, "archive/old-projects/app", storedSrc.Path)
+
+	var storedMain models.File
+	require.NoError(t, db.First(&storedMain, "id = ?", mainFile.ID).Error)
+	assert.Equal(t, "archive/old-projects/app/src", storedMain.Path)
+
+	// Moving a directory up to the root
+	root := ""
+	moved, err = service.MoveDirectory(user.ID, app.ID, MoveOptions{ParentDirectory: &root})
+	require.NoError(t, err)
+	assert.Equal(t, "/", moved.Path)
+	require.NoError(t, db.First(&storedMain, "id = ?", mainFile.ID).Error)
+	assert.Equal(t, "app/src", storedMain.Path)
+
+	t.Run("Conflicts", func(t *testing.T) {
+		taken := "archive"
+		_, err := service.MoveDirectory(user.ID, app.ID, MoveOptions{Name: &taken})
+		assert.ErrorIs(t, err, ErrNameConflict)
+	})
+}
+
+func TestFileService_NameIndexes(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("names@example.com", "namesuser", "Password123!", false)
+	require.NoError(t, err)
+
+	// race, when set, runs once just before the next insert to take a name
+	// after it has been checked. Inserts run without their own transaction,
+	// so a refused insert doesn't roll back the one that beat it.
+	service.db = db.Session(&gorm.Session{SkipDefaultTransaction: true})
+	var race func(tx *gorm.DB)
+	require.NoError(t, db.Callback().Create().Before("gorm:create").Register("test:name_race", func(tx *gorm.DB) {
+		if race != nil {
+			run := race
+			race = nil
+			run(tx.Session(&gorm.Session{NewDB: true}))
+		}
+	}))
+	takeDirectory := func(name string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			require.NoError(t, tx.Create(&models.Directory{Name: name, Path: "/", User: user.ID}).Error)
+		}
+	}
+
+	t.Run("Live names are unique", func(t *testing.T) {
+		docs := createTestDirectory(t, db, user.ID, nil, "Docs")
+		err := db.Create(&models.Directory{Name: "Docs", Path: "/", User: user.ID}).Error
+		assert.True(t, isNameConstraint(err), "Got %v", err)
+
+		_, err = service.TrashDirectory(user.ID, docs.ID)
+		require.NoError(t, err)
+		createTestDirectory(t, db, user.ID, nil, "Docs")
+
+		item, err := service.Restore(user.ID, docs.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Docs (1)", item.Name, "Restored items don't take a name back")
+	})
+
+	t.Run("A directory that lost a race is renamed", func(t *testing.T) {
+		race = takeDirectory("Reports")
+		dir, applied, err := service.CreateDirectory(user.ID, "", "Reports", ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "Reports (1)", dir.Name)
+		assert.Equal(t, ConflictRename, applied)
+	})
+
+	t.Run("Or fails with the fail policy", func(t *testing.T) {
+		race = takeDirectory("Plans")
+		_, _, err := service.CreateDirectory(user.ID, "", "Plans", ConflictFail)
+		assert.ErrorIs(t, err, ErrNameConflict)
+	})
+
+	t.Run("An upload that lost a race is renamed", func(t *testing.T) {
+		race = func(tx *gorm.DB) {
+			require.NoError(t, tx.Create(&models.File{Name: "notes.txt", Path: "/", User: user.ID, S3Key: "k1", S3Bucket: "b"}).Error)
+		}
+		file := &models.File{Name: "notes.txt", Path: "/", User: user.ID, S3Key: "k2", S3Bucket: "b"}
+		var result *UploadResult
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			result, err = service.SaveUpload(tx, file, ConflictRename)
+			return err
+		}))
+		assert.Equal(t, "notes (1).txt", file.Name)
+		assert.Equal(t, ConflictRename, result.Policy)
+	})
+
+	t.Run("Duplicates from before the indexes are renamed", func(t *testing.T) {
+		require.NoError(t, db.Exec("DROP INDEX "+models.FileNameIndex).Error)
+		require.NoError(t, db.Exec("DROP INDEX "+models.DirectoryNameIndex).Error)
+
+		older := time.Now().Add(-time.Hour)
+		first := &models.Directory{Name: "Photos", Path: "/", User: user.ID, CreatedAt: older}
+		require.NoError(t, db.Create(first).Error)
+		second := createTestDirectory(t, db, user.ID, nil, "Photos")
+		trip := createTestDirectory(t, db, user.ID, second, "trip")
+		photo := createTestTreeFile(t, storage, db, user.ID, trip, "beach.jpg", "jpeg")
+		keep := &models.File{Name: "todo.txt", Path: "/", User: user.ID, S3Key: "k3", S3Bucket: "b", CreatedAt: older}
+		require.NoError(t, db.Create(keep).Error)
+		dup := createTestTreeFile(t, storage, db, user.ID, nil, "todo.txt", "todo")
+
+		require.NoError(t, models.MigrateNameIndexes(db))
+
+		var storedFirst, storedSecond, storedTrip models.Directory
+		require.NoError(t, db.First(&storedFirst, "id = ?", first.ID).Error)
+		assert.Equal(t, "Photos", storedFirst.Name, "The oldest keeps its name")
+		require.NoError(t, db.First(&storedSecond, "id = ?", second.ID).Error)
+		assert.Equal(t, "Photos (1)", storedSecond.Name)
+		require.NoError(t, db.First(&storedTrip, "id = ?", trip.ID).Error)
+		assert.Equal(t, "Photos (1)", storedTrip.Path)
+
+		var storedPhoto, storedDup models.File
+		require.NoError(t, db.First(&storedPhoto, "id = ?", photo.ID).Error)
+		assert.Equal(t, "Photos (1)/trip", storedPhoto.Path, "Paths below a renamed directory follow it")
+		require.NoError(t, db.First(&storedDup, "id = ?", dup.ID).Error)
+		assert.Equal(t, "todo (1).txt", storedDup.Name)
+
+		err := db.Create(&models.File{Name: "todo.txt", Path: "/", User: user.ID, S3Key: "k4", S3Bucket: "b"}).Error
+		assert.True(t, isNameConstraint(err), "The indexes are in place")
+	})
+}
+
+// failingDeleteStorage is local storage whose batch deletes always fail
+type failingDeleteStorage struct {
+	*LocalStorageService
+}
+
+func (s *failingDeleteStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	return ErrDeleteFailed
+}
+
+// batchRecordingStorage is local storage that records the size of each
+// batch delete, failing those over the S3 limit
+type batchRecordingStorage struct {
+	*LocalStorageService
+	batches []int
+}
+
+func (s *batchRecordingStorage) DeleteFiles(ctx context.Context, keys []string) error {
+	s.batches = append(s.batches, len(keys))
+	if len(keys) > deleteBatchSize {
+		return ErrDeleteFailed
+	}
+	return nil
+}
+
+func TestUserService_DeleteUserReleasesInBatches(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+
+	recording := &batchRecordingStorage{LocalStorageService: storage}
+	service.userService.SetBlobService(NewBlobService(db, recording, zerolog.Nop()))
+
+	user, err := service.userService.CreateUser("many@example.com", "manyuser", "Password123!", false)
+	require.NoError(t, err)
+	files := make([]*models.File, deleteBatchSize+201)
+	for i := range files {
+		files[i] = &models.File{Name: fmt.Sprintf("f%d.txt", i), Path: "/", User: user.ID, S3Key: fmt.Sprintf("users/%s/f%d", user.ID, i), S3Bucket: "b"}
+	}
+	require.NoError(t, db.CreateInBatches(files, fileBatchSize).Error)
+
+	failed, err := service.userService.DeleteUser(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	assert.Equal(t, []int{deleteBatchSize, 201}, recording.batches)
+
+	t.Run("Failures are returned", func(t *testing.T) {
+		service.userService.SetBlobService(NewBlobService(db, &failingDeleteStorage{storage}, zerolog.Nop()))
+		other, err := service.userService.CreateUser("few@example.com", "fewuser", "Password123!", false)
+		require.NoError(t, err)
+		file := createTestTreeFile(t, storage, db, other.ID, nil, "kept.txt", "kept")
+
+		failed, err := service.userService.DeleteUser(other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{file.S3Key}, failed)
+	})
+}
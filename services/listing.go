@@ -0,0 +1,369 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// Directory listing limits
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Listing sort fields
+const (
+	SortByName    = "name"
+	SortBySize    = "size"
+	SortByCreated = "created"
+	SortByUpdated = "updated"
+	SortByType    = "type"
+)
+
+// ErrInvalidListOptions is returned for an unknown sort field, or a cursor
+// that wasn't returned by a listing with the same options
+var ErrInvalidListOptions = errors.New("invalid listing options")
+
+// ListOptions selects a page of a directory listing
+type ListOptions struct {
+	Sort       string // One of the SortBy fields, name if empty
+	Descending bool
+	Cursor     string   // NextCursor of the previous page, empty for the first page
+	Limit      int      // Items per page, DefaultListLimit if zero
+	MimePrefix string   // Only files whose MIME type starts with this, like "image/"
+	Extension  string   // Only files with this extension, with or without the dot
+	Tags       []string // Only items with every one of these tags, by name
+}
+
+// filtered reports whether the listing is limited to some files, in which
+// case directories aren't listed
+func (o ListOptions) filtered() bool {
+	return o.MimePrefix != "" || o.Extension != ""
+}
+
+// DirectoryListing is one page of a directory's contents. Directories come
+// before files, each in the requested order.
+type DirectoryListing struct {
+	Directories      []*models.Directory      `json:"directories"`
+	Files            []*models.File           `json:"files"`
+	Tags             map[string][]*models.Tag `json:"tags"` // Tags on the listed items, by item ID
+	TotalDirectories int64                    `json:"total_directories"`
+	TotalFiles       int64                    `json:"total_files"`
+	NextCursor       string                   `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// Cursor kinds, for the part of the listing a cursor is in
+const (
+	cursorDirectories = "d"
+	cursorFiles       = "f"
+)
+
+// listCursor is the position after the last item of a page: its kind and
+// its values for the sort columns. A files cursor without values is the
+// start of the files.
+type listCursor struct {
+	Kind   string   `json:"k"`
+	Values []string `json:"v,omitempty"`
+}
+
+// column types, for encoding cursor values
+const (
+	columnText = iota
+	columnInteger
+	columnTime
+)
+
+// sortColumn is a column a listing is ordered by
+type sortColumn struct {
+	name string
+	kind int
+}
+
+// ListDirectory returns a page of the contents of directory directoryID
+// (empty for the root directory) owned by userID. Pages are keyed on the
+// sort columns rather than offsets, so they stay consistent while items
+// are added or removed.
+func (s *FileService) ListDirectory(userID, directoryID string, opts ListOptions) (*DirectoryListing, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+	dirColumns, fileColumns, err := listColumns(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if opts.Cursor != "" {
+		if cursor, err = decodeListCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	listing := &DirectoryListing{Directories: []*models.Directory{}, Files: []*models.File{}}
+	remaining := opts.Limit
+
+	if !opts.filtered() {
+		dirs := s.directoryQuery(userID, directoryID, opts)
+		if err := dirs.Count(&listing.TotalDirectories).Error; err != nil {
+			return nil, err
+		}
+
+		if cursor.Kind != cursorFiles {
+			query, err := pageQuery(s.directoryQuery(userID, directoryID, opts), dirColumns, cursor.Values, opts.Descending)
+			if err != nil {
+				return nil, err
+			}
+			if err := query.Limit(remaining + 1).Find(&listing.Directories).Error; err != nil {
+				return nil, err
+			}
+			if len(listing.Directories) > remaining {
+				listing.Directories = listing.Directories[:remaining]
+				last := listing.Directories[remaining-1]
+				listing.NextCursor = encodeListCursor(cursorDirectories, dirColumns, directoryValues(last))
+			}
+			remaining -= len(listing.Directories)
+			cursor = listCursor{Kind: cursorFiles}
+		}
+	} else if cursor.Kind == cursorDirectories {
+		return nil, fmt.Errorf("%w: cursor doesn't match the filters", ErrInvalidListOptions)
+	}
+
+	files := s.fileQuery(userID, directoryID, opts)
+	if err := files.Count(&listing.TotalFiles).Error; err != nil {
+		return nil, err
+	}
+	if listing.NextCursor != "" {
+		return s.withTags(listing)
+	}
+
+	query, err := pageQuery(s.fileQuery(userID, directoryID, opts), fileColumns, cursor.Values, opts.Descending)
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		// The directories filled the page; only check whether files follow
+		var more []*models.File
+		if err := query.Limit(1).Find(&more).Error; err != nil {
+			return nil, err
+		}
+		if len(more) > 0 {
+			listing.NextCursor = encodeListCursor(cursorFiles, nil, nil)
+		}
+		return s.withTags(listing)
+	}
+	if err := query.Limit(remaining + 1).Find(&listing.Files).Error; err != nil {
+		return nil, err
+	}
+	if len(listing.Files) > remaining {
+		listing.Files = listing.Files[:remaining]
+		last := listing.Files[remaining-1]
+		listing.NextCursor = encodeListCursor(cursorFiles, fileColumns, fileValues(last))
+	}
+
+	return s.withTags(listing)
+}
+
+// withTags adds the tags on the listed items to a listing
+func (s *FileService) withTags(listing *DirectoryListing) (*DirectoryListing, error) {
+	dirIDs := make([]string, len(listing.Directories))
+	for i, dir := range listing.Directories {
+		dirIDs[i] = dir.ID
+	}
+	fileIDs := make([]string, len(listing.Files))
+	for i, file := range listing.Files {
+		fileIDs[i] = file.ID
+	}
+
+	dirTags, err := s.tagsOf(models.ResourceTypeDirectory, dirIDs)
+	if err != nil {
+		return nil, err
+	}
+	fileTags, err := s.tagsOf(models.ResourceTypeFile, fileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs are unique across files and directories
+	listing.Tags = dirTags
+	for id, tags := range fileTags {
+		listing.Tags[id] = tags
+	}
+	return listing, nil
+}
+
+// directoryQuery returns a query for the directories listed in directory
+// directoryID with opts
+func (s *FileService) directoryQuery(userID, directoryID string, opts ListOptions) *gorm.DB {
+	return s.tagFilter(s.childQuery(&models.Directory{}, userID, directoryID), models.ResourceTypeDirectory, userID, opts.Tags)
+}
+
+// fileQuery returns a query for the files listed in directory directoryID
+// with opts
+func (s *FileService) fileQuery(userID, directoryID string, opts ListOptions) *gorm.DB {
+	query := s.fileFilter(s.childQuery(&models.File{}, userID, directoryID), opts)
+	return s.tagFilter(query, models.ResourceTypeFile, userID, opts.Tags)
+}
+
+// childQuery returns a query for the records of model in directory
+// directoryID owned by userID
+func (s *FileService) childQuery(model interface{}, userID, directoryID string) *gorm.DB {
+	query := s.db.Model(model).Where("user = ?", userID)
+	if directoryID != "" {
+		return query.Where("parent_directory = ?", directoryID)
+	}
+	return query.Where("parent_directory IS NULL OR parent_directory = ''")
+}
+
+// fileFilter limits a file query to the MIME type and extension in opts
+func (s *FileService) fileFilter(query *gorm.DB, opts ListOptions) *gorm.DB {
+	if opts.MimePrefix != "" {
+		query = query.Where(`LOWER(mime_type) LIKE ? ESCAPE '\'`, escapeLike(strings.ToLower(opts.MimePrefix))+"%")
+	}
+	if opts.Extension != "" {
+		ext := "." + strings.TrimPrefix(strings.ToLower(opts.Extension), ".")
+		query = query.Where(`LOWER(name) LIKE ? ESCAPE '\'`, "%"+escapeLike(ext))
+	}
+	return query
+}
+
+// listColumns returns the columns directories and files are ordered by for
+// a sort field. Directories have no size or type, so those sort them by
+// name. Ties are broken by name, then ID, so every item has a unique
+// position for cursors.
+func listColumns(sort string) ([]sortColumn, []sortColumn, error) {
+	name := sortColumn{"name", columnText}
+	id := sortColumn{"id", columnText}
+
+	switch sort {
+	case "", SortByName:
+		return []sortColumn{name, id}, []sortColumn{name, id}, nil
+	case SortBySize:
+		return []sortColumn{name, id}, []sortColumn{{"size", columnInteger}, name, id}, nil
+	case SortByType:
+		return []sortColumn{name, id}, []sortColumn{{"mime_type", columnText}, name, id}, nil
+	case SortByCreated:
+		created := sortColumn{"created_at", columnTime}
+		return []sortColumn{created, name, id}, []sortColumn{created, name, id}, nil
+	case SortByUpdated:
+		updated := sortColumn{"updated_at", columnTime}
+		return []sortColumn{updated, name, id}, []sortColumn{updated, name, id}, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidListOptions, sort)
+	}
+}
+
+// pageQuery orders query by columns and, given the values of the last item
+// of the previous page, limits it to the items after it
+func pageQuery(query *gorm.DB, columns []sortColumn, after []string, descending bool) (*gorm.DB, error) {
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+	for _, column := range columns {
+		query = query.Order(column.name + " " + direction)
+	}
+	if len(after) == 0 {
+		return query, nil
+	}
+	if len(after) != len(columns) {
+		return nil, fmt.Errorf("%w: cursor doesn't match the sort field", ErrInvalidListOptions)
+	}
+
+	values := make([]interface{}, len(after))
+	for i, column := range columns {
+		value, err := column.parse(after[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+		}
+		values[i] = value
+	}
+
+	// (a > x) OR (a = x AND b > y) OR (a = x AND b = y AND c > z)
+	var clauses []string
+	var args []interface{}
+	for i, column := range columns {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, columns[j].name+" = ?")
+			args = append(args, values[j])
+		}
+		terms = append(terms, column.name+" "+comparison+" ?")
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(terms, " AND ")+")")
+	}
+	return query.Where(strings.Join(clauses, " OR "), args...), nil
+}
+
+// parse decodes a cursor value for the column
+func (c sortColumn) parse(value string) (interface{}, error) {
+	switch c.kind {
+	case columnInteger:
+		return strconv.ParseInt(value, 10, 64)
+	case columnTime:
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		return value, nil
+	}
+}
+
+// directoryValues returns a directory's values for every sortable column
+func directoryValues(dir *models.Directory) map[string]string {
+	return map[string]string{
+		"name":       dir.Name,
+		"id":         dir.ID,
+		"created_at": dir.CreatedAt.Format(time.RFC3339Nano),
+		"updated_at": dir.UpdatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// fileValues returns a file's values for every sortable column
+func fileValues(file *models.File) map[string]string {
+	return map[string]string{
+		"name":       file.Name,
+		"id":         file.ID,
+		"size":       strconv.FormatInt(file.Size, 10),
+		"mime_type":  file.MimeType,
+		"created_at": file.CreatedAt.Format(time.RFC3339Nano),
+		"updated_at": file.UpdatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// encodeListCursor encodes the position after an item with values
+func encodeListCursor(kind string, columns []sortColumn, values map[string]string) string {
+	cursor := listCursor{Kind: kind}
+	for _, column := range columns {
+		cursor.Values = append(cursor.Values, values[column.name])
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor decodes a cursor from encodeListCursor
+func decodeListCursor(encoded string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil || (cursor.Kind != cursorDirectories && cursor.Kind != cursorFiles) {
+		return listCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return cursor, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for use with
+// ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
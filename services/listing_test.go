@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService_ListDirectory(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("listing@example.com", "listinguser", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("listing2@example.com", "listinguser2", "Password123!", false)
+	require.NoError(t, err)
+
+	parent := createTestDirectory(t, db, user.ID, nil, "big")
+	for _, name := range []string{"zeta", "Alpha", "mid"} {
+		createTestDirectory(t, db, user.ID, parent, name)
+	}
+	files := map[string]string{
+		"report.PDF": "application/pdf",
+		"notes.txt":  "text/plain",
+		"a.png":      "image/png",
+		"b.jpg":      "image/jpeg",
+		"c.png":      "image/png",
+		"100%_done":  "application/octet-stream",
+		"same.txt":   "text/plain",
+	}
+	for name, mimeType := range files {
+		file := createTestTreeFile(t, storage, db, user.ID, parent, name, strings.Repeat("x", len(name)%4))
+		require.NoError(t, db.Model(file).Update("mime_type", mimeType).Error)
+	}
+	trashed := createTestTreeFile(t, storage, db, user.ID, parent, "trashed.txt", "x")
+	require.NoError(t, db.Delete(trashed).Error)
+	createTestTreeFile(t, storage, db, other.ID, parent, "not-mine.txt", "x")
+
+	names := func(listing *DirectoryListing) []string {
+		var result []string
+		for _, dir := range listing.Directories {
+			result = append(result, dir.Name+"/")
+		}
+		for _, file := range listing.Files {
+			result = append(result, file.Name)
+		}
+		return result
+	}
+
+	for _, sort := range []string{SortByName, SortBySize, SortByCreated, SortByUpdated, SortByType} {
+		for _, descending := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s descending=%v", sort, descending), func(t *testing.T) {
+				all, err := service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: sort, Descending: descending})
+				require.NoError(t, err)
+				require.Len(t, all.Directories, 3)
+				require.Len(t, all.Files, len(files))
+				assert.Empty(t, all.NextCursor)
+				assert.Equal(t, int64(3), all.TotalDirectories)
+				assert.Equal(t, int64(len(files)), all.TotalFiles)
+
+				var paged []string
+				opts := ListOptions{Sort: sort, Descending: descending, Limit: 3}
+				for pages := 0; ; pages++ {
+					require.Less(t, pages, 10, "Paging should end")
+					page, err := service.ListDirectory(user.ID, parent.ID, opts)
+					require.NoError(t, err)
+					assert.LessOrEqual(t, len(page.Directories)+len(page.Files), 3)
+					assert.Equal(t, int64(len(files)), page.TotalFiles)
+					paged = append(paged, names(page)...)
+					if page.NextCursor == "" {
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+				assert.Equal(t, names(all), paged)
+			})
+		}
+	}
+
+	t.Run("Orders files by the sort field", func(t *testing.T) {
+		listing, err := service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: SortByName})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Alpha/", "mid/", "zeta/", "100%_done", "a.png", "b.jpg", "c.png", "notes.txt", "report.PDF", "same.txt"}, names(listing))
+
+		listing, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: SortByType, Descending: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"zeta/", "mid/", "Alpha/", "same.txt", "notes.txt", "c.png", "a.png", "b.jpg", "report.PDF", "100%_done"}, names(listing))
+	})
+
+	t.Run("Filters files", func(t *testing.T) {
+		listing, err := service.ListDirectory(user.ID, parent.ID, ListOptions{MimePrefix: "image/"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.png", "b.jpg", "c.png"}, names(listing))
+		assert.Zero(t, listing.TotalDirectories)
+		assert.Equal(t, int64(3), listing.TotalFiles)
+
+		listing, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Extension: "pdf"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"report.PDF"}, names(listing))
+
+		listing, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Extension: ".png", MimePrefix: "image/", Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.png"}, names(listing))
+		listing, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Extension: ".png", MimePrefix: "image/", Limit: 1, Cursor: listing.NextCursor})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c.png"}, names(listing))
+		assert.Empty(t, listing.NextCursor)
+
+		// Wildcards are matched literally
+		listing, err = service.ListDirectory(user.ID, parent.ID, ListOptions{MimePrefix: "image_"})
+		require.NoError(t, err)
+		assert.Empty(t, listing.Files)
+	})
+
+	t.Run("Rejects bad options", func(t *testing.T) {
+		_, err := service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: "owner"})
+		assert.ErrorIs(t, err, ErrInvalidListOptions)
+
+		_, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, ErrInvalidListOptions)
+
+		page, err := service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: SortBySize, Limit: 5})
+		require.NoError(t, err)
+		_, err = service.ListDirectory(user.ID, parent.ID, ListOptions{Sort: SortByName, Cursor: page.NextCursor})
+		assert.ErrorIs(t, err, ErrInvalidListOptions)
+	})
+
+	t.Run("Root directory", func(t *testing.T) {
+		listing, err := service.ListDirectory(user.ID, "", ListOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"big/"}, names(listing))
+	})
+}
@@ -0,0 +1,787 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Local storage errors
+var (
+	ErrInvalidSignature = errors.New("invalid presigned URL signature")
+	ErrURLExpired       = errors.New("presigned URL has expired")
+)
+
+// LocalStoragePathPrefix is the URL path under which the application serves
+// presigned URLs generated by the local storage backend
+const LocalStoragePathPrefix = "/storage/"
+
+// LocalStorageService implements S3Service on top of a local directory.
+// Objects are stored under {root}/objects/{key}, with a small JSON sidecar
+// under {root}/meta/{key}.json holding the content type and ETag.
+// Presigned URLs are HMAC-signed and served by the application itself.
+type LocalStorageService struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+// localObjectMeta is the sidecar metadata stored next to each object
+type localObjectMeta struct {
+	ContentType string    `json:"content_type"`
+	ETag        string    `json:"etag"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// NewLocalStorageService creates a new local filesystem storage service
+func NewLocalStorageService(cfg *config.Config) (*LocalStorageService, error) {
+	if cfg.LocalStoragePath == "" {
+		return nil, fmt.Errorf("%w: local storage path is required", ErrInvalidConfig)
+	}
+
+	root, err := filepath.Abs(cfg.LocalStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	for _, dir := range []string{"objects", "meta", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+
+	// Prefer a dedicated signing secret, fall back to the JWT secret, and as
+	// a last resort use a random key (presigned URLs won't survive restarts)
+	secret := cfg.LocalStorageSecret
+	if secret == "" {
+		secret = cfg.JWTSecret
+	}
+	signingKey := []byte(secret)
+	if secret == "" {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		log.Warn().Msg("No LOCAL_STORAGE_SECRET or JWT_SECRET set, presigned URLs will not survive restarts")
+	}
+
+	log.Info().
+		Str("root", root).
+		Msg("Using local filesystem storage backend")
+
+	return &LocalStorageService{
+		root:       root,
+		baseURL:    strings.TrimSuffix(cfg.AppURL, "/"),
+		signingKey: signingKey,
+	}, nil
+}
+
+// UploadFile atomically stores a file with known size
+func (s *LocalStorageService) UploadFile(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	log.Debug().
+		Str("key", key).
+		Int64("size", size).
+		Str("content_type", contentType).
+		Msg("Writing file to local storage")
+
+	hasher := md5.New()
+	written, err := s.writeAtomic(objectPath, io.TeeReader(&contextReader{ctx: ctx, reader: reader}, hasher), size)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("key", key).
+			Msg("Failed to write file to local storage")
+		return fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+
+	meta := &localObjectMeta{
+		ContentType: contentType,
+		ETag:        hex.EncodeToString(hasher.Sum(nil)),
+		Size:        written,
+		UploadedAt:  time.Now().UTC(),
+	}
+	if err := s.writeMeta(key, meta); err != nil {
+		return fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+
+	log.Info().
+		Str("key", key).
+		Int64("size", written).
+		Msg("Successfully wrote file to local storage")
+
+	return nil
+}
+
+// UploadStream stores a file of unknown size
+func (s *LocalStorageService) UploadStream(ctx context.Context, key string, reader io.Reader) error {
+	return s.UploadFile(ctx, key, reader, -1, "application/octet-stream")
+}
+
+// DownloadFile opens a stored file for reading
+func (s *LocalStorageService) DownloadFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(objectPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Warn().
+				Str("key", key).
+				Msg("File not found in local storage")
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return f, nil
+}
+
+// DownloadRange opens a file and positions it at offset. A negative length
+// reads to the end of the file.
+func (s *LocalStorageService) DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid range offset: %d", offset)
+	}
+
+	reader, err := s.DownloadFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := reader.(*os.File)
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	if length < 0 {
+		return f, nil
+	}
+	return &rangeReadCloser{Reader: io.LimitReader(f, length), closer: f}, nil
+}
+
+// DeleteFile removes a single file. Deleting a missing key is not an error,
+// matching S3 semantics.
+func (s *LocalStorageService) DeleteFile(ctx context.Context, key string) error {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().
+			Err(err).
+			Str("key", key).
+			Msg("Failed to delete file from local storage")
+		return fmt.Errorf("%w: %v", ErrDeleteFailed, err)
+	}
+
+	metaPath := s.metaPath(key)
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrDeleteFailed, err)
+	}
+
+	s.pruneEmptyDirs(filepath.Dir(objectPath), filepath.Join(s.root, "objects"))
+	s.pruneEmptyDirs(filepath.Dir(metaPath), filepath.Join(s.root, "meta"))
+
+	log.Debug().
+		Str("key", key).
+		Msg("Deleted file from local storage")
+
+	return nil
+}
+
+// DeleteFiles removes multiple files
+func (s *LocalStorageService) DeleteFiles(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Validate all keys first
+	for _, key := range keys {
+		if _, err := s.objectPath(key); err != nil {
+			return err
+		}
+	}
+
+	var failed []string
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.DeleteFile(ctx, key); err != nil {
+			failed = append(failed, key)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &DeleteFilesError{
+			Keys: failed,
+			Err:  fmt.Errorf("%w: %d of %d keys could not be deleted", ErrDeleteFailed, len(failed), len(keys)),
+		}
+	}
+
+	log.Info().
+		Int("count", len(keys)).
+		Msg("Successfully batch deleted files from local storage")
+
+	return nil
+}
+
+// CopyObject copies a stored file and its metadata to a new key
+func (s *LocalStorageService) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	srcPath, err := s.objectPath(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := s.objectPath(dstKey)
+	if err != nil {
+		return err
+	}
+
+	meta, err := s.readMeta(srcKey)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, srcKey)
+		}
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, srcKey)
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	if _, err := s.writeAtomic(dstPath, &contextReader{ctx: ctx, reader: src}, meta.Size); err != nil {
+		log.Error().
+			Err(err).
+			Str("src_key", srcKey).
+			Str("dst_key", dstKey).
+			Msg("Failed to copy file in local storage")
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	meta.UploadedAt = time.Now().UTC()
+	if err := s.writeMeta(dstKey, meta); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	log.Debug().
+		Str("src_key", srcKey).
+		Str("dst_key", dstKey).
+		Msg("Copied file in local storage")
+
+	return nil
+}
+
+// GetPresignedURL generates a time-limited URL served by the application
+func (s *LocalStorageService) GetPresignedURL(ctx context.Context, key string, expirationMinutes int) (string, error) {
+	return s.GetPresignedDownloadURL(ctx, key, expirationMinutes, PresignOptions{})
+}
+
+// GetPresignedDownloadURL generates a time-limited URL served by the
+// application, with signed response header overrides
+func (s *LocalStorageService) GetPresignedDownloadURL(ctx context.Context, key string, expirationMinutes int, opts PresignOptions) (string, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return "", err
+	}
+
+	if expirationMinutes <= 0 {
+		expirationMinutes = 15
+	}
+	if expirationMinutes > 60 {
+		expirationMinutes = 60
+	}
+
+	expires := time.Now().Add(time.Duration(expirationMinutes) * time.Minute).Unix()
+	expiresStr := strconv.FormatInt(expires, 10)
+
+	query := url.Values{}
+	query.Set("expires", expiresStr)
+	if opts.ContentDisposition != "" {
+		query.Set("response-content-disposition", opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+	query.Set("signature", s.sign("GET", key, expiresStr, opts))
+
+	log.Debug().
+		Str("key", key).
+		Int("expiration_minutes", expirationMinutes).
+		Msg("Generated presigned URL")
+
+	return s.baseURL + LocalStoragePathPrefix + escapeKey(key) + "?" + query.Encode(), nil
+}
+
+// VerifyPresignedURL checks the signature and expiry of a presigned request
+// and returns the signed response header overrides
+func (s *LocalStorageService) VerifyPresignedURL(method, key string, query url.Values) (PresignOptions, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return PresignOptions{}, err
+	}
+
+	expiresStr := query.Get("expires")
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return PresignOptions{}, ErrInvalidSignature
+	}
+
+	opts := PresignOptions{
+		ContentDisposition: query.Get("response-content-disposition"),
+		ContentType:        query.Get("response-content-type"),
+	}
+
+	expected := s.sign(method, key, expiresStr, opts)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return PresignOptions{}, ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expires {
+		return PresignOptions{}, ErrURLExpired
+	}
+
+	return opts, nil
+}
+
+// OpenObject opens a stored file along with its metadata, for serving
+// presigned requests with http.ServeContent
+func (s *LocalStorageService) OpenObject(ctx context.Context, key string) (*os.File, *FileMetadata, error) {
+	metadata, err := s.GetFileMetadata(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objectPath, _ := s.objectPath(key)
+	f, err := os.Open(objectPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+		}
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return f, metadata, nil
+}
+
+// FileExists checks if a file exists
+func (s *LocalStorageService) FileExists(ctx context.Context, key string) (bool, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check file existence: %w", err)
+	}
+
+	return info.Mode().IsRegular(), nil
+}
+
+// GetFileMetadata retrieves metadata about a stored file
+func (s *LocalStorageService) GetFileMetadata(ctx context.Context, key string) (*FileMetadata, error) {
+	objectPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Warn().
+				Str("key", key).
+				Msg("File not found in local storage")
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to get file metadata: %w", err)
+	}
+
+	metadata := &FileMetadata{
+		Size:         info.Size(),
+		LastModified: info.ModTime().UTC(),
+	}
+
+	// The sidecar is best effort, fall back to guessing from the extension
+	if meta, err := s.readMeta(key); err == nil {
+		metadata.ContentType = meta.ContentType
+		metadata.ETag = meta.ETag
+	} else {
+		metadata.ContentType = mime.TypeByExtension(path.Ext(key))
+		if metadata.ContentType == "" {
+			metadata.ContentType = "application/octet-stream"
+		}
+	}
+
+	return metadata, nil
+}
+
+// ListObjects calls fn for every stored object whose key starts with
+// prefix, in lexical key order
+func (s *LocalStorageService) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	objectsDir := filepath.Join(s.root, "objects")
+
+	return filepath.WalkDir(objectsDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(objectsDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // deleted while walking
+			}
+			return err
+		}
+
+		obj := ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+		}
+		if meta, err := s.readMeta(key); err == nil {
+			obj.ETag = meta.ETag
+		}
+		return fn(obj)
+	})
+}
+
+// localMultipartUpload is the state file kept in each multipart upload directory
+type localMultipartUpload struct {
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+}
+
+// CreateMultipartUpload starts a multipart upload. Parts are staged under
+// {root}/tmp/multipart/{uploadID} until the upload is completed.
+func (s *LocalStorageService) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return "", err
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	uploadID := hex.EncodeToString(idBytes)
+
+	dir := s.multipartDir(uploadID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	data, err := json.Marshal(&localMultipartUpload{Key: key, ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0o640); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write upload state: %w", err)
+	}
+
+	log.Debug().
+		Str("key", key).
+		Str("upload_id", uploadID).
+		Msg("Initiated multipart upload")
+
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload and returns its ETag
+func (s *LocalStorageService) UploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	if _, err := s.readMultipartUpload(key, uploadID); err != nil {
+		return "", err
+	}
+	if partNumber < 1 || partNumber > 10000 {
+		return "", fmt.Errorf("invalid part number: %d", partNumber)
+	}
+
+	sum := md5.Sum(data)
+	etag := hex.EncodeToString(sum[:])
+
+	partPath := s.partPath(uploadID, partNumber)
+	if _, err := s.writeAtomic(partPath, bytes.NewReader(data), int64(len(data))); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+	if err := os.WriteFile(partPath+".etag", []byte(etag), 0o640); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUploadFailed, err)
+	}
+
+	return etag, nil
+}
+
+// CompleteMultipartUpload concatenates the listed parts into the final object
+func (s *LocalStorageService) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	upload, err := s.readMultipartUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no parts to complete")
+	}
+
+	sorted := make([]CompletedPart, len(parts))
+	copy(sorted, parts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PartNumber < sorted[j].PartNumber })
+
+	readers := make([]io.Reader, 0, len(sorted))
+	for _, part := range sorted {
+		partPath := s.partPath(uploadID, part.PartNumber)
+		etag, err := os.ReadFile(partPath + ".etag")
+		if err != nil || string(etag) != strings.Trim(part.ETag, `"`) {
+			return fmt.Errorf("%w: part %d is missing or its ETag does not match", ErrUploadFailed, part.PartNumber)
+		}
+
+		f, err := os.Open(partPath)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUploadFailed, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := s.UploadFile(ctx, key, io.MultiReader(readers...), -1, upload.ContentType); err != nil {
+		return err
+	}
+
+	os.RemoveAll(s.multipartDir(uploadID))
+
+	log.Info().
+		Str("key", key).
+		Int("parts", len(parts)).
+		Msg("Completed multipart upload")
+
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *LocalStorageService) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if _, err := s.readMultipartUpload(key, uploadID); err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.RemoveAll(s.multipartDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+
+	log.Warn().
+		Str("key", key).
+		Str("upload_id", uploadID).
+		Msg("Aborted multipart upload")
+
+	return nil
+}
+
+// Helper methods
+
+func (s *LocalStorageService) multipartDir(uploadID string) string {
+	return filepath.Join(s.root, "tmp", "multipart", uploadID)
+}
+
+func (s *LocalStorageService) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(s.multipartDir(uploadID), strconv.Itoa(partNumber))
+}
+
+// readMultipartUpload loads the state of an upload and checks it belongs to key
+func (s *LocalStorageService) readMultipartUpload(key, uploadID string) (*localMultipartUpload, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return nil, fmt.Errorf("%w: invalid upload ID", ErrInvalidKey)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.multipartDir(uploadID), "upload.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: upload %s", ErrFileNotFound, uploadID)
+		}
+		return nil, err
+	}
+
+	var upload localMultipartUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if upload.Key != key {
+		return nil, fmt.Errorf("%w: upload %s is for a different key", ErrInvalidKey, uploadID)
+	}
+
+	return &upload, nil
+}
+
+// objectPath validates a key and maps it to a path inside the objects directory
+func (s *LocalStorageService) objectPath(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	// Keys must already be in canonical form so that two different keys can
+	// never map to the same file, and so they can't escape the root
+	if strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") ||
+		path.Clean(key) != key || key == "." || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("%w: key must be a relative, normalized path", ErrInvalidKey)
+	}
+	if strings.Contains(key, "\\") {
+		return "", fmt.Errorf("%w: key contains backslash", ErrInvalidKey)
+	}
+
+	return filepath.Join(s.root, "objects", filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStorageService) metaPath(key string) string {
+	return filepath.Join(s.root, "meta", filepath.FromSlash(key)+".json")
+}
+
+// writeAtomic writes the reader to a temp file and renames it into place so
+// readers never observe a partially written object
+func (s *LocalStorageService) writeAtomic(dest string, reader io.Reader, size int64) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	written, err := io.Copy(tmp, reader)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if size >= 0 && written != size {
+		tmp.Close()
+		return 0, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmpName, dest); err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
+
+func (s *LocalStorageService) writeMeta(key string, meta *localObjectMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = s.writeAtomic(s.metaPath(key), bytes.NewReader(data), int64(len(data)))
+	return err
+}
+
+func (s *LocalStorageService) readMeta(key string) (*localObjectMeta, error) {
+	data, err := os.ReadFile(s.metaPath(key))
+	if err != nil {
+		return nil, err
+	}
+	var meta localObjectMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// pruneEmptyDirs removes empty parent directories up to (but excluding) stop
+func (s *LocalStorageService) pruneEmptyDirs(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop) {
+		if err := os.Remove(dir); err != nil {
+			return // not empty, or already gone
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// sign computes the HMAC signature for a presigned request
+func (s *LocalStorageService) sign(method, key, expires string, opts PresignOptions) string {
+	h := hmac.New(sha256.New, s.signingKey)
+	h.Write([]byte(method + "\n" + key + "\n" + expires + "\n" + opts.ContentDisposition + "\n" + opts.ContentType))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contextReader stops reading once its context is done, so an abandoned
+// upload isn't written to disk in full
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// escapeKey URL-escapes each segment of a key while keeping the slashes
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
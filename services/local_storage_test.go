@@ -0,0 +1,395 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLocalStorage(t *testing.T) *LocalStorageService {
+	t.Helper()
+	service, err := NewLocalStorageService(&config.Config{
+		LocalStoragePath:   t.TempDir(),
+		LocalStorageSecret: "test-secret",
+		AppURL:             "http://localhost:8090/",
+	})
+	require.NoError(t, err)
+	return service
+}
+
+func TestNewLocalStorageService(t *testing.T) {
+	t.Run("Creates directory layout", func(t *testing.T) {
+		root := filepath.Join(t.TempDir(), "data")
+		service, err := NewLocalStorageService(&config.Config{LocalStoragePath: root})
+		require.NoError(t, err)
+		assert.NotNil(t, service)
+
+		for _, dir := range []string{"objects", "meta", "tmp"} {
+			info, err := os.Stat(filepath.Join(root, dir))
+			require.NoError(t, err)
+			assert.True(t, info.IsDir())
+		}
+	})
+
+	t.Run("Missing path", func(t *testing.T) {
+		service, err := NewLocalStorageService(&config.Config{})
+		assert.Error(t, err)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.Nil(t, service)
+	})
+}
+
+func TestLocalStorageService_UploadAndDownload(t *testing.T) {
+	service := newTestLocalStorage(t)
+	data := []byte("local storage content")
+
+	err := service.UploadFile(context.Background(), "users/u1/file.txt", bytes.NewReader(data), int64(len(data)), "text/plain")
+	require.NoError(t, err)
+
+	reader, err := service.DownloadFile(context.Background(), "users/u1/file.txt")
+	require.NoError(t, err)
+	defer reader.Close()
+
+	result, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, data, result)
+
+	// No temp files should be left behind
+	entries, err := os.ReadDir(filepath.Join(service.root, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLocalStorageService_UploadFile_SizeMismatch(t *testing.T) {
+	service := newTestLocalStorage(t)
+
+	err := service.UploadFile(context.Background(), "users/u1/short.txt", strings.NewReader("abc"), 10, "text/plain")
+	assert.ErrorIs(t, err, ErrUploadFailed)
+
+	exists, err := service.FileExists(context.Background(), "users/u1/short.txt")
+	require.NoError(t, err)
+	assert.False(t, exists, "Partial uploads must not become visible")
+}
+
+func TestLocalStorageService_UploadStream(t *testing.T) {
+	service := newTestLocalStorage(t)
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	require.NoError(t, service.UploadStream(context.Background(), "stream/file.bin", bytes.NewReader(data)))
+
+	metadata, err := service.GetFileMetadata(context.Background(), "stream/file.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), metadata.Size)
+	assert.Equal(t, "application/octet-stream", metadata.ContentType)
+}
+
+func TestLocalStorageService_DownloadFile_NotFound(t *testing.T) {
+	service := newTestLocalStorage(t)
+
+	reader, err := service.DownloadFile(context.Background(), "missing/file.txt")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	assert.Nil(t, reader)
+}
+
+func TestLocalStorageService_DownloadRange(t *testing.T) {
+	storage := newTestLocalStorage(t)
+	require.NoError(t, storage.UploadFile(context.Background(), "range/file.txt", strings.NewReader("0123456789"), 10, "text/plain"))
+
+	reader, err := storage.DownloadRange(context.Background(), "range/file.txt", 3, 4)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "3456", string(data))
+
+	reader, err = storage.DownloadRange(context.Background(), "range/file.txt", 8, -1)
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "89", string(data))
+
+	_, err = storage.DownloadRange(context.Background(), "range/missing.txt", 0, 1)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestLocalStorageService_MultipartUpload(t *testing.T) {
+	storage := newTestLocalStorage(t)
+	var _ MultipartUploader = storage
+
+	uploadID, err := storage.CreateMultipartUpload(context.Background(), "multi/file.txt", "text/plain")
+	require.NoError(t, err)
+
+	// Parts can arrive in any order
+	etag2, err := storage.UploadPart(context.Background(), "multi/file.txt", uploadID, 2, []byte("world"))
+	require.NoError(t, err)
+	etag1, err := storage.UploadPart(context.Background(), "multi/file.txt", uploadID, 1, []byte("hello "))
+	require.NoError(t, err)
+
+	// Uploads are bound to their key
+	_, err = storage.UploadPart(context.Background(), "multi/other.txt", uploadID, 3, []byte("x"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// ETags must match the stored parts
+	err = storage.CompleteMultipartUpload(context.Background(), "multi/file.txt", uploadID, []CompletedPart{
+		{PartNumber: 1, ETag: etag2},
+		{PartNumber: 2, ETag: etag2},
+	})
+	assert.ErrorIs(t, err, ErrUploadFailed)
+
+	err = storage.CompleteMultipartUpload(context.Background(), "multi/file.txt", uploadID, []CompletedPart{
+		{PartNumber: 2, ETag: `"` + etag2 + `"`},
+		{PartNumber: 1, ETag: etag1},
+	})
+	require.NoError(t, err)
+
+	reader, err := storage.DownloadFile(context.Background(), "multi/file.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "hello world", string(data))
+
+	// Completed uploads are cleaned up
+	_, err = storage.UploadPart(context.Background(), "multi/file.txt", uploadID, 1, []byte("again"))
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestLocalStorageService_AbortMultipartUpload(t *testing.T) {
+	storage := newTestLocalStorage(t)
+
+	uploadID, err := storage.CreateMultipartUpload(context.Background(), "multi/aborted.txt", "text/plain")
+	require.NoError(t, err)
+	_, err = storage.UploadPart(context.Background(), "multi/aborted.txt", uploadID, 1, []byte("data"))
+	require.NoError(t, err)
+
+	require.NoError(t, storage.AbortMultipartUpload(context.Background(), "multi/aborted.txt", uploadID))
+	_, err = os.Stat(storage.multipartDir(uploadID))
+	assert.True(t, os.IsNotExist(err))
+
+	// Aborting twice is fine, invalid IDs are not
+	assert.NoError(t, storage.AbortMultipartUpload(context.Background(), "multi/aborted.txt", uploadID))
+	assert.ErrorIs(t, storage.AbortMultipartUpload(context.Background(), "multi/aborted.txt", "../../objects"), ErrInvalidKey)
+}
+
+func TestLocalStorageService_DeleteFile(t *testing.T) {
+	service := newTestLocalStorage(t)
+	require.NoError(t, service.UploadFile(context.Background(), "a/b/c.txt", strings.NewReader("abc"), 3, "text/plain"))
+
+	require.NoError(t, service.DeleteFile(context.Background(), "a/b/c.txt"))
+
+	exists, err := service.FileExists(context.Background(), "a/b/c.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Empty parent directories are pruned
+	_, err = os.Stat(filepath.Join(service.root, "objects", "a"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleting a missing key succeeds, like S3
+	assert.NoError(t, service.DeleteFile(context.Background(), "a/b/c.txt"))
+}
+
+func TestLocalStorageService_DeleteFiles(t *testing.T) {
+	service := newTestLocalStorage(t)
+	keys := []string{"batch/1.txt", "batch/2.txt", "batch/3.txt"}
+	for _, key := range keys {
+		require.NoError(t, service.UploadFile(context.Background(), key, strings.NewReader("data"), 4, "text/plain"))
+	}
+
+	require.NoError(t, service.DeleteFiles(context.Background(), keys))
+
+	for _, key := range keys {
+		exists, err := service.FileExists(context.Background(), key)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	assert.NoError(t, service.DeleteFiles(context.Background(), nil))
+	assert.ErrorIs(t, service.DeleteFiles(context.Background(), []string{"ok.txt", "../escape"}), ErrInvalidKey)
+}
+
+func TestLocalStorageService_CopyObject(t *testing.T) {
+	service := newTestLocalStorage(t)
+	ctx := context.Background()
+	require.NoError(t, service.UploadFile(ctx, "src/file.txt", strings.NewReader("hello"), 5, "text/plain"))
+
+	require.NoError(t, service.CopyObject(ctx, "src/file.txt", "dst/nested/copy.txt"))
+
+	reader, err := service.DownloadFile(ctx, "dst/nested/copy.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Metadata is copied too
+	metadata, err := service.GetFileMetadata(ctx, "dst/nested/copy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", metadata.ContentType)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", metadata.ETag)
+
+	assert.ErrorIs(t, service.CopyObject(ctx, "src/missing.txt", "dst/x.txt"), ErrFileNotFound)
+	assert.ErrorIs(t, service.CopyObject(ctx, "src/file.txt", "../escape"), ErrInvalidKey)
+}
+
+func TestLocalStorageService_GetFileMetadata(t *testing.T) {
+	service := newTestLocalStorage(t)
+	require.NoError(t, service.UploadFile(context.Background(), "meta/file.txt", strings.NewReader("hello"), 5, "text/plain"))
+
+	metadata, err := service.GetFileMetadata(context.Background(), "meta/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), metadata.Size)
+	assert.Equal(t, "text/plain", metadata.ContentType)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", metadata.ETag) // md5("hello")
+	assert.False(t, metadata.LastModified.IsZero())
+
+	_, err = service.GetFileMetadata(context.Background(), "meta/missing.txt")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestLocalStorageService_ListObjects(t *testing.T) {
+	service := newTestLocalStorage(t)
+	for _, key := range []string{"users/u2/b.txt", "users/u1/a.txt", "blobs/x"} {
+		require.NoError(t, service.UploadFile(context.Background(), key, strings.NewReader("data"), 4, "text/plain"))
+	}
+
+	// Staged multipart parts are not objects
+	uploadID, err := service.CreateMultipartUpload(context.Background(), "users/u3/big.bin", "")
+	require.NoError(t, err)
+	_, err = service.UploadPart(context.Background(), "users/u3/big.bin", uploadID, 1, []byte("part"))
+	require.NoError(t, err)
+
+	var listed []ObjectInfo
+	err = service.ListObjects(context.Background(), "", func(obj ObjectInfo) error {
+		listed = append(listed, obj)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, listed, 3)
+	assert.Equal(t, "blobs/x", listed[0].Key)
+	assert.Equal(t, "users/u1/a.txt", listed[1].Key)
+	assert.Equal(t, "users/u2/b.txt", listed[2].Key)
+	assert.Equal(t, int64(4), listed[1].Size)
+	assert.Equal(t, "8d777f385d3dfec8815d20f7496026dc", listed[1].ETag) // md5("data")
+	assert.False(t, listed[1].LastModified.IsZero())
+
+	var prefixed []string
+	err = service.ListObjects(context.Background(), "users/", func(obj ObjectInfo) error {
+		prefixed = append(prefixed, obj.Key)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users/u1/a.txt", "users/u2/b.txt"}, prefixed)
+}
+
+func TestLocalStorageService_InvalidKeys(t *testing.T) {
+	service := newTestLocalStorage(t)
+
+	invalidKeys := []string{
+		"",
+		"../outside.txt",
+		"a/../../outside.txt",
+		"/absolute.txt",
+		"trailing/",
+		"double//slash",
+		"dot/./segment",
+		"back\\slash",
+		"null\x00byte",
+		strings.Repeat("a", 1025),
+	}
+
+	for _, key := range invalidKeys {
+		t.Run(strconv.Quote(key), func(t *testing.T) {
+			err := service.UploadFile(context.Background(), key, strings.NewReader("x"), 1, "text/plain")
+			assert.ErrorIs(t, err, ErrInvalidKey)
+		})
+	}
+}
+
+func TestLocalStorageService_PresignedURL(t *testing.T) {
+	service := newTestLocalStorage(t)
+	require.NoError(t, service.UploadFile(context.Background(), "users/u1/my file.txt", strings.NewReader("x"), 1, "text/plain"))
+
+	signedURL, err := service.GetPresignedURL(context.Background(), "users/u1/my file.txt", 15)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signedURL)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:8090", parsed.Host)
+	assert.Equal(t, "/storage/users/u1/my file.txt", parsed.Path)
+
+	key := strings.TrimPrefix(parsed.Path, LocalStoragePathPrefix)
+
+	t.Run("Valid signature", func(t *testing.T) {
+		_, err := service.VerifyPresignedURL("GET", key, parsed.Query())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Wrong method", func(t *testing.T) {
+		_, err := service.VerifyPresignedURL("PUT", key, parsed.Query())
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("Different key", func(t *testing.T) {
+		_, err := service.VerifyPresignedURL("GET", "users/u2/my file.txt", parsed.Query())
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("Tampered expiry", func(t *testing.T) {
+		query := parsed.Query()
+		query.Set("expires", strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10))
+		_, err := service.VerifyPresignedURL("GET", key, query)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expires := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+		query := url.Values{}
+		query.Set("expires", expires)
+		query.Set("signature", service.sign("GET", key, expires, PresignOptions{}))
+		_, err := service.VerifyPresignedURL("GET", key, query)
+		assert.ErrorIs(t, err, ErrURLExpired)
+	})
+}
+
+func TestLocalStorageService_PresignedDownloadURL(t *testing.T) {
+	service := newTestLocalStorage(t)
+	require.NoError(t, service.UploadFile(context.Background(), "users/u1/report.pdf", strings.NewReader("x"), 1, "application/octet-stream"))
+
+	opts := PresignOptions{
+		ContentDisposition: `attachment; filename="report.pdf"`,
+		ContentType:        "application/pdf",
+	}
+	signedURL, err := service.GetPresignedDownloadURL(context.Background(), "users/u1/report.pdf", 5, opts)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signedURL)
+	require.NoError(t, err)
+
+	overrides, err := service.VerifyPresignedURL("GET", "users/u1/report.pdf", parsed.Query())
+	require.NoError(t, err)
+	assert.Equal(t, opts, overrides)
+
+	// Overrides are covered by the signature
+	query := parsed.Query()
+	query.Set("response-content-type", "text/html")
+	_, err = service.VerifyPresignedURL("GET", "users/u1/report.pdf", query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestLocalStorageService_ImplementsS3Service(t *testing.T) {
+	var _ S3Service = (*LocalStorageService)(nil)
+	var _ ObjectLister = (*LocalStorageService)(nil)
+}
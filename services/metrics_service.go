@@ -29,6 +29,9 @@ type MetricsService struct {
 	shareAccessTotal   uint64
 	authAttemptsTotal  map[string]*uint64 // key: success/failure
 
+	// Integrity metrics
+	checksumMismatchTotal uint64
+
 	// System metrics
 	startTime time.Time
 }
@@ -100,6 +103,11 @@ func (m *MetricsService) RecordShareAccess() {
 	atomic.AddUint64(&m.shareAccessTotal, 1)
 }
 
+// RecordChecksumMismatch records a download whose content did not match the stored checksum.
+func (m *MetricsService) RecordChecksumMismatch() {
+	atomic.AddUint64(&m.checksumMismatchTotal, 1)
+}
+
 // RecordAuthAttempt records an authentication attempt.
 func (m *MetricsService) RecordAuthAttempt(success bool) {
 	if success {
@@ -205,6 +213,11 @@ func (m *MetricsService) GetMetrics() string {
 	sb.WriteString("# TYPE filesonthego_share_access_total counter\n")
 	sb.WriteString(fmt.Sprintf("filesonthego_share_access_total %d\n\n", atomic.LoadUint64(&m.shareAccessTotal)))
 
+	// Write integrity metrics
+	sb.WriteString("# HELP filesonthego_checksum_mismatch_total Total number of downloads that failed checksum verification\n")
+	sb.WriteString("# TYPE filesonthego_checksum_mismatch_total counter\n")
+	sb.WriteString(fmt.Sprintf("filesonthego_checksum_mismatch_total %d\n\n", atomic.LoadUint64(&m.checksumMismatchTotal)))
+
 	// Write auth metrics
 	sb.WriteString("# HELP filesonthego_auth_attempts_total Total number of authentication attempts\n")
 	sb.WriteString("# TYPE filesonthego_auth_attempts_total counter\n")
@@ -103,6 +103,18 @@ func TestMetricsService_RecordShareAccess(t *testing.T) {
 	assert.Contains(t, metrics, "filesonthego_share_access_total 3")
 }
 
+func TestMetricsService_RecordChecksumMismatch(t *testing.T) {
+	m := NewMetricsService()
+
+	assert.Contains(t, m.GetMetrics(), "filesonthego_checksum_mismatch_total 0")
+
+	m.RecordChecksumMismatch()
+
+	metrics := m.GetMetrics()
+
+	assert.Contains(t, metrics, "filesonthego_checksum_mismatch_total 1")
+}
+
 func TestMetricsService_RecordAuthAttempt(t *testing.T) {
 	m := NewMetricsService()
 
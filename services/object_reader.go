@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ObjectReadSeeker exposes a stored object as an io.ReadSeeker, so it can be
+// served with http.ServeContent. Seeking is free; each read after a seek
+// opens a new ranged download starting at the current offset.
+type ObjectReadSeeker struct {
+	ctx       context.Context
+	s3Service S3Service
+	key       string
+	size      int64
+	offset    int64
+	body      io.ReadCloser
+	bytesRead int64
+}
+
+// NewObjectReadSeeker creates a read seeker over the object stored at key.
+// Downloads are made with ctx, so cancelling it stops further reads.
+func NewObjectReadSeeker(ctx context.Context, s3Service S3Service, key string, size int64) *ObjectReadSeeker {
+	return &ObjectReadSeeker{
+		ctx:       ctx,
+		s3Service: s3Service,
+		key:       key,
+		size:      size,
+	}
+}
+
+// Read implements io.Reader
+func (r *ObjectReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		var (
+			body io.ReadCloser
+			err  error
+		)
+		if r.offset == 0 {
+			body, err = r.s3Service.DownloadFile(r.ctx, r.key)
+		} else {
+			body, err = r.s3Service.DownloadRange(r.ctx, r.key, r.offset, -1)
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.bytesRead += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker. It doesn't touch storage; the next Read opens
+// a download at the new offset.
+func (r *ObjectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("negative position: %d", target)
+	}
+
+	if target != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = target
+	return target, nil
+}
+
+// BytesRead returns the total number of bytes read from storage
+func (r *ObjectReadSeeker) BytesRead() int64 {
+	return r.bytesRead
+}
+
+// Close closes any open download
+func (r *ObjectReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
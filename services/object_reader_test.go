@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectReadSeeker(t *testing.T) {
+	storage := newTestLocalStorage(t)
+	require.NoError(t, storage.UploadFile(context.Background(), "objects/file.txt", strings.NewReader("0123456789"), 10, "text/plain"))
+
+	t.Run("Reads whole object", func(t *testing.T) {
+		reader := NewObjectReadSeeker(context.Background(), storage, "objects/file.txt", 10)
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(data))
+		assert.Equal(t, int64(10), reader.BytesRead())
+	})
+
+	t.Run("Seeks without reading", func(t *testing.T) {
+		reader := NewObjectReadSeeker(context.Background(), storage, "objects/file.txt", 10)
+		defer reader.Close()
+
+		size, err := reader.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), size)
+		assert.Equal(t, int64(0), reader.BytesRead())
+
+		pos, err := reader.Seek(-4, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), pos)
+
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "6789", string(data))
+	})
+
+	t.Run("Seek after partial read", func(t *testing.T) {
+		reader := NewObjectReadSeeker(context.Background(), storage, "objects/file.txt", 10)
+		defer reader.Close()
+
+		buf := make([]byte, 2)
+		_, err := io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "01", string(buf))
+
+		_, err = reader.Seek(5, io.SeekStart)
+		require.NoError(t, err)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "56", string(buf))
+	})
+
+	t.Run("Negative position", func(t *testing.T) {
+		reader := NewObjectReadSeeker(context.Background(), storage, "objects/file.txt", 10)
+		_, err := reader.Seek(-1, io.SeekStart)
+		assert.Error(t, err)
+	})
+
+	t.Run("Truncated object", func(t *testing.T) {
+		reader := NewObjectReadSeeker(context.Background(), storage, "objects/file.txt", 20)
+		defer reader.Close()
+
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// maxPDFStream bounds the size of one decompressed PDF stream
+const maxPDFStream = 16 * 1024 * 1024
+
+// extractPDFText returns the text shown by the content streams of a PDF.
+// It is a best effort for search: it reads uncompressed and FlateDecode
+// streams, and the strings given to the text showing operators (Tj, TJ, '
+// and "). Text in fonts with custom encodings comes out garbled and is
+// dropped, as is anything in scanned images.
+func extractPDFText(data []byte) string {
+	var out strings.Builder
+
+	for offset := 0; ; {
+		start := bytes.Index(data[offset:], []byte("stream"))
+		if start < 0 {
+			break
+		}
+		start += offset
+		offset = start + len("stream")
+
+		// "endstream" also contains "stream"
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+
+		body := offset
+		if body < len(data) && data[body] == '\r' {
+			body++
+		}
+		if body < len(data) && data[body] == '\n' {
+			body++
+		}
+		end := bytes.Index(data[body:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		end += body
+		offset = end + len("endstream")
+
+		dict := streamDictionary(data[:start])
+		if !isContentStream(dict) {
+			continue
+		}
+
+		stream := data[body:end]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still give what was inflated before the error
+			stream, _ = io.ReadAll(io.LimitReader(reader, maxPDFStream))
+			reader.Close()
+		}
+
+		if text := contentStreamText(stream); text != "" {
+			out.WriteString(text)
+			out.WriteByte('\n')
+		}
+	}
+
+	return out.String()
+}
+
+// streamDictionary returns the dictionary of the stream whose "stream"
+// keyword ends before, back to the start of its object
+func streamDictionary(before []byte) []byte {
+	from := len(before) - 4096
+	if from < 0 {
+		from = 0
+	}
+	window := before[from:]
+	if i := bytes.LastIndex(window, []byte(" obj")); i >= 0 {
+		return window[i:]
+	}
+	return window
+}
+
+// isContentStream reports whether a stream could hold page content, rather
+// than an image, font or other binary data in a format we can't read
+func isContentStream(dict []byte) bool {
+	for _, skip := range []string{"/Image", "/FontFile", "/Length1", "/Length2", "/Length3", "/DCTDecode", "/JPXDecode", "/CCITTFaxDecode", "/JBIG2Decode", "/XRef", "/ObjStm", "/Metadata"} {
+		if bytes.Contains(dict, []byte(skip)) {
+			return false
+		}
+	}
+	// Filters other than Flate, like LZW or ASCII85, aren't supported
+	if bytes.Contains(dict, []byte("/Filter")) && !bytes.Contains(dict, []byte("/FlateDecode")) {
+		return false
+	}
+	return bytes.Contains(dict, []byte("/FlateDecode")) || !bytes.Contains(dict, []byte("/Filter"))
+}
+
+// contentStreamText returns the strings shown by the text operators in a
+// content stream, with line breaks where the text moves to a new line
+func contentStreamText(stream []byte) string {
+	var out strings.Builder
+	var operands []string // Strings since the last operator
+	inArray := false
+
+	for i := 0; i < len(stream); {
+		c := stream[i]
+		switch {
+		case c == '(':
+			s, next := pdfLiteralString(stream, i)
+			operands = append(operands, s)
+			i = next
+		case c == '<' && i+1 < len(stream) && stream[i+1] != '<':
+			s, next := pdfHexString(stream, i)
+			operands = append(operands, s)
+			i = next
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '-' && inArray:
+			// A large negative kerning in a TJ array is a word space
+			j := i + 1
+			for j < len(stream) && (stream[j] >= '0' && stream[j] <= '9' || stream[j] == '.') {
+				j++
+			}
+			if j-i > 3 {
+				operands = append(operands, " ")
+			}
+			i = j
+		case c == '%':
+			for i < len(stream) && stream[i] != '\n' && stream[i] != '\r' {
+				i++
+			}
+		case isPDFRegular(c):
+			j := i
+			for j < len(stream) && isPDFRegular(stream[j]) {
+				j++
+			}
+			switch string(stream[i:j]) {
+			case "Tj", "TJ":
+				out.WriteString(printableText(strings.Join(operands, "")))
+			case "'", "\"":
+				out.WriteByte('\n')
+				out.WriteString(printableText(strings.Join(operands, "")))
+			case "Td", "TD", "T*", "ET":
+				if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+					out.WriteByte('\n')
+				}
+			}
+			if !inArray {
+				operands = operands[:0]
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+
+	return strings.TrimSpace(out.String())
+}
+
+// isPDFRegular reports whether c is part of a PDF name, number or operator
+func isPDFRegular(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+// pdfLiteralString decodes the literal string starting with the "(" at
+// start, returning it and the offset after its closing ")"
+func pdfLiteralString(data []byte, start int) (string, int) {
+	var out []byte
+	depth := 0
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				out = append(out, c)
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(out), i + 1
+			}
+			out = append(out, c)
+		case '\\':
+			i++
+			if i >= len(data) {
+				return string(out), i
+			}
+			switch e := data[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for n := 0; n < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; n++ {
+						value = value*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(value))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out), len(data)
+}
+
+// pdfHexString decodes the hex string starting with the "<" at start,
+// returning it and the offset after its closing ">"
+func pdfHexString(data []byte, start int) (string, int) {
+	var out []byte
+	var digit byte
+	half := false
+	i := start + 1
+	for ; i < len(data) && data[i] != '>'; i++ {
+		var v byte
+		switch c := data[i]; {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if half {
+			out = append(out, digit<<4|v)
+		} else {
+			digit = v
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, digit<<4)
+	}
+	return string(out), i + 1
+}
+
+// printableText decodes PDF string bytes as Latin-1, which covers the
+// standard encodings for plain text, and drops strings that are mostly
+// unprintable (glyph IDs of embedded fonts)
+func printableText(s string) string {
+	if s == "" {
+		return ""
+	}
+	runes := make([]rune, 0, len(s))
+	printable := 0
+	for i := 0; i < len(s); i++ {
+		r := rune(s[i])
+		if unicode.IsPrint(r) || r == '\n' || r == '\t' {
+			printable++
+		} else {
+			r = ' '
+		}
+		runes = append(runes, r)
+	}
+	if printable*10 < len(s)*8 {
+		return ""
+	}
+	return string(runes)
+}
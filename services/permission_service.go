@@ -200,7 +200,8 @@ func (s *PermissionService) CanUploadFile(userID, directoryID, shareToken string
 			return false, nil
 		}
 
-		if sharePerms.ResourceType == string(models.ResourceTypeDirectory) && sharePerms.ResourceID == directoryID {
+		// Uploads can go into folders created below the shared directory
+		if sharePerms.ResourceType == string(models.ResourceTypeDirectory) && s.isDirectoryInDirectory(directoryID, sharePerms.ResourceID, 0) {
 			return s.canPerformAction(sharePerms.PermissionType, "upload"), nil
 		}
 	}
@@ -254,7 +255,8 @@ func (s *PermissionService) CanReadDirectory(userID, directoryID, shareToken str
 			return false, nil
 		}
 
-		if sharePerms.ResourceType == string(models.ResourceTypeDirectory) && sharePerms.ResourceID == directoryID {
+		// A directory share covers its subdirectories too
+		if sharePerms.ResourceType == string(models.ResourceTypeDirectory) && s.isDirectoryInDirectory(directoryID, sharePerms.ResourceID, 0) {
 			return s.canPerformAction(sharePerms.PermissionType, "view"), nil
 		}
 	}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// ErrListingUnsupported is returned when the storage backend can't list objects
+var ErrListingUnsupported = errors.New("storage backend does not support listing objects")
+
+const (
+	// orphanGracePeriod keeps reconciliation away from objects that were
+	// just written and whose records may not be committed yet
+	orphanGracePeriod = time.Hour
+	// reconcileBatchSize bounds record batches and storage batch deletes
+	// (S3 accepts at most 1000 keys per delete request)
+	reconcileBatchSize = 1000
+)
+
+// reconcilePrefixes are the parts of the bucket the app writes to: users'
+// files (see GenerateS3Key) and shared blobs (see models.BlobKey). Anything
+// else in the bucket isn't the app's to judge, let alone delete.
+var reconcilePrefixes = []string{"users/", "blobs/"}
+
+// OrphanedObject is a stored object that no record refers to
+type OrphanedObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// MissingObject is a file record whose stored object is gone
+type MissingObject struct {
+	FileID string `json:"file_id"`
+	UserID string `json:"user_id"`
+	Key    string `json:"s3_key"`
+	Size   int64  `json:"size"`
+}
+
+// ReconcileReport summarises a reconciliation run
+type ReconcileReport struct {
+	DryRun          bool             `json:"dry_run"`
+	StartedAt       time.Time        `json:"started_at"`
+	FinishedAt      time.Time        `json:"finished_at"`
+	ObjectsScanned  int              `json:"objects_scanned"`
+	RecordsScanned  int              `json:"records_scanned"`
+	OrphanedObjects []OrphanedObject `json:"orphaned_objects"`
+	OrphanedBytes   int64            `json:"orphaned_bytes"`
+	MissingObjects  []MissingObject  `json:"missing_objects"`
+	ObjectsDeleted  int              `json:"objects_deleted"`
+	RecordsRemoved  int              `json:"records_removed"`
+	Errors          []string         `json:"errors,omitempty"`
+}
+
+// ReconcileService compares the objects in storage with the records that
+// refer to them, reporting (and optionally fixing) the mismatches: objects
+// nothing refers to, and file records whose object is gone.
+type ReconcileService struct {
+	db          *gorm.DB
+	s3Service   S3Service
+	userService *UserService
+	fileService *FileService
+	logger      zerolog.Logger
+}
+
+// NewReconcileService creates a new reconcile service
+func NewReconcileService(db *gorm.DB, s3Service S3Service, userService *UserService, fileService *FileService, logger zerolog.Logger) *ReconcileService {
+	return &ReconcileService{
+		db:          db,
+		s3Service:   s3Service,
+		userService: userService,
+		fileService: fileService,
+		logger:      logger,
+	}
+}
+
+// Reconcile scans storage and the database. In dry-run mode (apply false)
+// it only reports; otherwise orphaned objects are deleted and file records
+// without an object are removed, crediting their size back to the owner.
+func (s *ReconcileService) Reconcile(ctx context.Context, apply bool) (*ReconcileReport, error) {
+	lister, ok := s.s3Service.(ObjectLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	report := &ReconcileReport{
+		DryRun:          !apply,
+		StartedAt:       time.Now().UTC(),
+		OrphanedObjects: []OrphanedObject{},
+		MissingObjects:  []MissingObject{},
+	}
+
+	// List storage first and load the known keys afterwards, so a record
+	// committed while listing still counts as known
+	stored := make(map[string]ObjectInfo)
+	for _, prefix := range reconcilePrefixes {
+		err := lister.ListObjects(ctx, prefix, func(obj ObjectInfo) error {
+			stored[obj.Key] = obj
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stored objects under %s: %w", prefix, err)
+		}
+	}
+	report.ObjectsScanned = len(stored)
+
+	known, err := s.knownKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known keys: %w", err)
+	}
+
+	cutoff := report.StartedAt.Add(-orphanGracePeriod)
+	for key, obj := range stored {
+		if known[key] || obj.LastModified.After(cutoff) {
+			continue
+		}
+		report.OrphanedObjects = append(report.OrphanedObjects, OrphanedObject{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+		report.OrphanedBytes += obj.Size
+	}
+	sort.Slice(report.OrphanedObjects, func(i, j int) bool {
+		return report.OrphanedObjects[i].Key < report.OrphanedObjects[j].Key
+	})
+
+	// Only records that existed before the listing started can be judged,
+	// newer ones may have been written after their key was listed
+	var files []models.File
+	err = s.db.Where("created_at < ?", report.StartedAt).
+		FindInBatches(&files, reconcileBatchSize, func(tx *gorm.DB, batch int) error {
+			report.RecordsScanned += len(files)
+			for _, file := range files {
+				if _, ok := stored[file.S3Key]; ok {
+					continue
+				}
+				report.MissingObjects = append(report.MissingObjects, MissingObject{
+					FileID: file.ID,
+					UserID: file.User,
+					Key:    file.S3Key,
+					Size:   file.Size,
+				})
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan file records: %w", err)
+	}
+
+	if apply {
+		s.deleteOrphans(ctx, report)
+		s.removeMissing(ctx, report)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+
+	s.logger.Info().
+		Bool("dry_run", report.DryRun).
+		Int("objects_scanned", report.ObjectsScanned).
+		Int("records_scanned", report.RecordsScanned).
+		Int("orphaned_objects", len(report.OrphanedObjects)).
+		Int64("orphaned_bytes", report.OrphanedBytes).
+		Int("missing_objects", len(report.MissingObjects)).
+		Int("objects_deleted", report.ObjectsDeleted).
+		Int("records_removed", report.RecordsRemoved).
+		Int("errors", len(report.Errors)).
+		Msg("Storage reconciliation finished")
+
+	return report, nil
+}
+
+// knownKeys returns every storage key a record refers to, including the
+// records of trashed files
+func (s *ReconcileService) knownKeys() (map[string]bool, error) {
+	known := make(map[string]bool)
+
+	for _, model := range []interface{}{
+		&models.File{},
+		&models.FileVersion{},
+		&models.Blob{},
+		&models.UploadSession{},
+		&models.TusUpload{},
+	} {
+		var keys []string
+		if err := s.db.Unscoped().Model(model).Distinct().Pluck("s3_key", &keys).Error; err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			known[key] = true
+		}
+	}
+
+	return known, nil
+}
+
+// deleteOrphans removes the orphaned objects from storage in batches
+func (s *ReconcileService) deleteOrphans(ctx context.Context, report *ReconcileReport) {
+	orphans := report.OrphanedObjects
+	for start := 0; start < len(orphans); start += reconcileBatchSize {
+		end := min(start+reconcileBatchSize, len(orphans))
+
+		keys := make([]string, 0, end-start)
+		for _, orphan := range orphans[start:end] {
+			keys = append(keys, orphan.Key)
+		}
+
+		if err := s.s3Service.DeleteFiles(ctx, keys); err != nil {
+			failed := FailedKeys(err, keys)
+			s.logger.Error().
+				Err(err).
+				Int("count", len(keys)).
+				Int("failed", len(failed)).
+				Msg("Failed to delete orphaned objects")
+			report.Errors = append(report.Errors, fmt.Sprintf("delete orphaned objects: %v", err))
+			report.ObjectsDeleted += len(keys) - len(failed)
+			continue
+		}
+		report.ObjectsDeleted += len(keys)
+	}
+}
+
+// removeMissing deletes the file records whose object is gone, the way a
+// permanent delete does: their versions, shares, tags and metadata go with
+// them, their size is credited back to the owner and their remaining
+// objects (older versions, blob references) are released
+func (s *ReconcileService) removeMissing(ctx context.Context, report *ReconcileReport) {
+	for _, missing := range report.MissingObjects {
+		var file models.File
+		err := s.db.Unscoped().First(&file, "id = ?", missing.FileID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue // deleted since the scan
+		}
+
+		var deleted *DeleteReport
+		if err == nil {
+			// Trash that doesn't count toward the quota was credited back
+			// when it was trashed
+			releaseQuota := !file.DeletedAt.Valid || s.fileService.config.TrashCountsTowardQuota
+			deleted, err = s.fileService.deleteRecords(ctx, file.User, nil, []*models.File{&file}, releaseQuota)
+		}
+		if err != nil {
+			s.logger.Error().
+				Err(err).
+				Str("file_id", missing.FileID).
+				Msg("Failed to remove file record without object")
+			report.Errors = append(report.Errors, fmt.Sprintf("remove file %s: %v", missing.FileID, err))
+			continue
+		}
+		report.RecordsRemoved++
+
+		s.logger.Warn().
+			Str("file_id", missing.FileID).
+			Str("user_id", missing.UserID).
+			Str("s3_key", missing.Key).
+			Int("failed_objects", len(deleted.FailedObjects)).
+			Msg("Removed file record without stored object")
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestReconcileService(t *testing.T) (*ReconcileService, *LocalStorageService, *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Directory{}, &models.Blob{},
+		&models.UploadSession{}, &models.TusUpload{}, &models.Share{}, &models.Tag{}, &models.TagLink{}, &models.Metadata{}))
+
+	storage := newTestLocalStorage(t)
+	userService := NewUserService(db, zerolog.Nop())
+	blobService := NewBlobService(db, storage, zerolog.Nop())
+	userService.SetBlobService(blobService)
+	fileService := NewFileService(db, storage, blobService, userService, zerolog.Nop(), &config.Config{TrashCountsTowardQuota: true})
+
+	return NewReconcileService(db, storage, userService, fileService, zerolog.Nop()), storage, db
+}
+
+// storeAged uploads an object and backdates it past the orphan grace period
+func storeAged(t *testing.T, storage *LocalStorageService, key, content string) {
+	t.Helper()
+	require.NoError(t, storage.UploadFile(context.Background(), key, strings.NewReader(content), int64(len(content)), "text/plain"))
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	require.NoError(t, os.Chtimes(filepath.Join(storage.root, "objects", filepath.FromSlash(key)), old, old))
+}
+
+func TestReconcileService_Reconcile(t *testing.T) {
+	service, storage, db := newTestReconcileService(t)
+
+	user, err := service.userService.CreateUser("gc@example.com", "gcuser", "Password123!", false)
+	require.NoError(t, err)
+	require.NoError(t, service.userService.UpdateStorageUsed(user.ID, 35))
+
+	// A healthy file, a blob-backed file and a file whose object is gone
+	storeAged(t, storage, "users/u1/f1/ok.txt", "0123456789")
+	storeAged(t, storage, models.BlobKey("abcdef"), "0123456789")
+	require.NoError(t, db.Create(&models.Blob{Checksum: "abcdef", S3Key: models.BlobKey("abcdef"), Size: 10, RefCount: 1}).Error)
+	for _, file := range []*models.File{
+		{Name: "ok.txt", Path: "/", User: user.ID, Size: 10, S3Key: "users/u1/f1/ok.txt", S3Bucket: "b"},
+		{Name: "shared.txt", Path: "/", User: user.ID, Size: 10, S3Key: models.BlobKey("abcdef"), S3Bucket: "b"},
+		{ID: "missingfile0001", Name: "gone.txt", Path: "/", User: user.ID, Size: 10, S3Key: "users/u1/f3/gone.txt", S3Bucket: "b"},
+	} {
+		require.NoError(t, db.Create(file).Error)
+	}
+
+	// The missing file's older version, share, tag and metadata
+	storeAged(t, storage, "users/u1/f3/gone-v1.txt", "12345")
+	require.NoError(t, db.Create(&models.FileVersion{File: "missingfile0001", User: user.ID, Version: 1, Size: 5, S3Key: "users/u1/f3/gone-v1.txt", S3Bucket: "b"}).Error)
+	require.NoError(t, db.Create(&models.Share{User: user.ID, ResourceType: models.ResourceTypeFile, File: "missingfile0001", ShareToken: "gone-token", PermissionType: models.PermissionRead}).Error)
+	require.NoError(t, db.Create(&models.TagLink{Tag: "tag", ResourceType: models.ResourceTypeFile, Resource: "missingfile0001"}).Error)
+	require.NoError(t, db.Create(&models.Metadata{ResourceType: models.ResourceTypeFile, Resource: "missingfile0001", Key: "k", Value: "v", User: user.ID}).Error)
+
+	// Something else keeps files in the bucket
+	storeAged(t, storage, "backups/db.sqlite", "backup")
+
+	// An old orphan, and a fresh one that may still be getting its record
+	storeAged(t, storage, "users/u1/f4/orphan.txt", "orphaned")
+	require.NoError(t, storage.UploadFile(context.Background(), "users/u1/f5/new.txt", strings.NewReader("new"), 3, "text/plain"))
+
+	t.Run("Dry run only reports", func(t *testing.T) {
+		report, err := service.Reconcile(context.Background(), false)
+		require.NoError(t, err)
+
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 5, report.ObjectsScanned, "Only the app's prefixes are listed")
+		assert.Equal(t, 3, report.RecordsScanned)
+		require.Len(t, report.OrphanedObjects, 1)
+		assert.Equal(t, "users/u1/f4/orphan.txt", report.OrphanedObjects[0].Key)
+		assert.Equal(t, int64(8), report.OrphanedBytes)
+		require.Len(t, report.MissingObjects, 1)
+		assert.Equal(t, "missingfile0001", report.MissingObjects[0].FileID)
+		assert.Zero(t, report.ObjectsDeleted)
+		assert.Zero(t, report.RecordsRemoved)
+
+		exists, err := storage.FileExists(context.Background(), "users/u1/f4/orphan.txt")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Apply fixes mismatches", func(t *testing.T) {
+		report, err := service.Reconcile(context.Background(), true)
+		require.NoError(t, err)
+
+		assert.False(t, report.DryRun)
+		assert.Equal(t, 1, report.ObjectsDeleted)
+		assert.Equal(t, 1, report.RecordsRemoved)
+		assert.Empty(t, report.Errors)
+
+		exists, err := storage.FileExists(context.Background(), "users/u1/f4/orphan.txt")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		exists, err = storage.FileExists(context.Background(), "users/u1/f5/new.txt")
+		require.NoError(t, err)
+		assert.True(t, exists, "objects inside the grace period are left alone")
+
+		remaining := func(model interface{}, query string) int64 {
+			var count int64
+			require.NoError(t, db.Unscoped().Model(model).Where(query, "missingfile0001").Count(&count).Error)
+			return count
+		}
+		assert.Zero(t, remaining(&models.File{}, "id = ?"))
+		assert.Zero(t, remaining(&models.FileVersion{}, "file = ?"), "Versions go with the file")
+		assert.Zero(t, remaining(&models.Share{}, "file = ?"), "Shares go with the file")
+		assert.Zero(t, remaining(&models.TagLink{}, "resource = ?"), "Tags go with the file")
+		assert.Zero(t, remaining(&models.Metadata{}, "resource = ?"), "Metadata goes with the file")
+
+		exists, err = storage.FileExists(context.Background(), "users/u1/f3/gone-v1.txt")
+		require.NoError(t, err)
+		assert.False(t, exists, "Older versions are released")
+		exists, err = storage.FileExists(context.Background(), "backups/db.sqlite")
+		require.NoError(t, err)
+		assert.True(t, exists, "Objects outside the app's prefixes are left alone")
+
+		updated, err := service.userService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(20), updated.StorageUsed)
+
+		// Nothing is left to fix
+		report, err = service.Reconcile(context.Background(), true)
+		require.NoError(t, err)
+		assert.Empty(t, report.OrphanedObjects)
+		assert.Empty(t, report.MissingObjects)
+	})
+}
+
+func TestReconcileService_ListingUnsupported(t *testing.T) {
+	service, storage, _ := newTestReconcileService(t)
+	service.s3Service = struct{ S3Service }{storage}
+
+	_, err := service.Reconcile(context.Background(), false)
+	assert.ErrorIs(t, err, ErrListingUnsupported)
+}
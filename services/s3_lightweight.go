@@ -7,13 +7,17 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"html"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jd-boyd/filesonthego/config"
@@ -29,8 +33,25 @@ type LightweightS3Service struct {
 	endpoint  string
 	bucket    string
 	client    *http.Client
+
+	// Multipart tuning; zero values fall back to the defaults
+	partSize          int64
+	uploadConcurrency int
+
+	// Retries for transient failures; zero disables them
+	maxRetries     int
+	retryBaseDelay time.Duration
 }
 
+const (
+	// defaultUploadConcurrency is the number of parts uploaded in parallel
+	defaultUploadConcurrency = 4
+	// maxRetryDelay caps the backoff between retries
+	maxRetryDelay = 10 * time.Second
+	// maxErrorBodySize bounds how much of an error response is buffered
+	maxErrorBodySize = 64 * 1024
+)
+
 // S3ErrorResponse represents S3 API error response
 type S3ErrorResponse struct {
 	XMLName xml.Name `xml:"Error"`
@@ -39,6 +60,34 @@ type S3ErrorResponse struct {
 	Key     string   `xml:"Key"`
 }
 
+// S3Error is an error returned by the S3 API
+type S3Error struct {
+	Operation  string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error implements the error interface
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s: HTTP %d", e.Operation, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s - %s", e.Operation, e.Code, e.Message)
+}
+
+// Retryable reports whether the request may succeed if sent again: server
+// errors, throttling and request timeouts
+func (e *S3Error) Retryable() bool {
+	return e.StatusCode >= 500 || e.Code == "SlowDown" || e.Code == "RequestTimeout"
+}
+
+// IsRetryableError reports whether err is a transient S3 error
+func IsRetryableError(err error) bool {
+	var s3Err *S3Error
+	return errors.As(err, &s3Err) && s3Err.Retryable()
+}
+
 // NewLightweightS3Service creates a new lightweight S3 service
 func NewLightweightS3Service(cfg *config.Config) (*LightweightS3Service, error) {
 	// Validate configuration
@@ -61,15 +110,23 @@ func NewLightweightS3Service(cfg *config.Config) (*LightweightS3Service, error)
 		endpoint = "https://s3." + cfg.S3Region + ".amazonaws.com"
 	}
 
+	// Requests are bounded by their context rather than a client timeout,
+	// which would also cut off long transfers. Only the wait for response
+	// headers is capped.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+
 	service := &LightweightS3Service{
-		accessKey: cfg.S3AccessKey,
-		secretKey: cfg.S3SecretKey,
-		region:    cfg.S3Region,
-		endpoint:  endpoint,
-		bucket:    cfg.S3Bucket,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		accessKey:         cfg.S3AccessKey,
+		secretKey:         cfg.S3SecretKey,
+		region:            cfg.S3Region,
+		endpoint:          endpoint,
+		bucket:            cfg.S3Bucket,
+		client:            &http.Client{Transport: transport},
+		partSize:          cfg.S3UploadPartSize,
+		uploadConcurrency: cfg.S3UploadConcurrency,
+		maxRetries:        cfg.S3MaxRetries,
+		retryBaseDelay:    time.Duration(cfg.S3RetryBaseDelay) * time.Millisecond,
 	}
 
 	// Test connection
@@ -109,7 +166,7 @@ func NewLightweightS3Service(cfg *config.Config) (*LightweightS3Service, error)
 }
 
 // UploadFile uploads a file with known size to S3
-func (s *LightweightS3Service) UploadFile(key string, reader io.Reader, size int64, contentType string) error {
+func (s *LightweightS3Service) UploadFile(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
 	if err := validateKey(key); err != nil {
 		return err
 	}
@@ -120,8 +177,9 @@ func (s *LightweightS3Service) UploadFile(key string, reader io.Reader, size int
 
 	url := s.getObjectURL(key)
 
-	// Create request
-	req, err := http.NewRequest("PUT", url, reader)
+	// Create request. The reader belongs to the caller, so the transport
+	// must not close it.
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, io.NopCloser(reader))
 	if err != nil {
 		return fmt.Errorf("failed to create upload request: %w", err)
 	}
@@ -130,6 +188,18 @@ func (s *LightweightS3Service) UploadFile(key string, reader io.Reader, size int
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("x-amz-meta-uploaded-at", time.Now().UTC().Format(time.RFC3339))
 
+	// Seekable content can be sent again if the upload is retried
+	if seeker, ok := reader.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(reader), nil
+			}
+		}
+	}
+
 	// Sign request
 	s.signRequest(req, "")
 
@@ -140,7 +210,7 @@ func (s *LightweightS3Service) UploadFile(key string, reader io.Reader, size int
 		Msg("Uploading file to S3")
 
 	// Send request
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -177,8 +247,8 @@ type UploadedPart struct {
 }
 
 // UploadStream uploads a file using streaming with multipart upload for large files
-// Automatically uses multipart upload for files larger than 10MB
-func (s *LightweightS3Service) UploadStream(key string, reader io.Reader) error {
+// Automatically uses multipart upload for files larger than one part
+func (s *LightweightS3Service) UploadStream(ctx context.Context, key string, reader io.Reader) error {
 	if err := validateKey(key); err != nil {
 		return err
 	}
@@ -188,11 +258,12 @@ func (s *LightweightS3Service) UploadStream(key string, reader io.Reader) error
 		Msg("Starting streaming upload to S3")
 
 	// Create a buffer reader to determine file size
-	bufReader := newBufferedReader(reader, 10*1024*1024) // 10MB buffer
+	partSize := s.uploadPartSize()
+	bufReader := newBufferedReader(reader, int(partSize))
 
-	// Check if file is large enough for multipart upload (>10MB)
-	if bufReader.hasMoreThan(10 * 1024 * 1024) {
-		return s.multipartUpload(key, bufReader)
+	// Check if file is large enough for multipart upload
+	if bufReader.hasMoreThan(int(partSize)) {
+		return s.multipartUpload(ctx, key, bufReader)
 	}
 
 	// For smaller files, use simple upload
@@ -205,34 +276,34 @@ func (s *LightweightS3Service) UploadStream(key string, reader io.Reader) error
 		return fmt.Errorf("failed to read content: %w", err)
 	}
 
-	return s.UploadFile(key, bytes.NewReader(content), int64(len(content)), "application/octet-stream")
+	return s.UploadFile(ctx, key, bytes.NewReader(content), int64(len(content)), "application/octet-stream")
 }
 
 // multipartUpload implements the full multipart upload workflow
-func (s *LightweightS3Service) multipartUpload(key string, reader io.Reader) error {
+func (s *LightweightS3Service) multipartUpload(ctx context.Context, key string, reader io.Reader) error {
 	log.Info().
 		Str("key", key).
 		Msg("Starting multipart upload for large file")
 
 	// 1. Initiate multipart upload
-	upload, err := s.initiateMultipartUpload(key)
+	upload, err := s.initiateMultipartUpload(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to initiate multipart upload: %w", err)
 	}
 
 	// 2. Upload parts
-	parts, err := s.uploadParts(upload, reader)
+	parts, err := s.uploadParts(ctx, upload, reader)
 	if err != nil {
-		// Abort upload on error
-		_ = s.abortMultipartUpload(upload)
+		// Abort upload on error, even if the caller has gone away
+		_ = s.abortMultipartUpload(context.WithoutCancel(ctx), upload)
 		return fmt.Errorf("failed to upload parts: %w", err)
 	}
 
 	// 3. Complete multipart upload
-	err = s.completeMultipartUpload(upload, parts)
+	err = s.completeMultipartUpload(ctx, upload, parts)
 	if err != nil {
 		// Abort upload on error
-		_ = s.abortMultipartUpload(upload)
+		_ = s.abortMultipartUpload(context.WithoutCancel(ctx), upload)
 		return fmt.Errorf("failed to complete multipart upload: %w", err)
 	}
 
@@ -245,18 +316,24 @@ func (s *LightweightS3Service) multipartUpload(key string, reader io.Reader) err
 }
 
 // initiateMultipartUpload starts a multipart upload session
-func (s *LightweightS3Service) initiateMultipartUpload(key string) (*MultipartUpload, error) {
+func (s *LightweightS3Service) initiateMultipartUpload(ctx context.Context, key string) (*MultipartUpload, error) {
+	return s.initiateMultipartUploadWithType(ctx, key, "application/octet-stream")
+}
+
+// initiateMultipartUploadWithType starts a multipart upload whose final
+// object will have the given content type
+func (s *LightweightS3Service) initiateMultipartUploadWithType(ctx context.Context, key, contentType string) (*MultipartUpload, error) {
 	url := fmt.Sprintf("%s?uploads", s.getObjectURL(key))
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create initiate request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Type", contentType)
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initiate multipart upload: %w", err)
 	}
@@ -291,64 +368,136 @@ func (s *LightweightS3Service) initiateMultipartUpload(key string) (*MultipartUp
 	}, nil
 }
 
-// uploadParts uploads data in chunks and returns uploaded parts
-func (s *LightweightS3Service) uploadParts(upload *MultipartUpload, reader io.Reader) ([]*UploadedPart, error) {
-	const partSize = 10 * 1024 * 1024 // 10MB parts
-	const maxParts = 10000             // S3 limit
+// uploadParts reads data in part-sized chunks and uploads them with a
+// bounded pool of workers. At most concurrency+1 part buffers exist at once.
+// The first failure cancels the remaining parts. Parts are returned in order.
+func (s *LightweightS3Service) uploadParts(ctx context.Context, upload *MultipartUpload, reader io.Reader) ([]*UploadedPart, error) {
+	const maxParts = 10000 // S3 limit
 
-	var parts []*UploadedPart
-	partNumber := 1
+	partSize := s.uploadPartSize()
+	concurrency := s.uploadConcurrency
+	if concurrency < 1 {
+		concurrency = defaultUploadConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type partJob struct {
+		partNumber int
+		buf        []byte
+		size       int
+	}
+
+	var (
+		mu       sync.Mutex
+		parts    []*UploadedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	// Buffers are allocated on first use and handed back by the workers
+	buffers := make(chan []byte, concurrency+1)
+	for i := 0; i < cap(buffers); i++ {
+		buffers <- nil
+	}
+
+	jobs := make(chan partJob)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() == nil {
+					part, err := s.uploadPart(ctx, upload, job.partNumber, job.buf[:job.size])
+					if err != nil {
+						fail(fmt.Errorf("failed to upload part %d: %w", job.partNumber, err))
+					} else {
+						mu.Lock()
+						parts = append(parts, part)
+						mu.Unlock()
+
+						log.Debug().
+							Str("upload_id", upload.UploadID).
+							Int("part_number", job.partNumber).
+							Int("part_size", job.size).
+							Msg("Uploaded part")
+					}
+				}
+				buffers <- job.buf
+			}
+		}()
+	}
+
+readLoop:
+	for partNumber := 1; ; partNumber++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+			break readLoop
+		}
+		if buf == nil {
+			buf = make([]byte, partSize)
+		}
 
-	for {
 		// Read chunk
-		buf := make([]byte, partSize)
 		n, err := io.ReadFull(reader, buf)
-
-		if err == io.EOF {
+		if err == io.EOF || n == 0 {
 			break
 		}
 		if err != nil && err != io.ErrUnexpectedEOF {
-			return nil, fmt.Errorf("failed to read part %d: %w", partNumber, err)
+			fail(fmt.Errorf("failed to read part %d: %w", partNumber, err))
+			break
 		}
-
-		if n == 0 {
+		if partNumber > maxParts {
+			fail(fmt.Errorf("too many parts: %d > %d", partNumber, maxParts))
 			break
 		}
 
-		// Upload part
-		partData := buf[:n]
-		part, err := s.uploadPart(upload, partNumber, partData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		select {
+		case jobs <- partJob{partNumber: partNumber, buf: buf, size: n}:
+		case <-ctx.Done():
+			break readLoop
 		}
+	}
 
-		parts = append(parts, part)
-		partNumber++
-
-		log.Debug().
-			Str("upload_id", upload.UploadID).
-			Int("part_number", partNumber-1).
-			Int64("part_size", int64(n)).
-			Msg("Uploaded part")
+	close(jobs)
+	wg.Wait()
 
-		if partNumber > maxParts {
-			return nil, fmt.Errorf("too many parts: %d > %d", partNumber, maxParts)
-		}
+	if firstErr != nil {
+		return nil, firstErr
 	}
-
 	if len(parts) == 0 {
 		return nil, fmt.Errorf("no parts to upload")
 	}
 
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
 	return parts, nil
 }
 
+// uploadPartSize returns the configured multipart part size
+func (s *LightweightS3Service) uploadPartSize() int64 {
+	if s.partSize < minPartSize {
+		return defaultPartSize
+	}
+	return s.partSize
+}
+
 // uploadPart uploads a single part of a multipart upload
-func (s *LightweightS3Service) uploadPart(upload *MultipartUpload, partNumber int, data []byte) (*UploadedPart, error) {
+func (s *LightweightS3Service) uploadPart(ctx context.Context, upload *MultipartUpload, partNumber int, data []byte) (*UploadedPart, error) {
 	url := fmt.Sprintf("%s?partNumber=%d&uploadId=%s",
 		s.getObjectURL(upload.Key), partNumber, upload.UploadID)
 
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create part request: %w", err)
 	}
@@ -356,7 +505,7 @@ func (s *LightweightS3Service) uploadPart(upload *MultipartUpload, partNumber in
 	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(data)))
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload part: %w", err)
 	}
@@ -379,7 +528,7 @@ func (s *LightweightS3Service) uploadPart(upload *MultipartUpload, partNumber in
 }
 
 // completeMultipartUpload completes a multipart upload session
-func (s *LightweightS3Service) completeMultipartUpload(upload *MultipartUpload, parts []*UploadedPart) error {
+func (s *LightweightS3Service) completeMultipartUpload(ctx context.Context, upload *MultipartUpload, parts []*UploadedPart) error {
 	// Build complete multipart upload XML
 	var completeXML bytes.Buffer
 	completeXML.WriteString(`<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUpload>`)
@@ -392,7 +541,7 @@ func (s *LightweightS3Service) completeMultipartUpload(upload *MultipartUpload,
 
 	url := fmt.Sprintf("%s?uploadId=%s", s.getObjectURL(upload.Key), upload.UploadID)
 
-	req, err := http.NewRequest("POST", url, &completeXML)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &completeXML)
 	if err != nil {
 		return fmt.Errorf("failed to create complete request: %w", err)
 	}
@@ -400,7 +549,7 @@ func (s *LightweightS3Service) completeMultipartUpload(upload *MultipartUpload,
 	req.Header.Set("Content-Type", "application/xml")
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return fmt.Errorf("failed to complete multipart upload: %w", err)
 	}
@@ -414,17 +563,17 @@ func (s *LightweightS3Service) completeMultipartUpload(upload *MultipartUpload,
 }
 
 // abortMultipartUpload aborts a multipart upload session
-func (s *LightweightS3Service) abortMultipartUpload(upload *MultipartUpload) error {
+func (s *LightweightS3Service) abortMultipartUpload(ctx context.Context, upload *MultipartUpload) error {
 	url := fmt.Sprintf("%s?uploadId=%s", s.getObjectURL(upload.Key), upload.UploadID)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create abort request: %w", err)
 	}
 
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return fmt.Errorf("failed to abort multipart upload: %w", err)
 	}
@@ -442,6 +591,115 @@ func (s *LightweightS3Service) abortMultipartUpload(upload *MultipartUpload) err
 	return nil
 }
 
+// CreateMultipartUpload starts a multipart upload whose parts are uploaded
+// separately, by the server or by clients through presigned URLs
+func (s *LightweightS3Service) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	upload, err := s.initiateMultipartUploadWithType(ctx, key, contentType)
+	if err != nil {
+		return "", err
+	}
+	return upload.UploadID, nil
+}
+
+// PresignUploadPart generates a presigned PUT URL for one part of a
+// multipart upload
+func (s *LightweightS3Service) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int, expirationMinutes int) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if uploadID == "" {
+		return "", fmt.Errorf("upload ID is required")
+	}
+	if partNumber < 1 || partNumber > 10000 {
+		return "", fmt.Errorf("invalid part number: %d", partNumber)
+	}
+
+	if expirationMinutes <= 0 {
+		expirationMinutes = 15
+	}
+	if expirationMinutes > 60 {
+		expirationMinutes = 60
+	}
+
+	req, err := http.NewRequest("PUT", s.getObjectURL(key), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create presigned part request: %w", err)
+	}
+
+	query := req.URL.Query()
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadID)
+	req.URL.RawQuery = query.Encode()
+
+	return s.presignURL(req, time.Duration(expirationMinutes)*time.Minute), nil
+}
+
+// UploadPart uploads one part of a multipart upload and returns its ETag
+func (s *LightweightS3Service) UploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if partNumber < 1 || partNumber > 10000 {
+		return "", fmt.Errorf("invalid part number: %d", partNumber)
+	}
+
+	part, err := s.uploadPart(ctx, &MultipartUpload{UploadID: uploadID, Key: key, Bucket: s.bucket}, partNumber, data)
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+// CompleteMultipartUpload assembles uploaded parts into the final object
+func (s *LightweightS3Service) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no parts to complete")
+	}
+
+	uploadedParts := make([]*UploadedPart, len(parts))
+	for i, part := range parts {
+		uploadedParts[i] = &UploadedPart{
+			PartNumber: part.PartNumber,
+			ETag:       strings.Trim(part.ETag, `"`),
+		}
+	}
+
+	// S3 requires parts in ascending order
+	sort.Slice(uploadedParts, func(i, j int) bool {
+		return uploadedParts[i].PartNumber < uploadedParts[j].PartNumber
+	})
+
+	upload := &MultipartUpload{UploadID: uploadID, Key: key, Bucket: s.bucket}
+	if err := s.completeMultipartUpload(ctx, upload, uploadedParts); err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("key", key).
+		Int("parts", len(parts)).
+		Msg("Completed multipart upload")
+
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *LightweightS3Service) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return s.abortMultipartUpload(ctx, &MultipartUpload{UploadID: uploadID, Key: key, Bucket: s.bucket})
+}
+
 // bufferedReader helps read a specific amount of data from a reader
 type bufferedReader struct {
 	reader io.Reader
@@ -497,25 +755,51 @@ func (b *bufferedReader) hasMoreThan(size int) bool {
 }
 
 // DownloadFile downloads a file from S3 as a stream
-func (s *LightweightS3Service) DownloadFile(key string) (io.ReadCloser, error) {
+func (s *LightweightS3Service) DownloadFile(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.getObject(ctx, key, -1, -1)
+}
+
+// DownloadRange downloads length bytes of a file starting at offset using a
+// ranged GET. A negative length reads to the end of the object.
+func (s *LightweightS3Service) DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid range offset: %d", offset)
+	}
+	return s.getObject(ctx, key, offset, length)
+}
+
+// getObject issues a GET for key. A negative offset fetches the whole object.
+func (s *LightweightS3Service) getObject(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
 	if err := validateKey(key); err != nil {
 		return nil, err
 	}
 
 	url := s.getObjectURL(key)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create download request: %w", err)
 	}
 
+	ranged := offset >= 0
+	if ranged {
+		if length < 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		} else if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		}
+	}
+
 	s.signRequest(req, "")
 
 	log.Debug().
 		Str("key", key).
+		Str("range", req.Header.Get("Range")).
 		Msg("Downloading file from S3")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -532,6 +816,10 @@ func (s *LightweightS3Service) DownloadFile(key string) (io.ReadCloser, error) {
 		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, key)
 	}
 
+	if ranged && resp.StatusCode == http.StatusPartialContent {
+		return resp.Body, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		defer resp.Body.Close()
 		return nil, s.parseS3Error(resp, "download failed")
@@ -541,18 +829,47 @@ func (s *LightweightS3Service) DownloadFile(key string) (io.ReadCloser, error) {
 		Str("key", key).
 		Msg("Successfully retrieved file from S3")
 
+	if ranged {
+		// The endpoint ignored the Range header, so trim the full body ourselves
+		return newRangeReadCloser(resp.Body, offset, length)
+	}
+
 	return resp.Body, nil
 }
 
+// rangeReadCloser limits a full object body to a byte range
+type rangeReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func newRangeReadCloser(body io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to skip to range offset: %w", err)
+	}
+
+	var reader io.Reader = body
+	if length >= 0 {
+		reader = io.LimitReader(body, length)
+	}
+	return &rangeReadCloser{Reader: reader, closer: body}, nil
+}
+
+// Close closes the underlying body
+func (r *rangeReadCloser) Close() error {
+	return r.closer.Close()
+}
+
 // DeleteFile deletes a single file from S3
-func (s *LightweightS3Service) DeleteFile(key string) error {
+func (s *LightweightS3Service) DeleteFile(ctx context.Context, key string) error {
 	if err := validateKey(key); err != nil {
 		return err
 	}
 
 	url := s.getObjectURL(key)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create delete request: %w", err)
 	}
@@ -563,7 +880,7 @@ func (s *LightweightS3Service) DeleteFile(key string) error {
 		Str("key", key).
 		Msg("Deleting file from S3")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -584,8 +901,23 @@ func (s *LightweightS3Service) DeleteFile(key string) error {
 	return nil
 }
 
-// DeleteFiles deletes multiple files from S3 (batch operation using S3 delete API)
-func (s *LightweightS3Service) DeleteFiles(keys []string) error {
+// deleteResult is the response to a quiet DeleteObjects request, which
+// lists only the keys that could not be deleted
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Errors  []struct {
+		Key     string `xml:"Key"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// DeleteFiles deletes multiple files from S3 (batch operation using S3 delete API).
+// Keys are sent in requests of at most 1000, the most S3 accepts. S3
+// answers 200 even when some keys fail, so the keys its DeleteResult lists
+// as errors are returned in a *DeleteFilesError, along with every key of a
+// request that failed outright.
+func (s *LightweightS3Service) DeleteFiles(ctx context.Context, keys []string) error {
 	if len(keys) == 0 {
 		return nil
 	}
@@ -601,9 +933,43 @@ func (s *LightweightS3Service) DeleteFiles(keys []string) error {
 		Int("count", len(keys)).
 		Msg("Batch deleting files from S3")
 
+	var failed []string
+	var errs []error
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		batch := keys[start:min(start+deleteBatchSize, len(keys))]
+		batchFailed, err := s.deleteBatch(ctx, batch)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Int("count", len(batch)).
+				Msg("Failed to batch delete files from S3")
+			failed = append(failed, batch...)
+			errs = append(errs, err)
+			continue
+		}
+		failed = append(failed, batchFailed...)
+	}
+
+	if len(failed) > 0 {
+		if len(errs) == 0 {
+			errs = append(errs, fmt.Errorf("%w: %d of %d keys could not be deleted", ErrDeleteFailed, len(failed), len(keys)))
+		}
+		return &DeleteFilesError{Keys: failed, Err: errors.Join(errs...)}
+	}
+
+	log.Info().
+		Int("count", len(keys)).
+		Msg("Successfully batch deleted files from S3")
+
+	return nil
+}
+
+// deleteBatch sends one DeleteObjects request for at most 1000 keys and
+// returns the keys S3 reported it couldn't delete
+func (s *LightweightS3Service) deleteBatch(ctx context.Context, keys []string) ([]string, error) {
 	// Build delete XML payload
 	var deleteXML bytes.Buffer
-	deleteXML.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Delete>`)
+	deleteXML.WriteString(`<?xml version="1.0" encoding="UTF-8"?><Delete><Quiet>true</Quiet>`)
 	for _, key := range keys {
 		deleteXML.WriteString(fmt.Sprintf(`<Object><Key>%s</Key></Object>`, html.EscapeString(key)))
 	}
@@ -611,9 +977,9 @@ func (s *LightweightS3Service) DeleteFiles(keys []string) error {
 
 	url := fmt.Sprintf("%s?delete", s.getBucketURL())
 
-	req, err := http.NewRequest("POST", url, &deleteXML)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &deleteXML)
 	if err != nil {
-		return fmt.Errorf("failed to create batch delete request: %w", err)
+		return nil, fmt.Errorf("failed to create batch delete request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/xml")
@@ -621,29 +987,102 @@ func (s *LightweightS3Service) DeleteFiles(keys []string) error {
 
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDeleteFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.parseS3Error(resp, "batch delete failed")
+	}
+
+	var result deleteResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse delete response: %v", ErrDeleteFailed, err)
+	}
+
+	failed := make([]string, 0, len(result.Errors))
+	for _, e := range result.Errors {
+		log.Warn().
+			Str("key", e.Key).
+			Str("code", e.Code).
+			Str("message", e.Message).
+			Msg("S3 could not delete object")
+		failed = append(failed, e.Key)
+	}
+	return failed, nil
+}
+
+// CopyObject copies an object server-side using the x-amz-copy-source
+// header. S3 limits a single copy to 5GB.
+func (s *LightweightS3Service) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	if err := validateKey(srcKey); err != nil {
+		return err
+	}
+	if err := validateKey(dstKey); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.getObjectURL(dstKey), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create copy request: %w", err)
+	}
+
+	segments := strings.Split(srcKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	req.Header.Set("x-amz-copy-source", "/"+s.bucket+"/"+strings.Join(segments, "/"))
+
+	s.signRequest(req, "")
+
+	log.Debug().
+		Str("src_key", srcKey).
+		Str("dst_key", dstKey).
+		Msg("Copying object in S3")
+
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
-			Int("count", len(keys)).
-			Msg("Failed to batch delete files from S3")
-		return fmt.Errorf("%w: %v", ErrDeleteFailed, err)
+			Str("src_key", srcKey).
+			Str("dst_key", dstKey).
+			Msg("Failed to copy object in S3")
+		return fmt.Errorf("failed to copy object: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return s.parseS3Error(resp, "batch delete failed")
+		return s.parseS3Error(resp, "copy failed")
+	}
+
+	// A copy can fail after S3 has already answered 200, in which case the
+	// error is in the body
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read copy response: %w", err)
+	}
+	if bytes.Contains(body, []byte("<Error>")) {
+		return decodeS3Error(resp.StatusCode, body, "copy failed")
 	}
 
 	log.Info().
-		Int("count", len(keys)).
-		Msg("Successfully batch deleted files from S3")
+		Str("src_key", srcKey).
+		Str("dst_key", dstKey).
+		Msg("Successfully copied object in S3")
 
 	return nil
 }
 
 // GetPresignedURL generates a time-limited presigned URL for file access
-func (s *LightweightS3Service) GetPresignedURL(key string, expirationMinutes int) (string, error) {
+func (s *LightweightS3Service) GetPresignedURL(ctx context.Context, key string, expirationMinutes int) (string, error) {
+	return s.GetPresignedDownloadURL(ctx, key, expirationMinutes, PresignOptions{})
+}
+
+// GetPresignedDownloadURL generates a presigned GET URL that makes S3
+// respond with the given Content-Disposition and Content-Type overrides
+func (s *LightweightS3Service) GetPresignedDownloadURL(ctx context.Context, key string, expirationMinutes int, opts PresignOptions) (string, error) {
 	if err := validateKey(key); err != nil {
 		return "", err
 	}
@@ -658,11 +1097,21 @@ func (s *LightweightS3Service) GetPresignedURL(key string, expirationMinutes int
 	url := s.getObjectURL(key)
 
 	// Create request for signing
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create presigned URL request: %w", err)
 	}
 
+	// Response header overrides are part of the signed query
+	query := req.URL.Query()
+	if opts.ContentDisposition != "" {
+		query.Set("response-content-disposition", opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+	req.URL.RawQuery = query.Encode()
+
 	// Generate presigned URL with expiration
 	expiration := time.Duration(expirationMinutes) * time.Minute
 	signedURL := s.presignURL(req, expiration)
@@ -676,21 +1125,21 @@ func (s *LightweightS3Service) GetPresignedURL(key string, expirationMinutes int
 }
 
 // FileExists checks if a file exists in S3 without downloading it
-func (s *LightweightS3Service) FileExists(key string) (bool, error) {
+func (s *LightweightS3Service) FileExists(ctx context.Context, key string) (bool, error) {
 	if err := validateKey(key); err != nil {
 		return false, err
 	}
 
 	url := s.getObjectURL(key)
 
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return false, fmt.Errorf("failed to create HEAD request: %w", err)
 	}
 
 	s.signRequest(req, "")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -712,14 +1161,14 @@ func (s *LightweightS3Service) FileExists(key string) (bool, error) {
 }
 
 // GetFileMetadata retrieves metadata about a file without downloading it
-func (s *LightweightS3Service) GetFileMetadata(key string) (*FileMetadata, error) {
+func (s *LightweightS3Service) GetFileMetadata(ctx context.Context, key string) (*FileMetadata, error) {
 	if err := validateKey(key); err != nil {
 		return nil, err
 	}
 
 	url := s.getObjectURL(key)
 
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HEAD request: %w", err)
 	}
@@ -730,7 +1179,7 @@ func (s *LightweightS3Service) GetFileMetadata(key string) (*FileMetadata, error
 		Str("key", key).
 		Msg("Retrieving file metadata from S3")
 
-	resp, err := s.client.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -771,6 +1220,97 @@ func (s *LightweightS3Service) GetFileMetadata(key string) (*FileMetadata, error
 	return metadata, nil
 }
 
+// listBucketResult is one page of a ListObjectsV2 response
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+}
+
+// ListObjects calls fn for every object whose key starts with prefix,
+// fetching ListObjectsV2 pages as they are consumed
+func (s *LightweightS3Service) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		page, err := s.listObjectsPage(ctx, prefix, token)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range page.Contents {
+			info := ObjectInfo{
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         strings.Trim(obj.ETag, `"`),
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated {
+			return nil
+		}
+		if page.NextContinuationToken == "" {
+			return fmt.Errorf("list objects: truncated page without continuation token")
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *LightweightS3Service) listObjectsPage(ctx context.Context, prefix, token string) (*listBucketResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.getBucketURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create list request: %w", err)
+	}
+	req.URL.RawQuery = awsQueryEncode(query)
+
+	s.signRequest(req, "")
+
+	resp, err := s.do(req)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("prefix", prefix).
+			Msg("Failed to list objects in S3")
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.parseS3Error(resp, "list objects failed")
+	}
+
+	var page listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to parse list objects response: %w", err)
+	}
+
+	log.Debug().
+		Str("prefix", prefix).
+		Int("count", len(page.Contents)).
+		Bool("truncated", page.IsTruncated).
+		Msg("Listed objects page from S3")
+
+	return &page, nil
+}
+
 // Helper methods
 
 func (s *LightweightS3Service) getBucketURL() string {
@@ -803,7 +1343,7 @@ func (s *LightweightS3Service) signRequest(req *http.Request, payload string) {
 		canonicalURI = "/"
 	}
 
-	canonicalQuery := req.URL.Query().Encode()
+	canonicalQuery := awsQueryEncode(req.URL.Query())
 	canonicalHeaders, signedHeaders := s.getCanonicalHeaders(req)
 
 	payloadHash := s.sha256Hash(payload)
@@ -893,13 +1433,16 @@ func (s *LightweightS3Service) presignURL(req *http.Request, expiration time.Dur
 	query.Set("X-Amz-Expires", strconv.Itoa(expirationSeconds))
 	query.Set("X-Amz-SignedHeaders", "host")
 
-	req.URL.RawQuery = query.Encode()
+	// SigV4 requires %20 rather than + for spaces, in both the signed and
+	// the sent query string
+	canonicalQuery := awsQueryEncode(query)
+	req.URL.RawQuery = canonicalQuery
 
 	// Create string to sign for presigned URL
 	canonicalRequest := strings.Join([]string{
 		req.Method,
 		req.URL.Path,
-		req.URL.Query().Encode(),
+		canonicalQuery,
 		"host:" + req.URL.Host + "\n",
 		"host",
 		"UNSIGNED-PAYLOAD",
@@ -924,29 +1467,127 @@ func (s *LightweightS3Service) presignURL(req *http.Request, expiration time.Dur
 	return req.URL.String()
 }
 
+// awsQueryEncode encodes query values sorted by key, using the SigV4
+// canonical escaping (RFC 3986, spaces as %20)
+func awsQueryEncode(values url.Values) string {
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
 func (s *LightweightS3Service) calculateMD5(data string) string {
 	// Simple MD5 for delete payload (in production, use crypto/md5)
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
 
+// parseS3Error converts an error response into an error. API errors are
+// returned as *S3Error, except missing keys which wrap ErrFileNotFound.
 func (s *LightweightS3Service) parseS3Error(resp *http.Response, operation string) error {
-	var s3Error S3ErrorResponse
-	if err := xml.NewDecoder(resp.Body).Decode(&s3Error); err != nil {
-		return fmt.Errorf("%s: HTTP %d", operation, resp.StatusCode)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	err := decodeS3Error(resp.StatusCode, body, operation)
+
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) && s3Err.Code == "" {
+		return err
 	}
 
 	log.Error().
-		Str("code", s3Error.Code).
-		Str("message", s3Error.Message).
+		Err(err).
 		Int("status", resp.StatusCode).
 		Msg("S3 API error")
 
+	return err
+}
+
+// decodeS3Error builds the error for an error response body
+func decodeS3Error(statusCode int, body []byte, operation string) error {
+	var s3Error S3ErrorResponse
+	if err := xml.Unmarshal(body, &s3Error); err != nil {
+		return &S3Error{Operation: operation, StatusCode: statusCode}
+	}
+
 	if s3Error.Code == "NoSuchKey" || s3Error.Code == "NotFound" {
 		return fmt.Errorf("%w: %s", ErrFileNotFound, s3Error.Key)
 	}
 
-	return fmt.Errorf("%s: %s - %s", operation, s3Error.Code, s3Error.Message)
+	return &S3Error{
+		Operation:  operation,
+		StatusCode: statusCode,
+		Code:       s3Error.Code,
+		Message:    s3Error.Message,
+	}
+}
+
+// do sends a signed request, retrying transport failures and retryable
+// error responses with jittered exponential backoff. Requests whose body
+// can't be replayed are only sent once. The final response is returned as
+// is, so callers check the status and parse errors as usual.
+func (s *LightweightS3Service) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		resp, err := s.client.Do(req)
+		if attempt >= s.maxRetries || !replayable || ctx.Err() != nil {
+			return resp, err
+		}
+
+		retryErr := err
+		if err == nil && resp.StatusCode >= 400 {
+			// Buffer the error so it can still be parsed if we give up
+			body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			if readErr != nil {
+				retryErr = readErr
+			} else if decoded := decodeS3Error(resp.StatusCode, body, req.Method+" "+req.URL.Path); IsRetryableError(decoded) {
+				retryErr = decoded
+			}
+		}
+		if retryErr == nil {
+			return resp, nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		delay := s.retryDelay(attempt)
+		log.Warn().
+			Err(retryErr).
+			Str("method", req.Method).
+			Str("path", req.URL.Path).
+			Int("attempt", attempt+1).
+			Dur("delay", delay).
+			Msg("Retrying S3 request")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		next := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			next.Body = body
+		}
+		s.signRequest(next, "")
+		req = next
+	}
+}
+
+// retryDelay returns the backoff before retry number attempt+1, using full
+// jitter over an exponentially growing window
+func (s *LightweightS3Service) retryDelay(attempt int) time.Duration {
+	window := s.retryBaseDelay << attempt
+	if window <= 0 || window > maxRetryDelay {
+		window = maxRetryDelay
+	}
+	return time.Duration(rand.Int64N(int64(window)))
 }
 
 // validateKey checks if an S3 key is valid
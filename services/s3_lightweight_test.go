@@ -2,12 +2,16 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,10 +43,31 @@ func createMockS3Server(t *testing.T) (*httptest.Server, map[string][]byte) {
 		case "GET":
 			// Download file
 			if data, exists := files[path]; exists {
-				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
 				w.Header().Set("Content-Type", "application/octet-stream")
 				w.Header().Set("ETag", `"test-etag"`)
 				w.Header().Set("Last-Modified", time.Now().Format(time.RFC1123))
+
+				// Ranged GET: bytes=start- or bytes=start-end
+				if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+					var start, end int
+					if n, _ := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); n < 2 {
+						end = len(data) - 1
+					}
+					if end >= len(data) {
+						end = len(data) - 1
+					}
+					if start > end {
+						w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+						return
+					}
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+					w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(data[start : end+1])
+					return
+				}
+
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
 				w.WriteHeader(http.StatusOK)
 				w.Write(data)
 			} else {
@@ -85,6 +110,21 @@ func createMockS3Server(t *testing.T) (*httptest.Server, map[string][]byte) {
 			}
 
 		case "PUT":
+			// Server-side copy
+			if copySource := r.Header.Get("x-amz-copy-source"); copySource != "" {
+				srcKey, _ := url.PathUnescape(strings.TrimPrefix(copySource, "/test-bucket/"))
+				data, exists := files[srcKey]
+				if !exists {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist</Message></Error>`))
+					return
+				}
+				files[path] = bytes.Clone(data)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><CopyObjectResult><ETag>"test-etag"</ETag></CopyObjectResult>`))
+				return
+			}
+
 			// Upload file
 			body, _ := io.ReadAll(r.Body)
 			files[path] = body
@@ -275,7 +315,7 @@ func TestLightweightS3Service_UploadFile(t *testing.T) {
 				delete(files, k)
 			}
 
-			err := service.UploadFile(tt.key, bytes.NewReader(tt.data), tt.size, tt.contentType)
+			err := service.UploadFile(context.Background(), tt.key, bytes.NewReader(tt.data), tt.size, tt.contentType)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -337,7 +377,7 @@ func TestLightweightS3Service_DownloadFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			reader, err := service.DownloadFile(tt.key)
+			reader, err := service.DownloadFile(context.Background(), tt.key)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -359,6 +399,67 @@ func TestLightweightS3Service_DownloadFile(t *testing.T) {
 	}
 }
 
+func TestLightweightS3Service_DownloadRange(t *testing.T) {
+	server, files := createMockS3Server(t)
+	defer server.Close()
+
+	files["range/file.txt"] = []byte("0123456789")
+
+	service := &LightweightS3Service{
+		accessKey: "test-access-key",
+		secretKey: "test-secret-key",
+		region:    "us-east-1",
+		endpoint:  server.URL,
+		bucket:    "test-bucket",
+		client:    server.Client(),
+	}
+
+	tests := []struct {
+		name          string
+		offset        int64
+		length        int64
+		expectContent string
+	}{
+		{name: "Bounded range", offset: 2, length: 3, expectContent: "234"},
+		{name: "Open-ended range", offset: 7, length: -1, expectContent: "789"},
+		{name: "Whole file", offset: 0, length: 10, expectContent: "0123456789"},
+		{name: "Empty range", offset: 4, length: 0, expectContent: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := service.DownloadRange(context.Background(), "range/file.txt", tt.offset, tt.length)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectContent, string(data))
+		})
+	}
+
+	t.Run("File not found", func(t *testing.T) {
+		_, err := service.DownloadRange(context.Background(), "range/missing.txt", 0, 5)
+		assert.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("Negative offset", func(t *testing.T) {
+		_, err := service.DownloadRange(context.Background(), "range/file.txt", -1, 5)
+		assert.Error(t, err)
+	})
+}
+
+func TestNewRangeReadCloser(t *testing.T) {
+	// Endpoints that ignore Range return the full body, which is trimmed locally
+	reader, err := newRangeReadCloser(io.NopCloser(strings.NewReader("0123456789")), 3, 4)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "3456", string(data))
+	assert.NoError(t, reader.Close())
+}
+
 func TestLightweightS3Service_DeleteFile(t *testing.T) {
 	server, files := createMockS3Server(t)
 	defer server.Close()
@@ -404,7 +505,7 @@ func TestLightweightS3Service_DeleteFile(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := service.DeleteFile(tt.key)
+			err := service.DeleteFile(context.Background(), tt.key)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -462,7 +563,7 @@ func TestLightweightS3Service_DeleteFiles(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := service.DeleteFiles(tt.keys)
+			err := service.DeleteFiles(context.Background(), tt.keys)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -485,6 +586,100 @@ func TestLightweightS3Service_DeleteFiles(t *testing.T) {
 	}
 }
 
+func TestLightweightS3Service_DeleteFilesPartialFailure(t *testing.T) {
+	var requests []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, strings.Count(string(body), "<Key>"))
+
+		// Deleting "locked/..." keys is refused, but the request succeeds
+		var result strings.Builder
+		result.WriteString(`<?xml version="1.0" encoding="UTF-8"?><DeleteResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+		for _, part := range strings.Split(string(body), "<Key>")[1:] {
+			key := part[:strings.Index(part, "</Key>")]
+			if strings.HasPrefix(key, "locked/") {
+				fmt.Fprintf(&result, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, key)
+			}
+		}
+		result.WriteString(`</DeleteResult>`)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(result.String()))
+	}))
+	defer server.Close()
+
+	service := &LightweightS3Service{
+		accessKey: "test-access-key",
+		secretKey: "test-secret-key",
+		region:    "us-east-1",
+		endpoint:  server.URL,
+		bucket:    "test-bucket",
+		client:    server.Client(),
+	}
+
+	keys := make([]string, 0, 2*deleteBatchSize+1)
+	for i := 0; i < 2*deleteBatchSize; i++ {
+		keys = append(keys, fmt.Sprintf("users/u1/f%d", i))
+	}
+	keys = append(keys, "locked/a.txt")
+	keys[5] = "locked/b.txt"
+
+	err := service.DeleteFiles(context.Background(), keys)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	assert.ElementsMatch(t, []string{"locked/b.txt", "locked/a.txt"}, FailedKeys(err, keys), "Only the keys S3 refused are reported")
+	assert.Equal(t, []int{deleteBatchSize, deleteBatchSize, 1}, requests, "Keys are sent 1000 at a time")
+}
+
+func TestLightweightS3Service_CopyObject(t *testing.T) {
+	server, files := createMockS3Server(t)
+	defer server.Close()
+
+	files["src/my file.txt"] = []byte("copy me")
+
+	service := &LightweightS3Service{
+		accessKey: "test-access-key",
+		secretKey: "test-secret-key",
+		region:    "us-east-1",
+		endpoint:  server.URL,
+		bucket:    "test-bucket",
+		client:    server.Client(),
+	}
+
+	t.Run("Successful copy", func(t *testing.T) {
+		err := service.CopyObject(context.Background(), "src/my file.txt", "dst/copy.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("copy me"), files["dst/copy.txt"])
+		assert.Equal(t, []byte("copy me"), files["src/my file.txt"])
+	})
+
+	t.Run("Missing source", func(t *testing.T) {
+		err := service.CopyObject(context.Background(), "src/missing.txt", "dst/other.txt")
+		assert.ErrorIs(t, err, ErrFileNotFound)
+	})
+
+	t.Run("Invalid keys", func(t *testing.T) {
+		assert.ErrorIs(t, service.CopyObject(context.Background(), "", "dst/x"), ErrInvalidKey)
+		assert.ErrorIs(t, service.CopyObject(context.Background(), "src/my file.txt", ""), ErrInvalidKey)
+	})
+
+	t.Run("Error after 200", func(t *testing.T) {
+		// S3 can fail a copy after it has started responding
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>We encountered an internal error</Message></Error>`))
+		}))
+		defer failing.Close()
+
+		failingService := *service
+		failingService.endpoint = failing.URL
+		failingService.client = failing.Client()
+
+		err := failingService.CopyObject(context.Background(), "src/my file.txt", "dst/copy.txt")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "InternalError")
+	})
+}
+
 func TestLightweightS3Service_FileExists(t *testing.T) {
 	server, files := createMockS3Server(t)
 	defer server.Close()
@@ -530,7 +725,7 @@ func TestLightweightS3Service_FileExists(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			exists, err := service.FileExists(tt.key)
+			exists, err := service.FileExists(context.Background(), tt.key)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -588,7 +783,7 @@ func TestLightweightS3Service_GetFileMetadata(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			metadata, err := service.GetFileMetadata(tt.key)
+			metadata, err := service.GetFileMetadata(context.Background(), tt.key)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -656,7 +851,7 @@ func TestLightweightS3Service_GetPresignedURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			url, err := service.GetPresignedURL(tt.key, tt.expirationMinutes)
+			url, err := service.GetPresignedURL(context.Background(), tt.key, tt.expirationMinutes)
 
 			if tt.expectErr {
 				assert.Error(t, err)
@@ -681,6 +876,36 @@ func TestLightweightS3Service_GetPresignedURL(t *testing.T) {
 	}
 }
 
+func TestLightweightS3Service_GetPresignedDownloadURL(t *testing.T) {
+	service := &LightweightS3Service{
+		accessKey: "test-access-key",
+		secretKey: "test-secret-key",
+		region:    "us-east-1",
+		endpoint:  "https://s3.us-east-1.amazonaws.com",
+		bucket:    "test-bucket",
+		client:    &http.Client{},
+	}
+
+	signedURL, err := service.GetPresignedDownloadURL(context.Background(), "test/file.txt", 5, PresignOptions{
+		ContentDisposition: `attachment; filename="my report.pdf"`,
+		ContentType:        "application/pdf",
+	})
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(signedURL)
+	require.NoError(t, err)
+
+	query := parsed.Query()
+	assert.Equal(t, `attachment; filename="my report.pdf"`, query.Get("response-content-disposition"))
+	assert.Equal(t, "application/pdf", query.Get("response-content-type"))
+	assert.Equal(t, "300", query.Get("X-Amz-Expires"))
+	assert.NotEmpty(t, query.Get("X-Amz-Signature"))
+
+	// SigV4 canonical encoding uses %20, never +
+	assert.NotContains(t, parsed.RawQuery, "+")
+	assert.Contains(t, parsed.RawQuery, "my%20report.pdf")
+}
+
 // Test AWS v4 signing helpers
 func TestLightweightS3Service_Signing(t *testing.T) {
 	service := &LightweightS3Service{
@@ -764,8 +989,12 @@ func createMockMultipartS3Server(t *testing.T) (*httptest.Server, map[string][]b
 	files := make(map[string][]byte)
 	uploadSessions := make(map[string]*MultipartUpload)
 	partCounter := 0
+	var mu sync.Mutex // parts arrive concurrently
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
 		// Verify required headers
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -948,7 +1177,7 @@ func TestLightweightS3Service_UploadStream_SmallFile(t *testing.T) {
 		smallData[i] = byte(i % 256)
 	}
 
-	err := service.UploadStream("small/file.bin", bytes.NewReader(smallData))
+	err := service.UploadStream(context.Background(), "small/file.bin", bytes.NewReader(smallData))
 	assert.NoError(t, err)
 
 	storedData, exists := files["small/file.bin"]
@@ -975,7 +1204,7 @@ func TestLightweightS3Service_UploadStream_LargeFile(t *testing.T) {
 		largeData[i] = byte(i % 256)
 	}
 
-	err := service.UploadStream("large/file.bin", bytes.NewReader(largeData))
+	err := service.UploadStream(context.Background(), "large/file.bin", bytes.NewReader(largeData))
 	assert.NoError(t, err)
 
 	// Check that multipart upload was used (parts should exist)
@@ -984,6 +1213,141 @@ func TestLightweightS3Service_UploadStream_LargeFile(t *testing.T) {
 	assert.True(t, part1Exists || part2Exists, "Multipart upload should have been used")
 }
 
+// createMockPartServer returns a server that records multipart parts and
+// how many were in flight at once. Parts listed in failParts are rejected.
+func createMockPartServer(t *testing.T, failParts map[string]bool) (*httptest.Server, *mockPartRecorder) {
+	recorder := &mockPartRecorder{parts: make(map[string][]byte)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		switch {
+		case r.Method == "POST" && query.Has("uploads"):
+			w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>test-bucket</Bucket><Key>big.bin</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+
+		case r.Method == "PUT" && query.Get("partNumber") != "":
+			inFlight := recorder.inFlight.Add(1)
+			defer recorder.inFlight.Add(-1)
+			for {
+				peak := recorder.peak.Load()
+				if inFlight <= peak || recorder.peak.CompareAndSwap(peak, inFlight) {
+					break
+				}
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			time.Sleep(20 * time.Millisecond) // keep parts overlapping
+
+			partNumber := query.Get("partNumber")
+			if failParts[partNumber] {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`<Error><Code>InternalError</Code><Message>part failed</Message></Error>`))
+				return
+			}
+
+			recorder.mu.Lock()
+			recorder.parts[partNumber] = body
+			recorder.mu.Unlock()
+			w.Header().Set("ETag", `"etag-`+partNumber+`"`)
+
+		case r.Method == "POST" && query.Get("uploadId") != "":
+			body, _ := io.ReadAll(r.Body)
+			recorder.mu.Lock()
+			recorder.completeXML = string(body)
+			recorder.mu.Unlock()
+			w.Write([]byte(`<CompleteMultipartUploadResult/>`))
+
+		case r.Method == "DELETE" && query.Get("uploadId") != "":
+			recorder.aborted.Store(true)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	return server, recorder
+}
+
+type mockPartRecorder struct {
+	mu          sync.Mutex
+	parts       map[string][]byte
+	completeXML string
+	inFlight    atomic.Int32
+	peak        atomic.Int32
+	aborted     atomic.Bool
+}
+
+func TestLightweightS3Service_UploadStream_ParallelParts(t *testing.T) {
+	server, recorder := createMockPartServer(t, nil)
+	defer server.Close()
+
+	service := &LightweightS3Service{
+		accessKey:         "test-access-key",
+		secretKey:         "test-secret-key",
+		region:            "us-east-1",
+		endpoint:          server.URL,
+		bucket:            "test-bucket",
+		client:            server.Client(),
+		partSize:          minPartSize,
+		uploadConcurrency: 3,
+	}
+
+	data := make([]byte, 6*minPartSize+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	require.NoError(t, service.UploadStream(context.Background(), "big.bin", bytes.NewReader(data)))
+
+	// Parts overlapped, but never beyond the configured concurrency
+	assert.Greater(t, recorder.peak.Load(), int32(1))
+	assert.LessOrEqual(t, recorder.peak.Load(), int32(3))
+	assert.False(t, recorder.aborted.Load())
+
+	// Every part arrived intact and the completion lists them in order
+	require.Len(t, recorder.parts, 7)
+	var combined []byte
+	for i := 1; i <= 7; i++ {
+		combined = append(combined, recorder.parts[fmt.Sprint(i)]...)
+	}
+	assert.True(t, bytes.Equal(data, combined))
+
+	var expected strings.Builder
+	for i := 1; i <= 7; i++ {
+		fmt.Fprintf(&expected, "<Part><PartNumber>%d</PartNumber><ETag>etag-%d</ETag></Part>", i, i)
+	}
+	assert.Contains(t, recorder.completeXML, expected.String())
+}
+
+func TestLightweightS3Service_UploadStream_PartFailureAborts(t *testing.T) {
+	server, recorder := createMockPartServer(t, map[string]bool{"2": true})
+
+	service := &LightweightS3Service{
+		accessKey:         "test-access-key",
+		secretKey:         "test-secret-key",
+		region:            "us-east-1",
+		endpoint:          server.URL,
+		bucket:            "test-bucket",
+		client:            server.Client(),
+		partSize:          minPartSize,
+		uploadConcurrency: 2,
+	}
+
+	data := make([]byte, 20*minPartSize)
+	err := service.UploadStream(context.Background(), "big.bin", bytes.NewReader(data))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to upload part 2")
+
+	// Wait for handlers of cancelled parts to finish
+	server.Close()
+
+	// The remaining parts were cancelled and the upload aborted
+	assert.True(t, recorder.aborted.Load())
+	assert.Less(t, len(recorder.parts), 19)
+	assert.Empty(t, recorder.completeXML)
+}
+
 func TestLightweightS3Service_MultipartUploadInitiate(t *testing.T) {
 	service := &LightweightS3Service{
 		accessKey: "test-access-key",
@@ -1032,6 +1396,58 @@ func TestLightweightS3Service_UploadPart(t *testing.T) {
 	})
 }
 
+func TestLightweightS3Service_ClientMultipartUpload(t *testing.T) {
+	server, _ := createMockMultipartS3Server(t)
+	defer server.Close()
+
+	service := &LightweightS3Service{
+		accessKey: "test-access-key",
+		secretKey: "test-secret-key",
+		region:    "us-east-1",
+		endpoint:  server.URL,
+		bucket:    "test-bucket",
+		client:    server.Client(),
+	}
+
+	var _ PresignedPartUploader = service
+
+	uploadID, err := service.CreateMultipartUpload(context.Background(), "direct/file.bin", "video/mp4")
+	require.NoError(t, err)
+	assert.NotEmpty(t, uploadID)
+
+	etag, err := service.UploadPart(context.Background(), "direct/file.bin", uploadID, 1, []byte("part one"))
+	require.NoError(t, err)
+	assert.Equal(t, "etag-part-1", etag)
+
+	partURL, err := service.PresignUploadPart(context.Background(), "direct/file.bin", uploadID, 2, 30)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(partURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "2", query.Get("partNumber"))
+	assert.Equal(t, uploadID, query.Get("uploadId"))
+	assert.Equal(t, "1800", query.Get("X-Amz-Expires"))
+	assert.NotEmpty(t, query.Get("X-Amz-Signature"))
+
+	_, err = service.PresignUploadPart(context.Background(), "direct/file.bin", uploadID, 0, 30)
+	assert.Error(t, err)
+	_, err = service.PresignUploadPart(context.Background(), "direct/file.bin", "", 1, 30)
+	assert.Error(t, err)
+
+	err = service.CompleteMultipartUpload(context.Background(), "direct/file.bin", uploadID, []CompletedPart{
+		{PartNumber: 2, ETag: `"etag-2"`},
+		{PartNumber: 1, ETag: `"etag-1"`},
+	})
+	assert.NoError(t, err)
+
+	assert.Error(t, service.CompleteMultipartUpload(context.Background(), "direct/file.bin", uploadID, nil))
+
+	otherID, err := service.CreateMultipartUpload(context.Background(), "direct/other.bin", "")
+	require.NoError(t, err)
+	assert.NoError(t, service.AbortMultipartUpload(context.Background(), "direct/other.bin", otherID))
+}
+
 func TestBufferedReader(t *testing.T) {
 	t.Run("Small data", func(t *testing.T) {
 		data := []byte("small content")
@@ -1081,11 +1497,248 @@ func TestLightweightS3Service_MultipartUploadErrorHandling(t *testing.T) {
 		largeData[i] = byte(i % 256)
 	}
 
-	err := service.UploadStream("test/large.bin", bytes.NewReader(largeData))
+	err := service.UploadStream(context.Background(), "test/large.bin", bytes.NewReader(largeData))
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to initiate multipart upload")
 }
 
+func TestLightweightS3Service_ListObjects(t *testing.T) {
+	keys := []string{"blobs/sha256/ab/cd/abcd", "users/u1/f1/a.txt", "users/u1/f2/b.txt", "users/u2/f3/c.txt"}
+	var tokens []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/test-bucket", r.URL.Path)
+		assert.Equal(t, "2", query.Get("list-type"))
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+
+		// Serve two keys per page, using the next index as the token
+		var matching []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, query.Get("prefix")) {
+				matching = append(matching, key)
+			}
+		}
+		start := 0
+		if token := query.Get("continuation-token"); token != "" {
+			tokens = append(tokens, token)
+			fmt.Sscanf(token, "page+%d", &start)
+		}
+		end := min(start+2, len(matching))
+
+		fmt.Fprint(w, `<ListBucketResult>`)
+		for _, key := range matching[start:end] {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2024-01-02T03:04:05.000Z</LastModified><ETag>"etag"</ETag></Contents>`,
+				html.EscapeString(key), len(key))
+		}
+		if end < len(matching) {
+			fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextContinuationToken>page+%d</NextContinuationToken>`, end)
+		} else {
+			fmt.Fprint(w, `<IsTruncated>false</IsTruncated>`)
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	}))
+	defer server.Close()
+
+	service := newRetryingTestService(server, 0)
+
+	t.Run("All pages", func(t *testing.T) {
+		var listed []ObjectInfo
+		err := service.ListObjects(context.Background(), "", func(obj ObjectInfo) error {
+			listed = append(listed, obj)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, listed, len(keys))
+		for i, obj := range listed {
+			assert.Equal(t, keys[i], obj.Key)
+			assert.Equal(t, int64(len(keys[i])), obj.Size)
+			assert.Equal(t, "etag", obj.ETag)
+			assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), obj.LastModified.UTC())
+		}
+		assert.Equal(t, []string{"page+2"}, tokens)
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		var listed []string
+		err := service.ListObjects(context.Background(), "users/u1/", func(obj ObjectInfo) error {
+			listed = append(listed, obj.Key)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"users/u1/f1/a.txt", "users/u1/f2/b.txt"}, listed)
+	})
+
+	t.Run("Callback error stops listing", func(t *testing.T) {
+		stop := fmt.Errorf("stop")
+		calls := 0
+		err := service.ListObjects(context.Background(), "", func(obj ObjectInfo) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+// createFlakyS3Server returns a server that answers the first failures
+// requests with the given status and error code, then succeeds
+func createFlakyS3Server(t *testing.T, failures int, status int, code string) (*httptest.Server, *atomic.Int32, map[string][]byte) {
+	var requests atomic.Int32
+	files := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+
+		if int(n) <= failures {
+			w.WriteHeader(status)
+			if code != "" {
+				fmt.Fprintf(w, `<Error><Code>%s</Code><Message>try again</Message></Error>`, code)
+			}
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+		switch r.Method {
+		case "PUT":
+			files[key] = body
+			w.WriteHeader(http.StatusOK)
+		case "GET":
+			w.Write(files[key])
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	return server, &requests, files
+}
+
+func newRetryingTestService(server *httptest.Server, maxRetries int) *LightweightS3Service {
+	return &LightweightS3Service{
+		accessKey:      "test-access-key",
+		secretKey:      "test-secret-key",
+		region:         "us-east-1",
+		endpoint:       server.URL,
+		bucket:         "test-bucket",
+		client:         server.Client(),
+		maxRetries:     maxRetries,
+		retryBaseDelay: time.Millisecond,
+	}
+}
+
+func TestLightweightS3Service_RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		code   string
+	}{
+		{"Service unavailable", http.StatusServiceUnavailable, ""},
+		{"SlowDown", http.StatusServiceUnavailable, "SlowDown"},
+		{"Internal error", http.StatusInternalServerError, "InternalError"},
+		{"RequestTimeout", http.StatusBadRequest, "RequestTimeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests, files := createFlakyS3Server(t, 2, tt.status, tt.code)
+			defer server.Close()
+
+			service := newRetryingTestService(server, 3)
+
+			err := service.UploadFile(context.Background(), "retry/file.txt", bytes.NewReader([]byte("content")), 7, "text/plain")
+			require.NoError(t, err)
+
+			assert.Equal(t, int32(3), requests.Load())
+			assert.Equal(t, []byte("content"), files["retry/file.txt"], "Retried upload should resend the whole body")
+		})
+	}
+}
+
+func TestLightweightS3Service_RetriesExhausted(t *testing.T) {
+	server, requests, _ := createFlakyS3Server(t, 10, http.StatusServiceUnavailable, "SlowDown")
+	defer server.Close()
+
+	service := newRetryingTestService(server, 2)
+
+	_, err := service.GetFileMetadata(context.Background(), "retry/file.txt")
+	require.Error(t, err)
+	assert.True(t, IsRetryableError(err))
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestLightweightS3Service_NoRetryOnClientErrors(t *testing.T) {
+	server, requests, _ := createFlakyS3Server(t, 10, http.StatusForbidden, "AccessDenied")
+	defer server.Close()
+
+	service := newRetryingTestService(server, 3)
+
+	err := service.DeleteFile(context.Background(), "retry/file.txt")
+	require.Error(t, err)
+	assert.False(t, IsRetryableError(err))
+	assert.Contains(t, err.Error(), "AccessDenied")
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestLightweightS3Service_NoRetryForUnseekableBody(t *testing.T) {
+	server, requests, _ := createFlakyS3Server(t, 1, http.StatusServiceUnavailable, "")
+	defer server.Close()
+
+	service := newRetryingTestService(server, 3)
+
+	reader := io.MultiReader(strings.NewReader("content"))
+	err := service.UploadFile(context.Background(), "retry/file.txt", reader, 7, "text/plain")
+	require.Error(t, err)
+	assert.True(t, IsRetryableError(err))
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestLightweightS3Service_ContextCancellation(t *testing.T) {
+	t.Run("Cancelled before the request", func(t *testing.T) {
+		server, requests, _ := createFlakyS3Server(t, 0, 0, "")
+		defer server.Close()
+
+		service := newRetryingTestService(server, 3)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := service.DownloadFile(ctx, "retry/file.txt")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int32(0), requests.Load())
+	})
+
+	t.Run("Cancelled during backoff", func(t *testing.T) {
+		server, requests, _ := createFlakyS3Server(t, 10, http.StatusServiceUnavailable, "")
+		defer server.Close()
+
+		service := newRetryingTestService(server, 5)
+		service.retryBaseDelay = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := service.FileExists(ctx, "retry/file.txt")
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func TestS3Error_Retryable(t *testing.T) {
+	assert.True(t, (&S3Error{StatusCode: 503}).Retryable())
+	assert.True(t, (&S3Error{StatusCode: 500, Code: "InternalError"}).Retryable())
+	assert.True(t, (&S3Error{StatusCode: 503, Code: "SlowDown"}).Retryable())
+	assert.True(t, (&S3Error{StatusCode: 400, Code: "RequestTimeout"}).Retryable())
+	assert.False(t, (&S3Error{StatusCode: 403, Code: "AccessDenied"}).Retryable())
+	assert.False(t, IsRetryableError(ErrFileNotFound))
+	assert.True(t, IsRetryableError(fmt.Errorf("wrapped: %w", &S3Error{StatusCode: 502})))
+}
+
 // Benchmark tests
 func BenchmarkLightweightS3Service_SignRequest(b *testing.B) {
 	service := &LightweightS3Service{
@@ -1127,7 +1780,7 @@ func BenchmarkLightweightS3Service_UploadStream_Small(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		key := fmt.Sprintf("bench/test_%d.bin", i)
-		err := service.UploadStream(key, bytes.NewReader(data))
+		err := service.UploadStream(context.Background(), key, bytes.NewReader(data))
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"path/filepath"
 	"strings"
@@ -18,6 +20,34 @@ var (
 	ErrConnectionFailed = errors.New("failed to connect to S3")
 )
 
+// DeleteFilesError is returned by a batch delete that couldn't remove some
+// of its keys; the others were deleted
+type DeleteFilesError struct {
+	Keys []string // The keys that are still stored
+	Err  error
+}
+
+func (e *DeleteFilesError) Error() string {
+	return fmt.Sprintf("%d of the objects could not be deleted: %v", len(e.Keys), e.Err)
+}
+
+func (e *DeleteFilesError) Unwrap() error {
+	return e.Err
+}
+
+// FailedKeys returns the keys a failed batch delete of keys left behind:
+// those listed by a DeleteFilesError, or all of them for any other error
+func FailedKeys(err error, keys []string) []string {
+	if err == nil {
+		return nil
+	}
+	var deleteErr *DeleteFilesError
+	if errors.As(err, &deleteErr) {
+		return deleteErr.Keys
+	}
+	return keys
+}
+
 // FileMetadata represents metadata about a file stored in S3
 type FileMetadata struct {
 	Size         int64
@@ -26,31 +56,99 @@ type FileMetadata struct {
 	ETag         string
 }
 
-// S3Service defines the interface for S3 operations
+// PresignOptions sets the response headers storage should send when a
+// presigned download URL is used
+type PresignOptions struct {
+	ContentDisposition string
+	ContentType        string
+}
+
+// S3Service defines the interface for S3 operations. Every method takes a
+// context, so a cancelled request also cancels the storage call.
 type S3Service interface {
 	// UploadFile uploads a file with known size to S3
-	UploadFile(key string, reader io.Reader, size int64, contentType string) error
+	UploadFile(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error
 
 	// UploadStream uploads a file using streaming (multipart upload for large files)
-	UploadStream(key string, reader io.Reader) error
+	UploadStream(ctx context.Context, key string, reader io.Reader) error
 
 	// DownloadFile downloads a file from S3 as a stream
-	DownloadFile(key string) (io.ReadCloser, error)
+	DownloadFile(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// DownloadRange downloads part of a file from S3 as a stream, starting
+	// at offset. A negative length reads to the end of the file.
+	DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
 
 	// DeleteFile deletes a single file from S3
-	DeleteFile(key string) error
+	DeleteFile(ctx context.Context, key string) error
+
+	// DeleteFiles deletes multiple files from S3 (batch operation). When
+	// only some keys fail, it returns a *DeleteFilesError listing them.
+	DeleteFiles(ctx context.Context, keys []string) error
 
-	// DeleteFiles deletes multiple files from S3 (batch operation)
-	DeleteFiles(keys []string) error
+	// CopyObject copies an object to a new key inside storage, without the
+	// bytes passing through the application
+	CopyObject(ctx context.Context, srcKey, dstKey string) error
 
 	// GetPresignedURL generates a time-limited presigned URL for file access
-	GetPresignedURL(key string, expirationMinutes int) (string, error)
+	GetPresignedURL(ctx context.Context, key string, expirationMinutes int) (string, error)
+
+	// GetPresignedDownloadURL generates a time-limited presigned URL for
+	// downloading a file, with response header overrides
+	GetPresignedDownloadURL(ctx context.Context, key string, expirationMinutes int, opts PresignOptions) (string, error)
 
 	// FileExists checks if a file exists in S3
-	FileExists(key string) (bool, error)
+	FileExists(ctx context.Context, key string) (bool, error)
 
 	// GetFileMetadata retrieves metadata about a file
-	GetFileMetadata(key string) (*FileMetadata, error)
+	GetFileMetadata(ctx context.Context, key string) (*FileMetadata, error)
+}
+
+// CompletedPart identifies an uploaded part when completing a multipart upload
+type CompletedPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// MultipartUploader is implemented by storage backends that can assemble
+// an object from separately uploaded parts
+type MultipartUploader interface {
+	// CreateMultipartUpload starts a multipart upload and returns its upload ID
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+
+	// UploadPart uploads one part and returns its ETag
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error)
+
+	// CompleteMultipartUpload assembles the uploaded parts into the final object
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+
+	// AbortMultipartUpload discards a multipart upload and its parts
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// PresignedPartUploader is implemented by storage backends that also let
+// clients upload parts directly, through presigned URLs
+type PresignedPartUploader interface {
+	MultipartUploader
+
+	// PresignUploadPart generates a time-limited URL for PUTting one part
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int, expirationMinutes int) (string, error)
+}
+
+// ObjectInfo describes one object returned by a listing
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// ObjectLister is implemented by storage backends that can enumerate the
+// objects they hold
+type ObjectLister interface {
+	// ListObjects calls fn for every object whose key starts with prefix.
+	// Listing stops at the first error fn returns.
+	ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
 }
 
 // GenerateS3Key generates a unique S3 key for storing a file.
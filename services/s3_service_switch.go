@@ -4,8 +4,12 @@ import (
 	"github.com/jd-boyd/filesonthego/config"
 )
 
-// NewS3Service creates a new S3 service instance using the lightweight implementation
-// This replaces the AWS SDK implementation for better build performance
+// NewS3Service creates a new storage service instance for the configured backend.
+// The "s3" backend uses the lightweight implementation, which replaces the AWS SDK
+// for better build performance. The "local" backend stores objects on disk.
 func NewS3Service(cfg *config.Config) (S3Service, error) {
+	if cfg.UsesLocalStorage() {
+		return NewLocalStorageService(cfg)
+	}
 	return NewLightweightS3Service(cfg)
-}
\ No newline at end of file
+}
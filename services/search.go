@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// Search result types
+const (
+	SearchTypeFile      = "file"
+	SearchTypeDirectory = "directory"
+)
+
+// SearchOptions selects a user's files and directories. Empty fields don't
+// filter. The extension, MIME type and size filters only apply to files, so
+// directories are only searched when none of them is set.
+type SearchOptions struct {
+	Query          string // Substring of the name, or words in the content, matched without regard to case
+	Type           string // SearchTypeFile or SearchTypeDirectory to search only one kind
+	Extension      string // With or without the dot
+	MimePrefix     string // Like "image/"
+	MinSize        *int64
+	MaxSize        *int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Tags           []string // Only items with every one of these tags, by name
+	Limit          int      // DefaultListLimit if zero, at most MaxListLimit
+}
+
+// searchesFiles reports whether files match the options' type
+func (o SearchOptions) searchesFiles() bool {
+	return o.Type != SearchTypeDirectory
+}
+
+// searchesDirectories reports whether directories can match the options
+func (o SearchOptions) searchesDirectories() bool {
+	return o.Type != SearchTypeFile && o.Extension == "" && o.MimePrefix == "" && o.MinSize == nil && o.MaxSize == nil
+}
+
+// SearchResult is a file or directory found by a search, with the trail of
+// directories it is in, from the root directory down. Items in the root
+// directory have no breadcrumbs. Files whose content matched have a
+// snippet of it, HTML escaped, with the matches in <mark> elements.
+type SearchResult struct {
+	Type        string               `json:"type"`
+	Directory   *models.Directory    `json:"directory,omitempty"`
+	File        *models.File         `json:"file,omitempty"`
+	Breadcrumbs []*models.Breadcrumb `json:"breadcrumbs"`
+	Tags        []*models.Tag        `json:"tags"`
+	Snippet     string               `json:"snippet,omitempty"`
+}
+
+// contentWords finds the words of a query for the content index
+var contentWords = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// SearchResults is the first Limit matches of a search, directories before
+// files, each by name
+type SearchResults struct {
+	Results []SearchResult `json:"results"`
+	Total   int64          `json:"total"` // Matches, including those past the limit
+}
+
+// Search finds userID's files and directories matching opts. Name queries
+// go through the FTS5 search index (see models.MigrateSearchIndex); the
+// trigram index needs three characters, so shorter queries scan the names.
+// With content indexing enabled, files also match on the words of the
+// query in their text.
+func (s *FileService) Search(userID string, opts SearchOptions) (*SearchResults, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+
+	results := &SearchResults{Results: []SearchResult{}}
+	crumbs := make(map[string][]*models.Breadcrumb)
+	remaining := opts.Limit
+
+	if opts.searchesDirectories() {
+		query := s.searchQuery(&models.Directory{}, models.DirectorySearchTable, userID, opts, "")
+		query = s.tagFilter(query, models.ResourceTypeDirectory, userID, opts.Tags).Session(&gorm.Session{})
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, err
+		}
+		results.Total += total
+
+		var dirs []*models.Directory
+		if err := query.Order("name ASC").Order("id ASC").Limit(remaining).Find(&dirs).Error; err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(dirs))
+		for i, dir := range dirs {
+			ids[i] = dir.ID
+		}
+		tags, err := s.tagsOf(models.ResourceTypeDirectory, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			breadcrumbs, err := s.breadcrumbs(dir.ParentDirectory, crumbs)
+			if err != nil {
+				return nil, err
+			}
+			results.Results = append(results.Results, SearchResult{
+				Type:        SearchTypeDirectory,
+				Directory:   dir,
+				Breadcrumbs: breadcrumbs,
+				Tags:        tagList(tags[dir.ID]),
+			})
+		}
+		remaining -= len(dirs)
+	}
+
+	if opts.searchesFiles() {
+		contentMatch := ""
+		if s.config.ContentIndexEnabled {
+			contentMatch = contentMatchQuery(opts.Query)
+		}
+
+		query := s.searchQuery(&models.File{}, models.FileSearchTable, userID, opts, contentMatch)
+		query = s.fileFilter(query, ListOptions{MimePrefix: opts.MimePrefix, Extension: opts.Extension})
+		query = s.tagFilter(query, models.ResourceTypeFile, userID, opts.Tags)
+		if opts.MinSize != nil {
+			query = query.Where("size >= ?", *opts.MinSize)
+		}
+		if opts.MaxSize != nil {
+			query = query.Where("size <= ?", *opts.MaxSize)
+		}
+		query = query.Session(&gorm.Session{})
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, err
+		}
+		results.Total += total
+
+		if remaining > 0 {
+			var files []*models.File
+			if err := query.Order("name ASC").Order("id ASC").Limit(remaining).Find(&files).Error; err != nil {
+				return nil, err
+			}
+			snippets, err := s.contentSnippets(contentMatch, files)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]string, len(files))
+			for i, file := range files {
+				ids[i] = file.ID
+			}
+			tags, err := s.tagsOf(models.ResourceTypeFile, ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				breadcrumbs, err := s.breadcrumbs(file.ParentDirectory, crumbs)
+				if err != nil {
+					return nil, err
+				}
+				results.Results = append(results.Results, SearchResult{
+					Type:        SearchTypeFile,
+					File:        file,
+					Breadcrumbs: breadcrumbs,
+					Tags:        tagList(tags[file.ID]),
+					Snippet:     snippets[file.ID],
+				})
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// searchQuery returns a query for userID's records of model that match the
+// name query and modification dates in opts, using the search table index.
+// Records also match if contentMatch, when set, matches their text in the
+// content index.
+func (s *FileService) searchQuery(model interface{}, index, userID string, opts SearchOptions, contentMatch string) *gorm.DB {
+	query := s.db.Model(model).Where("user = ?", userID)
+
+	if opts.Query != "" {
+		var match *gorm.DB
+		if utf8.RuneCountInString(opts.Query) >= 3 {
+			// A quoted phrase matches as a substring with the trigram tokenizer
+			phrase := `{name} : "` + strings.ReplaceAll(opts.Query, `"`, `""`) + `"`
+			match = s.db.Where("rowid IN (SELECT rowid FROM "+index+" WHERE "+index+" MATCH ?)", phrase)
+		} else {
+			match = s.db.Where(`LOWER(name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(opts.Query))+"%")
+		}
+		if contentMatch != "" {
+			match = match.Or("id IN (SELECT fc.file_id FROM file_contents fc JOIN "+models.ContentSearchTable+
+				" ON "+models.ContentSearchTable+".rowid = fc.doc_id WHERE "+models.ContentSearchTable+" MATCH ?)", contentMatch)
+		}
+		query = query.Where(match)
+	}
+	if !opts.ModifiedAfter.IsZero() {
+		query = query.Where("updated_at >= ?", opts.ModifiedAfter.UTC())
+	}
+	if !opts.ModifiedBefore.IsZero() {
+		query = query.Where("updated_at < ?", opts.ModifiedBefore.UTC())
+	}
+	return query.Session(&gorm.Session{})
+}
+
+// contentMatchQuery returns the content index query for a search query: its
+// words as a phrase, the last one as a prefix so results come as the user
+// types. It is empty if the query has no words.
+func contentMatchQuery(query string) string {
+	words := contentWords.FindAllString(query, -1)
+	if len(words) == 0 {
+		return ""
+	}
+	return `"` + strings.Join(words, " ") + `" *`
+}
+
+// contentSnippets returns snippets of the text matching contentMatch in
+// files, by file ID, for those files whose content matched
+func (s *FileService) contentSnippets(contentMatch string, files []*models.File) (map[string]string, error) {
+	snippets := make(map[string]string)
+	if contentMatch == "" || len(files) == 0 {
+		return snippets, nil
+	}
+
+	ids := make([]string, len(files))
+	for i, file := range files {
+		ids[i] = file.ID
+	}
+
+	// The matches are marked with control characters, replaced with tags
+	// once the text around them is escaped
+	var rows []struct {
+		FileID  string
+		Snippet string
+	}
+	err := s.db.Raw("SELECT fc.file_id AS file_id, snippet("+models.ContentSearchTable+", 0, char(2), char(3), '…', 16) AS snippet"+
+		" FROM "+models.ContentSearchTable+" JOIN file_contents fc ON fc.doc_id = "+models.ContentSearchTable+".rowid"+
+		" WHERE "+models.ContentSearchTable+" MATCH ? AND fc.file_id IN ?", contentMatch, ids).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	marks := strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+	for _, row := range rows {
+		snippets[row.FileID] = marks.Replace(html.EscapeString(row.Snippet))
+	}
+	return snippets, nil
+}
+
+// tagList returns tags, or an empty list for an item without tags
+func tagList(tags []*models.Tag) []*models.Tag {
+	if tags == nil {
+		return []*models.Tag{}
+	}
+	return tags
+}
+
+// breadcrumbs returns the breadcrumbs for an item in directory dirID,
+// caching them by directory
+func (s *FileService) breadcrumbs(dirID string, cache map[string][]*models.Breadcrumb) ([]*models.Breadcrumb, error) {
+	if dirID == "" {
+		return []*models.Breadcrumb{}, nil
+	}
+	if breadcrumbs, ok := cache[dirID]; ok {
+		return breadcrumbs, nil
+	}
+
+	var dir models.Directory
+	if err := s.db.First(&dir, "id = ?", dirID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []*models.Breadcrumb{}, nil
+		}
+		return nil, err
+	}
+	breadcrumbs, err := dir.GetBreadcrumbs(s.db)
+	if err != nil {
+		return nil, err
+	}
+	cache[dirID] = breadcrumbs
+	return breadcrumbs, nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService_Search(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+
+	user, err := service.userService.CreateUser("search@example.com", "searchuser", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("search2@example.com", "searchuser2", "Password123!", false)
+	require.NoError(t, err)
+
+	// Rows written before the index exists are picked up when it is built
+	projects := createTestDirectory(t, db, user.ID, nil, "Projects")
+	require.NoError(t, models.MigrateSearchIndex(db))
+
+	reports := createTestDirectory(t, db, user.ID, projects, "Quarterly Reports")
+	q1 := createTestTreeFile(t, storage, db, user.ID, reports, "q1-report.pdf", "1234567890")
+	createTestTreeFile(t, storage, db, user.ID, reports, "q2-report.PDF", "12345")
+	createTestTreeFile(t, storage, db, user.ID, projects, "photo.png", "123")
+	createTestTreeFile(t, storage, db, user.ID, nil, "report-notes.txt", "1")
+	createTestTreeFile(t, storage, db, other.ID, nil, "other-report.pdf", "1")
+	require.NoError(t, db.Model(&models.File{}).Where("name = ?", "photo.png").Update("mime_type", "image/png").Error)
+
+	search := func(opts SearchOptions) []string {
+		t.Helper()
+		results, err := service.Search(user.ID, opts)
+		require.NoError(t, err)
+		var names []string
+		for _, result := range results.Results {
+			if result.Directory != nil {
+				names = append(names, result.Directory.Name+"/")
+			} else {
+				names = append(names, result.File.Name)
+			}
+		}
+		return names
+	}
+
+	t.Run("Matches name substrings", func(t *testing.T) {
+		assert.Equal(t, []string{"Quarterly Reports/", "q1-report.pdf", "q2-report.PDF", "report-notes.txt"}, search(SearchOptions{Query: "REPORT"}))
+		assert.Equal(t, []string{"photo.png", "report-notes.txt"}, search(SearchOptions{Query: "ot"}), "Short queries scan the names")
+		assert.Empty(t, search(SearchOptions{Query: `q1"`}), "Quotes are matched literally")
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		assert.Equal(t, []string{"q1-report.pdf", "q2-report.PDF"}, search(SearchOptions{Query: "report", Extension: "pdf"}))
+		assert.Equal(t, []string{"photo.png"}, search(SearchOptions{MimePrefix: "image/"}))
+		minSize, maxSize := int64(3), int64(5)
+		assert.Equal(t, []string{"photo.png", "q2-report.PDF"}, search(SearchOptions{MinSize: &minSize, MaxSize: &maxSize}))
+		assert.Equal(t, []string{"Projects/", "Quarterly Reports/"}, search(SearchOptions{Type: SearchTypeDirectory}))
+		assert.Empty(t, search(SearchOptions{ModifiedAfter: time.Now().Add(time.Hour)}))
+		assert.Len(t, search(SearchOptions{ModifiedBefore: time.Now().Add(time.Hour)}), 6)
+	})
+
+	t.Run("Results carry breadcrumbs and a total", func(t *testing.T) {
+		results, err := service.Search(user.ID, SearchOptions{Query: "q1-"})
+		require.NoError(t, err)
+		require.Len(t, results.Results, 1)
+		var trail []string
+		for _, crumb := range results.Results[0].Breadcrumbs {
+			trail = append(trail, crumb.Name)
+		}
+		assert.Equal(t, []string{"Projects", "Quarterly Reports"}, trail)
+
+		results, err = service.Search(user.ID, SearchOptions{Query: "report", Limit: 2})
+		require.NoError(t, err)
+		assert.Len(t, results.Results, 2)
+		assert.Equal(t, int64(4), results.Total)
+	})
+
+	t.Run("The index follows renames, trash and deletes", func(t *testing.T) {
+		name := "annual-summary.pdf"
+		_, err := service.MoveFile(user.ID, q1.ID, MoveOptions{Name: &name})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"annual-summary.pdf"}, search(SearchOptions{Query: "summary"}))
+		assert.NotContains(t, search(SearchOptions{Query: "report"}), "q1-report.pdf")
+
+		_, err = service.TrashFile(user.ID, q1.ID)
+		require.NoError(t, err)
+		assert.Empty(t, search(SearchOptions{Query: "summary"}))
+
+		_, err = service.Restore(user.ID, q1.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"annual-summary.pdf"}, search(SearchOptions{Query: "summary"}))
+
+		require.NoError(t, db.Unscoped().Delete(&models.File{}, "id = ?", q1.ID).Error)
+		var indexed int64
+		require.NoError(t, db.Raw("SELECT COUNT(*) FROM file_search WHERE name = ?", name).Scan(&indexed).Error)
+		assert.Zero(t, indexed)
+	})
+}
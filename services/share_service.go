@@ -11,8 +11,9 @@ import (
 
 // ShareService handles share link operations
 type ShareService struct {
-	db     *gorm.DB
-	logger zerolog.Logger
+	db       *gorm.DB
+	logger   zerolog.Logger
+	activity *ActivityService // Records new shares, nil if not set
 }
 
 // NewShareService creates a new share service
@@ -23,6 +24,11 @@ func NewShareService(db *gorm.DB, logger zerolog.Logger) *ShareService {
 	}
 }
 
+// SetActivityService sets the service that records new shares
+func (s *ShareService) SetActivityService(activity *ActivityService) {
+	s.activity = activity
+}
+
 // CreateShare creates a new share link
 func (s *ShareService) CreateShare(userID, resourceID string, resourceType models.ResourceType, permissionType models.PermissionType, password string, expiresAt *time.Time) (*models.Share, error) {
 	share := &models.Share{
@@ -58,9 +64,35 @@ func (s *ShareService) CreateShare(userID, resourceID string, resourceType model
 		Str("permission_type", string(permissionType)).
 		Msg("Share created successfully")
 
+	s.recordShare(share)
+
 	return share, nil
 }
 
+// recordShare records a new share in its creator's activity, with the
+// shared item's name and the permission given
+func (s *ShareService) recordShare(share *models.Share) {
+	if s.activity == nil {
+		return
+	}
+
+	var model interface{}
+	resourceID := share.File
+	if share.ResourceType == models.ResourceTypeFile {
+		model = &models.File{}
+	} else {
+		model = &models.Directory{}
+		resourceID = share.Directory
+	}
+	var names []string
+	if err := s.db.Model(model).Where("id = ?", resourceID).Pluck("name", &names).Error; err != nil || len(names) == 0 {
+		s.logger.Warn().Err(err).Str("share_id", share.ID).Msg("Failed to look up the shared item for activity")
+		return
+	}
+
+	s.activity.Record(share.User, models.ActivityShare, share.ResourceType, resourceID, names[0], string(share.PermissionType))
+}
+
 // GetShare retrieves a share by ID
 func (s *ShareService) GetShare(shareID string) (*models.Share, error) {
 	var share models.Share
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// StarredItems are a user's starred directories and files
+type StarredItems struct {
+	Directories []*models.Directory `json:"directories"`
+	Files       []*models.File      `json:"files"`
+}
+
+// SetStarred stars or unstars userID's file or directory itemID. Starring
+// doesn't count as a change, so the item's updated time is left alone.
+func (s *FileService) SetStarred(userID string, resourceType models.ResourceType, itemID string, starred bool) error {
+	var model interface{}
+	switch resourceType {
+	case models.ResourceTypeFile:
+		model = &models.File{}
+	case models.ResourceTypeDirectory:
+		model = &models.Directory{}
+	default:
+		return fmt.Errorf("unknown resource type %q", resourceType)
+	}
+
+	result := s.db.Model(model).Where("id = ? AND user = ?", itemID, userID).UpdateColumn("starred", starred)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update starred: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListStarred returns userID's starred directories and files, by name.
+// Starred items in the trash are left out until they are restored.
+func (s *FileService) ListStarred(userID string) (*StarredItems, error) {
+	items := &StarredItems{
+		Directories: []*models.Directory{},
+		Files:       []*models.File{},
+	}
+	if err := s.db.Where("user = ? AND starred = ?", userID, true).Order("name ASC").Find(&items.Directories).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user = ? AND starred = ?", userID, true).Order("name ASC").Find(&items.Files).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
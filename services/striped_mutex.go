@@ -0,0 +1,23 @@
+package services
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// mutexStripes is the number of mutexes in a stripedMutex. Collisions only
+// cost a little concurrency.
+const mutexStripes = 64
+
+// stripedMutex serializes in-process operations on the same key without
+// keeping a mutex per key around
+type stripedMutex struct {
+	locks [mutexStripes]sync.Mutex
+}
+
+// lockFor returns the mutex guarding key
+func (m *stripedMutex) lockFor(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.locks[h.Sum32()%mutexStripes]
+}
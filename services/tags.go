@@ -0,0 +1,388 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tag and metadata limits
+const (
+	MaxTagLength           = 64
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 1024
+	MaxMetadataEntries     = 100 // Per file or directory
+)
+
+var (
+	ErrInvalidTag      = errors.New("invalid tag")
+	ErrInvalidMetadata = errors.New("invalid metadata")
+)
+
+var (
+	tagColorPattern    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// TagUsage is a tag with the number of live files and directories it is on
+type TagUsage struct {
+	models.Tag
+	Items int64 `json:"items"`
+}
+
+// ListTags returns userID's tags by name. With a prefix, only the tags
+// whose name starts with it, without regard to case, for autocomplete.
+// Limit is DefaultListLimit if zero, at most MaxListLimit.
+func (s *FileService) ListTags(userID, prefix string, limit int) ([]*TagUsage, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	query := s.db.Model(&models.Tag{}).
+		Select(`tags.*, (SELECT COUNT(*) FROM tag_links WHERE tag_links.tag = tags.id AND (
+			(tag_links.resource_type = ? AND tag_links.resource IN (SELECT id FROM files WHERE deleted_at IS NULL)) OR
+			(tag_links.resource_type = ? AND tag_links.resource IN (SELECT id FROM directories WHERE deleted_at IS NULL)))) AS items`,
+			models.ResourceTypeFile, models.ResourceTypeDirectory).
+		Where("user = ?", userID)
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		query = query.Where(`LOWER(name) LIKE ? ESCAPE '\'`, escapeLike(strings.ToLower(prefix))+"%")
+	}
+
+	tags := []*TagUsage{}
+	if err := query.Order("LOWER(name) ASC").Limit(limit).Scan(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// CreateTag creates a tag for userID. A tag with the same name fails with
+// ErrNameConflict.
+func (s *FileService) CreateTag(userID, name, color string) (*models.Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTagColor(color); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.findTag(userID, name); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNameConflict, name)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	tag := &models.Tag{User: userID, Name: name, Color: color}
+	if err := s.db.Create(tag).Error; err != nil {
+		return nil, fmt.Errorf("failed to create tag: %w", err)
+	}
+
+	s.logger.Info().Str("user_id", userID).Str("tag_id", tag.ID).Str("name", name).Msg("Tag created")
+	return tag, nil
+}
+
+// UpdateTag renames and/or recolors one of userID's tags. Nil fields are
+// left as they are; an empty color resets it to the default.
+func (s *FileService) UpdateTag(userID, tagID string, name, color *string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := s.db.First(&tag, "id = ? AND user = ?", tagID, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if name != nil {
+		normalized, err := normalizeTagName(*name)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := s.findTag(userID, normalized)
+		if err == nil && existing.ID != tag.ID {
+			return nil, fmt.Errorf("%w: %s", ErrNameConflict, normalized)
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		tag.Name = normalized
+	}
+	if color != nil {
+		if err := validateTagColor(*color); err != nil {
+			return nil, err
+		}
+		tag.Color = *color
+	}
+
+	err := s.db.Model(&models.Tag{}).Where("id = ?", tag.ID).Updates(map[string]interface{}{
+		"name":  tag.Name,
+		"color": tag.Color,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update tag: %w", err)
+	}
+
+	return &tag, nil
+}
+
+// DeleteTag deletes one of userID's tags, taking it off everything it is on
+func (s *FileService) DeleteTag(userID, tagID string) error {
+	var tag models.Tag
+	if err := s.db.First(&tag, "id = ? AND user = ?", tagID, userID).Error; err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tag = ?", tag.ID).Delete(&models.TagLink{}).Error; err != nil {
+			return fmt.Errorf("failed to delete tag links: %w", err)
+		}
+		if err := tx.Delete(&tag).Error; err != nil {
+			return fmt.Errorf("failed to delete tag: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info().Str("user_id", userID).Str("tag_id", tag.ID).Msg("Tag deleted")
+	return nil
+}
+
+// ItemTags returns the tags on one of userID's files or directories, by name
+func (s *FileService) ItemTags(userID string, resourceType models.ResourceType, itemID string) ([]*models.Tag, error) {
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return nil, err
+	}
+	tags, err := s.tagsOf(resourceType, []string{itemID})
+	if err != nil {
+		return nil, err
+	}
+	return tagList(tags[itemID]), nil
+}
+
+// TagItem puts the tag called name on one of userID's files or directories,
+// creating the tag if userID has none by that name
+func (s *FileService) TagItem(userID string, resourceType models.ResourceType, itemID, name string) (*models.Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return nil, err
+	}
+
+	var tag *models.Tag
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		tag, err = s.in(tx).findTag(userID, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tag = &models.Tag{User: userID, Name: name}
+			err = tx.Create(tag).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		link := &models.TagLink{Tag: tag.ID, ResourceType: resourceType, Resource: itemID}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(link).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to tag item: %w", err)
+	}
+
+	return tag, nil
+}
+
+// UntagItem takes a tag off one of userID's files or directories
+func (s *FileService) UntagItem(userID string, resourceType models.ResourceType, itemID, tagID string) error {
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return err
+	}
+
+	result := s.db.
+		Where("tag = ? AND resource_type = ? AND resource = ?", tagID, resourceType, itemID).
+		Delete(&models.TagLink{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to untag item: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ItemMetadata returns the metadata of one of userID's files or
+// directories, by key
+func (s *FileService) ItemMetadata(userID string, resourceType models.ResourceType, itemID string) (map[string]string, error) {
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return nil, err
+	}
+
+	var entries []*models.Metadata
+	if err := s.db.Where("resource_type = ? AND resource = ?", resourceType, itemID).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		metadata[entry.Key] = entry.Value
+	}
+	return metadata, nil
+}
+
+// SetItemMetadata sets a metadata key on one of userID's files or
+// directories, replacing its value if it is already set
+func (s *FileService) SetItemMetadata(userID string, resourceType models.ResourceType, itemID, key, value string) error {
+	if !metadataKeyPattern.MatchString(key) || len(key) > MaxMetadataKeyLength {
+		return fmt.Errorf("%w: keys are up to %d letters, digits, '_', '-' and '.'", ErrInvalidMetadata, MaxMetadataKeyLength)
+	}
+	if len(value) > MaxMetadataValueLength || !utf8.ValidString(value) {
+		return fmt.Errorf("%w: values are up to %d bytes of text", ErrInvalidMetadata, MaxMetadataValueLength)
+	}
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&models.Metadata{}).
+			Where("resource_type = ? AND resource = ? AND key <> ?", resourceType, itemID, key).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= MaxMetadataEntries {
+			return fmt.Errorf("%w: at most %d keys per item", ErrInvalidMetadata, MaxMetadataEntries)
+		}
+
+		entry := &models.Metadata{ResourceType: resourceType, Resource: itemID, Key: key, Value: value, User: userID}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error
+	})
+}
+
+// DeleteItemMetadata removes a metadata key from one of userID's files or
+// directories
+func (s *FileService) DeleteItemMetadata(userID string, resourceType models.ResourceType, itemID, key string) error {
+	if err := s.checkItemOwner(userID, resourceType, itemID); err != nil {
+		return err
+	}
+
+	result := s.db.
+		Where("resource_type = ? AND resource = ? AND key = ?", resourceType, itemID, key).
+		Delete(&models.Metadata{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete metadata: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// tagsOf returns the tags on the files or directories with ids, by item
+// ID, each by name
+func (s *FileService) tagsOf(resourceType models.ResourceType, ids []string) (map[string][]*models.Tag, error) {
+	tags := make(map[string][]*models.Tag)
+	for start := 0; start < len(ids); start += fileBatchSize {
+		batch := ids[start:min(start+fileBatchSize, len(ids))]
+
+		var rows []struct {
+			models.Tag
+			Resource string
+		}
+		err := s.db.Model(&models.Tag{}).
+			Select("tags.*, tag_links.resource AS resource").
+			Joins("JOIN tag_links ON tag_links.tag = tags.id").
+			Where("tag_links.resource_type = ? AND tag_links.resource IN ?", resourceType, batch).
+			Order("LOWER(tags.name) ASC").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			tag := rows[i].Tag
+			tags[rows[i].Resource] = append(tags[rows[i].Resource], &tag)
+		}
+	}
+	return tags, nil
+}
+
+// tagFilter limits a query of userID's files or directories to those with
+// every tag in names, matched without regard to case
+func (s *FileService) tagFilter(query *gorm.DB, resourceType models.ResourceType, userID string, names []string) *gorm.DB {
+	for _, name := range names {
+		query = query.Where(`id IN (SELECT tag_links.resource FROM tag_links JOIN tags ON tags.id = tag_links.tag
+			WHERE tag_links.resource_type = ? AND tags.user = ? AND LOWER(tags.name) = ?)`,
+			resourceType, userID, strings.ToLower(strings.TrimSpace(name)))
+	}
+	return query
+}
+
+// deleteLabels removes the tags and metadata of deleted files or
+// directories
+func (s *FileService) deleteLabels(resourceType models.ResourceType, ids []string) error {
+	if err := s.db.Where("resource_type = ? AND resource IN ?", resourceType, ids).Delete(&models.TagLink{}).Error; err != nil {
+		return fmt.Errorf("failed to delete tag links: %w", err)
+	}
+	if err := s.db.Where("resource_type = ? AND resource IN ?", resourceType, ids).Delete(&models.Metadata{}).Error; err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	return nil
+}
+
+// findTag returns userID's tag called name, without regard to case
+func (s *FileService) findTag(userID, name string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := s.db.First(&tag, "user = ? AND LOWER(name) = ?", userID, strings.ToLower(name)).Error; err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// checkItemOwner returns gorm.ErrRecordNotFound unless userID owns the live
+// file or directory itemID
+func (s *FileService) checkItemOwner(userID string, resourceType models.ResourceType, itemID string) error {
+	var model interface{}
+	switch resourceType {
+	case models.ResourceTypeFile:
+		model = &models.File{}
+	case models.ResourceTypeDirectory:
+		model = &models.Directory{}
+	default:
+		return fmt.Errorf("unknown resource type %q", resourceType)
+	}
+
+	var count int64
+	if err := s.db.Model(model).Where("id = ? AND user = ?", itemID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// normalizeTagName trims a tag name and checks it is usable
+func normalizeTagName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" || utf8.RuneCountInString(name) > MaxTagLength {
+		return "", fmt.Errorf("%w: names are 1 to %d characters", ErrInvalidTag, MaxTagLength)
+	}
+	for _, r := range name {
+		if r < 32 || r == 127 {
+			return "", fmt.Errorf("%w: control character in name", ErrInvalidTag)
+		}
+	}
+	return name, nil
+}
+
+// validateTagColor checks a tag color is empty or like #1e90ff
+func validateTagColor(color string) error {
+	if color != "" && !tagColorPattern.MatchString(color) {
+		return fmt.Errorf("%w: colors are like #1e90ff", ErrInvalidTag)
+	}
+	return nil
+}
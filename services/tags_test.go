@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestFileService_Tags(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	require.NoError(t, models.MigrateSearchIndex(db))
+
+	user, err := service.userService.CreateUser("tags@example.com", "tagsuser", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("tags2@example.com", "tagsuser2", "Password123!", false)
+	require.NoError(t, err)
+
+	acme := createTestDirectory(t, db, user.ID, nil, "Acme")
+	globex := createTestDirectory(t, db, user.ID, nil, "Globex")
+	brief := createTestTreeFile(t, storage, db, user.ID, nil, "brief.pdf", "brief")
+	invoice := createTestTreeFile(t, storage, db, user.ID, nil, "invoice.pdf", "invoice")
+	createTestTreeFile(t, storage, db, user.ID, nil, "notes.txt", "notes")
+	theirs := createTestTreeFile(t, storage, db, other.ID, nil, "theirs.pdf", "theirs")
+
+	names := func(tags []*models.Tag) []string {
+		var result []string
+		for _, tag := range tags {
+			result = append(result, tag.Name)
+		}
+		return result
+	}
+
+	t.Run("Create, rename and recolor", func(t *testing.T) {
+		tag, err := service.CreateTag(user.ID, "  In   review ", "#ff8800")
+		require.NoError(t, err)
+		assert.Equal(t, "In review", tag.Name, "Whitespace is collapsed")
+
+		_, err = service.CreateTag(user.ID, "in REVIEW", "")
+		assert.ErrorIs(t, err, ErrNameConflict, "Names are unique without regard to case")
+		_, err = service.CreateTag(other.ID, "In review", "")
+		assert.NoError(t, err, "Names are per user")
+
+		_, err = service.CreateTag(user.ID, "", "")
+		assert.ErrorIs(t, err, ErrInvalidTag)
+		_, err = service.CreateTag(user.ID, strings.Repeat("x", MaxTagLength+1), "")
+		assert.ErrorIs(t, err, ErrInvalidTag)
+		_, err = service.CreateTag(user.ID, "blue", "blue")
+		assert.ErrorIs(t, err, ErrInvalidTag)
+
+		renamed, empty := "Review", ""
+		updated, err := service.UpdateTag(user.ID, tag.ID, &renamed, &empty)
+		require.NoError(t, err)
+		assert.Equal(t, "Review", updated.Name)
+		assert.Empty(t, updated.Color)
+
+		_, err = service.UpdateTag(other.ID, tag.ID, &renamed, nil)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Other users' tags can't be changed")
+	})
+
+	t.Run("Tag items", func(t *testing.T) {
+		tag, err := service.TagItem(user.ID, models.ResourceTypeFile, brief.ID, "client: acme")
+		require.NoError(t, err)
+		again, err := service.TagItem(user.ID, models.ResourceTypeFile, brief.ID, "Client: ACME")
+		require.NoError(t, err, "Tagging twice is a no-op")
+		assert.Equal(t, tag.ID, again.ID, "Existing tags are reused without regard to case")
+
+		_, err = service.TagItem(user.ID, models.ResourceTypeFile, brief.ID, "review")
+		require.NoError(t, err)
+		_, err = service.TagItem(user.ID, models.ResourceTypeFile, invoice.ID, "client: acme")
+		require.NoError(t, err)
+		_, err = service.TagItem(user.ID, models.ResourceTypeDirectory, acme.ID, "client: acme")
+		require.NoError(t, err)
+		_, err = service.TagItem(user.ID, models.ResourceTypeDirectory, globex.ID, "client: globex")
+		require.NoError(t, err)
+
+		tags, err := service.ItemTags(user.ID, models.ResourceTypeFile, brief.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"client: acme", "Review"}, names(tags))
+
+		_, err = service.TagItem(user.ID, models.ResourceTypeFile, theirs.ID, "review")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Only the owner can tag an item")
+		_, err = service.ItemTags(user.ID, models.ResourceTypeDirectory, brief.ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound, "Types must match")
+	})
+
+	t.Run("Autocomplete with usage counts", func(t *testing.T) {
+		tags, err := service.ListTags(user.ID, "CLI", 0)
+		require.NoError(t, err)
+		require.Len(t, tags, 2)
+		assert.Equal(t, "client: acme", tags[0].Name)
+		assert.Equal(t, int64(3), tags[0].Items)
+		assert.Equal(t, "client: globex", tags[1].Name)
+
+		all, err := service.ListTags(user.ID, "", 0)
+		require.NoError(t, err)
+		assert.Len(t, all, 3)
+
+		_, err = service.TrashFile(user.ID, invoice.ID)
+		require.NoError(t, err)
+		tags, err = service.ListTags(user.ID, "client: a", 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), tags[0].Items, "Trashed items aren't counted")
+		_, err = service.Restore(user.ID, invoice.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("Filter listings and search", func(t *testing.T) {
+		listing, err := service.ListDirectory(user.ID, "", ListOptions{Tags: []string{"Client: Acme"}})
+		require.NoError(t, err)
+		require.Len(t, listing.Directories, 1)
+		assert.Equal(t, "Acme", listing.Directories[0].Name)
+		require.Len(t, listing.Files, 2)
+		assert.Equal(t, int64(2), listing.TotalFiles)
+		assert.Equal(t, []string{"client: acme", "Review"}, names(listing.Tags[brief.ID]))
+		assert.Equal(t, []string{"client: acme"}, names(listing.Tags[acme.ID]))
+
+		listing, err = service.ListDirectory(user.ID, "", ListOptions{Tags: []string{"client: acme", "review"}})
+		require.NoError(t, err)
+		assert.Empty(t, listing.Directories)
+		require.Len(t, listing.Files, 1, "Items need every tag")
+		assert.Equal(t, brief.ID, listing.Files[0].ID)
+
+		results, err := service.Search(user.ID, SearchOptions{Query: "pdf", Tags: []string{"review"}})
+		require.NoError(t, err)
+		require.Len(t, results.Results, 1)
+		assert.Equal(t, brief.ID, results.Results[0].File.ID)
+		assert.Equal(t, []string{"client: acme", "Review"}, names(results.Results[0].Tags))
+
+		results, err = service.Search(user.ID, SearchOptions{Tags: []string{"client: globex"}})
+		require.NoError(t, err)
+		require.Len(t, results.Results, 1)
+		assert.Equal(t, globex.ID, results.Results[0].Directory.ID)
+	})
+
+	t.Run("Untag and delete tags", func(t *testing.T) {
+		tags, err := service.ListTags(user.ID, "review", 0)
+		require.NoError(t, err)
+		require.Len(t, tags, 1)
+
+		require.NoError(t, service.UntagItem(user.ID, models.ResourceTypeFile, brief.ID, tags[0].ID))
+		err = service.UntagItem(user.ID, models.ResourceTypeFile, brief.ID, tags[0].ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		acmeTags, err := service.ListTags(user.ID, "client: acme", 0)
+		require.NoError(t, err)
+		require.NoError(t, service.DeleteTag(user.ID, acmeTags[0].ID))
+		remaining, err := service.ItemTags(user.ID, models.ResourceTypeFile, brief.ID)
+		require.NoError(t, err)
+		assert.Empty(t, remaining)
+		var links int64
+		require.NoError(t, db.Model(&models.TagLink{}).Where("tag = ?", acmeTags[0].ID).Count(&links).Error)
+		assert.Zero(t, links)
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		require.NoError(t, service.SetItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "client", "Acme"))
+		require.NoError(t, service.SetItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "status", "draft"))
+		require.NoError(t, service.SetItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "status", "final"))
+
+		metadata, err := service.ItemMetadata(user.ID, models.ResourceTypeFile, brief.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"client": "Acme", "status": "final"}, metadata)
+
+		assert.ErrorIs(t, service.SetItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "bad key", "x"), ErrInvalidMetadata)
+		assert.ErrorIs(t, service.SetItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "big", strings.Repeat("x", MaxMetadataValueLength+1)), ErrInvalidMetadata)
+		assert.ErrorIs(t, service.SetItemMetadata(other.ID, models.ResourceTypeFile, brief.ID, "client", "x"), gorm.ErrRecordNotFound)
+
+		require.NoError(t, service.DeleteItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "client"))
+		assert.ErrorIs(t, service.DeleteItemMetadata(user.ID, models.ResourceTypeFile, brief.ID, "client"), gorm.ErrRecordNotFound)
+		metadata, err = service.ItemMetadata(user.ID, models.ResourceTypeFile, brief.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"status": "final"}, metadata)
+	})
+
+	t.Run("Deleting items drops their tags and metadata", func(t *testing.T) {
+		require.NoError(t, service.SetItemMetadata(user.ID, models.ResourceTypeDirectory, globex.ID, "client", "Globex"))
+		inside := createTestTreeFile(t, storage, db, user.ID, globex, "contract.pdf", "contract")
+		_, err := service.TagItem(user.ID, models.ResourceTypeFile, inside.ID, "signed")
+		require.NoError(t, err)
+
+		_, err = service.TrashDirectory(user.ID, globex.ID)
+		require.NoError(t, err)
+		_, err = service.Purge(context.Background(), user.ID, globex.ID)
+		require.NoError(t, err)
+
+		var links, entries int64
+		require.NoError(t, db.Model(&models.TagLink{}).Where("resource IN ?", []string{globex.ID, inside.ID}).Count(&links).Error)
+		assert.Zero(t, links)
+		require.NoError(t, db.Model(&models.Metadata{}).Where("resource = ?", globex.ID).Count(&entries).Error)
+		assert.Zero(t, entries)
+	})
+}
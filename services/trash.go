@@ -0,0 +1,610 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// Trash item types
+const (
+	TrashItemFile      = "file"
+	TrashItemDirectory = "directory"
+)
+
+// TrashItem is a file or directory in its owner's trash. Items trashed
+// along with a directory are only listed through that directory.
+type TrashItem struct {
+	ID              string     `json:"id"`
+	Type            string     `json:"type"`
+	Name            string     `json:"name"`
+	Path            string     `json:"path"` // Where the item was when it was trashed
+	ParentDirectory string     `json:"parent_directory"`
+	Size            int64      `json:"size"` // For directories, the size of the files trashed with it
+	DeletedAt       time.Time  `json:"deleted_at"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"` // When it will be purged, nil if kept until emptied
+}
+
+// TrashFile moves a file to the trash
+func (s *FileService) TrashFile(userID, fileID string) (*TrashItem, error) {
+	var file models.File
+	if err := s.db.First(&file, "id = ? AND user = ?", fileID, userID).Error; err != nil {
+		return nil, err
+	}
+	stored, err := s.storedSize([]*models.File{&file})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		"deleted_at":   now,
+		"trashed_with": "",
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to trash file: %w", err)
+	}
+	file.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+
+	s.adjustTrashedQuota(file.User, -stored)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("filename", file.Name).
+		Msg("File moved to trash")
+
+	s.recordActivity(userID, models.ActivityDelete, models.ResourceTypeFile, file.ID, file.Name, "")
+
+	return s.fileTrashItem(&file), nil
+}
+
+// TrashDirectory moves a directory and everything below it to the trash.
+// Only the directory is listed; the items below it are marked as trashed
+// with it, so they are restored and purged along with it.
+func (s *FileService) TrashDirectory(userID, dirID string) (*TrashItem, error) {
+	var dir models.Directory
+	if err := s.db.First(&dir, "id = ? AND user = ?", dirID, userID).Error; err != nil {
+		return nil, err
+	}
+
+	dirs, files, err := s.loadSubtree(&dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirIDs := make([]string, 0, len(dirs)-1)
+	for _, d := range dirs[1:] {
+		dirIDs = append(dirIDs, d.ID)
+	}
+	fileIDs := make([]string, len(files))
+	var size int64
+	for i, file := range files {
+		fileIDs[i] = file.ID
+		size += file.Size
+	}
+	stored, err := s.storedSize(files)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Directory{}).Where("id = ?", dir.ID).Updates(map[string]interface{}{
+			"deleted_at":   now,
+			"trashed_with": "",
+		}).Error; err != nil {
+			return err
+		}
+
+		descendant := map[string]interface{}{"deleted_at": now, "trashed_with": dir.ID}
+		for start := 0; start < len(dirIDs); start += fileBatchSize {
+			batch := dirIDs[start:min(start+fileBatchSize, len(dirIDs))]
+			if err := tx.Model(&models.Directory{}).Where("id IN ?", batch).Updates(descendant).Error; err != nil {
+				return err
+			}
+		}
+		for start := 0; start < len(fileIDs); start += fileBatchSize {
+			batch := fileIDs[start:min(start+fileBatchSize, len(fileIDs))]
+			if err := tx.Model(&models.File{}).Where("id IN ?", batch).Updates(descendant).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to trash directory: %w", err)
+	}
+	dir.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+
+	s.adjustTrashedQuota(dir.User, -stored)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("directory_id", dir.ID).
+		Int("directories", len(dirs)).
+		Int("files", len(files)).
+		Int64("size", size).
+		Msg("Directory moved to trash")
+
+	s.recordActivity(userID, models.ActivityDelete, models.ResourceTypeDirectory, dir.ID, dir.Name, "")
+
+	return s.directoryTrashItem(&dir, size), nil
+}
+
+// ListTrash lists userID's trash, most recently trashed first
+func (s *FileService) ListTrash(userID string) ([]*TrashItem, error) {
+	var files []*models.File
+	if err := s.trashedItems(userID).Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to list trashed files: %w", err)
+	}
+	var dirs []*models.Directory
+	if err := s.trashedItems(userID).Find(&dirs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list trashed directories: %w", err)
+	}
+
+	// Directory sizes are the files trashed with them
+	var sizes []struct {
+		TrashedWith string
+		Size        int64
+	}
+	err := s.db.Unscoped().Model(&models.File{}).
+		Select("trashed_with, SUM(size) AS size").
+		Where("user = ? AND trashed_with <> ''", userID).
+		Group("trashed_with").
+		Scan(&sizes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to size trashed directories: %w", err)
+	}
+	dirSizes := make(map[string]int64, len(sizes))
+	for _, row := range sizes {
+		dirSizes[row.TrashedWith] = row.Size
+	}
+
+	items := make([]*TrashItem, 0, len(files)+len(dirs))
+	for _, dir := range dirs {
+		items = append(items, s.directoryTrashItem(dir, dirSizes[dir.ID]))
+	}
+	for _, file := range files {
+		items = append(items, s.fileTrashItem(file))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+
+	return items, nil
+}
+
+// Restore takes an item out of userID's trash and puts it back where it
+// was, returning it with its restored name and location. Missing parent
+// directories are recreated from the stored path, and the item is renamed
+// if its name has been taken since.
+func (s *FileService) Restore(userID, itemID string) (*TrashItem, error) {
+	file, dir, err := s.findTrashed(userID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		return s.restoreFile(userID, file)
+	}
+	return s.restoreDirectory(userID, dir)
+}
+
+// Purge permanently deletes an item in userID's trash, along with
+// everything trashed with it
+func (s *FileService) Purge(ctx context.Context, userID, itemID string) (*DeleteReport, error) {
+	file, dir, err := s.findTrashed(userID, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []*models.Directory
+	var files []*models.File
+	if file != nil {
+		files = append(files, file)
+	} else {
+		if err := s.db.Scopes(trashedWith(dir.ID)).Find(&dirs).Error; err != nil {
+			return nil, fmt.Errorf("failed to load trashed directories: %w", err)
+		}
+		dirs = append(dirs, dir)
+		if err := s.db.Scopes(trashedWith(dir.ID)).Find(&files).Error; err != nil {
+			return nil, fmt.Errorf("failed to load trashed files: %w", err)
+		}
+	}
+
+	report, err := s.deleteRecords(ctx, userID, dirs, files, s.config.TrashCountsTowardQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("item_id", itemID).
+		Int("directories", report.DirectoriesDeleted).
+		Int("files", report.FilesDeleted).
+		Int64("bytes", report.BytesFreed).
+		Int("failed_objects", len(report.FailedObjects)).
+		Msg("Trash item purged")
+
+	return report, nil
+}
+
+// EmptyTrash permanently deletes everything in userID's trash
+func (s *FileService) EmptyTrash(ctx context.Context, userID string) (*DeleteReport, error) {
+	var dirs []*models.Directory
+	if err := s.db.Unscoped().Where("user = ? AND deleted_at IS NOT NULL", userID).Find(&dirs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load trashed directories: %w", err)
+	}
+	var files []*models.File
+	if err := s.db.Unscoped().Where("user = ? AND deleted_at IS NOT NULL", userID).Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("failed to load trashed files: %w", err)
+	}
+
+	report, err := s.deleteRecords(ctx, userID, dirs, files, s.config.TrashCountsTowardQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Int("directories", report.DirectoriesDeleted).
+		Int("files", report.FilesDeleted).
+		Int64("bytes", report.BytesFreed).
+		Int("failed_objects", len(report.FailedObjects)).
+		Msg("Trash emptied")
+
+	return report, nil
+}
+
+// PurgeExpired permanently deletes the items that have been in the trash
+// longer than the retention period and returns how many were purged
+func (s *FileService) PurgeExpired(ctx context.Context) (int, error) {
+	if s.config.TrashRetentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -s.config.TrashRetentionDays)
+
+	var expired []struct {
+		ID   string
+		User string
+	}
+	for _, model := range []interface{}{&models.File{}, &models.Directory{}} {
+		var rows []struct {
+			ID   string
+			User string
+		}
+		err := s.db.Unscoped().Model(model).
+			Select("id", "user").
+			Where("deleted_at < ?", cutoff).
+			Where("trashed_with IS NULL OR trashed_with = ''").
+			Find(&rows).Error
+		if err != nil {
+			return 0, err
+		}
+		expired = append(expired, rows...)
+	}
+
+	purged := 0
+	for _, item := range expired {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		if _, err := s.Purge(ctx, item.User, item.ID); err != nil {
+			s.logger.Error().
+				Err(err).
+				Str("item_id", item.ID).
+				Msg("Failed to purge expired trash item")
+			continue
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		s.logger.Info().Int("count", purged).Msg("Purged expired trash")
+	}
+
+	return purged, nil
+}
+
+// purgeExpiredTrash periodically purges trash past its retention
+func (s *FileService) purgeExpiredTrash() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.PurgeExpired(context.Background()); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to purge expired trash")
+		}
+	}
+}
+
+// restoreFile takes a file out of the trash
+func (s *FileService) restoreFile(userID string, file *models.File) (*TrashItem, error) {
+	stored, err := s.storedSize([]*models.File{file})
+	if err != nil {
+		return nil, err
+	}
+	if !s.config.TrashCountsTowardQuota {
+		if err := s.checkQuota(file.User, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	parentID, path, err := s.restoreLocation(userID, file.ParentDirectory, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	oldName := file.Name
+	err = retryName(ConflictRename, oldName, func() error {
+		name, err := s.resolveName(userID, parentID, oldName, false, ConflictRename)
+		if err != nil {
+			return err
+		}
+
+		file.Name = name
+		file.Path = path
+		file.ParentDirectory = parentID
+
+		return s.db.Unscoped().Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+			"name":             file.Name,
+			"path":             file.Path,
+			"parent_directory": file.ParentDirectory,
+			"deleted_at":       nil,
+			"trashed_with":     "",
+		}).Error
+	})
+	if errors.Is(err, ErrNameConflict) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore file: %w", err)
+	}
+
+	s.adjustTrashedQuota(file.User, stored)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("name", file.Name).
+		Str("directory_id", parentID).
+		Msg("File restored from trash")
+
+	item := s.fileTrashItem(file)
+	item.ExpiresAt = nil
+	return item, nil
+}
+
+// restoreDirectory takes a directory and everything trashed with it out of
+// the trash
+func (s *FileService) restoreDirectory(userID string, dir *models.Directory) (*TrashItem, error) {
+	dirs, files, err := s.loadSubtree(dir, trashedWith(dir.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size
+	}
+	stored, err := s.storedSize(files)
+	if err != nil {
+		return nil, err
+	}
+	if !s.config.TrashCountsTowardQuota {
+		if err := s.checkQuota(dir.User, stored); err != nil {
+			return nil, err
+		}
+	}
+
+	parentID, path, err := s.restoreLocation(userID, dir.ParentDirectory, dir.Path)
+	if err != nil {
+		return nil, err
+	}
+	oldName := dir.Name
+	err = retryName(ConflictRename, oldName, func() error {
+		name, err := s.resolveName(userID, parentID, oldName, true, ConflictRename)
+		if err != nil {
+			return err
+		}
+
+		dir.Name = name
+		dir.Path = path
+		dir.ParentDirectory = parentID
+
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			// The directory comes back under its new name, as its old
+			// one may have been taken while it was in the trash
+			if err := tx.Unscoped().Model(&models.Directory{}).Where("id = ?", dir.ID).Updates(map[string]interface{}{
+				"name":             dir.Name,
+				"path":             dir.Path,
+				"parent_directory": dir.ParentDirectory,
+				"deleted_at":       nil,
+				"trashed_with":     "",
+			}).Error; err != nil {
+				return err
+			}
+
+			restored := map[string]interface{}{"deleted_at": nil, "trashed_with": ""}
+			if err := tx.Unscoped().Model(&models.Directory{}).
+				Where("trashed_with = ?", dir.ID).
+				Updates(restored).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&models.File{}).
+				Where("trashed_with = ?", dir.ID).
+				Updates(restored).Error; err != nil {
+				return err
+			}
+
+			// The parent may have moved while the directory was in the trash
+			return relocateDirectory(tx, dirs, files)
+		})
+	})
+	if errors.Is(err, ErrNameConflict) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore directory: %w", err)
+	}
+
+	s.adjustTrashedQuota(dir.User, stored)
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("directory_id", dir.ID).
+		Str("name", dir.Name).
+		Str("parent_id", parentID).
+		Int("descendants", len(dirs)-1+len(files)).
+		Msg("Directory restored from trash")
+
+	item := s.directoryTrashItem(dir, size)
+	item.ExpiresAt = nil
+	return item, nil
+}
+
+// restoreLocation returns the parent and path to restore an item into. The
+// original parent is used if it is still there; otherwise the directories
+// on the item's stored path are found or recreated from the root.
+func (s *FileService) restoreLocation(userID, parentID, path string) (string, string, error) {
+	if parentID != "" {
+		var parent models.Directory
+		err := s.db.First(&parent, "id = ? AND user = ?", parentID, userID).Error
+		if err == nil {
+			return parent.ID, parent.GetFullPath(), nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", err
+		}
+	}
+
+	parentID, parentPath := "", "/"
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		var dir models.Directory
+		query := s.db.Where("user = ? AND name = ?", userID, name)
+		if parentID != "" {
+			query = query.Where("parent_directory = ?", parentID)
+		} else {
+			query = query.Where("parent_directory IS NULL OR parent_directory = ''")
+		}
+		err := query.First(&dir).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// A file may hold the name, in which case the directory is
+			// recreated next to it
+			dir = models.Directory{Path: parentPath, User: userID, ParentDirectory: parentID}
+			err := retryName(ConflictRename, name, func() error {
+				dirName, err := s.resolveName(userID, parentID, name, true, ConflictRename)
+				if err != nil {
+					return err
+				}
+				dir.Name = dirName
+				return s.db.Create(&dir).Error
+			})
+			if errors.Is(err, ErrNameConflict) {
+				return "", "", err
+			}
+			if err != nil {
+				return "", "", fmt.Errorf("failed to recreate directory: %w", err)
+			}
+		} else if err != nil {
+			return "", "", err
+		}
+
+		parentID, parentPath = dir.ID, dir.GetFullPath()
+	}
+
+	return parentID, parentPath, nil
+}
+
+// findTrashed finds the item listed in userID's trash with ID itemID,
+// which is either a file or a directory
+func (s *FileService) findTrashed(userID, itemID string) (*models.File, *models.Directory, error) {
+	var file models.File
+	err := s.trashedItems(userID).First(&file, "id = ?", itemID).Error
+	if err == nil {
+		return &file, nil, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+
+	var dir models.Directory
+	if err := s.trashedItems(userID).First(&dir, "id = ?", itemID).Error; err != nil {
+		return nil, nil, err
+	}
+	return nil, &dir, nil
+}
+
+// trashedItems queries the items listed in userID's trash
+func (s *FileService) trashedItems(userID string) *gorm.DB {
+	return s.db.Unscoped().
+		Where("user = ? AND deleted_at IS NOT NULL", userID).
+		Where("trashed_with IS NULL OR trashed_with = ''")
+}
+
+// trashedWith scopes a query to the items trashed along with directory dirID
+func trashedWith(dirID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Where("trashed_with = ?", dirID)
+	}
+}
+
+// adjustTrashedQuota updates userID's storage used by delta for bytes
+// moving in or out of the trash, if trashed bytes don't count toward quota
+func (s *FileService) adjustTrashedQuota(userID string, delta int64) {
+	if s.config.TrashCountsTowardQuota || delta == 0 {
+		return
+	}
+	if err := s.userService.UpdateStorageUsed(userID, delta); err != nil {
+		s.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update storage used for trash")
+	}
+}
+
+// fileTrashItem describes a trashed file
+func (s *FileService) fileTrashItem(file *models.File) *TrashItem {
+	item := &TrashItem{
+		ID:              file.ID,
+		Type:            TrashItemFile,
+		Name:            file.Name,
+		Path:            file.Path,
+		ParentDirectory: file.ParentDirectory,
+		Size:            file.Size,
+		DeletedAt:       file.DeletedAt.Time,
+	}
+	item.ExpiresAt = s.trashExpiry(item.DeletedAt)
+	return item
+}
+
+// directoryTrashItem describes a trashed directory holding size bytes
+func (s *FileService) directoryTrashItem(dir *models.Directory, size int64) *TrashItem {
+	item := &TrashItem{
+		ID:              dir.ID,
+		Type:            TrashItemDirectory,
+		Name:            dir.Name,
+		Path:            dir.Path,
+		ParentDirectory: dir.ParentDirectory,
+		Size:            size,
+		DeletedAt:       dir.DeletedAt.Time,
+	}
+	item.ExpiresAt = s.trashExpiry(item.DeletedAt)
+	return item
+}
+
+// trashExpiry returns when an item trashed at deletedAt will be purged,
+// or nil if trash is kept until emptied
+func (s *FileService) trashExpiry(deletedAt time.Time) *time.Time {
+	if s.config.TrashRetentionDays <= 0 {
+		return nil
+	}
+	expiry := deletedAt.AddDate(0, 0, s.config.TrashRetentionDays)
+	return &expiry
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestFileService_TrashFile(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("trash@example.com", "trashuser", "Password123!", false)
+	require.NoError(t, err)
+
+	docs := createTestDirectory(t, db, user.ID, nil, "docs")
+	file := createTestTreeFile(t, storage, db, user.ID, docs, "report.txt", "quarterly")
+
+	item, err := service.TrashFile(user.ID, file.ID)
+	require.NoError(t, err)
+	assert.Equal(t, TrashItemFile, item.Type)
+	assert.Equal(t, "docs", item.Path)
+	assert.False(t, item.DeletedAt.IsZero())
+	assert.Nil(t, item.ExpiresAt)
+
+	// Trashed files are hidden but keep their object
+	err = db.First(&models.File{}, "id = ?", file.ID).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Equal(t, "quarterly", readObject(t, storage, file.S3Key))
+
+	items, err := service.ListTrash(user.ID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, file.ID, items[0].ID)
+
+	_, err = service.TrashFile(user.ID, file.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	t.Run("Restore renames around a new file", func(t *testing.T) {
+		createTestTreeFile(t, storage, db, user.ID, docs, "report.txt", "newer")
+
+		restored, err := service.Restore(user.ID, file.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "report (1).txt", restored.Name)
+		assert.Equal(t, docs.ID, restored.ParentDirectory)
+
+		var live models.File
+		require.NoError(t, db.First(&live, "id = ?", file.ID).Error)
+		assert.Equal(t, "report (1).txt", live.Name)
+		assert.Equal(t, "docs", live.Path)
+
+		items, err := service.ListTrash(user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("Other users can't restore", func(t *testing.T) {
+		_, err := service.TrashFile(user.ID, file.ID)
+		require.NoError(t, err)
+
+		_, err = service.Restore("someone-else", file.ID)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestFileService_TrashDirectory(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("trashdir@example.com", "trashdiruser", "Password123!", false)
+	require.NoError(t, err)
+
+	archive := createTestDirectory(t, db, user.ID, nil, "archive")
+	projects := createTestDirectory(t, db, user.ID, archive, "projects")
+	app := createTestDirectory(t, db, user.ID, projects, "app")
+	createTestTreeFile(t, storage, db, user.ID, projects, "plan.txt", "plan")
+	mainFile := createTestTreeFile(t, storage, db, user.ID, app, "main.go", "package main")
+
+	item, err := service.TrashDirectory(user.ID, projects.ID)
+	require.NoError(t, err)
+	assert.Equal(t, TrashItemDirectory, item.Type)
+	assert.Equal(t, int64(len("plan")+len("package main")), item.Size)
+
+	var dirCount, fileCount int64
+	db.Model(&models.Directory{}).Count(&dirCount)
+	db.Model(&models.File{}).Count(&fileCount)
+	assert.Equal(t, int64(1), dirCount)
+	assert.Zero(t, fileCount)
+
+	// Only the trashed directory is listed, not what went with it
+	items, err := service.ListTrash(user.ID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, projects.ID, items[0].ID)
+	assert.Equal(t, item.Size, items[0].Size)
+
+	_, err = service.Restore(user.ID, app.ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// With its parent gone, restoring recreates the parent from the path
+	_, err = service.TrashDirectory(user.ID, archive.ID)
+	require.NoError(t, err)
+	_, err = service.Purge(ctx, user.ID, archive.ID)
+	require.NoError(t, err)
+
+	restored, err := service.Restore(user.ID, projects.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", restored.Path)
+	assert.NotEqual(t, archive.ID, restored.ParentDirectory)
+
+	var recreated models.Directory
+	require.NoError(t, db.First(&recreated, "id = ?", restored.ParentDirectory).Error)
+	assert.Equal(t, "archive", recreated.Name)
+	assert.Equal(t, "/", recreated.Path)
+
+	var restoredMain models.File
+	require.NoError(t, db.First(&restoredMain, "id = ?", mainFile.ID).Error)
+	assert.Equal(t, "archive/projects/app", restoredMain.Path)
+	assert.Equal(t, "package main", readObject(t, storage, restoredMain.S3Key))
+
+	items, err = service.ListTrash(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestFileService_PurgeTrash(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("purge@example.com", "purgeuser", "Password123!", false)
+	require.NoError(t, err)
+	require.NoError(t, service.userService.UpdateStorageUsed(user.ID, 10))
+
+	projects := createTestDirectory(t, db, user.ID, nil, "projects")
+	plan := createTestTreeFile(t, storage, db, user.ID, projects, "plan.txt", "plan")
+	loose := createTestTreeFile(t, storage, db, user.ID, nil, "notes.md", "notes!")
+	require.NoError(t, db.Create(&models.Share{User: user.ID, ResourceType: models.ResourceTypeFile, File: plan.ID, PermissionType: models.PermissionRead}).Error)
+	require.NoError(t, db.Create(&models.Share{User: user.ID, ResourceType: models.ResourceTypeDirectory, Directory: projects.ID, PermissionType: models.PermissionRead}).Error)
+
+	_, err = service.TrashDirectory(user.ID, projects.ID)
+	require.NoError(t, err)
+	_, err = service.TrashFile(user.ID, loose.ID)
+	require.NoError(t, err)
+
+	// Trashed bytes still count toward quota
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), refreshed.StorageUsed)
+
+	report, err := service.Purge(ctx, user.ID, projects.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.DirectoriesDeleted)
+	assert.Equal(t, 1, report.FilesDeleted)
+	assert.Equal(t, int64(4), report.BytesFreed)
+
+	exists, err := storage.FileExists(ctx, plan.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	var remaining int64
+	db.Unscoped().Model(&models.File{}).Where("id = ?", plan.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+	db.Model(&models.Share{}).Count(&remaining)
+	assert.Zero(t, remaining)
+
+	// Storage failures are reported rather than failing the delete
+	service.blobService = NewBlobService(db, &failingDeleteStorage{storage}, zerolog.Nop())
+	report, err = service.EmptyTrash(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.FilesDeleted)
+	assert.Equal(t, []string{loose.S3Key}, report.FailedObjects)
+
+	refreshed, err = service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, refreshed.StorageUsed)
+
+	items, err := service.ListTrash(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestFileService_TrashQuota(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	service.config.TrashCountsTowardQuota = false
+
+	user, err := service.userService.CreateUser("trashquota@example.com", "trashquotauser", "Password123!", false)
+	require.NoError(t, err)
+	require.NoError(t, service.userService.UpdateStorageUsed(user.ID, 6))
+
+	file := createTestTreeFile(t, storage, db, user.ID, nil, "notes.md", "notes!")
+
+	_, err = service.TrashFile(user.ID, file.ID)
+	require.NoError(t, err)
+
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, refreshed.StorageUsed)
+
+	// Restoring needs the bytes to fit again
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", 4).Error)
+	_, err = service.Restore(user.ID, file.ID)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", 100).Error)
+	_, err = service.Restore(user.ID, file.ID)
+	require.NoError(t, err)
+
+	refreshed, err = service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), refreshed.StorageUsed)
+}
+
+func TestFileService_PurgeExpired(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("expire@example.com", "expireuser", "Password123!", false)
+	require.NoError(t, err)
+
+	old := createTestTreeFile(t, storage, db, user.ID, nil, "old.txt", "old")
+	recent := createTestTreeFile(t, storage, db, user.ID, nil, "recent.txt", "recent")
+	for _, file := range []*models.File{old, recent} {
+		_, err := service.TrashFile(user.ID, file.ID)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Unscoped().Model(&models.File{}).Where("id = ?", old.ID).
+		Update("deleted_at", time.Now().AddDate(0, 0, -31)).Error)
+
+	// Without a retention period nothing expires
+	purged, err := service.PurgeExpired(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	service.config.TrashRetentionDays = 30
+	purged, err = service.PurgeExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	items, err := service.ListTrash(user.ID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, recent.ID, items[0].ID)
+	require.NotNil(t, items[0].ExpiresAt)
+	assert.WithinDuration(t, items[0].DeletedAt.AddDate(0, 0, 30), *items[0].ExpiresAt, time.Second)
+}
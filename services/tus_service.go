@@ -0,0 +1,551 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Resumable upload errors
+var (
+	ErrTusUnsupported       = errors.New("resumable uploads are not supported by the storage backend")
+	ErrTusUploadNotFound    = errors.New("upload not found")
+	ErrTusUploadExpired     = errors.New("upload expired")
+	ErrTusOffsetMismatch    = errors.New("upload offset does not match")
+	ErrTusQuotaExceeded     = errors.New("insufficient storage quota")
+	ErrTusDirectoryNotFound = errors.New("directory not found")
+	ErrTusUploadDiscarded   = errors.New("upload could not be saved and was discarded")
+)
+
+// TusService manages resumable uploads made with the tus protocol. Received
+// bytes are streamed into a storage multipart upload one part at a time, so
+// an interrupted upload can continue from the last byte the server stored.
+type TusService struct {
+	db          *gorm.DB
+	s3Service   S3Service
+	uploader    MultipartUploader
+	userService *UserService
+	fileService *FileService
+	logger      zerolog.Logger
+	config      *config.Config
+	locks       stripedMutex
+}
+
+// NewTusService creates a new tus service. Resumable uploads are only
+// available when the storage backend supports multipart uploads.
+func NewTusService(db *gorm.DB, s3Service S3Service, userService *UserService, fileService *FileService, logger zerolog.Logger, cfg *config.Config) *TusService {
+	service := &TusService{
+		db:          db,
+		s3Service:   s3Service,
+		userService: userService,
+		fileService: fileService,
+		logger:      logger,
+		config:      cfg,
+	}
+
+	if uploader, ok := s3Service.(MultipartUploader); ok {
+		service.uploader = uploader
+
+		// Start background goroutine to discard abandoned uploads
+		go service.cleanupExpiredUploads()
+	}
+
+	return service
+}
+
+// Enabled reports whether resumable uploads are available
+func (s *TusService) Enabled() bool {
+	return s.uploader != nil
+}
+
+// UploadOwner returns the user a new upload belongs to and is charged to:
+// the owner of the target directory, see FileService.UploadOwner
+func (s *TusService) UploadOwner(userID, directoryID string) (string, error) {
+	owner, err := s.fileService.UploadOwner(userID, directoryID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrTusDirectoryNotFound
+	}
+	return owner, err
+}
+
+// CreateUpload starts a resumable upload. Uploads made through a share
+// remember the share so later requests can present the same token.
+// onConflict is the upload's conflict policy, checked now so a taken name
+// fails before anything is uploaded. Permission and quota checks are the
+// caller's responsibility.
+func (s *TusService) CreateUpload(ctx context.Context, userID, directoryID, shareToken, filename, mimeType, metadata string, size int64, onConflict string) (*models.TusUpload, error) {
+	if s.uploader == nil {
+		return nil, ErrTusUnsupported
+	}
+
+	owner, err := s.UploadOwner(userID, directoryID)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := s.fileService.UploadConflictPolicy(onConflict)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fileService.CheckUploadName(owner, directoryID, filename, policy); err != nil {
+		return nil, err
+	}
+
+	upload := &models.TusUpload{
+		ID:              models.GenerateID(),
+		User:            owner,
+		ParentDirectory: directoryID,
+		FileName:        filename,
+		MimeType:        mimeType,
+		ConflictPolicy:  string(policy),
+		Metadata:        metadata,
+		Size:            size,
+		ExpiresAt:       time.Now().Add(time.Duration(s.config.TusUploadTTL) * time.Hour),
+	}
+	upload.S3Key = GenerateS3Key(owner, upload.ID, filename)
+
+	if shareToken != "" {
+		var share models.Share
+		if err := s.db.First(&share, "share_token = ?", shareToken).Error; err == nil {
+			upload.Share = share.ID
+		}
+	}
+
+	// Empty files have nothing to upload, so they are stored right away
+	if size == 0 {
+		if err := s.db.Create(upload).Error; err != nil {
+			return nil, fmt.Errorf("failed to save upload: %w", err)
+		}
+		if _, err := s.complete(ctx, upload, sha256.New()); err != nil {
+			return nil, err
+		}
+		return upload, nil
+	}
+
+	uploadID, err := s.uploader.CreateMultipartUpload(ctx, upload.S3Key, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	upload.UploadID = uploadID
+
+	if err := s.db.Create(upload).Error; err != nil {
+		s.uploader.AbortMultipartUpload(context.WithoutCancel(ctx), upload.S3Key, uploadID)
+		return nil, fmt.Errorf("failed to save upload: %w", err)
+	}
+
+	s.logger.Info().
+		Str("upload_id", upload.ID).
+		Str("user_id", owner).
+		Str("filename", filename).
+		Int64("size", size).
+		Msg("Resumable upload created")
+
+	return upload, nil
+}
+
+// GetUpload retrieves an unexpired upload
+func (s *TusService) GetUpload(uploadID string) (*models.TusUpload, error) {
+	var upload models.TusUpload
+	if err := s.db.First(&upload, "id = ?", uploadID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTusUploadNotFound
+		}
+		return nil, err
+	}
+
+	if upload.IsExpired() {
+		return nil, ErrTusUploadExpired
+	}
+
+	return &upload, nil
+}
+
+// CanAccess checks whether a request may continue or terminate an upload:
+// either the owner is signed in, or it presents a valid token for the share
+// the upload was created through
+func (s *TusService) CanAccess(upload *models.TusUpload, userID, shareToken string) bool {
+	if userID != "" && userID == upload.User {
+		return true
+	}
+	if upload.Share == "" || shareToken == "" {
+		return false
+	}
+
+	var share models.Share
+	if err := s.db.First(&share, "share_token = ?", shareToken).Error; err != nil {
+		return false
+	}
+
+	return share.ID == upload.Share && share.IsValid() && share.CanUpload()
+}
+
+// WriteChunk appends data to an upload at the given offset, which must match
+// the upload's current offset. Whatever was received is kept even if the
+// body ends early, and the file record is created once the upload is
+// complete. The updated upload is returned along with any error.
+func (s *TusService) WriteChunk(ctx context.Context, uploadID string, offset int64, body io.Reader) (*models.TusUpload, error) {
+	lock := s.locks.lockFor(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := s.GetUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrTusOffsetMismatch
+	}
+
+	hasher, err := restoreHash(upload.HashState)
+	if err != nil {
+		return upload, err
+	}
+
+	if !upload.IsComplete() {
+		if err := s.receive(ctx, upload, body, hasher); err != nil {
+			return upload, err
+		}
+	}
+
+	// A previous attempt may have stored every byte but failed to finish
+	if upload.IsComplete() && upload.File == "" {
+		if _, err := s.complete(ctx, upload, hasher); err != nil {
+			return upload, err
+		}
+	}
+
+	return upload, nil
+}
+
+// Terminate cancels an upload and discards the bytes received so far.
+// Files from completed uploads are left alone.
+func (s *TusService) Terminate(ctx context.Context, uploadID string) error {
+	lock := s.locks.lockFor(uploadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := s.GetUpload(uploadID)
+	if err != nil {
+		return err
+	}
+
+	return s.discard(ctx, upload)
+}
+
+// CleanupExpired discards all expired uploads and returns how many were
+// removed
+func (s *TusService) CleanupExpired(ctx context.Context) (int, error) {
+	var uploads []models.TusUpload
+	if err := s.db.Where("expires_at < ?", time.Now()).Find(&uploads).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := range uploads {
+		if err := s.discard(ctx, &uploads[i]); err != nil {
+			s.logger.Error().
+				Err(err).
+				Str("upload_id", uploads[i].ID).
+				Msg("Failed to discard expired upload")
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		s.logger.Info().Int("count", removed).Msg("Discarded expired resumable uploads")
+	}
+
+	return removed, nil
+}
+
+// cleanupExpiredUploads periodically discards expired uploads
+func (s *TusService) cleanupExpiredUploads() {
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.CleanupExpired(context.Background()); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to clean up resumable uploads")
+		}
+	}
+}
+
+// receive reads the body into part-sized chunks, uploading each full part
+// and saving progress after it. If a part upload fails the saved state is
+// left as it was, so the client resumes from the last stored byte.
+func (s *TusService) receive(ctx context.Context, upload *models.TusUpload, body io.Reader, hasher hash.Hash) error {
+	buf := make([]byte, uploadPartSize(upload.Size))
+
+	// Every byte past the uploaded parts must be staged. If they aren't
+	// (the staging directory was cleared, or the upload was started by a
+	// version that kept them in the database) the upload can't be finished
+	// and is dropped, so the client starts over.
+	unsent := upload.Offset - int64(len(upload.Parts))*int64(len(buf))
+	filled, err := s.readStaged(upload, buf)
+	if err != nil || int64(filled) != unsent {
+		s.logger.Warn().
+			Err(err).
+			Str("upload_id", upload.ID).
+			Int64("staged", int64(filled)).
+			Int64("expected", unsent).
+			Msg("Staged bytes of resumable upload are missing")
+		if err := s.discard(ctx, upload); err != nil {
+			return err
+		}
+		return ErrTusUploadNotFound
+	}
+
+	reader := io.LimitReader(body, upload.Size-upload.Offset)
+
+	for {
+		n, readErr := io.ReadFull(reader, buf[filled:])
+		hasher.Write(buf[filled : filled+n])
+		filled += n
+		upload.Offset += int64(n)
+
+		if filled == len(buf) || (upload.IsComplete() && filled > 0) {
+			partNumber := len(upload.Parts) + 1
+			etag, err := s.uploader.UploadPart(ctx, upload.S3Key, upload.UploadID, partNumber, buf[:filled])
+			if err != nil {
+				return fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+			}
+			upload.Parts = append(upload.Parts, models.TusPart{PartNumber: partNumber, ETag: etag})
+			filled = 0
+
+			if err := s.saveProgress(upload, nil, hasher); err != nil {
+				return err
+			}
+		}
+
+		if readErr != nil {
+			// Keep what arrived before the client went away
+			if err := s.saveProgress(upload, buf[:filled], hasher); err != nil {
+				return err
+			}
+			if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read upload data: %w", readErr)
+		}
+	}
+}
+
+// saveProgress persists the offset, uploaded parts, leftover bytes and hash
+// state of an upload. The leftover bytes are staged on disk and only their
+// file and length are stored with the upload.
+func (s *TusService) saveProgress(upload *models.TusUpload, buffered []byte, hasher hash.Hash) error {
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to save checksum state: %w", err)
+	}
+
+	if err := s.stage(upload, buffered); err != nil {
+		return err
+	}
+	upload.HashState = state
+	if err := s.db.Save(upload).Error; err != nil {
+		return fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	return nil
+}
+
+// stage replaces the staged bytes of an upload with buffered. The file is
+// written in full and then renamed into place, so it never holds part of a
+// write.
+func (s *TusService) stage(upload *models.TusUpload, buffered []byte) error {
+	if len(buffered) == 0 {
+		return s.unstage(upload)
+	}
+
+	if err := os.MkdirAll(s.config.TusStagingPath, 0o700); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	path := filepath.Join(s.config.TusStagingPath, upload.ID)
+	if err := os.WriteFile(path+".tmp", buffered, 0o600); err != nil {
+		return fmt.Errorf("failed to stage upload data: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to stage upload data: %w", err)
+	}
+
+	upload.Staged = path
+	upload.StagedSize = int64(len(buffered))
+	return nil
+}
+
+// unstage removes the staged bytes of an upload
+func (s *TusService) unstage(upload *models.TusUpload) error {
+	if upload.Staged != "" {
+		if err := os.Remove(upload.Staged); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove staged upload data: %w", err)
+		}
+	}
+	upload.Staged = ""
+	upload.StagedSize = 0
+	return nil
+}
+
+// readStaged reads the staged bytes of an upload into buf, returning how
+// many there were
+func (s *TusService) readStaged(upload *models.TusUpload, buf []byte) (int, error) {
+	if upload.StagedSize == 0 {
+		return 0, nil
+	}
+	if upload.StagedSize > int64(len(buf)) {
+		return 0, fmt.Errorf("staged %d bytes, more than a part", upload.StagedSize)
+	}
+
+	file, err := os.Open(upload.Staged)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.ReadFull(file, buf[:upload.StagedSize])
+}
+
+// complete assembles the stored parts and creates the file record. The
+// upload record is kept, pointing at the file, until it expires.
+func (s *TusService) complete(ctx context.Context, upload *models.TusUpload, hasher hash.Hash) (*models.File, error) {
+	// Quota may have been used up while the upload was in progress
+	var user models.User
+	if err := s.db.First(&user, "id = ?", upload.User).Error; err != nil {
+		return nil, err
+	}
+	if !user.HasQuotaAvailable(upload.Size) {
+		return nil, ErrTusQuotaExceeded
+	}
+
+	// So is the name, while the upload can still be retried
+	policy := ConflictPolicy(upload.ConflictPolicy)
+	if err := s.fileService.CheckUploadName(upload.User, upload.ParentDirectory, upload.FileName, policy); err != nil {
+		return nil, err
+	}
+
+	if len(upload.Parts) == 0 {
+		if err := s.s3Service.UploadFile(ctx, upload.S3Key, bytes.NewReader(nil), 0, upload.MimeType); err != nil {
+			return nil, fmt.Errorf("failed to store empty file: %w", err)
+		}
+	} else {
+		parts := make([]CompletedPart, len(upload.Parts))
+		for i, part := range upload.Parts {
+			parts[i] = CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag}
+		}
+		if err := s.uploader.CompleteMultipartUpload(ctx, upload.S3Key, upload.UploadID, parts); err != nil {
+			return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+		}
+	}
+
+	// Get directory path
+	directoryPath := "/"
+	if upload.ParentDirectory != "" {
+		var dir models.Directory
+		if err := s.db.First(&dir, "id = ?", upload.ParentDirectory).Error; err == nil {
+			directoryPath = dir.GetFullPath()
+		}
+	}
+
+	file := &models.File{
+		Name:            upload.FileName,
+		Path:            directoryPath,
+		User:            upload.User,
+		ParentDirectory: upload.ParentDirectory,
+		Size:            upload.Size,
+		MimeType:        upload.MimeType,
+		S3Key:           upload.S3Key,
+		S3Bucket:        s.config.S3Bucket,
+		Checksum:        hex.EncodeToString(hasher.Sum(nil)),
+	}
+
+	var result *UploadResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		saved, err := s.fileService.SaveUpload(tx, file, policy)
+		if err != nil {
+			return err
+		}
+		result = saved
+		upload.File = file.ID
+		upload.Parts = nil
+		upload.HashState = nil
+		return tx.Save(upload).Error
+	})
+	if err != nil {
+		// The multipart upload is finished, so the upload can't be retried
+		// once its object is gone
+		ctx := context.WithoutCancel(ctx)
+		upload.File = ""
+		upload.UploadID = ""
+		s.s3Service.DeleteFile(ctx, upload.S3Key)
+		if discardErr := s.discard(ctx, upload); discardErr != nil {
+			s.logger.Error().Err(discardErr).Str("upload_id", upload.ID).Msg("Failed to discard upload")
+		}
+		s.logger.Error().Err(err).Str("upload_id", upload.ID).Msg("Failed to create file record")
+		return nil, fmt.Errorf("%w: %w", ErrTusUploadDiscarded, err)
+	}
+
+	if err := s.userService.UpdateStorageUsed(upload.User, upload.Size); err != nil {
+		s.logger.Error().Err(err).Str("user_id", upload.User).Msg("Failed to update storage usage")
+	}
+
+	s.fileService.FinishUpload(ctx, file, result)
+
+	s.logger.Info().
+		Str("upload_id", upload.ID).
+		Str("user_id", upload.User).
+		Str("file_id", file.ID).
+		Int64("size", file.Size).
+		Msg("Resumable upload completed")
+
+	return file, nil
+}
+
+// discard aborts an unfinished upload's multipart upload and removes the
+// upload record
+func (s *TusService) discard(ctx context.Context, upload *models.TusUpload) error {
+	if upload.File == "" && upload.UploadID != "" && s.uploader != nil {
+		if err := s.uploader.AbortMultipartUpload(ctx, upload.S3Key, upload.UploadID); err != nil &&
+			!errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+
+	if err := s.unstage(upload); err != nil {
+		return err
+	}
+	if err := s.db.Delete(upload).Error; err != nil {
+		return err
+	}
+
+	s.logger.Info().
+		Str("upload_id", upload.ID).
+		Str("user_id", upload.User).
+		Msg("Resumable upload discarded")
+
+	return nil
+}
+
+// restoreHash recreates the SHA-256 state saved with an upload
+func restoreHash(state []byte) (hash.Hash, error) {
+	hasher := sha256.New()
+	if len(state) > 0 {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, fmt.Errorf("failed to restore checksum state: %w", err)
+		}
+	}
+	return hasher, nil
+}
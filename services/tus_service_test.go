@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestTusService(t *testing.T) (*TusService, *LocalStorageService, *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Directory{}, &models.Share{}, &models.TusUpload{}))
+
+	storage := newTestLocalStorage(t)
+	cfg := &config.Config{
+		S3Bucket:       "test-bucket",
+		TusUploadTTL:   24,
+		TusStagingPath: t.TempDir(),
+	}
+	userService := NewUserService(db, zerolog.Nop())
+	fileService := NewFileService(db, storage, NewBlobService(db, storage, zerolog.Nop()), userService, zerolog.Nop(), cfg)
+
+	return NewTusService(db, storage, userService, fileService, zerolog.Nop(), cfg), storage, db
+}
+
+// failingReader returns its data and then fails, like a dropped connection
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTusService_ResumableUpload(t *testing.T) {
+	service, storage, db := newTestTusService(t)
+	require.True(t, service.Enabled())
+
+	user, err := service.userService.CreateUser("tus@example.com", "tususer", "Password123!", false)
+	require.NoError(t, err)
+
+	const mib = 1024 * 1024
+	content := make([]byte, 12*mib)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	upload, err := service.CreateUpload(context.Background(), user.ID, "", "", "backup.bin", "application/octet-stream", "filename YmFja3VwLmJpbg==", int64(len(content)), "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), upload.Offset)
+	assert.NotEmpty(t, upload.UploadID)
+
+	// First chunk stays buffered because it doesn't fill a part
+	upload, err = service.WriteChunk(context.Background(), upload.ID, 0, bytes.NewReader(content[:3*mib]))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3*mib), upload.Offset)
+	assert.Empty(t, upload.Parts)
+
+	// Offsets must match what the server stored
+	_, err = service.WriteChunk(context.Background(), upload.ID, 0, bytes.NewReader(content[:mib]))
+	assert.ErrorIs(t, err, ErrTusOffsetMismatch)
+
+	// The connection drops partway through the next chunk, after a part fills
+	upload, err = service.WriteChunk(context.Background(), upload.ID, 3*mib, &failingReader{data: content[3*mib : 11*mib]})
+	assert.Error(t, err)
+	assert.Equal(t, int64(11*mib), upload.Offset)
+
+	stored, err := service.GetUpload(upload.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11*mib), stored.Offset, "Received bytes are kept")
+	assert.Len(t, stored.Parts, 1)
+	assert.Equal(t, int64(mib), stored.StagedSize)
+	staged, err := os.ReadFile(stored.Staged)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content[10*mib:11*mib], staged), "Bytes past the last part are staged on disk")
+	assert.Empty(t, stored.File)
+
+	// Resume from the stored offset
+	upload, err = service.WriteChunk(context.Background(), upload.ID, stored.Offset, bytes.NewReader(content[11*mib:]))
+	require.NoError(t, err)
+	assert.True(t, upload.IsComplete())
+	require.NotEmpty(t, upload.File)
+
+	var file models.File
+	require.NoError(t, db.First(&file, "id = ?", upload.File).Error)
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), file.Checksum)
+	assert.Equal(t, "backup.bin", file.Name)
+	assert.Equal(t, user.ID, file.User)
+
+	reader, err := storage.DownloadFile(context.Background(), file.S3Key)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(content, data))
+
+	updated, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), updated.StorageUsed)
+
+	// The finished upload can still be queried and an empty final PATCH is harmless
+	upload, err = service.WriteChunk(context.Background(), upload.ID, int64(len(content)), bytes.NewReader(nil))
+	require.NoError(t, err)
+	assert.Equal(t, file.ID, upload.File)
+
+	var count int64
+	db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	_, err = os.Stat(stored.Staged)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Nothing is left staged")
+}
+
+func TestTusService_LostStagedBytes(t *testing.T) {
+	service, _, _ := newTestTusService(t)
+
+	user, err := service.userService.CreateUser("lost@example.com", "lostuser", "Password123!", false)
+	require.NoError(t, err)
+
+	upload, err := service.CreateUpload(context.Background(), user.ID, "", "", "a.bin", "application/octet-stream", "", 100, "")
+	require.NoError(t, err)
+	upload, err = service.WriteChunk(context.Background(), upload.ID, 0, bytes.NewReader(make([]byte, 40)))
+	require.NoError(t, err)
+	require.Equal(t, int64(40), upload.StagedSize)
+
+	// The staging directory is cleared while the upload is paused
+	require.NoError(t, os.Remove(upload.Staged))
+
+	_, err = service.WriteChunk(context.Background(), upload.ID, 40, bytes.NewReader(make([]byte, 60)))
+	assert.ErrorIs(t, err, ErrTusUploadNotFound)
+	_, err = service.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrTusUploadNotFound, "The upload is dropped so the client starts over")
+}
+
+func TestTusService_EmptyFile(t *testing.T) {
+	service, storage, db := newTestTusService(t)
+
+	user, err := service.userService.CreateUser("empty@example.com", "emptyuser", "Password123!", false)
+	require.NoError(t, err)
+
+	upload, err := service.CreateUpload(context.Background(), user.ID, "", "", "empty.txt", "text/plain", "", 0, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, upload.File, "Empty uploads complete on creation")
+
+	var file models.File
+	require.NoError(t, db.First(&file, "id = ?", upload.File).Error)
+	assert.Equal(t, int64(0), file.Size)
+
+	exists, err := storage.FileExists(context.Background(), file.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestTusService_NameConflict(t *testing.T) {
+	service, _, _ := newTestTusService(t)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("tusconflict@example.com", "tusconflictuser", "Password123!", false)
+	require.NoError(t, err)
+
+	_, err = service.CreateUpload(ctx, user.ID, "", "", "notes.txt", "text/plain", "", 0, "")
+	require.NoError(t, err)
+
+	// A taken name fails before anything is uploaded
+	_, err = service.CreateUpload(ctx, user.ID, "", "", "notes.txt", "text/plain", "", 10, "")
+	assert.ErrorIs(t, err, ErrNameConflict)
+
+	upload, err := service.CreateUpload(ctx, user.ID, "", "", "notes.txt", "text/plain", "", 0, "rename")
+	require.NoError(t, err)
+	assert.Equal(t, "rename", upload.ConflictPolicy)
+
+	var file models.File
+	require.NoError(t, service.db.First(&file, "id = ?", upload.File).Error)
+	assert.Equal(t, "notes (1).txt", file.Name)
+
+	// A name taken while uploading fails the last chunk, which can be
+	// retried once the name is free
+	upload, err = service.CreateUpload(ctx, user.ID, "", "", "draft.txt", "text/plain", "", 10, "")
+	require.NoError(t, err)
+	taken, err := service.CreateUpload(ctx, user.ID, "", "", "draft.txt", "text/plain", "", 0, "")
+	require.NoError(t, err)
+
+	upload, err = service.WriteChunk(ctx, upload.ID, 0, bytes.NewReader([]byte("0123456789")))
+	assert.ErrorIs(t, err, ErrNameConflict)
+	assert.True(t, upload.IsComplete())
+	assert.Empty(t, upload.File)
+
+	require.NoError(t, service.db.Unscoped().Delete(&models.File{}, "id = ?", taken.File).Error)
+	upload, err = service.WriteChunk(ctx, upload.ID, 10, bytes.NewReader(nil))
+	require.NoError(t, err)
+	assert.NotEmpty(t, upload.File)
+}
+
+func TestTusService_SaveFailure(t *testing.T) {
+	service, storage, db := newTestTusService(t)
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("tussave@example.com", "tussaveuser", "Password123!", false)
+	require.NoError(t, err)
+
+	upload, err := service.CreateUpload(ctx, user.ID, "", "", "report.txt", "text/plain", "", 10, "")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Callback().Create().Before("gorm:create").Register("test:fail_files", func(tx *gorm.DB) {
+		if tx.Statement.Table == "files" {
+			tx.AddError(errors.New("disk full"))
+		}
+	}))
+
+	// Once the object is stored, a failed save can't be retried
+	_, err = service.WriteChunk(ctx, upload.ID, 0, bytes.NewReader([]byte("0123456789")))
+	assert.ErrorIs(t, err, ErrTusUploadDiscarded)
+
+	_, err = service.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrTusUploadNotFound)
+	exists, err := storage.FileExists(ctx, upload.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTusService_QuotaExceededOnCompletion(t *testing.T) {
+	service, _, db := newTestTusService(t)
+
+	user, err := service.userService.CreateUser("quota@example.com", "quotauser", "Password123!", false)
+	require.NoError(t, err)
+
+	upload, err := service.CreateUpload(context.Background(), user.ID, "", "", "big.bin", "application/octet-stream", "", 1024, "")
+	require.NoError(t, err)
+
+	// Storage fills up while the upload is in progress
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("storage_quota", 512).Error)
+
+	upload, err = service.WriteChunk(context.Background(), upload.ID, 0, bytes.NewReader(make([]byte, 1024)))
+	assert.ErrorIs(t, err, ErrTusQuotaExceeded)
+	assert.True(t, upload.IsComplete())
+	assert.Empty(t, upload.File)
+
+	var count int64
+	db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestTusService_CanAccess(t *testing.T) {
+	service, _, db := newTestTusService(t)
+
+	owner, err := service.userService.CreateUser("owner@example.com", "owner", "Password123!", false)
+	require.NoError(t, err)
+	other, err := service.userService.CreateUser("other@example.com", "other", "Password123!", false)
+	require.NoError(t, err)
+
+	dir := &models.Directory{Name: "inbox", Path: "/inbox", User: owner.ID}
+	require.NoError(t, db.Create(dir).Error)
+	share := &models.Share{
+		User:           owner.ID,
+		ResourceType:   models.ResourceTypeDirectory,
+		Directory:      dir.ID,
+		ShareToken:     "upload-token",
+		PermissionType: models.PermissionUploadOnly,
+	}
+	require.NoError(t, db.Create(share).Error)
+
+	// Anonymous share uploads belong to the directory owner
+	upload, err := service.CreateUpload(context.Background(), "", dir.ID, share.ShareToken, "drop.txt", "text/plain", "", 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, owner.ID, upload.User)
+	assert.Equal(t, share.ID, upload.Share)
+
+	// So do signed-in users' share uploads
+	signedIn, err := service.CreateUpload(context.Background(), other.ID, dir.ID, share.ShareToken, "drop2.txt", "text/plain", "", 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, owner.ID, signedIn.User)
+	assert.True(t, service.CanAccess(signedIn, other.ID, share.ShareToken))
+
+	assert.True(t, service.CanAccess(upload, owner.ID, ""))
+	assert.True(t, service.CanAccess(upload, "", share.ShareToken))
+	assert.False(t, service.CanAccess(upload, other.ID, ""))
+	assert.False(t, service.CanAccess(upload, "", "wrong-token"))
+	assert.False(t, service.CanAccess(upload, "", ""))
+
+	// Expired shares stop working
+	expired := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Model(share).Update("expires_at", &expired).Error)
+	assert.False(t, service.CanAccess(upload, "", share.ShareToken))
+
+	_, err = service.UploadOwner("", "missing")
+	assert.ErrorIs(t, err, ErrTusDirectoryNotFound)
+}
+
+func TestTusService_TerminateAndCleanup(t *testing.T) {
+	service, storage, db := newTestTusService(t)
+
+	user, err := service.userService.CreateUser("term@example.com", "termuser", "Password123!", false)
+	require.NoError(t, err)
+
+	upload, err := service.CreateUpload(context.Background(), user.ID, "", "", "a.txt", "text/plain", "", 100, "")
+	require.NoError(t, err)
+	upload, err = service.WriteChunk(context.Background(), upload.ID, 0, bytes.NewReader([]byte("partial")))
+	require.NoError(t, err)
+	require.NoError(t, service.Terminate(context.Background(), upload.ID))
+	_, err = os.Stat(upload.Staged)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Staged bytes are removed")
+
+	_, err = service.GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrTusUploadNotFound)
+	_, err = storage.UploadPart(context.Background(), upload.S3Key, upload.UploadID, 1, []byte("x"))
+	assert.ErrorIs(t, err, ErrFileNotFound, "The multipart upload is aborted")
+
+	// Expired uploads are rejected and cleaned up
+	expiring, err := service.CreateUpload(context.Background(), user.ID, "", "", "b.txt", "text/plain", "", 100, "")
+	require.NoError(t, err)
+	require.NoError(t, db.Model(expiring).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, err = service.WriteChunk(context.Background(), expiring.ID, 0, bytes.NewReader([]byte("data")))
+	assert.ErrorIs(t, err, ErrTusUploadExpired)
+
+	removed, err := service.CleanupExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	var count int64
+	db.Model(&models.TusUpload{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
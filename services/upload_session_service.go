@@ -0,0 +1,450 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Direct upload errors
+var (
+	ErrDirectUploadUnsupported = errors.New("direct uploads are not available")
+	ErrUploadSessionNotFound   = errors.New("upload session not found")
+	ErrUploadSessionExpired    = errors.New("upload session expired")
+	ErrInvalidParts            = errors.New("invalid upload parts")
+	ErrUploadSizeMismatch      = errors.New("uploaded size does not match the declared size")
+)
+
+const (
+	// minPartSize is the S3 minimum for every part but the last
+	minPartSize = 5 * 1024 * 1024
+	// defaultPartSize is used unless the file needs bigger parts to stay
+	// under the S3 part limit
+	defaultPartSize = 10 * 1024 * 1024
+	// maxUploadParts is the S3 limit on parts per multipart upload
+	maxUploadParts = 10000
+	// partURLExpiryMinutes is the lifetime of presigned part URLs. Clients
+	// can request fresh URLs for the remaining parts if they run out.
+	partURLExpiryMinutes = 60
+	// checksumQueueSize bounds the completed uploads waiting for their
+	// checksum. When it is full, files are left without one.
+	checksumQueueSize = 1000
+	// checksumTimeout bounds reading back one completed upload
+	checksumTimeout = time.Hour
+)
+
+// checksumJob is a completed direct upload whose checksum is still to be
+// computed from the object at key
+type checksumJob struct {
+	fileID string
+	key    string
+}
+
+// PartURL is a presigned URL for uploading one part of an upload session
+type PartURL struct {
+	PartNumber int    `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// UploadSessionService manages browser-driven multipart uploads that go
+// straight to storage. The server never sees their bytes, so they are not
+// deduplicated, and their checksum is computed in the background by reading
+// the object back once the upload completes.
+type UploadSessionService struct {
+	db          *gorm.DB
+	s3Service   S3Service
+	uploader    PresignedPartUploader
+	userService *UserService
+	fileService *FileService
+	logger      zerolog.Logger
+	config      *config.Config
+	checksums   chan checksumJob
+}
+
+// NewUploadSessionService creates a new upload session service. Direct
+// uploads are only available when enabled and supported by the backend.
+func NewUploadSessionService(db *gorm.DB, s3Service S3Service, userService *UserService, fileService *FileService, logger zerolog.Logger, cfg *config.Config) *UploadSessionService {
+	service := &UploadSessionService{
+		db:          db,
+		s3Service:   s3Service,
+		userService: userService,
+		fileService: fileService,
+		logger:      logger,
+		config:      cfg,
+		checksums:   make(chan checksumJob, checksumQueueSize),
+	}
+
+	if uploader, ok := s3Service.(PresignedPartUploader); ok && cfg.DirectUploadEnabled {
+		service.uploader = uploader
+
+		// Start background goroutines to abort abandoned uploads and to
+		// checksum completed ones
+		go service.cleanupExpiredSessions()
+		go service.checksumQueued()
+	}
+
+	return service
+}
+
+// Enabled reports whether direct uploads are available
+func (s *UploadSessionService) Enabled() bool {
+	return s.uploader != nil
+}
+
+// CreateSession starts a multipart upload for a file and returns the
+// session along with presigned URLs for all of its parts. onConflict is
+// the upload's conflict policy, checked now so a taken name fails before
+// anything is uploaded. Permission and quota checks are the caller's
+// responsibility.
+func (s *UploadSessionService) CreateSession(ctx context.Context, userID, directoryID, filename, mimeType string, size int64, onConflict string) (*models.UploadSession, []PartURL, error) {
+	if s.uploader == nil {
+		return nil, nil, ErrDirectUploadUnsupported
+	}
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("%w: size must be greater than 0", ErrInvalidParts)
+	}
+	policy, err := s.fileService.UploadConflictPolicy(onConflict)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.fileService.CheckUploadName(userID, directoryID, filename, policy); err != nil {
+		return nil, nil, err
+	}
+
+	partSize := uploadPartSize(size)
+	session := &models.UploadSession{
+		ID:              models.GenerateID(),
+		User:            userID,
+		ParentDirectory: directoryID,
+		FileName:        filename,
+		Size:            size,
+		MimeType:        mimeType,
+		ConflictPolicy:  string(policy),
+		PartSize:        partSize,
+		PartCount:       int((size + partSize - 1) / partSize),
+		ExpiresAt:       time.Now().Add(time.Duration(s.config.UploadSessionTTL) * time.Hour),
+	}
+	session.S3Key = GenerateS3Key(userID, session.ID, filename)
+
+	uploadID, err := s.uploader.CreateMultipartUpload(ctx, session.S3Key, mimeType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+	session.UploadID = uploadID
+
+	if err := s.db.Create(session).Error; err != nil {
+		s.uploader.AbortMultipartUpload(context.WithoutCancel(ctx), session.S3Key, uploadID)
+		return nil, nil, fmt.Errorf("failed to save upload session: %w", err)
+	}
+
+	partNumbers := make([]int, session.PartCount)
+	for i := range partNumbers {
+		partNumbers[i] = i + 1
+	}
+
+	urls, err := s.presignParts(ctx, session, partNumbers)
+	if err != nil {
+		s.abort(context.WithoutCancel(ctx), session)
+		return nil, nil, err
+	}
+
+	s.logger.Info().
+		Str("session_id", session.ID).
+		Str("user_id", userID).
+		Str("filename", filename).
+		Int64("size", size).
+		Int("parts", session.PartCount).
+		Msg("Direct upload session created")
+
+	return session, urls, nil
+}
+
+// GetSession retrieves an active upload session owned by the user
+func (s *UploadSessionService) GetSession(sessionID, userID string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	if err := s.db.First(&session, "id = ? AND user = ?", sessionID, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, err
+	}
+
+	if session.IsExpired() {
+		return nil, ErrUploadSessionExpired
+	}
+
+	return &session, nil
+}
+
+// PartURLs issues fresh presigned URLs for the given parts of a session
+func (s *UploadSessionService) PartURLs(ctx context.Context, sessionID, userID string, partNumbers []int) ([]PartURL, error) {
+	if s.uploader == nil {
+		return nil, ErrDirectUploadUnsupported
+	}
+
+	session, err := s.GetSession(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.presignParts(ctx, session, partNumbers)
+}
+
+// CompleteSession assembles the uploaded parts, verifies the result and
+// creates the file record, returning the conflict policy applied to its name
+// (empty if the name was free). The record starts without a checksum, which
+// is filled in once the object has been read back in the background.
+func (s *UploadSessionService) CompleteSession(ctx context.Context, sessionID, userID string, parts []CompletedPart) (*models.File, ConflictPolicy, error) {
+	if s.uploader == nil {
+		return nil, "", ErrDirectUploadUnsupported
+	}
+
+	session, err := s.GetSession(sessionID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := validateCompletedParts(parts, session.PartCount); err != nil {
+		return nil, "", err
+	}
+
+	if err := s.uploader.CompleteMultipartUpload(ctx, session.S3Key, session.UploadID, parts); err != nil {
+		return nil, "", fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	// The client controls what went into the parts, so check the result
+	metadata, err := s.s3Service.GetFileMetadata(ctx, session.S3Key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to verify uploaded object: %w", err)
+	}
+	if metadata.Size != session.Size {
+		s.s3Service.DeleteFile(context.WithoutCancel(ctx), session.S3Key)
+		s.db.Delete(session)
+		s.logger.Warn().
+			Str("session_id", session.ID).
+			Int64("declared_size", session.Size).
+			Int64("actual_size", metadata.Size).
+			Msg("Direct upload size mismatch")
+		return nil, "", ErrUploadSizeMismatch
+	}
+
+	// Get directory path
+	directoryPath := "/"
+	if session.ParentDirectory != "" {
+		var dir models.Directory
+		if err := s.db.First(&dir, "id = ?", session.ParentDirectory).Error; err == nil {
+			directoryPath = dir.GetFullPath()
+		}
+	}
+
+	file := &models.File{
+		Name:            session.FileName,
+		Path:            directoryPath,
+		User:            session.User,
+		ParentDirectory: session.ParentDirectory,
+		Size:            session.Size,
+		MimeType:        session.MimeType,
+		S3Key:           session.S3Key,
+		S3Bucket:        s.config.S3Bucket,
+	}
+
+	var result *UploadResult
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		saved, err := s.fileService.SaveUpload(tx, file, ConflictPolicy(session.ConflictPolicy))
+		if err != nil {
+			return err
+		}
+		result = saved
+		return tx.Delete(session).Error
+	})
+	if err != nil {
+		// The multipart upload is finished, so the session can't be
+		// completed again once its object is gone
+		s.s3Service.DeleteFile(context.WithoutCancel(ctx), session.S3Key)
+		s.db.Delete(session)
+		return nil, "", fmt.Errorf("failed to create file record: %w", err)
+	}
+
+	if err := s.userService.UpdateStorageUsed(session.User, session.Size); err != nil {
+		s.logger.Error().Err(err).Str("user_id", session.User).Msg("Failed to update storage usage")
+	}
+
+	s.fileService.FinishUpload(ctx, file, result)
+
+	select {
+	case s.checksums <- checksumJob{fileID: file.ID, key: file.S3Key}:
+	default:
+		s.logger.Warn().Str("file_id", file.ID).Msg("Checksum queue is full, direct upload left without a checksum")
+	}
+
+	s.logger.Info().
+		Str("session_id", session.ID).
+		Str("user_id", session.User).
+		Str("file_id", file.ID).
+		Int64("size", file.Size).
+		Msg("Direct upload completed")
+
+	return file, result.Policy, nil
+}
+
+// AbortSession cancels an upload session and discards its parts
+func (s *UploadSessionService) AbortSession(ctx context.Context, sessionID, userID string) error {
+	var session models.UploadSession
+	if err := s.db.First(&session, "id = ? AND user = ?", sessionID, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUploadSessionNotFound
+		}
+		return err
+	}
+
+	return s.abort(ctx, &session)
+}
+
+// CleanupExpired aborts all expired upload sessions and returns how many
+// were removed
+func (s *UploadSessionService) CleanupExpired(ctx context.Context) (int, error) {
+	var sessions []models.UploadSession
+	if err := s.db.Where("expires_at < ?", time.Now()).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := range sessions {
+		if err := s.abort(ctx, &sessions[i]); err != nil {
+			s.logger.Error().
+				Err(err).
+				Str("session_id", sessions[i].ID).
+				Msg("Failed to abort expired upload session")
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		s.logger.Info().Int("count", removed).Msg("Aborted expired upload sessions")
+	}
+
+	return removed, nil
+}
+
+// cleanupExpiredSessions periodically aborts expired upload sessions
+func (s *UploadSessionService) cleanupExpiredSessions() {
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.CleanupExpired(context.Background()); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to clean up upload sessions")
+		}
+	}
+}
+
+// checksumQueued computes the checksums of completed uploads one at a time
+func (s *UploadSessionService) checksumQueued() {
+	for job := range s.checksums {
+		ctx, cancel := context.WithTimeout(context.Background(), checksumTimeout)
+		if err := s.recordChecksum(ctx, job.fileID, job.key); err != nil {
+			s.logger.Error().Err(err).Str("file_id", job.fileID).Msg("Failed to checksum direct upload")
+		}
+		cancel()
+	}
+}
+
+// recordChecksum reads the object at key back from storage and records its
+// checksum on the file. A file whose content has been replaced since then
+// is left alone.
+func (s *UploadSessionService) recordChecksum(ctx context.Context, fileID, key string) error {
+	reader, err := s.s3Service.DownloadFile(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	checksumReader := NewChecksumReader(reader)
+	if _, err := io.Copy(io.Discard, checksumReader); err != nil {
+		return fmt.Errorf("failed to read uploaded object: %w", err)
+	}
+
+	return s.db.Unscoped().Model(&models.File{}).
+		Where("id = ? AND s3_key = ?", fileID, key).
+		UpdateColumn("checksum", checksumReader.Checksum()).Error
+}
+
+// abort discards the multipart upload and removes the session record
+func (s *UploadSessionService) abort(ctx context.Context, session *models.UploadSession) error {
+	if s.uploader != nil {
+		if err := s.uploader.AbortMultipartUpload(ctx, session.S3Key, session.UploadID); err != nil &&
+			!errors.Is(err, ErrFileNotFound) {
+			return err
+		}
+	}
+
+	if err := s.db.Delete(session).Error; err != nil {
+		return err
+	}
+
+	s.logger.Info().
+		Str("session_id", session.ID).
+		Str("user_id", session.User).
+		Msg("Direct upload session aborted")
+
+	return nil
+}
+
+func (s *UploadSessionService) presignParts(ctx context.Context, session *models.UploadSession, partNumbers []int) ([]PartURL, error) {
+	urls := make([]PartURL, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		if partNumber < 1 || partNumber > session.PartCount {
+			return nil, fmt.Errorf("%w: part %d out of range", ErrInvalidParts, partNumber)
+		}
+
+		url, err := s.uploader.PresignUploadPart(ctx, session.S3Key, session.UploadID, partNumber, partURLExpiryMinutes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign part %d: %w", partNumber, err)
+		}
+		urls = append(urls, PartURL{PartNumber: partNumber, URL: url})
+	}
+	return urls, nil
+}
+
+// uploadPartSize picks the part size for a file, growing it in whole MiB
+// when the default would need more than the S3 part limit
+func uploadPartSize(size int64) int64 {
+	partSize := int64(defaultPartSize)
+	if size > partSize*maxUploadParts {
+		const mib = 1024 * 1024
+		partSize = (size + maxUploadParts - 1) / maxUploadParts
+		partSize = (partSize + mib - 1) / mib * mib
+	}
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	return partSize
+}
+
+// validateCompletedParts checks that every part from 1 to partCount is
+// listed exactly once with an ETag
+func validateCompletedParts(parts []CompletedPart, partCount int) error {
+	if len(parts) != partCount {
+		return fmt.Errorf("%w: expected %d parts, got %d", ErrInvalidParts, partCount, len(parts))
+	}
+
+	seen := make(map[int]bool, len(parts))
+	for _, part := range parts {
+		if part.PartNumber < 1 || part.PartNumber > partCount || seen[part.PartNumber] {
+			return fmt.Errorf("%w: unexpected part number %d", ErrInvalidParts, part.PartNumber)
+		}
+		if part.ETag == "" {
+			return fmt.Errorf("%w: part %d is missing its ETag", ErrInvalidParts, part.PartNumber)
+		}
+		seen[part.PartNumber] = true
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/config"
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeMultipartStorage adds in-memory multipart uploads to local storage
+type fakeMultipartStorage struct {
+	*LocalStorageService
+	uploads map[string]map[int][]byte
+	aborted []string
+}
+
+func (f *fakeMultipartStorage) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	uploadID := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+	f.uploads[uploadID] = make(map[int][]byte)
+	return uploadID, nil
+}
+
+func (f *fakeMultipartStorage) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int, expirationMinutes int) (string, error) {
+	return fmt.Sprintf("https://storage.example/%s?uploadId=%s&partNumber=%d", key, uploadID, partNumber), nil
+}
+
+func (f *fakeMultipartStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	f.uploads[uploadID][partNumber] = data
+	return fmt.Sprintf("etag-%d", partNumber), nil
+}
+
+func (f *fakeMultipartStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	var data []byte
+	for _, part := range parts {
+		data = append(data, f.uploads[uploadID][part.PartNumber]...)
+	}
+	delete(f.uploads, uploadID)
+	return f.UploadFile(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream")
+}
+
+func (f *fakeMultipartStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	delete(f.uploads, uploadID)
+	f.aborted = append(f.aborted, uploadID)
+	return nil
+}
+
+func newTestUploadSessionService(t *testing.T) (*UploadSessionService, *fakeMultipartStorage, *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(sqlite.Dialector{Conn: sqlDB}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Directory{}, &models.UploadSession{}))
+
+	storage := &fakeMultipartStorage{
+		LocalStorageService: newTestLocalStorage(t),
+		uploads:             make(map[string]map[int][]byte),
+	}
+	cfg := &config.Config{
+		S3Bucket:            "test-bucket",
+		DirectUploadEnabled: true,
+		UploadSessionTTL:    24,
+	}
+	userService := NewUserService(db, zerolog.Nop())
+	fileService := NewFileService(db, storage, NewBlobService(db, storage, zerolog.Nop()), userService, zerolog.Nop(), cfg)
+
+	return NewUploadSessionService(db, storage, userService, fileService, zerolog.Nop(), cfg), storage, db
+}
+
+func TestUploadSessionService_Disabled(t *testing.T) {
+	service := NewUploadSessionService(nil, newTestLocalStorage(t), nil, nil, zerolog.Nop(), &config.Config{DirectUploadEnabled: true})
+	assert.False(t, service.Enabled(), "Backends without multipart support can't do direct uploads")
+
+	_, _, err := service.CreateSession(context.Background(), "user1", "", "file.txt", "text/plain", 10, "")
+	assert.ErrorIs(t, err, ErrDirectUploadUnsupported)
+}
+
+func TestUploadSessionService_CreateAndComplete(t *testing.T) {
+	service, storage, db := newTestUploadSessionService(t)
+	require.True(t, service.Enabled())
+
+	user, err := service.userService.CreateUser("upload@example.com", "uploader", "Password123!", false)
+	require.NoError(t, err)
+
+	session, parts, err := service.CreateSession(context.Background(), user.ID, "", "video.mp4", "video/mp4", 25*1024*1024, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(defaultPartSize), session.PartSize)
+	assert.Equal(t, 3, session.PartCount)
+	require.Len(t, parts, 3)
+	assert.Equal(t, 1, parts[0].PartNumber)
+	assert.Contains(t, parts[2].URL, "partNumber=3")
+
+	// Simulate the browser uploading the parts
+	content := bytes.Repeat([]byte("v"), int(session.Size))
+	var completed []CompletedPart
+	for i := 0; i < session.PartCount; i++ {
+		start := int64(i) * session.PartSize
+		end := min(start+session.PartSize, session.Size)
+		storage.uploads[session.UploadID][i+1] = content[start:end]
+		completed = append(completed, CompletedPart{PartNumber: i + 1, ETag: fmt.Sprintf(`"etag-%d"`, i+1)})
+	}
+
+	file, _, err := service.CompleteSession(context.Background(), session.ID, user.ID, completed)
+	require.NoError(t, err)
+	assert.Equal(t, "video.mp4", file.Name)
+	assert.Equal(t, session.Size, file.Size)
+	assert.Equal(t, "test-bucket", file.S3Bucket)
+
+	exists, err := storage.FileExists(context.Background(), file.S3Key)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The session is gone and storage usage is charged
+	var count int64
+	db.Model(&models.UploadSession{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	updated, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, session.Size, updated.StorageUsed)
+
+	// The checksum is computed in the background from the stored object
+	sum := sha256.Sum256(content)
+	assert.Eventually(t, func() bool {
+		var stored models.File
+		return db.First(&stored, "id = ?", file.ID).Error == nil && stored.Checksum == hex.EncodeToString(sum[:])
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestUploadSessionService_CompleteSession_SizeMismatch(t *testing.T) {
+	service, storage, _ := newTestUploadSessionService(t)
+
+	session, _, err := service.CreateSession(context.Background(), "user1", "", "file.bin", "application/octet-stream", 100, "")
+	require.NoError(t, err)
+
+	storage.uploads[session.UploadID][1] = []byte(strings.Repeat("x", 50))
+
+	_, _, err = service.CompleteSession(context.Background(), session.ID, "user1", []CompletedPart{{PartNumber: 1, ETag: "etag"}})
+	assert.ErrorIs(t, err, ErrUploadSizeMismatch)
+
+	exists, err := storage.FileExists(context.Background(), session.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists, "Mismatched objects must be removed")
+}
+
+func TestUploadSessionService_CompleteSession_NameTaken(t *testing.T) {
+	service, storage, db := newTestUploadSessionService(t)
+
+	session, _, err := service.CreateSession(context.Background(), "user1", "", "file.bin", "application/octet-stream", 10, "")
+	require.NoError(t, err)
+
+	// The name is taken while the parts are uploading
+	require.NoError(t, db.Create(&models.File{Name: "file.bin", Path: "/", User: "user1", S3Key: "other-key"}).Error)
+	storage.uploads[session.UploadID][1] = []byte(strings.Repeat("x", 10))
+
+	_, _, err = service.CompleteSession(context.Background(), session.ID, "user1", []CompletedPart{{PartNumber: 1, ETag: "etag"}})
+	assert.ErrorIs(t, err, ErrNameConflict)
+
+	exists, err := storage.FileExists(context.Background(), session.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, err = service.GetSession(session.ID, "user1")
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound, "The finished session can't be completed again")
+}
+
+func TestUploadSessionService_CompleteSession_InvalidParts(t *testing.T) {
+	service, _, _ := newTestUploadSessionService(t)
+
+	session, _, err := service.CreateSession(context.Background(), "user1", "", "file.bin", "application/octet-stream", 25*1024*1024, "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		parts []CompletedPart
+	}{
+		{"Missing parts", []CompletedPart{{PartNumber: 1, ETag: "a"}}},
+		{"Duplicate part", []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 1, ETag: "b"}, {PartNumber: 2, ETag: "c"}}},
+		{"Out of range", []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}, {PartNumber: 4, ETag: "c"}}},
+		{"Missing ETag", []CompletedPart{{PartNumber: 1, ETag: "a"}, {PartNumber: 2, ETag: "b"}, {PartNumber: 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := service.CompleteSession(context.Background(), session.ID, "user1", tt.parts)
+			assert.ErrorIs(t, err, ErrInvalidParts)
+		})
+	}
+}
+
+func TestUploadSessionService_OtherUsersSession(t *testing.T) {
+	service, _, _ := newTestUploadSessionService(t)
+
+	session, _, err := service.CreateSession(context.Background(), "user1", "", "file.bin", "application/octet-stream", 10, "")
+	require.NoError(t, err)
+
+	_, err = service.GetSession(session.ID, "user2")
+	assert.ErrorIs(t, err, ErrUploadSessionNotFound)
+
+	assert.ErrorIs(t, service.AbortSession(context.Background(), session.ID, "user2"), ErrUploadSessionNotFound)
+}
+
+func TestUploadSessionService_AbortAndCleanup(t *testing.T) {
+	service, storage, db := newTestUploadSessionService(t)
+
+	active, _, err := service.CreateSession(context.Background(), "user1", "", "active.bin", "application/octet-stream", 10, "")
+	require.NoError(t, err)
+	abandoned, _, err := service.CreateSession(context.Background(), "user1", "", "abandoned.bin", "application/octet-stream", 10, "")
+	require.NoError(t, err)
+	require.NoError(t, db.Model(abandoned).Update("expires_at", time.Now().Add(-time.Hour)).Error)
+
+	_, err = service.GetSession(abandoned.ID, "user1")
+	assert.ErrorIs(t, err, ErrUploadSessionExpired)
+
+	removed, err := service.CleanupExpired(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, []string{abandoned.UploadID}, storage.aborted)
+
+	require.NoError(t, service.AbortSession(context.Background(), active.ID, "user1"))
+	assert.Contains(t, storage.aborted, active.UploadID)
+
+	var count int64
+	db.Model(&models.UploadSession{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestUploadPartSize(t *testing.T) {
+	assert.Equal(t, int64(defaultPartSize), uploadPartSize(1))
+	assert.Equal(t, int64(defaultPartSize), uploadPartSize(defaultPartSize*maxUploadParts))
+
+	// Very large files get bigger parts to stay under the part limit
+	size := int64(200) * 1024 * 1024 * 1024
+	partSize := uploadPartSize(size)
+	assert.Greater(t, partSize, int64(defaultPartSize))
+	assert.Zero(t, partSize%(1024*1024))
+	assert.LessOrEqual(t, (size+partSize-1)/partSize, int64(maxUploadParts))
+}
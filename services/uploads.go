@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// UploadResult describes how an upload was saved
+type UploadResult struct {
+	// Policy is the conflict policy that was applied, empty if the name
+	// was free
+	Policy ConflictPolicy
+	// Replaced is the content an overwrite replaced, released by
+	// FinishUpload
+	Replaced *models.File
+}
+
+// UploadOwner returns the user an upload into directoryID belongs to and
+// is charged to: the owner of the directory, which for share uploads isn't
+// the uploader. Uploads into the root directory belong to the uploader.
+func (s *FileService) UploadOwner(userID, directoryID string) (string, error) {
+	if directoryID == "" && userID != "" {
+		return userID, nil
+	}
+
+	var dir models.Directory
+	if err := s.db.First(&dir, "id = ?", directoryID).Error; err != nil {
+		return "", err
+	}
+	return dir.User, nil
+}
+
+// CheckUploadName fails with ErrNameConflict if an upload of name into
+// directory parentID could not be saved under policy. Uploads check this
+// before storing any content; SaveUpload checks again when saving.
+func (s *FileService) CheckUploadName(userID, parentID, name string, policy ConflictPolicy) error {
+	if policy == ConflictRename {
+		return nil
+	}
+
+	current, err := s.liveFile(userID, parentID, name)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if policy == ConflictFail {
+			return fmt.Errorf("%w: %s", ErrNameConflict, name)
+		}
+		return nil
+	}
+
+	// Only a directory can hold the name now, and it can't be replaced
+	taken, err := s.nameTaken(userID, parentID, name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%w: %s", ErrNameConflict, name)
+	}
+	return nil
+}
+
+// SaveUpload stores the record for a newly uploaded file in tx, applying
+// policy if its name is taken (an empty policy is the upload default, see
+// UploadConflictPolicy). With ConflictOverwrite or ConflictVersion, file is
+// updated to the existing record it was saved into. Call FinishUpload once
+// the transaction has committed and the upload has been charged.
+func (s *FileService) SaveUpload(tx *gorm.DB, file *models.File, policy ConflictPolicy) (*UploadResult, error) {
+	if policy == "" {
+		policy, _ = s.UploadConflictPolicy("")
+	}
+
+	// A file saved under the name since it was looked up is found by the
+	// next attempt, to be renamed around, overwritten or versioned
+	name := file.Name
+	var result *UploadResult
+	err := retryName(policy, name, func() error {
+		file.Name = name
+		var err error
+		result, err = s.saveUpload(tx, file, policy)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// saveUpload makes one attempt at SaveUpload
+func (s *FileService) saveUpload(tx *gorm.DB, file *models.File, policy ConflictPolicy) (*UploadResult, error) {
+	svc := s.in(tx)
+
+	current, err := svc.liveFile(file.User, file.ParentDirectory, file.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		// The name may still be held by a directory
+		name, err := svc.resolveName(file.User, file.ParentDirectory, file.Name, false, policy)
+		if err != nil {
+			return nil, err
+		}
+		result := &UploadResult{}
+		if name != file.Name {
+			file.Name = name
+			result.Policy = ConflictRename
+		}
+		return result, tx.Create(file).Error
+	}
+
+	result := &UploadResult{Policy: policy}
+	switch policy {
+	case ConflictRename:
+		name, err := svc.resolveName(file.User, file.ParentDirectory, file.Name, false, policy)
+		if err != nil {
+			return nil, err
+		}
+		file.Name = name
+		return result, tx.Create(file).Error
+
+	case ConflictOverwrite:
+		result.Replaced = current
+		file.Version = current.Version
+
+	case ConflictVersion:
+		if err := tx.Create(models.NewFileVersion(current)).Error; err != nil {
+			return nil, fmt.Errorf("failed to keep previous version: %w", err)
+		}
+		file.Version = current.Version + 1
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNameConflict, file.Name)
+	}
+
+	file.ID = current.ID
+	if err := updateContent(tx, file); err != nil {
+		return nil, fmt.Errorf("failed to replace file content: %w", err)
+	}
+	if err := tx.First(file, "id = ?", current.ID).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FinishUpload completes a saved upload after its transaction: content an
+// overwrite replaced is released and taken off the owner's storage used,
+// versions past the retention limits are pruned, and the upload is
+// recorded in the owner's activity
+func (s *FileService) FinishUpload(ctx context.Context, file *models.File, result *UploadResult) {
+	if replaced := result.Replaced; replaced != nil {
+		if err := s.userService.UpdateStorageUsed(replaced.User, -replaced.Size); err != nil {
+			s.logger.Error().Err(err).Str("user_id", replaced.User).Msg("Failed to update storage used after overwrite")
+		}
+		if err := s.blobService.ReleaseObject(context.WithoutCancel(ctx), replaced.S3Key); err != nil {
+			s.logger.Error().Err(err).Str("s3_key", replaced.S3Key).Msg("Failed to delete overwritten content")
+		}
+	}
+
+	if result.Policy == ConflictVersion {
+		if _, err := s.PruneVersions(ctx, file.ID); err != nil {
+			s.logger.Error().Err(err).Str("file_id", file.ID).Msg("Failed to prune file versions")
+		}
+	}
+
+	s.queueContentIndex(file)
+	s.recordActivity(file.User, models.ActivityUpload, models.ResourceTypeFile, file.ID, file.Name, "")
+}
+
+// liveFile returns userID's file called name in directory parentID, or nil
+// if there is none
+func (s *FileService) liveFile(userID, parentID, name string) (*models.File, error) {
+	var file models.File
+	query := s.db.Where("user = ? AND name = ?", userID, name)
+	if parentID != "" {
+		query = query.Where("parent_directory = ?", parentID)
+	} else {
+		query = query.Where("parent_directory IS NULL OR parent_directory = ''")
+	}
+	err := query.First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileService_UploadConflictPolicy(t *testing.T) {
+	service, _, _ := newTestFileService(t)
+
+	policy, err := service.UploadConflictPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictFail, policy)
+
+	policy, err = service.UploadConflictPolicy("overwrite")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictOverwrite, policy)
+
+	_, err = service.UploadConflictPolicy("version")
+	assert.ErrorIs(t, err, ErrInvalidConflictPolicy, "Versions need versioning enabled")
+
+	service.config.VersioningEnabled = true
+	policy, err = service.UploadConflictPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, ConflictVersion, policy)
+
+	_, err = service.UploadConflictPolicy("clobber")
+	assert.ErrorIs(t, err, ErrInvalidConflictPolicy)
+}
+
+func TestFileService_SaveUploadConflicts(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	ctx := context.Background()
+
+	user, err := service.userService.CreateUser("conflict@example.com", "conflictuser", "Password123!", false)
+	require.NoError(t, err)
+
+	original, result, err := saveTestUpload(t, service, storage, user.ID, nil, "report.txt", "first", ConflictFail)
+	require.NoError(t, err)
+	assert.Empty(t, result.Policy)
+
+	t.Run("Fail", func(t *testing.T) {
+		assert.ErrorIs(t, service.CheckUploadName(user.ID, "", "report.txt", ConflictFail), ErrNameConflict)
+
+		_, _, err := saveTestUpload(t, service, storage, user.ID, nil, "report.txt", "second", ConflictFail)
+		assert.ErrorIs(t, err, ErrNameConflict)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		renamed, result, err := saveTestUpload(t, service, storage, user.ID, nil, "report.txt", "second", ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, ConflictRename, result.Policy)
+		assert.Equal(t, "report (1).txt", renamed.Name)
+		assert.NotEqual(t, original.ID, renamed.ID)
+	})
+
+	t.Run("Overwrite keeps the ID and shares", func(t *testing.T) {
+		require.NoError(t, db.Create(&models.Share{User: user.ID, ResourceType: models.ResourceTypeFile, File: original.ID, PermissionType: models.PermissionRead}).Error)
+		before, err := service.userService.GetUserByID(user.ID)
+		require.NoError(t, err)
+
+		overwritten, result, err := saveTestUpload(t, service, storage, user.ID, nil, "report.txt", "rewritten", ConflictOverwrite)
+		require.NoError(t, err)
+		assert.Equal(t, ConflictOverwrite, result.Policy)
+		assert.Equal(t, original.ID, overwritten.ID)
+		assert.Equal(t, "rewritten", readObject(t, storage, overwritten.S3Key))
+
+		exists, err := storage.FileExists(ctx, original.S3Key)
+		require.NoError(t, err)
+		assert.False(t, exists, "Overwritten content should be deleted")
+
+		var shares int64
+		db.Model(&models.Share{}).Where("file = ?", original.ID).Count(&shares)
+		assert.Equal(t, int64(1), shares)
+
+		after, err := service.userService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, before.StorageUsed+int64(len("rewritten")-len("first")), after.StorageUsed)
+	})
+
+	t.Run("A directory's name can't be overwritten", func(t *testing.T) {
+		createTestDirectory(t, db, user.ID, nil, "docs")
+		assert.ErrorIs(t, service.CheckUploadName(user.ID, "", "docs", ConflictOverwrite), ErrNameConflict)
+
+		_, _, err := saveTestUpload(t, service, storage, user.ID, nil, "docs", "data", ConflictOverwrite)
+		assert.ErrorIs(t, err, ErrNameConflict)
+
+		renamed, _, err := saveTestUpload(t, service, storage, user.ID, nil, "docs", "data", ConflictRename)
+		require.NoError(t, err)
+		assert.Equal(t, "docs (1)", renamed.Name)
+	})
+}
+
+func TestFileService_CreateDirectory(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("mkdir@example.com", "mkdiruser", "Password123!", false)
+	require.NoError(t, err)
+
+	docs, applied, err := service.CreateDirectory(user.ID, "", "docs", ConflictFail)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.Equal(t, "/", docs.Path)
+
+	_, _, err = service.CreateDirectory(user.ID, "", "docs", ConflictFail)
+	assert.ErrorIs(t, err, ErrNameConflict)
+
+	renamed, applied, err := service.CreateDirectory(user.ID, "", "docs", ConflictRename)
+	require.NoError(t, err)
+	assert.Equal(t, ConflictRename, applied)
+	assert.Equal(t, "docs (1)", renamed.Name)
+
+	// Files share the namespace
+	createTestTreeFile(t, storage, db, user.ID, docs, "notes", "text")
+	_, _, err = service.CreateDirectory(user.ID, docs.ID, "notes", ConflictFail)
+	assert.ErrorIs(t, err, ErrNameConflict)
+
+	child, _, err := service.CreateDirectory(user.ID, docs.ID, "drafts", ConflictFail)
+	require.NoError(t, err)
+	assert.Equal(t, "docs", child.Path)
+}
+
+func TestFileService_EnsureDirectories(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("folders@example.com", "foldersuser", "Password123!", false)
+	require.NoError(t, err)
+	target := createTestDirectory(t, db, user.ID, nil, "target")
+
+	// Concurrent uploads into the same new folders create each one once
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, _, err := service.EnsureDirectories(user.ID, target.ID, []string{"photos", "2024"})
+			assert.NoError(t, err)
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+	var count int64
+	db.Model(&models.Directory{}).Where("name IN ?", []string{"photos", "2024"}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	var leaf models.Directory
+	require.NoError(t, db.First(&leaf, "id = ?", ids[0]).Error)
+	assert.Equal(t, "target/photos", leaf.Path)
+
+	// Calling again creates nothing
+	id, created, err := service.EnsureDirectories(user.ID, target.ID, []string{"photos", "2024"})
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], id)
+	assert.Empty(t, created)
+
+	createTestTreeFile(t, storage, db, user.ID, target, "notes", "text")
+	_, _, err = service.EnsureDirectories(user.ID, target.ID, []string{"notes", "drafts"})
+	assert.ErrorIs(t, err, ErrNameConflict)
+}
+
+func TestFileService_ShareUploadOwner(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	owner, err := service.userService.CreateUser("sharer@example.com", "shareruser", "Password123!", false)
+	require.NoError(t, err)
+	uploader, err := service.userService.CreateUser("uploader@example.com", "uploaderuser", "Password123!", false)
+	require.NoError(t, err)
+	shared := createTestDirectory(t, db, owner.ID, nil, "shared")
+	docs := createTestDirectory(t, db, owner.ID, shared, "docs")
+
+	// A signed-in user uploading docs/a.txt through a share of shared
+	userID, err := service.UploadOwner(uploader.ID, shared.ID)
+	require.NoError(t, err)
+	assert.Equal(t, owner.ID, userID, "Uploads belong to the directory's owner, not the uploader")
+
+	parentID, created, err := service.EnsureDirectories(userID, shared.ID, []string{"docs"})
+	require.NoError(t, err)
+	assert.Equal(t, docs.ID, parentID, "The owner's folder is reused")
+	assert.Empty(t, created)
+
+	_, _, err = saveTestUpload(t, service, storage, userID, docs, "a.txt", "shared upload", "")
+	require.NoError(t, err)
+
+	listing, err := service.ListDirectory(owner.ID, docs.ID, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, listing.Files, 1)
+	assert.Equal(t, "a.txt", listing.Files[0].Name)
+
+	after, err := service.userService.GetUserByID(uploader.ID)
+	require.NoError(t, err)
+	assert.Zero(t, after.StorageUsed, "The uploader isn't charged")
+	var dirs int64
+	db.Model(&models.Directory{}).Where("user = ?", uploader.ID).Count(&dirs)
+	assert.Zero(t, dirs)
+
+	// Uploads into the root directory are the uploader's own
+	userID, err = service.UploadOwner(uploader.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, uploader.ID, userID)
+}
+
+func TestSplitRelativePath(t *testing.T) {
+	parts, err := SplitRelativePath("photos/./2024//trip.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"photos", "2024", "trip.jpg"}, parts)
+
+	parts, err = SplitRelativePath(`photos\trip.jpg`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"photos", "trip.jpg"}, parts)
+
+	for _, path := range []string{"../etc/passwd", "photos/../../x", "/etc/passwd", "photos/%2e%2e/x", "bad\x01name"} {
+		_, err := SplitRelativePath(path)
+		assert.ErrorIs(t, err, ErrInvalidName, path)
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -12,8 +13,9 @@ import (
 
 // UserService handles user-related business logic
 type UserService struct {
-	db     *gorm.DB
-	logger zerolog.Logger
+	db          *gorm.DB
+	blobService *BlobService
+	logger      zerolog.Logger
 }
 
 // NewUserService creates a new user service
@@ -24,6 +26,12 @@ func NewUserService(db *gorm.DB, logger zerolog.Logger) *UserService {
 	}
 }
 
+// SetBlobService sets the blob service used to release stored objects
+// when a user is deleted. Without it, DeleteUser only removes records.
+func (s *UserService) SetBlobService(blobService *BlobService) {
+	s.blobService = blobService
+}
+
 // CreateUser creates a new user
 func (s *UserService) CreateUser(email, username, password string, isAdmin bool) (*models.User, error) {
 	// Validate input
@@ -241,10 +249,14 @@ func (s *UserService) ResetPassword(userID, newPassword string) error {
 	return nil
 }
 
-// DeleteUser deletes a user and all their data
-func (s *UserService) DeleteUser(userID string) error {
+// DeleteUser deletes a user and all their data. It returns the stored
+// objects that couldn't be removed; their records are gone either way, so
+// they are left for the reconciliation job.
+func (s *UserService) DeleteUser(userID string) ([]string, error) {
+	var s3Keys []string
+
 	// Start transaction
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
 		// Get user to check if exists
 		var user models.User
 		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
@@ -254,13 +266,29 @@ func (s *UserService) DeleteUser(userID string) error {
 			return fmt.Errorf("failed to get user: %w", err)
 		}
 
+		// Collect the stored objects to release once the records are gone,
+		// including those of files in the trash and of older versions
+		if err := tx.Unscoped().Model(&models.File{}).Where("user = ?", userID).Pluck("s3_key", &s3Keys).Error; err != nil {
+			return fmt.Errorf("failed to get user files: %w", err)
+		}
+		var versionKeys []string
+		if err := tx.Model(&models.FileVersion{}).Where("user = ?", userID).Pluck("s3_key", &versionKeys).Error; err != nil {
+			return fmt.Errorf("failed to get user file versions: %w", err)
+		}
+		s3Keys = append(s3Keys, versionKeys...)
+
 		// Delete user's files
-		if err := tx.Where("user = ?", userID).Delete(&models.File{}).Error; err != nil {
+		if err := tx.Unscoped().Where("user = ?", userID).Delete(&models.File{}).Error; err != nil {
 			return fmt.Errorf("failed to delete user files: %w", err)
 		}
 
+		// Delete user's file versions
+		if err := tx.Where("user = ?", userID).Delete(&models.FileVersion{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user file versions: %w", err)
+		}
+
 		// Delete user's directories
-		if err := tx.Where("user = ?", userID).Delete(&models.Directory{}).Error; err != nil {
+		if err := tx.Unscoped().Where("user = ?", userID).Delete(&models.Directory{}).Error; err != nil {
 			return fmt.Errorf("failed to delete user directories: %w", err)
 		}
 
@@ -269,6 +297,22 @@ func (s *UserService) DeleteUser(userID string) error {
 			return fmt.Errorf("failed to delete user shares: %w", err)
 		}
 
+		// Delete user's tags and metadata
+		if err := tx.Where("tag IN (?)", tx.Model(&models.Tag{}).Select("id").Where("user = ?", userID)).Delete(&models.TagLink{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user tag links: %w", err)
+		}
+		if err := tx.Where("user = ?", userID).Delete(&models.Tag{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user tags: %w", err)
+		}
+		if err := tx.Where("user = ?", userID).Delete(&models.Metadata{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user metadata: %w", err)
+		}
+
+		// Delete user's activity
+		if err := tx.Where("user = ?", userID).Delete(&models.Activity{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user activity: %w", err)
+		}
+
 		// Delete the user
 		if err := tx.Delete(&user).Error; err != nil {
 			return fmt.Errorf("failed to delete user: %w", err)
@@ -281,6 +325,29 @@ func (s *UserService) DeleteUser(userID string) error {
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Release storage outside the transaction, in batches S3 accepts. The
+	// records are already gone, so this isn't tied to the caller's request,
+	// and failures only leave orphaned objects behind.
+	var failed []string
+	if s.blobService != nil {
+		for start := 0; start < len(s3Keys); start += deleteBatchSize {
+			batch := s3Keys[start:min(start+deleteBatchSize, len(s3Keys))]
+			if err := s.blobService.ReleaseObjects(context.Background(), batch); err != nil {
+				s.logger.Error().
+					Err(err).
+					Str("user_id", userID).
+					Int("objects", len(batch)).
+					Msg("Failed to release stored objects for deleted user")
+				failed = append(failed, FailedKeys(err, batch)...)
+			}
+		}
+	}
+
+	return failed, nil
 }
 
 // UpdateStorageUsed updates a user's storage usage
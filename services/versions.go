@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"gorm.io/gorm"
+)
+
+// ListVersions returns a file with its older versions, newest first
+func (s *FileService) ListVersions(fileID string) (*models.File, []*models.FileVersion, error) {
+	var file models.File
+	if err := s.db.First(&file, "id = ?", fileID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var versions []*models.FileVersion
+	if err := s.db.Where("file = ?", fileID).Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+
+	return &file, versions, nil
+}
+
+// VersionContent returns a file as it was at an older version, for
+// downloading it
+func (s *FileService) VersionContent(fileID, versionID string) (*models.File, error) {
+	var file models.File
+	if err := s.db.First(&file, "id = ?", fileID).Error; err != nil {
+		return nil, err
+	}
+
+	var version models.FileVersion
+	if err := s.db.First(&version, "id = ? AND file = ?", versionID, fileID).Error; err != nil {
+		return nil, err
+	}
+
+	return version.ContentOf(&file), nil
+}
+
+// RestoreVersion makes an older version of a file current again. The
+// current content is kept as a version in its place, so nothing is lost
+// and the bytes stored don't change; the restored content gets the next
+// version number.
+func (s *FileService) RestoreVersion(userID, fileID, versionID string) (*models.File, error) {
+	var file models.File
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&file, "id = ?", fileID).Error; err != nil {
+			return err
+		}
+		var version models.FileVersion
+		if err := tx.First(&version, "id = ? AND file = ?", versionID, fileID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(models.NewFileVersion(&file)).Error; err != nil {
+			return err
+		}
+		restored := version.ContentOf(&file)
+		restored.Version = file.Version + 1
+		if err := updateContent(tx, restored); err != nil {
+			return err
+		}
+		if err := tx.Delete(&version).Error; err != nil {
+			return err
+		}
+		return tx.First(&file, "id = ?", fileID).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to restore version: %w", err)
+	}
+
+	s.logger.Info().
+		Str("user_id", userID).
+		Str("file_id", file.ID).
+		Str("version_id", versionID).
+		Int("version", file.Version).
+		Msg("File version restored")
+
+	s.queueContentIndex(&file)
+	return &file, nil
+}
+
+// PruneVersions deletes the versions of a file that fall outside the
+// retention limits and returns how many were deleted
+func (s *FileService) PruneVersions(ctx context.Context, fileID string) (int, error) {
+	var versions []*models.FileVersion
+	if err := s.db.Where("file = ?", fileID).Order("version DESC").Find(&versions).Error; err != nil {
+		return 0, fmt.Errorf("failed to load versions: %w", err)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -s.config.VersionRetentionDays)
+	var expired []*models.FileVersion
+	for i, version := range versions {
+		tooMany := s.config.VersionRetentionCount > 0 && i >= s.config.VersionRetentionCount
+		tooOld := s.config.VersionRetentionDays > 0 && version.ReplacedAt.Before(cutoff)
+		if tooMany || tooOld {
+			expired = append(expired, version)
+		}
+	}
+
+	return len(expired), s.deleteVersions(ctx, expired)
+}
+
+// PruneExpiredVersions deletes the versions replaced longer ago than the
+// retention period and returns how many were deleted. Versions of trashed
+// files are left for the trash to purge.
+func (s *FileService) PruneExpiredVersions(ctx context.Context) (int, error) {
+	if s.config.VersionRetentionDays <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -s.config.VersionRetentionDays)
+
+	var fileIDs []string
+	err := s.db.Model(&models.FileVersion{}).
+		Distinct("file").
+		Where("replaced_at < ?", cutoff).
+		Where("file IN (?)", s.db.Model(&models.File{}).Select("id")).
+		Pluck("file", &fileIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, fileID := range fileIDs {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+		count, err := s.PruneVersions(ctx, fileID)
+		if err != nil {
+			s.logger.Error().Err(err).Str("file_id", fileID).Msg("Failed to prune file versions")
+			continue
+		}
+		pruned += count
+	}
+
+	if pruned > 0 {
+		s.logger.Info().Int("count", pruned).Msg("Pruned expired file versions")
+	}
+
+	return pruned, nil
+}
+
+// pruneExpiredVersions periodically prunes versions past their retention
+func (s *FileService) pruneExpiredVersions() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.PruneExpiredVersions(context.Background()); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to prune expired file versions")
+		}
+	}
+}
+
+// deleteVersions deletes versions, releasing their objects and taking
+// their bytes off their owners' storage used
+func (s *FileService) deleteVersions(ctx context.Context, versions []*models.FileVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(versions))
+	keys := make([]string, len(versions))
+	freed := make(map[string]int64)
+	for i, version := range versions {
+		ids[i] = version.ID
+		keys[i] = version.S3Key
+		freed[version.User] += version.Size
+	}
+
+	if err := s.db.Where("id IN ?", ids).Delete(&models.FileVersion{}).Error; err != nil {
+		return fmt.Errorf("failed to delete versions: %w", err)
+	}
+
+	for userID, size := range freed {
+		if err := s.userService.UpdateStorageUsed(userID, -size); err != nil {
+			s.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update storage used after pruning versions")
+		}
+	}
+
+	if err := s.blobService.ReleaseObjects(context.WithoutCancel(ctx), keys); err != nil {
+		s.logger.Error().Err(err).Int("objects", len(keys)).Msg("Failed to delete stored versions")
+	}
+
+	return nil
+}
+
+// versionsOf loads the older versions of fileIDs
+func (s *FileService) versionsOf(fileIDs []string) ([]*models.FileVersion, error) {
+	var versions []*models.FileVersion
+	for start := 0; start < len(fileIDs); start += fileBatchSize {
+		var batch []*models.FileVersion
+		ids := fileIDs[start:min(start+fileBatchSize, len(fileIDs))]
+		if err := s.db.Where("file IN ?", ids).Find(&batch).Error; err != nil {
+			return nil, fmt.Errorf("failed to load versions: %w", err)
+		}
+		versions = append(versions, batch...)
+	}
+	return versions, nil
+}
+
+// storedSize returns the bytes held by files, counting their older versions
+func (s *FileService) storedSize(files []*models.File) (int64, error) {
+	fileIDs := make([]string, len(files))
+	var size int64
+	for i, file := range files {
+		fileIDs[i] = file.ID
+		size += file.Size
+	}
+
+	versions, err := s.versionsOf(fileIDs)
+	if err != nil {
+		return 0, err
+	}
+	for _, version := range versions {
+		size += version.Size
+	}
+	return size, nil
+}
+
+// updateContent stores file's content fields and version number
+func updateContent(tx *gorm.DB, file *models.File) error {
+	return tx.Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		"version":     file.Version,
+		"size":        file.Size,
+		"mime_type":   file.MimeType,
+		"s3_key":      file.S3Key,
+		"s3_bucket":   file.S3Bucket,
+		"checksum":    file.Checksum,
+		"wrapped_key": file.WrappedKey,
+	}).Error
+}
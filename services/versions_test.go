@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd-boyd/filesonthego/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// saveTestUpload stores content and saves it as an upload of name into
+// dir under policy, charging and finishing it like the upload paths do
+func saveTestUpload(t *testing.T, service *FileService, storage *LocalStorageService, userID string, dir *models.Directory, name, content string, policy ConflictPolicy) (*models.File, *UploadResult, error) {
+	t.Helper()
+
+	key := GenerateS3Key(userID, models.GenerateID(), name)
+	require.NoError(t, storage.UploadFile(context.Background(), key, strings.NewReader(content), int64(len(content)), "text/plain"))
+
+	file := &models.File{Name: name, Path: "/", User: userID, Size: int64(len(content)), S3Key: key, S3Bucket: "b"}
+	if dir != nil {
+		file.Path = dir.GetFullPath()
+		file.ParentDirectory = dir.ID
+	}
+	result, err := service.SaveUpload(service.db, file, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	require.NoError(t, service.userService.UpdateStorageUsed(userID, file.Size))
+	service.FinishUpload(context.Background(), file, result)
+	return file, result, nil
+}
+
+// uploadTestVersion uploads content as name into dir with the default
+// conflict policy
+func uploadTestVersion(t *testing.T, service *FileService, storage *LocalStorageService, userID string, dir *models.Directory, name, content string) *models.File {
+	t.Helper()
+
+	file, _, err := saveTestUpload(t, service, storage, userID, dir, name, content, "")
+	require.NoError(t, err)
+	return file
+}
+
+func TestFileService_SaveUpload(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+
+	user, err := service.userService.CreateUser("versions@example.com", "versionsuser", "Password123!", false)
+	require.NoError(t, err)
+	docs := createTestDirectory(t, db, user.ID, nil, "docs")
+
+	t.Run("Without versioning a taken name fails by default", func(t *testing.T) {
+		uploadTestVersion(t, service, storage, user.ID, nil, "notes.md", "one")
+		_, _, err := saveTestUpload(t, service, storage, user.ID, nil, "notes.md", "two", "")
+		assert.ErrorIs(t, err, ErrNameConflict)
+	})
+
+	service.config.VersioningEnabled = true
+
+	first := uploadTestVersion(t, service, storage, user.ID, docs, "report.txt", "draft")
+	second := uploadTestVersion(t, service, storage, user.ID, docs, "report.txt", "final!")
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, 2, second.Version)
+	assert.Equal(t, int64(6), second.Size)
+	assert.Equal(t, first.CreatedAt.Unix(), second.CreatedAt.Unix())
+
+	var fileCount int64
+	db.Model(&models.File{}).Where("name = ?", "report.txt").Count(&fileCount)
+	assert.Equal(t, int64(1), fileCount)
+
+	// The same name elsewhere is a different file
+	other := uploadTestVersion(t, service, storage, user.ID, nil, "report.txt", "other")
+	assert.NotEqual(t, first.ID, other.ID)
+
+	file, versions, err := service.ListVersions(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, file.Version)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, first.S3Key, versions[0].S3Key)
+
+	old, err := service.VersionContent(first.ID, versions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "report.txt", old.Name)
+	assert.Equal(t, "draft", readObject(t, storage, old.S3Key))
+
+	_, err = service.VersionContent(other.ID, versions[0].ID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestFileService_RestoreVersion(t *testing.T) {
+	service, storage, _ := newTestFileService(t)
+	service.config.VersioningEnabled = true
+
+	user, err := service.userService.CreateUser("restorever@example.com", "restoreveruser", "Password123!", false)
+	require.NoError(t, err)
+
+	uploadTestVersion(t, service, storage, user.ID, nil, "notes.md", "first")
+	file := uploadTestVersion(t, service, storage, user.ID, nil, "notes.md", "second")
+
+	_, versions, err := service.ListVersions(file.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+
+	restored, err := service.RestoreVersion(user.ID, file.ID, versions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, restored.Version)
+	assert.Equal(t, "first", readObject(t, storage, restored.S3Key))
+
+	// The replaced content is kept in its place
+	_, versions, err = service.ListVersions(file.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "second", readObject(t, storage, versions[0].S3Key))
+
+	// Both contents are still stored, so usage is unchanged
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("first")+len("second")), refreshed.StorageUsed)
+
+	_, err = service.RestoreVersion(user.ID, file.ID, "missing")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestFileService_PruneVersions(t *testing.T) {
+	service, storage, db := newTestFileService(t)
+	require.NoError(t, db.AutoMigrate(&models.Share{}))
+	ctx := context.Background()
+	service.config.VersioningEnabled = true
+
+	user, err := service.userService.CreateUser("prune@example.com", "pruneuser", "Password123!", false)
+	require.NoError(t, err)
+
+	var file *models.File
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		file = uploadTestVersion(t, service, storage, user.ID, nil, "log.txt", content)
+	}
+	_, versions, err := service.ListVersions(file.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	oldest := versions[2]
+
+	service.config.VersionRetentionCount = 2
+	pruned, err := service.PruneVersions(ctx, file.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	_, versions, err = service.ListVersions(file.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 3, versions[0].Version)
+	assert.Equal(t, 2, versions[1].Version)
+
+	exists, err := storage.FileExists(ctx, oldest.S3Key)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	refreshed, err := service.userService.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), refreshed.StorageUsed)
+
+	t.Run("Expired versions", func(t *testing.T) {
+		service.config.VersionRetentionDays = 30
+		require.NoError(t, db.Model(&models.FileVersion{}).Where("version = ?", 2).
+			Update("replaced_at", time.Now().AddDate(0, 0, -31)).Error)
+
+		pruned, err := service.PruneExpiredVersions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, pruned)
+
+		_, versions, err := service.ListVersions(file.ID)
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, 3, versions[0].Version)
+	})
+
+	t.Run("Purging a file deletes its versions", func(t *testing.T) {
+		_, err := service.TrashFile(user.ID, file.ID)
+		require.NoError(t, err)
+
+		report, err := service.Purge(ctx, user.ID, file.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), report.BytesFreed)
+
+		var remaining int64
+		db.Model(&models.FileVersion{}).Count(&remaining)
+		assert.Zero(t, remaining)
+
+		refreshed, err := service.userService.GetUserByID(user.ID)
+		require.NoError(t, err)
+		assert.Zero(t, refreshed.StorageUsed)
+	})
+}
@@ -3,6 +3,7 @@
 package integration
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -29,7 +30,7 @@ func TestFileUpload_Integration_ValidUpload(t *testing.T) {
 
 		// Prepare file content
 		content := []byte("test file content for integration test")
-		body, contentType := tests.CreateMultipartUpload("integration-test.txt", content, nil)
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "integration-test.txt", content, nil)
 
 		// Create upload request
 		req := httptest.NewRequest("POST", "/api/files/upload", body)
@@ -85,7 +86,7 @@ func TestFileUpload_Integration_ValidUpload(t *testing.T) {
 
 		// Prepare file content
 		content := []byte("test file in subdirectory")
-		body, contentType := tests.CreateMultipartUpload("dir-test.txt", content, map[string]string{
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "dir-test.txt", content, map[string]string{
 			"directory_id": directory.ID,
 		})
 
@@ -120,7 +121,7 @@ func TestFileUpload_Integration_ValidUpload(t *testing.T) {
 
 		// Prepare file content
 		content := []byte("new file upload")
-		body, contentType := tests.CreateMultipartUpload("new-file.txt", content, map[string]string{
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "new-file.txt", content, map[string]string{
 			"share_token": share.ShareToken,
 		})
 
@@ -147,7 +148,7 @@ func TestFileUpload_Integration_PermissionDenied(t *testing.T) {
 
 		// Prepare file content
 		content := []byte("unauthenticated upload attempt")
-		body, contentType := tests.CreateMultipartUpload("unauth.txt", content, nil)
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "unauth.txt", content, nil)
 
 		// Create upload request without auth token
 		req := httptest.NewRequest("POST", "/api/files/upload", body)
@@ -174,7 +175,7 @@ func TestFileUpload_Integration_PermissionDenied(t *testing.T) {
 		// User 2 tries to upload to User 1's directory
 		token2 := app.AuthenticateUser(t, user2.Email, "password2")
 		content := []byte("unauthorized upload")
-		body, contentType := tests.CreateMultipartUpload("unauthorized.txt", content, map[string]string{
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "unauthorized.txt", content, map[string]string{
 			"directory_id": directory.ID,
 		})
 
@@ -202,7 +203,7 @@ func TestFileUpload_Integration_PermissionDenied(t *testing.T) {
 
 		// Try to upload with expired share token
 		content := []byte("upload with expired token")
-		body, contentType := tests.CreateMultipartUpload("expired-upload.txt", content, map[string]string{
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "expired-upload.txt", content, map[string]string{
 			"share_token": share.ShareToken,
 		})
 
@@ -228,7 +229,7 @@ func TestFileUpload_Integration_PermissionDenied(t *testing.T) {
 
 		// Try to upload with read-only share token
 		content := []byte("upload with read-only token")
-		body, contentType := tests.CreateMultipartUpload("readonly-upload.txt", content, map[string]string{
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "readonly-upload.txt", content, map[string]string{
 			"share_token": share.ShareToken,
 		})
 
@@ -266,7 +267,7 @@ func TestFileUpload_Integration_QuotaEnforcement(t *testing.T) {
 			content[i] = byte('A' + (i % 26))
 		}
 
-		body, contentType := tests.CreateMultipartUpload("too-large.txt", content, nil)
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "too-large.txt", content, nil)
 
 		req := httptest.NewRequest("POST", "/api/files/upload", body)
 		req.Header.Set("Content-Type", contentType)
@@ -303,7 +304,7 @@ func TestFileUpload_Integration_QuotaEnforcement(t *testing.T) {
 			content[i] = byte('B' + (i % 24))
 		}
 
-		body, contentType := tests.CreateMultipartUpload("exact-size.txt", content, nil)
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "exact-size.txt", content, nil)
 
 		req := httptest.NewRequest("POST", "/api/files/upload", body)
 		req.Header.Set("Content-Type", contentType)
@@ -316,7 +317,7 @@ func TestFileUpload_Integration_QuotaEnforcement(t *testing.T) {
 
 		// Try to upload one more byte
 		smallContent := []byte("x")
-		smallBody, smallContentType := tests.CreateMultipartUpload("too-much.txt", smallContent, nil)
+		smallBody, smallContentType := tests.CreateMultipartUpload(context.Background(), "too-much.txt", smallContent, nil)
 
 		smallReq := httptest.NewRequest("POST", "/api/files/upload", smallBody)
 		smallReq.Header.Set("Content-Type", smallContentType)
@@ -337,7 +338,7 @@ func TestFileUpload_Integration_QuotaEnforcement(t *testing.T) {
 
 		// Upload file
 		content := []byte("test content for quota verification")
-		body, contentType := tests.CreateMultipartUpload("quota-test.txt", content, nil)
+		body, contentType := tests.CreateMultipartUpload(context.Background(), "quota-test.txt", content, nil)
 
 		req := httptest.NewRequest("POST", "/api/files/upload", body)
 		req.Header.Set("Content-Type", contentType)
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -64,6 +65,9 @@ func SetupTestApp(t *testing.T) *TestApp {
 		S3AccessKey:      "test-access-key",
 		S3SecretKey:      "test-secret-key",
 		DefaultUserQuota: 10 * 1024 * 1024 * 1024, // 10GB
+		TrashCountsTowardQuota: true,
+		ExtractMaxEntries: 10000,
+		ExtractMaxSize:    10 * 1024 * 1024 * 1024, // 10GB
 		PublicRegistration: true,
 		TLSEnabled:      false,
 	}
@@ -83,11 +87,21 @@ func SetupTestApp(t *testing.T) *TestApp {
 	err = db.AutoMigrate(
 		&models.User{},
 		&models.File{},
+		&models.FileVersion{},
 		&models.Directory{},
 		&models.Share{},
 		&models.ShareAccessLog{},
+		&models.Blob{},
+		&models.UploadSession{},
+		&models.TusUpload{},
+		&models.Tag{},
+		&models.TagLink{},
+		&models.Metadata{},
+		&models.Activity{},
 	)
 	require.NoError(t, err)
+	require.NoError(t, models.MigrateNameIndexes(db))
+	require.NoError(t, models.MigrateSearchIndex(db))
 
 	// Initialize JWT manager
 	jwtConfig := auth.JWTConfig{
@@ -117,15 +131,27 @@ func SetupTestApp(t *testing.T) *TestApp {
 
 	// Mock S3 service for tests
 	s3Service := NewMockS3Service()
+	blobService := services.NewBlobService(db, s3Service, noOpLogger)
+	userService.SetBlobService(blobService)
+	fileService := services.NewFileService(db, s3Service, blobService, userService, noOpLogger, cfg)
+	activityService := services.NewActivityService(db, noOpLogger)
+	fileService.SetActivityService(activityService)
+	shareService.SetActivityService(activityService)
 
 	// Initialize template renderer (minimal for tests)
 	templateRenderer := handlers.NewTemplateRenderer("./assets/templates")
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, templateRenderer, noOpLogger, cfg, jwtManager, sessionManager)
-	fileUploadHandler := handlers.NewFileUploadHandler(db, s3Service, permissionService, userService, noOpLogger, cfg)
-	fileDownloadHandler := handlers.NewFileDownloadHandler(db, s3Service, permissionService, noOpLogger)
-	directoryHandler := handlers.NewDirectoryHandler(db, permissionService, noOpLogger, templateRenderer)
+	fileUploadHandler := handlers.NewFileUploadHandler(db, s3Service, permissionService, userService, blobService, fileService, noOpLogger, cfg)
+	fileDownloadHandler := handlers.NewFileDownloadHandler(db, s3Service, permissionService, services.NewMetricsService(), fileService, noOpLogger, cfg)
+	directoryHandler := handlers.NewDirectoryHandler(db, permissionService, fileService, noOpLogger, templateRenderer)
+	fileOperationsHandler := handlers.NewFileOperationsHandler(fileService, permissionService, noOpLogger)
+	archiveHandler := handlers.NewArchiveHandler(fileService, permissionService, shareService, services.NewMetricsService(), noOpLogger)
+	trashHandler := handlers.NewTrashHandler(fileService, noOpLogger)
+	searchHandler := handlers.NewSearchHandler(fileService, noOpLogger)
+	tagHandler := handlers.NewTagHandler(fileService, noOpLogger)
+	activityHandler := handlers.NewActivityHandler(activityService, fileService, noOpLogger)
 	shareHandler := handlers.NewShareHandler(db, shareService, permissionService, noOpLogger, templateRenderer)
 
 	// Set Gin to test mode
@@ -153,11 +179,58 @@ func SetupTestApp(t *testing.T) *TestApp {
 	protected.Use(sessionManager.RequireAuth())
 	{
 		protected.POST("/api/files/upload", fileUploadHandler.HandleUpload)
+		protected.POST("/api/files/extract", fileUploadHandler.HandleExtract)
 		protected.GET("/api/files/:id/download", fileDownloadHandler.HandleDownload)
 		protected.DELETE("/api/files/:id", fileDownloadHandler.HandleDelete)
+		protected.POST("/api/files/:id/copy", fileOperationsHandler.CopyFile)
+		protected.PATCH("/api/files/:id", fileOperationsHandler.UpdateFile)
+		protected.GET("/api/files/:id/versions", fileOperationsHandler.ListVersions)
+		protected.GET("/api/files/:id/versions/:versionId/download", fileDownloadHandler.DownloadVersion)
+		protected.POST("/api/files/:id/versions/:versionId/restore", fileOperationsHandler.RestoreVersion)
 		protected.GET("/api/directories", directoryHandler.ListDirectory)
+		protected.GET("/api/directories/tree", directoryHandler.DirectoryTree)
 		protected.POST("/api/directories", directoryHandler.CreateDirectory)
 		protected.DELETE("/api/directories/:id", directoryHandler.DeleteDirectory)
+		protected.POST("/api/directories/:id/copy", fileOperationsHandler.CopyDirectory)
+		protected.PATCH("/api/directories/:id", fileOperationsHandler.UpdateDirectory)
+		protected.GET("/api/directories/:id/archive", archiveHandler.DownloadDirectory)
+		protected.POST("/api/archive", archiveHandler.DownloadSelection)
+		protected.GET("/api/trash", trashHandler.ListTrash)
+		protected.POST("/api/trash/:id/restore", trashHandler.RestoreItem)
+		protected.DELETE("/api/trash/:id", trashHandler.PurgeItem)
+		protected.DELETE("/api/trash", trashHandler.EmptyTrash)
+
+		// Tag routes
+		protected.GET("/api/tags", tagHandler.ListTags)
+		protected.POST("/api/tags", tagHandler.CreateTag)
+		protected.PATCH("/api/tags/:id", tagHandler.UpdateTag)
+		protected.DELETE("/api/tags/:id", tagHandler.DeleteTag)
+		protected.GET("/api/files/:id/tags", tagHandler.ItemTags(models.ResourceTypeFile))
+		protected.POST("/api/files/:id/tags", tagHandler.AddItemTag(models.ResourceTypeFile))
+		protected.DELETE("/api/files/:id/tags/:tagId", tagHandler.RemoveItemTag(models.ResourceTypeFile))
+		protected.GET("/api/directories/:id/tags", tagHandler.ItemTags(models.ResourceTypeDirectory))
+		protected.POST("/api/directories/:id/tags", tagHandler.AddItemTag(models.ResourceTypeDirectory))
+		protected.DELETE("/api/directories/:id/tags/:tagId", tagHandler.RemoveItemTag(models.ResourceTypeDirectory))
+
+		// Metadata routes
+		protected.GET("/api/files/:id/metadata", tagHandler.ItemMetadata(models.ResourceTypeFile))
+		protected.PUT("/api/files/:id/metadata/:key", tagHandler.SetItemMetadata(models.ResourceTypeFile))
+		protected.DELETE("/api/files/:id/metadata/:key", tagHandler.DeleteItemMetadata(models.ResourceTypeFile))
+		protected.GET("/api/directories/:id/metadata", tagHandler.ItemMetadata(models.ResourceTypeDirectory))
+		protected.PUT("/api/directories/:id/metadata/:key", tagHandler.SetItemMetadata(models.ResourceTypeDirectory))
+		protected.DELETE("/api/directories/:id/metadata/:key", tagHandler.DeleteItemMetadata(models.ResourceTypeDirectory))
+
+		// Activity and starred routes
+		protected.GET("/api/activity", activityHandler.ListActivity)
+		protected.GET("/api/recent", activityHandler.RecentFiles)
+		protected.GET("/api/starred", activityHandler.ListStarred)
+		protected.PUT("/api/files/:id/star", activityHandler.SetStarred(models.ResourceTypeFile, true))
+		protected.DELETE("/api/files/:id/star", activityHandler.SetStarred(models.ResourceTypeFile, false))
+		protected.PUT("/api/directories/:id/star", activityHandler.SetStarred(models.ResourceTypeDirectory, true))
+		protected.DELETE("/api/directories/:id/star", activityHandler.SetStarred(models.ResourceTypeDirectory, false))
+
+		// Search routes
+		protected.GET("/api/search", searchHandler.Search)
 		protected.POST("/api/shares", shareHandler.CreateShare)
 		protected.GET("/api/shares", shareHandler.ListShares)
 		protected.GET("/api/shares/:id", shareHandler.GetShare)
@@ -167,6 +240,10 @@ func SetupTestApp(t *testing.T) *TestApp {
 	// Public share access
 	router.GET("/share", shareHandler.AccessShare)
 	router.POST("/share", shareHandler.AccessShare)
+	router.GET("/share/files/:id/download", fileDownloadHandler.HandleDownload)
+	router.POST("/share/files/upload", sessionManager.OptionalAuth(), fileUploadHandler.HandleUpload)
+	router.GET("/share/directories/:id/archive", archiveHandler.DownloadDirectory)
+	router.POST("/share/archive", archiveHandler.DownloadSelection)
 
 	cleanup := func() {
 		os.RemoveAll(tempDir)
@@ -417,7 +494,7 @@ func NewMockS3Service() *MockS3Service {
 }
 
 // UploadFile implements S3Service interface
-func (m *MockS3Service) UploadFile(key string, data io.Reader, size int64, contentType string) error {
+func (m *MockS3Service) UploadFile(ctx context.Context, key string, data io.Reader, size int64, contentType string) error {
 	content, err := io.ReadAll(data)
 	if err != nil {
 		return err
@@ -427,7 +504,7 @@ func (m *MockS3Service) UploadFile(key string, data io.Reader, size int64, conte
 }
 
 // UploadStream implements S3Service interface
-func (m *MockS3Service) UploadStream(key string, data io.Reader) error {
+func (m *MockS3Service) UploadStream(ctx context.Context, key string, data io.Reader) error {
 	content, err := io.ReadAll(data)
 	if err != nil {
 		return err
@@ -437,7 +514,7 @@ func (m *MockS3Service) UploadStream(key string, data io.Reader) error {
 }
 
 // DownloadFile implements S3Service interface
-func (m *MockS3Service) DownloadFile(key string) (io.ReadCloser, error) {
+func (m *MockS3Service) DownloadFile(ctx context.Context, key string) (io.ReadCloser, error) {
 	content, exists := m.Files[key]
 	if !exists {
 		return nil, fmt.Errorf("file not found: %s", key)
@@ -445,23 +522,51 @@ func (m *MockS3Service) DownloadFile(key string) (io.ReadCloser, error) {
 	return io.NopCloser(bytes.NewReader(content)), nil
 }
 
+// DownloadRange implements S3Service interface
+func (m *MockS3Service) DownloadRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	content, exists := m.Files[key]
+	if !exists {
+		return nil, fmt.Errorf("file not found: %s", key)
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	end := int64(len(content))
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(content[offset:end])), nil
+}
 
 // GetPresignedURL implements S3Service interface
-func (m *MockS3Service) GetPresignedURL(key string, expirationMinutes int) (string, error) {
+func (m *MockS3Service) GetPresignedURL(ctx context.Context, key string, expirationMinutes int) (string, error) {
 	return fmt.Sprintf("http://localhost:9000/test-bucket/%s?presigned=true", key), nil
 }
 
+// GetPresignedDownloadURL implements S3Service interface
+func (m *MockS3Service) GetPresignedDownloadURL(ctx context.Context, key string, expirationMinutes int, opts services.PresignOptions) (string, error) {
+	query := url.Values{}
+	query.Set("presigned", "true")
+	if opts.ContentDisposition != "" {
+		query.Set("response-content-disposition", opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+	return fmt.Sprintf("http://localhost:9000/test-bucket/%s?%s", key, query.Encode()), nil
+}
+
 // FileExists implements S3Service interface
-func (m *MockS3Service) FileExists(key string) (bool, error) {
+func (m *MockS3Service) FileExists(ctx context.Context, key string) (bool, error) {
 	_, exists := m.Files[key]
 	return exists, nil
 }
 
 // GetFileMetadata implements S3Service interface
-func (m *MockS3Service) GetFileMetadata(key string) (*services.FileMetadata, error) {
+func (m *MockS3Service) GetFileMetadata(ctx context.Context, key string) (*services.FileMetadata, error) {
 	content, exists := m.Files[key]
 	if !exists {
-		return nil, fmt.Errorf("file not found: %s", key)
+		return nil, fmt.Errorf("%w: %s", services.ErrFileNotFound, key)
 	}
 	return &services.FileMetadata{
 		Size:         int64(len(content)),
@@ -471,13 +576,23 @@ func (m *MockS3Service) GetFileMetadata(key string) (*services.FileMetadata, err
 }
 
 // DeleteFile implements S3Service interface
-func (m *MockS3Service) DeleteFile(key string) error {
+func (m *MockS3Service) DeleteFile(ctx context.Context, key string) error {
 	delete(m.Files, key)
 	return nil
 }
 
+// CopyObject implements S3Service interface
+func (m *MockS3Service) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	content, exists := m.Files[srcKey]
+	if !exists {
+		return fmt.Errorf("%w: %s", services.ErrFileNotFound, srcKey)
+	}
+	m.Files[dstKey] = bytes.Clone(content)
+	return nil
+}
+
 // DeleteFiles implements S3Service interface (batch delete)
-func (m *MockS3Service) DeleteFiles(keys []string) error {
+func (m *MockS3Service) DeleteFiles(ctx context.Context, keys []string) error {
 	for _, key := range keys {
 		delete(m.Files, key)
 	}
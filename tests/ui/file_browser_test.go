@@ -100,6 +100,15 @@ type ItemInfo struct {
 	Created          string
 	Updated          string
 	UpdatedFormatted string
+	Starred          bool
+	Tags             []TagInfo
+}
+
+// TagInfo represents a tag chip on an item
+type TagInfo struct {
+	ID    string
+	Name  string
+	Color string
 }
 
 // FileListData represents data for file list template
@@ -110,6 +119,9 @@ type FileListData struct {
 	SortOrder          string
 	IsLoading          bool
 	Items              []ItemInfo
+	NextCursor         string
+	TotalDirectories   int64
+	TotalFiles         int64
 }
 
 // TestFileListTemplateExists verifies the file-list.html template exists
@@ -210,6 +222,9 @@ func TestFileListTemplateContainsRequiredElements(t *testing.T) {
 		{"HTMX attributes", "hx-"},
 		{"accessibility role", `role="`},
 		{"aria label", "aria-label"},
+		{"load more on scroll", `hx-trigger="revealed"`},
+		{"next page cursor", "cursor={{.NextCursor}}"},
+		{"totals", "file-list-totals"},
 	}
 
 	for _, elem := range requiredElements {
@@ -241,6 +256,8 @@ func TestFileItemTemplateContainsRequiredElements(t *testing.T) {
 		{"download action", "download"},
 		{"mobile view", "file-item-mobile"},
 		{"file icon template", "file-icon"},
+		{"tag chips template", "file-tags"},
+		{"tag filter", "filterByTag"},
 	}
 
 	for _, elem := range requiredElements {
@@ -303,6 +320,7 @@ func TestContextMenuTemplateContainsRequiredElements(t *testing.T) {
 		{"delete option", "context-menu-delete"},
 		{"share option", "context-menu-share"},
 		{"properties option", "context-menu-details"},
+		{"star option", "context-menu-star"},
 		{"rename modal", "rename-modal"},
 		{"delete modal", "delete-modal"},
 		{"move modal", "move-modal"},
@@ -343,6 +361,9 @@ func TestFileDetailsModalContainsRequiredElements(t *testing.T) {
 		{"close function", "closeFileDetailsModal"},
 		{"dialog role", `role="dialog"`},
 		{"aria modal", `aria-modal="true"`},
+		{"tags list", "details-tags"},
+		{"tag input", "details-tag-input"},
+		{"tag autocomplete", "details-tag-suggestions"},
 	}
 
 	for _, elem := range requiredElements {
@@ -418,6 +439,7 @@ func TestFileBrowserJSContainsRequiredFunctions(t *testing.T) {
 		"contextMenuAction",
 		"downloadFile",
 		"navigateToDirectory",
+		"toggleStar",
 		"downloadSelected",
 		"deleteSelected",
 		"openNewFolderModal",
@@ -426,11 +448,13 @@ func TestFileBrowserJSContainsRequiredFunctions(t *testing.T) {
 		"closeUploadModal",
 		"openRenameModal",
 		"closeRenameModal",
+		"renameRequestDone",
 		"openDeleteModal",
 		"closeDeleteModal",
 		"confirmDelete",
 		"openMoveModal",
 		"closeMoveModal",
+		"loadMoveDirectoryTree",
 		"confirmMove",
 		"openShareModal",
 		"closeShareModal",
@@ -444,6 +468,11 @@ func TestFileBrowserJSContainsRequiredFunctions(t *testing.T) {
 		"handleFileSelect",
 		"startUpload",
 		"initKeyboardShortcuts",
+		"loadDetailsTags",
+		"addTagFromDetails",
+		"removeTagFromDetails",
+		"suggestTags",
+		"filterByTag",
 	}
 
 	for _, fn := range requiredFunctions {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/jd-boyd/filesonthego/assets"
 	handlers_gin "github.com/jd-boyd/filesonthego/handlers_gin"
+	"github.com/jd-boyd/filesonthego/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,6 +108,63 @@ func TestTemplateRenderer_RenderDashboard(t *testing.T) {
 	assert.Contains(t, output, "Storage Usage", "Output should contain storage info")
 }
 
+func TestTemplateRenderer_RenderFileList(t *testing.T) {
+	renderer := getTemplateRenderer(t)
+
+	// Load templates
+	err := renderer.LoadTemplates()
+	require.NoError(t, err, "Failed to load templates")
+
+	// Prepare test data
+	data := &handlers_gin.FileListData{
+		CurrentDirectoryID: "dir1",
+		ViewMode:           "list",
+		SortBy:             "size",
+		SortOrder:          "asc",
+		Query:              "directory_id=dir1&sort=size&tag=urgent",
+		FilterQuery:        "directory_id=dir1&tag=urgent",
+		Items: []handlers_gin.FileListItem{
+			{ID: "d1", Name: "Reports", Type: "directory"},
+			{ID: "f1", Name: "plan.pdf", Type: "file", Size: 2048, SizeFormatted: "2.0 KB", MimeType: "application/pdf", Extension: ".pdf",
+				Tags: []*models.Tag{{ID: "t1", Name: "urgent"}}},
+		},
+		NextCursor:       "next",
+		TotalDirectories: 1,
+		TotalFiles:       30,
+	}
+
+	// The first page is the whole list
+	var buf bytes.Buffer
+	err = renderer.RenderPartial(&buf, "file-list", "file-list-content", data)
+	require.NoError(t, err, "Failed to render file list")
+
+	output := buf.String()
+	assert.NotContains(t, output, "<!DOCTYPE html>", "Partials should not include the layout")
+	assert.Contains(t, output, "Reports")
+	assert.Contains(t, output, "plan.pdf")
+	assert.Contains(t, output, `data-tag="urgent"`)
+	assert.Contains(t, output, `hx-get="/api/directories?directory_id=dir1&amp;sort=size&amp;tag=urgent&cursor=next"`, "The sentinel should load the next page with the same query")
+	assert.Contains(t, output, `hx-get="/api/directories?directory_id=dir1&amp;tag=urgent&sort=size&order=desc"`, "Sorting should keep the filters")
+	assert.Contains(t, output, "1 folders, 30 files")
+
+	// Later pages are only items and the next sentinel, in the requested view
+	data.ViewMode = "grid"
+	buf.Reset()
+	err = renderer.RenderPartial(&buf, "file-list", "file-list-page", data)
+	require.NoError(t, err, "Failed to render file list page")
+
+	output = buf.String()
+	assert.Contains(t, output, "file-item-grid")
+	assert.Contains(t, output, "file-list-more")
+	assert.NotContains(t, output, "file-list-totals")
+
+	data.NextCursor = ""
+	buf.Reset()
+	err = renderer.RenderPartial(&buf, "file-list", "file-list-page", data)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "file-list-more", "The last page has no sentinel")
+}
+
 func TestTemplateRenderer_RenderNonExistent(t *testing.T) {
 	renderer := getTemplateRenderer(t)
 